@@ -62,15 +62,50 @@ export const productsApi = {
   },
   getByBarcode: (code) => apiRequest(`/products/barcode?code=${encodeURIComponent(code)}`, { auth: false }),
   getById: (id) => apiRequest(`/products/${encodeURIComponent(id)}`, { auth: false }),
+  priceHistory: (id, since = '') => apiRequest(`/products/${encodeURIComponent(id)}/price-history${since ? `?since=${encodeURIComponent(since)}` : ''}`, { auth: false }),
 };
 
 export const ordersApi = {
-  create: (items) => apiRequest('/orders', { method: 'POST', body: { items }, auth: true }),
+  create: (items, { addressId = '', deliveryWindow = '', pickupSlot = '', couponCode = '', redeemPoints = 0, giftCardCode = '', giftCardPin = '', storeCredit = false } = {}) => apiRequest('/orders', { method: 'POST', body: { items, addressId, deliveryWindow, pickupSlot, couponCode, redeemPoints, giftCardCode, giftCardPin, storeCredit }, auth: true }),
+  cancel: (orderId) => apiRequest(`/orders/cancel?id=${encodeURIComponent(orderId)}`, { method: 'POST', auth: true }),
   tracking: (orderId) => apiRequest(`/orders/tracking?id=${encodeURIComponent(orderId)}`),
+  returns: () => apiRequest('/orders/returns', { auth: true }),
+  openReturn: (orderId, reason, lines, comment = '') => apiRequest('/orders/returns', { method: 'POST', body: { orderId, reason, comment, lines }, auth: true }),
+  cancelReturn: (returnId) => apiRequest(`/orders/returns/cancel?id=${encodeURIComponent(returnId)}`, { method: 'POST', auth: true }),
+  pay: (orderId, token) => apiRequest(`/orders/pay?id=${encodeURIComponent(orderId)}`, { method: 'POST', body: { token }, auth: true }),
 };
 
+export const giftCardsApi = {
+  balance: (code, pin) => apiRequest('/giftcards/balance', { method: 'POST', body: { code, pin }, auth: false }),
+}
+
+export const pickupApi = {
+  slots: (date = '') => apiRequest(`/pickup/slots${date ? `?date=${encodeURIComponent(date)}` : ''}`, { auth: false }),
+}
+
+export const deliveryApi = {
+  quote: (addressId) => apiRequest(`/delivery/quote?addressId=${encodeURIComponent(addressId)}`, { auth: true }),
+}
+
+export const courierApi = {
+  deliveries: () => apiRequest('/courier/deliveries', { auth: true }),
+  pickedUp: (orderId) => apiRequest(`/courier/deliveries/picked-up?id=${encodeURIComponent(orderId)}`, { method: 'POST', auth: true }),
+  delivered: (orderId, { code = '', signature = '' } = {}) => apiRequest(`/courier/deliveries/delivered?id=${encodeURIComponent(orderId)}`, { method: 'POST', body: { code, signature }, auth: true }),
+  failed: (orderId, reason) => apiRequest(`/courier/deliveries/failed?id=${encodeURIComponent(orderId)}`, { method: 'POST', body: { reason }, auth: true }),
+}
+
 export const profileApi = {
   get: () => apiRequest('/profile', { auth: true }),
+  storeCredit: () => apiRequest('/profile/store-credit', { auth: true }),
+  loyalty: () => apiRequest('/profile/loyalty', { auth: true }),
+}
+
+export const addressesApi = {
+  list: () => apiRequest('/profile/addresses', { auth: true }),
+  create: (address) => apiRequest('/profile/addresses', { method: 'POST', body: address, auth: true }),
+  update: (id, address) => apiRequest(`/profile/addresses?id=${encodeURIComponent(id)}`, { method: 'PATCH', body: address, auth: true }),
+  remove: (id) => apiRequest(`/profile/addresses?id=${encodeURIComponent(id)}`, { method: 'DELETE', auth: true }),
+  setDefault: (id) => apiRequest(`/profile/addresses/default?id=${encodeURIComponent(id)}`, { method: 'POST', auth: true }),
 }
 
 export const cartApi = {
@@ -79,6 +114,7 @@ export const cartApi = {
   update: (productId, quantity) => apiRequest('/cart', { method: 'PATCH', body: { productId, quantity }, auth: true }),
   remove: (productId) => apiRequest(`/cart?productId=${encodeURIComponent(productId)}`, { method: 'DELETE', auth: true }),
   clear: () => apiRequest('/cart', { method: 'DELETE', auth: true }),
+  coupon: (code) => apiRequest('/cart/coupon', { method: 'POST', body: { code }, auth: true }),
 }
 
 export const wishlistApi = {
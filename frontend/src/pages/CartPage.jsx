@@ -63,7 +63,8 @@ export function CartPage({ auth }) {
 
     try {
       const payloadItems = items.map((it) => ({ productId: it.productId, quantity: it.quantity }))
-      const res = await ordersApi.create(payloadItems)
+      const couponCode = cart?.couponError ? '' : cart?.couponCode || ''
+      const res = await ordersApi.create(payloadItems, { couponCode })
 
       toast.push('Order is created. ', { type: 'success' })
 
@@ -38,7 +38,7 @@ export function StaffPage({ auth }) {
     brandId: '',
     categoryId: '',
     price: 0,
-    currency: 'USD',
+    currency: '',
     aisle: '',
     section: '',
     shelf: '',
@@ -353,7 +353,7 @@ export function StaffPage({ auth }) {
                   type="button"
                   className="px-4 py-2 rounded-2xl border border-slate-200 bg-white hover:bg-slate-50"
                   onClick={() => setForm({
-                    id: '', name: '', description: '', barcode: '', brandId: '', categoryId: '', price: 0, currency: 'USD', aisle: '', section: '', shelf: '', position: '', stockQty: 0, availabilityStatus: '', imageUrl: '',
+                    id: '', name: '', description: '', barcode: '', brandId: '', categoryId: '', price: 0, currency: '', aisle: '', section: '', shelf: '', position: '', stockQty: 0, availabilityStatus: '', imageUrl: '',
                   })}
                 >
                   Reset
@@ -383,7 +383,7 @@ export function StaffPage({ auth }) {
                             brandId: p.brandId || '',
                             categoryId: p.categoryId || '',
                             price: p.price ?? 0,
-                            currency: p.currency || 'USD',
+                            currency: p.currency || '',
                             aisle: p.aisle || '',
                             section: p.section || '',
                             shelf: p.shelf || '',
@@ -15,7 +15,6 @@ type Config struct {
 	MongoURI          string
 	DBName            string
 	Port              int
-	JWTSecret         string
 	StaffRegisterCode string
 }
 
@@ -25,16 +24,12 @@ func Load() (Config, error) {
 	cfg := Config{
 		MongoURI:          strings.TrimSpace(os.Getenv("MONGO_URI")),
 		DBName:            strings.TrimSpace(os.Getenv("DB_NAME")),
-		JWTSecret:         strings.TrimSpace(os.Getenv("JWT_SECRET")),
 		StaffRegisterCode: strings.TrimSpace(os.Getenv("STAFF_REGISTER_CODE")),
 	}
 
 	if cfg.DBName == "" {
 		cfg.DBName = "supermarket"
 	}
-	if cfg.JWTSecret == "" {
-		cfg.JWTSecret = "dev_secret_change_me"
-	}
 	if cfg.StaffRegisterCode == "" {
 		cfg.StaffRegisterCode = "Staff2006"
 	}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AddressHandler struct {
+	service *service.AddressService
+}
+
+func NewAddressHandler(s *service.AddressService) *AddressHandler {
+	return &AddressHandler{service: s}
+}
+
+type addressReq struct {
+	Label      string   `json:"label"`
+	Street     string   `json:"street"`
+	City       string   `json:"city"`
+	PostalCode string   `json:"postalCode"`
+	Phone      string   `json:"phone"`
+	Notes      string   `json:"notes"`
+	Latitude   *float64 `json:"latitude"`
+	Longitude  *float64 `json:"longitude"`
+	IsDefault  bool     `json:"isDefault"`
+}
+
+func (req addressReq) input() service.AddressInput {
+	return service.AddressInput{
+		Label:      req.Label,
+		Street:     req.Street,
+		City:       req.City,
+		PostalCode: req.PostalCode,
+		Phone:      req.Phone,
+		Notes:      req.Notes,
+		Latitude:   req.Latitude,
+		Longitude:  req.Longitude,
+		IsDefault:  req.IsDefault,
+	}
+}
+
+func (h *AddressHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	addresses, err := h.service.List(r.Context(), userID)
+	if err != nil {
+		writeAddressError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"addresses": addresses})
+}
+
+func (h *AddressHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req addressReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	a, err := h.service.Create(r.Context(), userID, req.input())
+	if err != nil {
+		writeAddressError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, a)
+}
+
+func (h *AddressHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var req addressReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	a, err := h.service.Update(r.Context(), userID, id, req.input())
+	if err != nil {
+		writeAddressError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+func (h *AddressHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.Delete(r.Context(), userID, id); err != nil {
+		writeAddressError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Address deleted"})
+}
+
+func (h *AddressHandler) SetDefault(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.SetDefault(r.Context(), userID, id); err != nil {
+		writeAddressError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Default address updated"})
+}
+
+func writeAddressError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrAddressNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidAddress),
+		errors.Is(err, service.ErrAddressFieldMissing):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrTooManyAddresses):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
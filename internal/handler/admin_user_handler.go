@@ -0,0 +1,234 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/middleware"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"github.com/dannieey/Assignment3_Absolute/internal/views"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AdminUserHandler struct {
+	service *service.UserService
+}
+
+func NewAdminUserHandler(s *service.UserService) *AdminUserHandler {
+	return &AdminUserHandler{service: s}
+}
+
+type adminUserListResponse struct {
+	Users      []views.AdminUser `json:"users"`
+	Total      int64             `json:"total"`
+	Page       int               `json:"page"`
+	Limit      int               `json:"limit"`
+	TotalPages int               `json:"totalPages"`
+}
+
+// ADMIN
+func (h *AdminUserHandler) List(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := repository.UserFilter{
+		Query: strings.TrimSpace(query.Get("q")),
+		Role:  strings.TrimSpace(strings.ToLower(query.Get("role"))),
+		Page:  1,
+		Limit: 20,
+	}
+	if v := query.Get("disabled"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			filter.Disabled = &b
+		}
+	}
+	if page := query.Get("page"); page != "" {
+		if val, err := strconv.Atoi(page); err == nil {
+			filter.Page = val
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if val, err := strconv.Atoi(limit); err == nil {
+			filter.Limit = val
+		}
+	}
+
+	result, err := h.service.List(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, adminUserListResponse{
+		Users:      views.NewAdminUsers(result.Users),
+		Total:      result.Total,
+		Page:       result.Page,
+		Limit:      result.Limit,
+		TotalPages: result.TotalPages,
+	})
+}
+
+// ADMIN
+func (h *AdminUserHandler) ChangeRole(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	role := strings.TrimSpace(strings.ToLower(req.Role))
+	if err := h.service.ChangeRole(r.Context(), id, role); err != nil {
+		writeUserServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Role updated"})
+}
+
+// ADMIN
+func (h *AdminUserHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	h.setDisabled(w, r, true)
+}
+
+// ADMIN
+func (h *AdminUserHandler) Enable(w http.ResponseWriter, r *http.Request) {
+	h.setDisabled(w, r, false)
+}
+
+func (h *AdminUserHandler) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	id, ok := userIDFromQuery(w, r)
+	if !ok {
+		return
+	}
+	if disabled && id == middleware.UserIDFromContext(r.Context()) {
+		http.Error(w, "You cannot disable your own account", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.SetDisabled(r.Context(), id, disabled); err != nil {
+		writeUserServiceError(w, err)
+		return
+	}
+
+	msg := "User enabled"
+	if disabled {
+		msg = "User disabled"
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": msg})
+}
+
+// ADMIN
+func (h *AdminUserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tmp, err := h.service.ResetPassword(r.Context(), id, req.Password)
+	if err != nil {
+		writeUserServiceError(w, err)
+		return
+	}
+
+	resp := map[string]string{"message": "Password reset"}
+	if tmp != "" {
+		resp["temporaryPassword"] = tmp
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// ADMIN: clears login lockouts for an account (?id=) or a client IP (?ip=).
+func (h *AdminUserHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	if ip := strings.TrimSpace(r.URL.Query().Get("ip")); ip != "" {
+		if err := h.service.UnlockIP(r.Context(), ip); err != nil {
+			writeUserServiceError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "IP unlocked"})
+		return
+	}
+
+	id, ok := userIDFromQuery(w, r)
+	if !ok {
+		return
+	}
+	if err := h.service.Unlock(r.Context(), id); err != nil {
+		writeUserServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "User unlocked"})
+}
+
+// ADMIN
+func (h *AdminUserHandler) ResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDFromQuery(w, r)
+	if !ok {
+		return
+	}
+	if err := h.service.ResetTwoFactor(r.Context(), id); err != nil {
+		writeUserServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Two-factor authentication reset"})
+}
+
+// ADMIN
+func (h *AdminUserHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := userIDFromQuery(w, r)
+	if !ok {
+		return
+	}
+	if id == middleware.UserIDFromContext(r.Context()) {
+		http.Error(w, "You cannot delete your own account here", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.Delete(r.Context(), id); err != nil {
+		writeUserServiceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "User deleted"})
+}
+
+func userIDFromQuery(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+func writeUserServiceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidRole):
+		http.Error(w, "role must be one of customer, staff, courier, admin", http.StatusBadRequest)
+	case errors.Is(err, service.ErrLastAdmin), errors.Is(err, service.ErrAdminChangeBusy):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrPasswordTooWeak):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/middleware"
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type APIKeyHandler struct {
+	service *service.APIKeyService
+}
+
+func NewAPIKeyHandler(s *service.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{service: s}
+}
+
+type createAPIKeyResponse struct {
+	models.APIKey
+	// Key is the plain API key. It is only returned once, at creation.
+	Key string `json:"key"`
+}
+
+// ADMIN
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expiresInDays"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		http.Error(w, "expiresInDays must be >= 0", http.StatusBadRequest)
+		return
+	}
+
+	ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	adminID := middleware.UserIDFromContext(r.Context())
+
+	k, raw, err := h.service.Create(r.Context(), req.Name, req.Scopes, ttl, adminID)
+	if err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, createAPIKeyResponse{APIKey: *k, Key: raw})
+}
+
+// ADMIN
+func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.service.List(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to fetch API keys", http.StatusInternalServerError)
+		return
+	}
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys":   keys,
+		"scopes": models.APIKeyScopes,
+	})
+}
+
+// ADMIN
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.Revoke(r.Context(), id); err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "API key revoked"})
+}
+
+func writeAPIKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrAPIKeyNotFound):
+		http.Error(w, "API key not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrAPIKeyNameRequired),
+		errors.Is(err, service.ErrAPIKeyScopes):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -2,10 +2,15 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
 	"github.com/dannieey/Assignment3_Absolute/internal/service"
 )
 
@@ -37,17 +42,17 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "fullName, email, password are required"})
 		return
 	}
-	role := "customer"
+	role := models.RoleCustomer
 
 	requestedRole := strings.TrimSpace(strings.ToLower(req.Role))
-	if requestedRole == "staff" {
+	if requestedRole == models.RoleStaff {
 		code := strings.TrimSpace(req.StaffCode)
 		secret := strings.TrimSpace(os.Getenv("STAFF_REGISTER_CODE"))
 		if secret == "" {
 			secret = "Staff2006"
 		}
 		if code == secret {
-			role = "staff"
+			role = models.RoleStaff
 		} else {
 			writeJSON(w, http.StatusForbidden, map[string]string{"error": "Invalid staffCode"})
 			return
@@ -84,15 +89,93 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, userID, role, err := h.svc.Login(r.Context(), req.Email, req.Password)
+	res, err := h.svc.Login(r.Context(), req.Email, req.Password, clientIP(r))
 	if err != nil {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid credentials"})
+		writeLoginError(w, err)
 		return
 	}
+	writeLoginResult(w, res)
+}
+
+type twoFactorLoginReq struct {
+	PreAuthToken string `json:"preAuthToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
 
+// Second login step for accounts with 2FA enabled.
+func (h *AuthHandler) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorLoginReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	if req.PreAuthToken == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "preAuthToken is required"})
+		return
+	}
+
+	res, err := h.svc.CompleteTwoFactor(r.Context(), req.PreAuthToken, req.Code, req.RecoveryCode, clientIP(r))
+	if err != nil {
+		writeLoginError(w, err)
+		return
+	}
+	writeLoginResult(w, res)
+}
+
+func writeLoginResult(w http.ResponseWriter, res *service.LoginResult) {
+	if res.PreAuthToken != "" {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"twoFactorRequired": true,
+			"twoFactorStep":     res.TwoFactorStep,
+			"preAuthToken":      res.PreAuthToken,
+			"userId":            res.UserID.Hex(),
+		})
+		return
+	}
 	writeJSON(w, http.StatusOK, map[string]string{
-		"token":  token,
-		"userId": userID.Hex(),
-		"role":   role,
+		"token":  res.Token,
+		"userId": res.UserID.Hex(),
+		"role":   res.Role,
 	})
 }
+
+func writeLoginError(w http.ResponseWriter, err error) {
+	var locked *service.LockedError
+	switch {
+	case errors.As(err, &locked):
+		secs := int(math.Ceil(locked.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		writeJSON(w, http.StatusTooManyRequests, map[string]any{
+			"error":      "Too many failed login attempts",
+			"retryAfter": secs,
+		})
+	case errors.Is(err, service.ErrAccountDisabled):
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "Account disabled"})
+	case errors.Is(err, service.ErrInvalidPreAuth):
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Login session expired, sign in again"})
+	case errors.Is(err, service.ErrInvalidTwoFactorCode),
+		errors.Is(err, service.ErrTwoFactorCodeRequired):
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidCredentials):
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid credentials"})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
+
+// clientIP prefers X-Forwarded-For only when TRUST_PROXY is set, since the
+// header is otherwise trivially spoofed to dodge the per-IP login limits.
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY") == "true" {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
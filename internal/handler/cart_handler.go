@@ -2,7 +2,9 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/dannieey/Assignment3_Absolute/internal/middleware"
 	"github.com/dannieey/Assignment3_Absolute/internal/service"
@@ -128,6 +130,40 @@ func (h *CartHandler) Remove(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"message": "Item removed from cart"})
 }
 
+// Coupon puts {"code"} on the cart, or takes the coupon off when code is
+// empty.
+func (h *CartHandler) Coupon(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	err = h.service.ApplyCoupon(r.Context(), userID, req.Code)
+	if errors.Is(err, service.ErrCouponNotApplicable) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update coupon", http.StatusInternalServerError)
+		return
+	}
+
+	if strings.TrimSpace(req.Code) == "" {
+		writeJSON(w, http.StatusOK, map[string]string{"message": "Coupon removed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Coupon applied"})
+}
+
 func getUserIDFromContext(r *http.Request) (primitive.ObjectID, error) {
 	userIDHex, ok := r.Context().Value(middleware.CtxUserID).(string)
 	if !ok || userIDHex == "" {
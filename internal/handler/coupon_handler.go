@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CouponHandler struct {
+	service *service.CouponService
+}
+
+func NewCouponHandler(s *service.CouponService) *CouponHandler {
+	return &CouponHandler{service: s}
+}
+
+// List shows coupons, optionally of one ?promotionId= and ?batch=.
+func (h *CouponHandler) List(w http.ResponseWriter, r *http.Request) {
+	var promotionID primitive.ObjectID
+	if s := r.URL.Query().Get("promotionId"); s != "" {
+		var err error
+		if promotionID, err = primitive.ObjectIDFromHex(s); err != nil {
+			http.Error(w, "Invalid promotionId", http.StatusBadRequest)
+			return
+		}
+	}
+	coupons, err := h.service.List(r.Context(), promotionID, r.URL.Query().Get("batch"))
+	if err != nil {
+		writeCouponError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"coupons": coupons})
+}
+
+func (h *CouponHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var c models.Coupon
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.Create(r.Context(), &c); err != nil {
+		writeCouponError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, c)
+}
+
+type generateCouponsReq struct {
+	PromotionID        primitive.ObjectID `json:"promotionId"`
+	Count              int                `json:"count"`
+	Prefix             string             `json:"prefix"`
+	Batch              string             `json:"batch"`
+	MaxUses            int                `json:"maxUses"`
+	MaxUsesPerCustomer int                `json:"maxUsesPerCustomer"`
+	MinBasket          models.Money       `json:"minBasket"`
+	ExpiresAt          *time.Time         `json:"expiresAt"`
+}
+
+// Generate creates a batch of random codes for printing.
+func (h *CouponHandler) Generate(w http.ResponseWriter, r *http.Request) {
+	var req generateCouponsReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	coupons, err := h.service.Generate(r.Context(), models.Coupon{
+		PromotionID:        req.PromotionID,
+		Batch:              req.Batch,
+		MaxUses:            req.MaxUses,
+		MaxUsesPerCustomer: req.MaxUsesPerCustomer,
+		MinBasket:          req.MinBasket,
+		ExpiresAt:          req.ExpiresAt,
+	}, req.Count, req.Prefix)
+	if err != nil {
+		writeCouponError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"coupons": coupons})
+}
+
+func (h *CouponHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.Delete(r.Context(), id); err != nil {
+		writeCouponError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Coupon deleted"})
+}
+
+func writeCouponError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrCouponNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidCoupon):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
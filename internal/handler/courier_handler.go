@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Signatures arrive base64-encoded in JSON, so allow some headroom over the
+// 512KB image limit.
+const maxDeliveryBody = 1 << 20
+
+type CourierHandler struct {
+	service *service.CourierService
+}
+
+func NewCourierHandler(s *service.CourierService) *CourierHandler {
+	return &CourierHandler{service: s}
+}
+
+// Staff side
+
+func (h *CourierHandler) ListCouriers(w http.ResponseWriter, r *http.Request) {
+	couriers, err := h.service.ListCouriers(r.Context())
+	if err != nil {
+		writeCourierError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"couriers": couriers})
+}
+
+func (h *CourierHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	list, err := h.service.Deliveries(r.Context(), r.URL.Query().Get("status"))
+	if err != nil {
+		writeCourierError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deliveries": list})
+}
+
+type assignCourierReq struct {
+	OrderID   string `json:"orderId"`
+	CourierID string `json:"courierId"`
+}
+
+func (h *CourierHandler) Assign(w http.ResponseWriter, r *http.Request) {
+	var req assignCourierReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	orderID, err := primitive.ObjectIDFromHex(req.OrderID)
+	if err != nil {
+		http.Error(w, "Invalid orderId", http.StatusBadRequest)
+		return
+	}
+	courierID, err := primitive.ObjectIDFromHex(req.CourierID)
+	if err != nil {
+		http.Error(w, "Invalid courierId", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.Assign(r.Context(), orderID, courierID); err != nil {
+		writeCourierError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Courier assigned"})
+}
+
+func (h *CourierHandler) Signature(w http.ResponseWriter, r *http.Request) {
+	orderID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	data, contentType, err := h.service.Signature(r.Context(), orderID)
+	if err != nil {
+		writeCourierError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// Courier side
+
+func (h *CourierHandler) MyDeliveries(w http.ResponseWriter, r *http.Request) {
+	courierID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	list, err := h.service.Assigned(r.Context(), courierID)
+	if err != nil {
+		writeCourierError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"deliveries": list})
+}
+
+func (h *CourierHandler) PickedUp(w http.ResponseWriter, r *http.Request) {
+	courierID, orderID, ok := courierAndOrder(w, r)
+	if !ok {
+		return
+	}
+	if err := h.service.PickedUp(r.Context(), courierID, orderID); err != nil {
+		writeCourierError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Marked as picked up"})
+}
+
+type deliveredReq struct {
+	Code string `json:"code"`
+	// Signature is a base64 PNG or JPEG, optionally as a data URL.
+	Signature string `json:"signature"`
+}
+
+func (h *CourierHandler) Delivered(w http.ResponseWriter, r *http.Request) {
+	courierID, orderID, ok := courierAndOrder(w, r)
+	if !ok {
+		return
+	}
+
+	var req deliveredReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDeliveryBody)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	var signature []byte
+	if req.Signature != "" {
+		raw := req.Signature
+		if i := strings.Index(raw, ","); strings.HasPrefix(raw, "data:") && i > 0 {
+			raw = raw[i+1:]
+		}
+		var err error
+		signature, err = base64.StdEncoding.DecodeString(raw)
+		if err != nil {
+			http.Error(w, "signature must be base64", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.service.Delivered(r.Context(), courierID, orderID, req.Code, signature); err != nil {
+		writeCourierError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Marked as delivered"})
+}
+
+type deliveryFailedReq struct {
+	Reason string `json:"reason"`
+}
+
+func (h *CourierHandler) Failed(w http.ResponseWriter, r *http.Request) {
+	courierID, orderID, ok := courierAndOrder(w, r)
+	if !ok {
+		return
+	}
+	var req deliveryFailedReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.Failed(r.Context(), courierID, orderID, req.Reason); err != nil {
+		writeCourierError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Marked as failed"})
+}
+
+func courierAndOrder(w http.ResponseWriter, r *http.Request) (courierID, orderID primitive.ObjectID, ok bool) {
+	courierID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return courierID, orderID, false
+	}
+	orderID, err = primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return courierID, orderID, false
+	}
+	return courierID, orderID, true
+}
+
+func writeCourierError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrOrderNotFound),
+		errors.Is(err, service.ErrCourierNotFound),
+		errors.Is(err, service.ErrProofNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrOrderNotAssignable),
+		errors.Is(err, service.ErrDeliveryTransition):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrProofRequired),
+		errors.Is(err, service.ErrInvalidSignature),
+		errors.Is(err, service.ErrFailureReasonMissing):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrWrongDeliveryCode):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
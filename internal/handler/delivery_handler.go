@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DeliveryHandler struct {
+	service *service.DeliveryService
+}
+
+func NewDeliveryHandler(s *service.DeliveryService) *DeliveryHandler {
+	return &DeliveryHandler{service: s}
+}
+
+// Quote prices delivery to ?addressId= and lists the open windows.
+func (h *DeliveryHandler) Quote(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	addressID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("addressId"))
+	if err != nil {
+		http.Error(w, "Invalid addressId", http.StatusBadRequest)
+		return
+	}
+
+	q, err := h.service.Quote(r.Context(), userID, addressID)
+	if err != nil {
+		writeDeliveryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, q)
+}
+
+func (h *DeliveryHandler) ListZones(w http.ResponseWriter, r *http.Request) {
+	zones, err := h.service.ListZones(r.Context())
+	if err != nil {
+		writeDeliveryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"zones": zones})
+}
+
+func (h *DeliveryHandler) CreateZone(w http.ResponseWriter, r *http.Request) {
+	var z models.DeliveryZone
+	if err := json.NewDecoder(r.Body).Decode(&z); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.CreateZone(r.Context(), &z); err != nil {
+		writeDeliveryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, z)
+}
+
+func (h *DeliveryHandler) UpdateZone(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	var z models.DeliveryZone
+	if err := json.NewDecoder(r.Body).Decode(&z); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.UpdateZone(r.Context(), id, &z); err != nil {
+		writeDeliveryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, z)
+}
+
+func (h *DeliveryHandler) DeleteZone(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.DeleteZone(r.Context(), id); err != nil {
+		writeDeliveryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Delivery zone deleted"})
+}
+
+func writeDeliveryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrDeliveryZoneNotFound),
+		errors.Is(err, service.ErrAddressNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidDeliveryZone):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrNoDeliveryZone):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
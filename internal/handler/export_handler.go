@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ExportHandler struct {
+	service *service.ExportService
+}
+
+func NewExportHandler(s *service.ExportService) *ExportHandler {
+	return &ExportHandler{service: s}
+}
+
+// Export sends the archive directly, or answers 202 with a job to poll when
+// the account is large enough to be exported in the background.
+func (h *ExportHandler) Export(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	data, fileName, job, err := h.service.Start(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to export data", http.StatusInternalServerError)
+		return
+	}
+	if job != nil {
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"message":   "Export started",
+			"export":    job,
+			"statusUrl": "/profile/export/status?id=" + job.ID.Hex(),
+		})
+		return
+	}
+
+	writeArchive(w, data, fileName)
+}
+
+func (h *ExportHandler) Status(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	exportID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	e, err := h.service.Status(r.Context(), userID, exportID)
+	if err != nil {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+
+	resp := map[string]any{"export": e}
+	if e.Status == models.ExportReady {
+		resp["downloadUrl"] = "/profile/export/download?id=" + e.ID.Hex()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *ExportHandler) Download(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	exportID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	data, fileName, err := h.service.Download(r.Context(), userID, exportID)
+	switch {
+	case errors.Is(err, service.ErrExportNotFound):
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrExportNotReady):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, service.ErrExportExpired):
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	case err != nil:
+		http.Error(w, "Failed to load export", http.StatusInternalServerError)
+		return
+	}
+
+	writeArchive(w, data, fileName)
+}
+
+func writeArchive(w http.ResponseWriter, data []byte, fileName string) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
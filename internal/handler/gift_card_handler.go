@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type GiftCardHandler struct {
+	service *service.StoredValueService
+}
+
+func NewGiftCardHandler(s *service.StoredValueService) *GiftCardHandler {
+	return &GiftCardHandler{service: s}
+}
+
+type giftCardBalanceReq struct {
+	Code string `json:"code"`
+	PIN  string `json:"pin"`
+}
+
+// Balance lets anyone holding a gift card check what is left on it.
+func (h *GiftCardHandler) Balance(w http.ResponseWriter, r *http.Request) {
+	var req giftCardBalanceReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	g, err := h.service.Check(r.Context(), req.Code, req.PIN)
+	if err != nil {
+		writeGiftCardError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"code":      g.MaskedCode(),
+		"balance":   g.Balance,
+		"currency":  g.Currency,
+		"expiresAt": g.ExpiresAt,
+		"disabled":  g.Disabled,
+	})
+}
+
+// Staff side
+
+func (h *GiftCardHandler) List(w http.ResponseWriter, r *http.Request) {
+	cards, err := h.service.List(r.Context())
+	if err != nil {
+		writeGiftCardError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"giftCards": cards})
+}
+
+type issueGiftCardsReq struct {
+	Value     models.Money `json:"value"`
+	Count     int          `json:"count"`
+	ExpiresAt *time.Time   `json:"expiresAt"`
+}
+
+// Issue creates gift cards. Their PINs are in the response and nowhere else.
+func (h *GiftCardHandler) Issue(w http.ResponseWriter, r *http.Request) {
+	staffID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req issueGiftCardsReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Count == 0 {
+		req.Count = 1
+	}
+	cards, err := h.service.Issue(r.Context(), req.Value, req.Count, req.ExpiresAt, staffID)
+	if err != nil {
+		writeGiftCardError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"giftCards": cards})
+}
+
+func (h *GiftCardHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	h.setDisabled(w, r, true)
+}
+
+func (h *GiftCardHandler) Enable(w http.ResponseWriter, r *http.Request) {
+	h.setDisabled(w, r, false)
+}
+
+func (h *GiftCardHandler) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.SetDisabled(r.Context(), id, disabled); err != nil {
+		writeGiftCardError(w, err)
+		return
+	}
+	msg := "Gift card enabled"
+	if disabled {
+		msg = "Gift card disabled"
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": msg})
+}
+
+// Ledger shows every transfer in and out of gift card ?id=.
+func (h *GiftCardHandler) Ledger(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	st, err := h.service.Statement(r.Context(), id)
+	if err != nil {
+		writeGiftCardError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func writeGiftCardError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrGiftCardNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidGiftCard):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrWrongGiftCardPIN):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrGiftCardLocked):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+)
+
+type JWKSHandler struct {
+	keys *service.KeyManager
+}
+
+func NewJWKSHandler(keys *service.KeyManager) *JWKSHandler {
+	return &JWKSHandler{keys: keys}
+}
+
+// PUBLIC: lets other services verify our access tokens.
+func (h *JWKSHandler) Get(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, h.keys.JWKS())
+}
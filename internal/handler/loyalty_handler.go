@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+)
+
+type LoyaltyHandler struct {
+	service *service.LoyaltyService
+}
+
+func NewLoyaltyHandler(s *service.LoyaltyService) *LoyaltyHandler {
+	return &LoyaltyHandler{service: s}
+}
+
+// Statement shows the current user's points balance and history.
+func (h *LoyaltyHandler) Statement(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	st, err := h.service.Statement(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *LoyaltyHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	st, err := h.service.Settings(r.Context())
+	if err != nil {
+		writeLoyaltyError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *LoyaltyHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var st models.LoyaltySettings
+	if err := json.NewDecoder(r.Body).Decode(&st); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.UpdateSettings(r.Context(), &st); err != nil {
+		writeLoyaltyError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func writeLoyaltyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidLoyaltySettings):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
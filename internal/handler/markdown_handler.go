@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/middleware"
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MarkdownHandler struct {
+	service *service.MarkdownService
+}
+
+func NewMarkdownHandler(s *service.MarkdownService) *MarkdownHandler {
+	return &MarkdownHandler{service: s}
+}
+
+func (h *MarkdownHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	st, err := h.service.Settings(r.Context())
+	if err != nil {
+		writeMarkdownError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *MarkdownHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var st models.MarkdownSettings
+	if err := json.NewDecoder(r.Body).Decode(&st); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.UpdateSettings(r.Context(), &st); err != nil {
+		writeMarkdownError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// Batches lists batches of ?productId= (all products when empty); ?open=true
+// leaves out cleared ones.
+func (h *MarkdownHandler) Batches(w http.ResponseWriter, r *http.Request) {
+	var productID primitive.ObjectID
+	if s := r.URL.Query().Get("productId"); s != "" {
+		id, err := primitive.ObjectIDFromHex(s)
+		if err != nil {
+			http.Error(w, "Invalid productId", http.StatusBadRequest)
+			return
+		}
+		productID = id
+	}
+	list, err := h.service.Batches(r.Context(), productID, r.URL.Query().Get("open") == "true")
+	if err != nil {
+		writeMarkdownError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"batches": list})
+}
+
+func (h *MarkdownHandler) Receive(w http.ResponseWriter, r *http.Request) {
+	var b models.ProductBatch
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.Receive(r.Context(), &b, middleware.UserIDFromContext(r.Context())); err != nil {
+		writeMarkdownError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, b)
+}
+
+// Clear closes batch ?id= once it has sold out or been written off.
+func (h *MarkdownHandler) Clear(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.Clear(r.Context(), id); err != nil {
+		writeMarkdownError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Batch cleared"})
+}
+
+func writeMarkdownError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidMarkdownSettings), errors.Is(err, service.ErrInvalidBatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrBatchNotFound), errors.Is(err, service.ErrBatchProductNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+)
+
+// The login state also lives in this cookie, so a callback only completes
+// in the browser that started the login.
+const (
+	oidcStateCookie    = "oidc_state"
+	oidcStateCookieTTL = 10 * time.Minute
+)
+
+// OIDCHandler serves the browser side of OIDC login. svc is nil when no
+// provider is configured.
+type OIDCHandler struct {
+	svc *service.OIDCService
+}
+
+func NewOIDCHandler(svc *service.OIDCService) *OIDCHandler {
+	return &OIDCHandler{svc: svc}
+}
+
+// Login redirects to the provider. ?redirect= is a path on the frontend to
+// return to afterwards.
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if h.svc == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "OIDC login is not configured"})
+		return
+	}
+
+	redirectTo := r.URL.Query().Get("redirect")
+	if !isLocalPath(redirectTo) {
+		redirectTo = "/"
+	}
+
+	target, state, err := h.svc.AuthCodeURL(r.Context(), redirectTo)
+	if err != nil {
+		writeOIDCError(w, err)
+		return
+	}
+	setOIDCStateCookie(w, r, state, int(oidcStateCookieTTL.Seconds()))
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// Callback is the redirect URI registered with the provider. On success the
+// browser is sent to the frontend with the token, or the pre-auth token when
+// 2FA is still due, in the URL fragment, which never reaches server logs.
+// Without FRONTEND_URL the result is returned as JSON, which is handy when
+// testing against a stub provider.
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	if h.svc == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "OIDC login is not configured"})
+		return
+	}
+
+	var browserState string
+	if c, err := r.Cookie(oidcStateCookie); err == nil {
+		browserState = c.Value
+	}
+	setOIDCStateCookie(w, r, "", -1)
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		h.finishWithError(w, r, http.StatusUnauthorized, e)
+		return
+	}
+	if q.Get("state") == "" || q.Get("code") == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "state and code are required"})
+		return
+	}
+
+	res, redirectTo, err := h.svc.Callback(r.Context(), q.Get("state"), browserState, q.Get("code"))
+	frontend := oidcFrontendURL()
+	if err != nil {
+		if frontend == "" {
+			writeOIDCError(w, err)
+			return
+		}
+		log.Printf("oidc callback: %v", err)
+		h.finishWithError(w, r, http.StatusUnauthorized, oidcErrorCode(err))
+		return
+	}
+	if frontend == "" {
+		writeLoginResult(w, res)
+		return
+	}
+	fragment := url.Values{
+		"userId": {res.UserID.Hex()},
+		"role":   {res.Role},
+	}
+	if res.PreAuthToken != "" {
+		fragment.Set("preAuthToken", res.PreAuthToken)
+		fragment.Set("twoFactorStep", res.TwoFactorStep)
+	} else {
+		fragment.Set("token", res.Token)
+	}
+	http.Redirect(w, r, frontend+redirectTo+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// setOIDCStateCookie sets the state cookie for maxAge seconds, or deletes it
+// when maxAge is negative. It has to survive the top-level redirect back from
+// the provider, hence SameSite=Lax.
+func setOIDCStateCookie(w http.ResponseWriter, r *http.Request, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.HasPrefix(oidcFrontendURL(), "https://"),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   maxAge,
+	})
+}
+
+func (h *OIDCHandler) finishWithError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	frontend := oidcFrontendURL()
+	if frontend == "" {
+		writeJSON(w, status, map[string]string{"error": code})
+		return
+	}
+	http.Redirect(w, r, frontend+"/login#"+url.Values{"error": {code}}.Encode(), http.StatusFound)
+}
+
+func oidcErrorCode(err error) string {
+	switch {
+	case errors.Is(err, service.ErrOIDCInvalidState):
+		return "login_expired"
+	case errors.Is(err, service.ErrAccountDisabled):
+		return "account_disabled"
+	case errors.Is(err, service.ErrOIDCEmailConflict):
+		return "email_conflict"
+	case errors.Is(err, service.ErrOIDCSignupDisabled):
+		return "account_not_linked"
+	default:
+		return "login_failed"
+	}
+}
+
+func writeOIDCError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrOIDCInvalidState):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrAccountDisabled):
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "Account disabled"})
+	case errors.Is(err, service.ErrOIDCEmailConflict):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrOIDCSignupDisabled),
+		errors.Is(err, service.ErrOIDCEmailRequired),
+		errors.Is(err, service.ErrOIDCInvalidToken):
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrOIDCProvider):
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
+
+func oidcFrontendURL() string {
+	return strings.TrimRight(strings.TrimSpace(os.Getenv("FRONTEND_URL")), "/")
+}
+
+// isLocalPath rejects anything that could send the browser to another host.
+func isLocalPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.ContainsAny(p, "\\#")
+}
@@ -2,7 +2,9 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/dannieey/Assignment3_Absolute/internal/middleware"
 	"github.com/dannieey/Assignment3_Absolute/internal/models"
@@ -19,7 +21,15 @@ func NewOrderHandler(s *service.OrderService) *OrderHandler {
 }
 
 type createOrderReq struct {
-	Items []struct {
+	AddressID      string `json:"addressId"`
+	DeliveryWindow string `json:"deliveryWindow"`
+	PickupSlot     string `json:"pickupSlot"`
+	CouponCode     string `json:"couponCode"`
+	RedeemPoints   int64  `json:"redeemPoints"`
+	GiftCardCode   string `json:"giftCardCode"`
+	GiftCardPIN    string `json:"giftCardPin"`
+	StoreCredit    bool   `json:"storeCredit"`
+	Items          []struct {
 		ProductID string `json:"productId"`
 		Quantity  int    `json:"quantity"`
 	} `json:"items"`
@@ -70,7 +80,55 @@ func (h *OrderHandler) Create(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
-	id, err := h.service.Create(r.Context(), order)
+	var opts service.CreateOrderOptions
+	if req.AddressID != "" {
+		opts.AddressID, err = primitive.ObjectIDFromHex(req.AddressID)
+		if err != nil {
+			http.Error(w, "Invalid addressId", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.DeliveryWindow != "" {
+		opts.DeliveryWindow, err = time.Parse(time.RFC3339, req.DeliveryWindow)
+		if err != nil {
+			http.Error(w, "deliveryWindow must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.PickupSlot != "" {
+		opts.PickupSlot, err = time.Parse(time.RFC3339, req.PickupSlot)
+		if err != nil {
+			http.Error(w, "pickupSlot must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.RedeemPoints < 0 {
+		http.Error(w, "redeemPoints must not be negative", http.StatusBadRequest)
+		return
+	}
+	opts.CouponCode = req.CouponCode
+	opts.RedeemPoints = req.RedeemPoints
+	opts.GiftCardCode = req.GiftCardCode
+	opts.GiftCardPIN = req.GiftCardPIN
+	opts.StoreCredit = req.StoreCredit
+
+	id, err := h.service.Create(r.Context(), order, opts)
+	if errors.Is(err, service.ErrGiftCardNotFound) || errors.Is(err, service.ErrWrongGiftCardPIN) ||
+		errors.Is(err, service.ErrGiftCardLocked) {
+		writeGiftCardError(w, err)
+		return
+	}
+	if errors.Is(err, service.ErrPickupSlotFull) || errors.Is(err, service.ErrStoredValueChanged) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, service.ErrNoDeliveryZone) || errors.Is(err, service.ErrBelowMinimumOrder) ||
+		errors.Is(err, service.ErrCouponNotApplicable) || errors.Is(err, service.ErrLoyaltyDisabled) ||
+		errors.Is(err, service.ErrNotEnoughPoints) || errors.Is(err, service.ErrGiftCardUnusable) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to create order: "+err.Error(), http.StatusBadRequest)
 		return
@@ -128,3 +186,42 @@ func (h *OrderHandler) GetTracking(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusOK, tracking)
 }
+
+// Cancel lets the current user call off one of their orders, ?id=, before it
+// is prepared.
+func (h *OrderHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.cancel(w, r, userID)
+}
+
+// StaffCancel cancels any order, ?id=, before it is prepared.
+func (h *OrderHandler) StaffCancel(w http.ResponseWriter, r *http.Request) {
+	h.cancel(w, r, primitive.NilObjectID)
+}
+
+func (h *OrderHandler) cancel(w http.ResponseWriter, r *http.Request, userID primitive.ObjectID) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	note := "Cancelled by customer"
+	if userID.IsZero() {
+		note = "Cancelled by staff"
+	}
+	err = h.service.Cancel(r.Context(), id, userID, note)
+	switch {
+	case errors.Is(err, service.ErrOrderNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrOrderNotCancellable):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, map[string]string{"message": "Order cancelled"})
+	}
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const maxWebhookBody = 64 << 10
+
+type PaymentHandler struct {
+	service *service.PaymentService
+}
+
+func NewPaymentHandler(s *service.PaymentService) *PaymentHandler {
+	return &PaymentHandler{service: s}
+}
+
+type payOrderReq struct {
+	Token string `json:"token"`
+}
+
+// Pay authorizes payment for ?id= with a card token. A declined card answers
+// 402 with the payment record so the client can show the reason.
+func (h *PaymentHandler) Pay(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	orderID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	var req payOrderReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	p, err := h.service.Pay(r.Context(), userID, orderID, req.Token)
+	if errors.Is(err, service.ErrPaymentDeclined) {
+		writeJSON(w, http.StatusPaymentRequired, p)
+		return
+	}
+	if err != nil {
+		writePaymentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// Webhook receives provider notifications. It is public; authenticity comes
+// from the provider's signature.
+func (h *PaymentHandler) Webhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.HandleWebhook(r.Context(), payload, r.Header); err != nil {
+		writePaymentError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// List shows staff every payment attempt for ?orderId=.
+func (h *PaymentHandler) List(w http.ResponseWriter, r *http.Request) {
+	orderID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("orderId"))
+	if err != nil {
+		http.Error(w, "Invalid orderId", http.StatusBadRequest)
+		return
+	}
+	list, err := h.service.ForOrder(r.Context(), orderID)
+	if err != nil {
+		writePaymentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"payments": list})
+}
+
+func (h *PaymentHandler) Capture(w http.ResponseWriter, r *http.Request) {
+	orderID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("orderId"))
+	if err != nil {
+		http.Error(w, "Invalid orderId", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.Capture(r.Context(), orderID); err != nil {
+		writePaymentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Payment captured"})
+}
+
+func (h *PaymentHandler) Void(w http.ResponseWriter, r *http.Request) {
+	orderID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("orderId"))
+	if err != nil {
+		http.Error(w, "Invalid orderId", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.Void(r.Context(), orderID); err != nil {
+		writePaymentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Payment voided"})
+}
+
+func writePaymentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrOrderNotFound),
+		errors.Is(err, service.ErrPaymentNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrOrderAlreadyPaid),
+		errors.Is(err, service.ErrOrderCancelled),
+		errors.Is(err, service.ErrPaymentState),
+		errors.Is(err, service.ErrProviderRejected):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrPaymentTokenRequired),
+		errors.Is(err, service.ErrInvalidWebhook):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+)
+
+type PickupHandler struct {
+	service *service.PickupService
+}
+
+func NewPickupHandler(s *service.PickupService) *PickupHandler {
+	return &PickupHandler{service: s}
+}
+
+// Slots lists the pickup slots of ?date=YYYY-MM-DD (store time, default
+// today) with how many places are left.
+func (h *PickupHandler) Slots(w http.ResponseWriter, r *http.Request) {
+	slots, err := h.service.Slots(r.Context(), r.URL.Query().Get("date"))
+	if err != nil {
+		writePickupError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"slots": slots})
+}
+
+func (h *PickupHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	st, err := h.service.Settings(r.Context())
+	if err != nil {
+		writePickupError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *PickupHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var st models.PickupSettings
+	if err := json.NewDecoder(r.Body).Decode(&st); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.UpdateSettings(r.Context(), &st); err != nil {
+		writePickupError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// Orders is the staff view of ?date= with each slot's orders.
+func (h *PickupHandler) Orders(w http.ResponseWriter, r *http.Request) {
+	slots, err := h.service.OrdersBySlot(r.Context(), r.URL.Query().Get("date"))
+	if err != nil {
+		writePickupError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"slots": slots})
+}
+
+func writePickupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidDate),
+		errors.Is(err, service.ErrInvalidPickupSettings):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/middleware"
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PriceHandler struct {
+	service *service.PriceService
+}
+
+func NewPriceHandler(s *service.PriceService) *PriceHandler {
+	return &PriceHandler{service: s}
+}
+
+type pricePoint struct {
+	Price    models.Money `json:"price"`
+	Currency string       `json:"currency"`
+	At       time.Time    `json:"at"`
+}
+
+// History serves the storefront's price chart for product {id}: one point
+// per price the product has had, optionally since ?since= (RFC 3339).
+func (h *PriceHandler) History(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	since, ok := parseSince(w, r)
+	if !ok {
+		return
+	}
+	changes, err := h.service.History(r.Context(), id, since)
+	if err != nil {
+		writePriceError(w, err)
+		return
+	}
+	points := make([]pricePoint, len(changes))
+	for i, c := range changes {
+		points[i] = pricePoint{Price: c.NewPrice, Currency: c.Currency, At: c.ChangedAt}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"productId": id.Hex(), "points": points})
+}
+
+// Staff side
+
+// StaffHistory returns the full price change records of product ?id=,
+// including who made each change.
+func (h *PriceHandler) StaffHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	since, ok := parseSince(w, r)
+	if !ok {
+		return
+	}
+	changes, err := h.service.History(r.Context(), id, since)
+	if err != nil {
+		writePriceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"changes": changes})
+}
+
+// Schedules lists price schedules, filtered by ?productId= and ?status=.
+func (h *PriceHandler) Schedules(w http.ResponseWriter, r *http.Request) {
+	var productID primitive.ObjectID
+	if s := r.URL.Query().Get("productId"); s != "" {
+		id, err := primitive.ObjectIDFromHex(s)
+		if err != nil {
+			http.Error(w, "Invalid productId", http.StatusBadRequest)
+			return
+		}
+		productID = id
+	}
+	list, err := h.service.Schedules(r.Context(), productID, r.URL.Query().Get("status"))
+	if err != nil {
+		writePriceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"schedules": list})
+}
+
+type schedulePriceReq struct {
+	ProductID   primitive.ObjectID `json:"productId"`
+	Price       models.Money       `json:"price"`
+	EffectiveAt time.Time          `json:"effectiveAt"`
+	Note        string             `json:"note"`
+}
+
+func (h *PriceHandler) Schedule(w http.ResponseWriter, r *http.Request) {
+	var req schedulePriceReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	sc := models.PriceSchedule{
+		ProductID:   req.ProductID,
+		Price:       req.Price,
+		EffectiveAt: req.EffectiveAt,
+		Note:        req.Note,
+	}
+	if err := h.service.Schedule(r.Context(), &sc, priceActor(r)); err != nil {
+		writePriceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, sc)
+}
+
+// CancelSchedule calls off pending schedule ?id=.
+func (h *PriceHandler) CancelSchedule(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.Cancel(r.Context(), id); err != nil {
+		writePriceError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Price schedule cancelled"})
+}
+
+// priceActor is the staff user or API key behind the request.
+func priceActor(r *http.Request) service.PriceActor {
+	by := service.PriceActor{UserID: middleware.UserIDFromContext(r.Context())}
+	if key := middleware.APIKeyFromContext(r.Context()); key != nil {
+		by.APIKeyID = key.ID
+	}
+	return by
+}
+
+func parseSince(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	s := r.URL.Query().Get("since")
+	if s == "" {
+		return time.Time{}, true
+	}
+	since, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		http.Error(w, "Invalid since, expected RFC 3339", http.StatusBadRequest)
+		return time.Time{}, false
+	}
+	return since, true
+}
+
+func writePriceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrPriceProductNotFound), errors.Is(err, service.ErrPriceScheduleNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidPriceSchedule):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrPriceScheduleState):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -65,16 +66,20 @@ func (h *ProductHandler) ListWithFilter(w http.ResponseWriter, r *http.Request)
 	}
 
 	if minPrice := query.Get("minPrice"); minPrice != "" {
-		if val, err := strconv.ParseFloat(minPrice, 64); err == nil {
+		if val, err := models.ParseMoney(minPrice); err == nil {
 			filter.MinPrice = &val
 		}
 	}
 	if maxPrice := query.Get("maxPrice"); maxPrice != "" {
-		if val, err := strconv.ParseFloat(maxPrice, 64); err == nil {
+		if val, err := models.ParseMoney(maxPrice); err == nil {
 			filter.MaxPrice = &val
 		}
 	}
 
+	if query.Get("reduced") == "true" {
+		filter.Reduced = true
+	}
+
 	if order := query.Get("order"); order == "desc" {
 		filter.SortOrder = -1
 	}
@@ -107,7 +112,11 @@ func (h *ProductHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := h.service.Create(r.Context(), &p)
+	id, err := h.service.Create(r.Context(), &p, priceActor(r))
+	if errors.Is(err, service.ErrInvalidProduct) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -130,7 +139,16 @@ func (h *ProductHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.Update(r.Context(), id, &p); err != nil {
+	err = h.service.Update(r.Context(), id, &p, priceActor(r))
+	if errors.Is(err, service.ErrInvalidProduct) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, service.ErrProductNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
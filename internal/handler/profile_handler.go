@@ -1,32 +1,34 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/dannieey/Assignment3_Absolute/internal/middleware"
 	"github.com/dannieey/Assignment3_Absolute/internal/repository"
 	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"github.com/dannieey/Assignment3_Absolute/internal/views"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type ProfileHandler struct {
-	userRepo     repository.UserRepo
-	orderService *service.OrderService
+	userRepo       repository.UserRepo
+	orderService   *service.OrderService
+	profileService *service.ProfileService
 }
 
-func NewProfileHandler(userRepo repository.UserRepo, orderService *service.OrderService) *ProfileHandler {
+func NewProfileHandler(userRepo repository.UserRepo, orderService *service.OrderService, profileService *service.ProfileService) *ProfileHandler {
 	return &ProfileHandler{
-		userRepo:     userRepo,
-		orderService: orderService,
+		userRepo:       userRepo,
+		orderService:   orderService,
+		profileService: profileService,
 	}
 }
 
 type ProfileResponse struct {
-	ID          string `json:"id"`
-	Email       string `json:"email"`
-	FullName    string `json:"fullName"`
-	Role        string `json:"role"`
-	OrdersCount int    `json:"ordersCount"`
+	views.SelfUser
+	OrdersCount int `json:"ordersCount"`
 }
 
 func (h *ProfileHandler) Get(w http.ResponseWriter, r *http.Request) {
@@ -55,12 +57,167 @@ func (h *ProfileHandler) Get(w http.ResponseWriter, r *http.Request) {
 	}
 
 	profile := ProfileResponse{
-		ID:          user.ID.Hex(),
-		Email:       user.Email,
-		FullName:    user.FullName,
-		Role:        user.Role,
+		SelfUser:    views.NewSelfUser(user),
 		OrdersCount: ordersCount,
 	}
 
 	writeJSON(w, http.StatusOK, profile)
 }
+
+func (h *ProfileHandler) Update(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		FullName *string `json:"fullName"`
+		Email    *string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	pending, err := h.profileService.Update(r.Context(), userID, service.ProfileUpdate{
+		FullName: req.FullName,
+		Email:    req.Email,
+	})
+	if err != nil {
+		writeProfileError(w, err)
+		return
+	}
+
+	msg := "Profile updated"
+	if pending {
+		msg = "Profile updated. Check your new email address to confirm the change"
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"message":      msg,
+		"emailPending": pending,
+	})
+}
+
+func (h *ProfileHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Accounts without a password send the emailed code instead of
+	// currentPassword.
+	var req struct {
+		CurrentPassword string `json:"currentPassword"`
+		Code            string `json:"code"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if (req.CurrentPassword == "" && req.Code == "") || req.NewPassword == "" {
+		http.Error(w, "currentPassword (or code) and newPassword are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.profileService.ChangePassword(r.Context(), userID, req.CurrentPassword, req.Code, req.NewPassword); err != nil {
+		writeProfileError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Password changed"})
+}
+
+func (h *ProfileHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Accounts without a password send the emailed code instead.
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Password == "" && req.Code == "" {
+		http.Error(w, "password or code is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.profileService.DeleteAccount(r.Context(), userID, req.Password, req.Code); err != nil {
+		writeProfileError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Account deleted"})
+}
+
+// RequestConfirmCode emails a code confirming {"action": "delete-account"}
+// or {"action": "set-password"} to a user who has no password.
+func (h *ProfileHandler) RequestConfirmCode(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.profileService.RequestConfirmCode(r.Context(), userID, req.Action); err != nil {
+		writeProfileError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Confirmation code sent"})
+}
+
+// PUBLIC: opened from the link in the verification email.
+func (h *ProfileHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.profileService.VerifyEmail(r.Context(), req.Token); err != nil {
+		writeProfileError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Email updated"})
+}
+
+func writeProfileError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrWrongPassword), errors.Is(err, service.ErrInvalidConfirmCode):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrHasPassword):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrEmailAlreadyUsed):
+		http.Error(w, "Email already used", http.StatusConflict)
+	case errors.Is(err, service.ErrLastAdmin), errors.Is(err, service.ErrAdminChangeBusy):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrInvalidEmail),
+		errors.Is(err, service.ErrInvalidVerifyToken),
+		errors.Is(err, service.ErrFullNameRequired),
+		errors.Is(err, service.ErrNothingToUpdate),
+		errors.Is(err, service.ErrUnknownConfirm),
+		errors.Is(err, service.ErrPasswordTooWeak):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PromotionHandler struct {
+	service *service.PromotionService
+}
+
+func NewPromotionHandler(s *service.PromotionService) *PromotionHandler {
+	return &PromotionHandler{service: s}
+}
+
+// Running lists the promotions on offer right now.
+func (h *PromotionHandler) Running(w http.ResponseWriter, r *http.Request) {
+	promos, err := h.service.Running(r.Context())
+	if err != nil {
+		writePromotionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"promotions": promos})
+}
+
+func (h *PromotionHandler) List(w http.ResponseWriter, r *http.Request) {
+	promos, err := h.service.List(r.Context())
+	if err != nil {
+		writePromotionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"promotions": promos})
+}
+
+func (h *PromotionHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var p models.Promotion
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.Create(r.Context(), &p); err != nil {
+		writePromotionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, p)
+}
+
+func (h *PromotionHandler) Update(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	var p models.Promotion
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.Update(r.Context(), id, &p); err != nil {
+		writePromotionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (h *PromotionHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.Delete(r.Context(), id); err != nil {
+		writePromotionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Promotion deleted"})
+}
+
+func writePromotionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrPromotionNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidPromotion):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type RefundHandler struct {
+	service *service.RefundService
+}
+
+func NewRefundHandler(s *service.RefundService) *RefundHandler {
+	return &RefundHandler{service: s}
+}
+
+type refundReq struct {
+	Full        bool   `json:"full"`
+	Restock     bool   `json:"restock"`
+	DeliveryFee bool   `json:"deliveryFee"`
+	StoreCredit bool   `json:"storeCredit"`
+	Reason      string `json:"reason"`
+	Note        string `json:"note"`
+	Lines       []struct {
+		ProductID string `json:"productId"`
+		Quantity  int    `json:"quantity"`
+		Restock   bool   `json:"restock"`
+	} `json:"lines"`
+}
+
+// Create refunds order ?id=, either in full or the given lines.
+func (h *RefundHandler) Create(w http.ResponseWriter, r *http.Request) {
+	staffID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	orderID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var req refundReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !req.Full && len(req.Lines) == 0 && !req.DeliveryFee {
+		http.Error(w, "give full, lines or deliveryFee", http.StatusBadRequest)
+		return
+	}
+
+	in := service.RefundInput{
+		Full:        req.Full,
+		Restock:     req.Restock,
+		DeliveryFee: req.DeliveryFee,
+		StoreCredit: req.StoreCredit,
+		Reason:      req.Reason,
+		Note:        req.Note,
+	}
+	for _, l := range req.Lines {
+		pid, err := primitive.ObjectIDFromHex(l.ProductID)
+		if err != nil {
+			http.Error(w, "Invalid productId", http.StatusBadRequest)
+			return
+		}
+		in.Lines = append(in.Lines, service.RefundLineInput{ProductID: pid, Quantity: l.Quantity, Restock: l.Restock})
+	}
+
+	rf, err := h.service.Refund(r.Context(), orderID, staffID, in)
+	if err != nil {
+		writeRefundError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, rf)
+}
+
+func (h *RefundHandler) List(w http.ResponseWriter, r *http.Request) {
+	orderID, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	list, err := h.service.ForOrder(r.Context(), orderID)
+	if err != nil {
+		writeRefundError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"refunds": list})
+}
+
+func writeRefundError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrOrderNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidRefund):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrNothingToRefund),
+		errors.Is(err, service.ErrOrderNotRefundable):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, service.ErrRefundConflict),
+		errors.Is(err, service.ErrPaymentState),
+		errors.Is(err, service.ErrProviderRejected):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
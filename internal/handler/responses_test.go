@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/views"
+)
+
+// shownOnce are the secrets a handler returns on purpose, keyed by the
+// handler function and the JSON name. Each of them is handed to its owner
+// once and never stored in plain form.
+var shownOnce = map[string]bool{
+	"writeLoginResult token":                                 true,
+	"writeLoginResult preAuthToken":                          true,
+	"AdminUserHandler.ResetPassword temporaryPassword":       true,
+	"TwoFactorHandler.Setup secret":                          true,
+	"TwoFactorHandler.Enable recoveryCodes":                  true,
+	"TwoFactorHandler.Enable token":                          true,
+	"TwoFactorHandler.RegenerateRecoveryCodes recoveryCodes": true,
+	"GiftCardHandler.Issue pin":                              true,
+}
+
+// TestResponsesExposeNoSecrets type-checks the handlers and walks the JSON
+// shape of every value passed to writeJSON, including map literals and keys
+// set on a response map later on.
+func TestResponsesExposeNoSecrets(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []*ast.File
+	for _, f := range pkgs["handler"].Files {
+		files = append(files, f)
+	}
+
+	info := &types.Info{
+		Types: map[ast.Expr]types.TypeAndValue{},
+		Uses:  map[*ast.Ident]types.Object{},
+		Defs:  map[*ast.Ident]types.Object{},
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err := conf.Check("handler", fset, files, info); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	used := map[string]bool{}
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			s := &secretScan{info: info, fn: funcName(fn), body: fn.Body, used: used}
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok || len(call.Args) != 3 {
+					return true
+				}
+				if id, ok := call.Fun.(*ast.Ident); !ok || id.Name != "writeJSON" {
+					return true
+				}
+				calls++
+				s.expr(call.Args[2], fset.Position(call.Pos()).String())
+				return true
+			})
+			for _, e := range s.errs {
+				t.Error(e)
+			}
+		}
+	}
+	if calls == 0 {
+		t.Fatal("found no writeJSON calls")
+	}
+	for k := range shownOnce {
+		if !used[k] {
+			t.Errorf("shownOnce entry %q matches no response; remove it", k)
+		}
+	}
+}
+
+type secretScan struct {
+	info *types.Info
+	fn   string
+	body *ast.BlockStmt
+	used map[string]bool
+	errs []string
+}
+
+func (s *secretScan) flag(where, name string) {
+	if k := s.fn + " " + name; shownOnce[k] {
+		s.used[k] = true
+		return
+	}
+	s.errs = append(s.errs, fmt.Sprintf("%s: %s writes sensitive field %q", where, s.fn, name))
+}
+
+// expr checks the value of e, looking into map literals key by key.
+func (s *secretScan) expr(e ast.Expr, where string) {
+	switch e := ast.Unparen(e).(type) {
+	case *ast.CompositeLit:
+		if _, ok := s.info.Types[e].Type.Underlying().(*types.Map); ok {
+			for _, el := range e.Elts {
+				kv := el.(*ast.KeyValueExpr)
+				s.key(kv.Key, where)
+				s.expr(kv.Value, where)
+			}
+			return
+		}
+	case *ast.Ident:
+		// A response map filled in step by step: check every key set on it.
+		if obj := s.info.Uses[e]; obj != nil {
+			if _, ok := obj.Type().Underlying().(*types.Map); ok {
+				s.mapWrites(obj, where)
+			}
+		}
+	}
+	s.typ(s.info.Types[e].Type, where, map[types.Type]bool{})
+}
+
+func (s *secretScan) key(k ast.Expr, where string) {
+	tv := s.info.Types[k]
+	if tv.Value == nil {
+		return
+	}
+	if name, err := strconv.Unquote(tv.Value.ExactString()); err == nil && views.IsSensitive(name) {
+		s.flag(where, name)
+	}
+}
+
+// mapWrites checks m[key] = value and m := literal statements on obj.
+func (s *secretScan) mapWrites(obj types.Object, where string) {
+	ast.Inspect(s.body, func(n ast.Node) bool {
+		as, ok := n.(*ast.AssignStmt)
+		if !ok {
+			return true
+		}
+		for i, lhs := range as.Lhs {
+			if i >= len(as.Rhs) {
+				break
+			}
+			switch l := lhs.(type) {
+			case *ast.IndexExpr:
+				if id, ok := l.X.(*ast.Ident); ok && s.info.Uses[id] == obj {
+					s.key(l.Index, where)
+					s.expr(as.Rhs[i], where)
+				}
+			case *ast.Ident:
+				if s.info.Defs[l] == obj || s.info.Uses[l] == obj {
+					if lit, ok := as.Rhs[i].(*ast.CompositeLit); ok {
+						s.expr(lit, where)
+					}
+				}
+			}
+		}
+		return true
+	})
+}
+
+// typ walks the JSON shape of t the way encoding/json would. Types with
+// their own MarshalJSON are opaque.
+func (s *secretScan) typ(t types.Type, where string, seen map[types.Type]bool) {
+	for t != nil {
+		switch u := t.Underlying().(type) {
+		case *types.Pointer:
+			t = u.Elem()
+			continue
+		case *types.Slice:
+			t = u.Elem()
+			continue
+		case *types.Array:
+			t = u.Elem()
+			continue
+		case *types.Map:
+			t = u.Elem()
+			continue
+		}
+		break
+	}
+	st, ok := t.Underlying().(*types.Struct)
+	if !ok || seen[t] || marshalsItself(t) {
+		return
+	}
+	seen[t] = true
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		if !f.Exported() {
+			continue
+		}
+		tag := reflect.StructTag(st.Tag(i)).Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Embedded() && name == "" {
+			s.typ(f.Type(), where, seen)
+			continue
+		}
+		if name == "" {
+			name = f.Name()
+		}
+		if views.IsSensitive(name) {
+			s.flag(where+" ("+types.TypeString(t, nil)+")", name)
+		}
+		s.typ(f.Type(), where, seen)
+	}
+}
+
+func marshalsItself(t types.Type) bool {
+	for _, tt := range []types.Type{t, types.NewPointer(t)} {
+		if sel := types.NewMethodSet(tt).Lookup(nil, "MarshalJSON"); sel != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func funcName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return fn.Name.Name
+	}
+	rt := fn.Recv.List[0].Type
+	if star, ok := rt.(*ast.StarExpr); ok {
+		rt = star.X
+	}
+	if id, ok := rt.(*ast.Ident); ok {
+		return id.Name + "." + fn.Name.Name
+	}
+	return fn.Name.Name
+}
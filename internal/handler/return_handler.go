@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ReturnHandler struct {
+	service *service.ReturnService
+}
+
+func NewReturnHandler(s *service.ReturnService) *ReturnHandler {
+	return &ReturnHandler{service: s}
+}
+
+// Customer side
+
+type openReturnReq struct {
+	OrderID string `json:"orderId"`
+	Reason  string `json:"reason"`
+	Comment string `json:"comment"`
+	Lines   []struct {
+		ProductID string `json:"productId"`
+		Quantity  int    `json:"quantity"`
+	} `json:"lines"`
+}
+
+func (h *ReturnHandler) Open(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req openReturnReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	orderID, err := primitive.ObjectIDFromHex(req.OrderID)
+	if err != nil {
+		http.Error(w, "Invalid orderId", http.StatusBadRequest)
+		return
+	}
+	lines := make([]service.ReturnLineInput, 0, len(req.Lines))
+	for _, l := range req.Lines {
+		pid, err := primitive.ObjectIDFromHex(l.ProductID)
+		if err != nil {
+			http.Error(w, "Invalid productId", http.StatusBadRequest)
+			return
+		}
+		lines = append(lines, service.ReturnLineInput{ProductID: pid, Quantity: l.Quantity})
+	}
+
+	rr, err := h.service.Open(r.Context(), userID, orderID, req.Reason, req.Comment, lines)
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, rr)
+}
+
+func (h *ReturnHandler) Mine(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	list, err := h.service.Mine(r.Context(), userID)
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"returns": list})
+}
+
+func (h *ReturnHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.Cancel(r.Context(), userID, id); err != nil {
+		writeReturnError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Return cancelled"})
+}
+
+// Staff side
+
+func (h *ReturnHandler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := h.service.List(r.Context(), r.URL.Query().Get("status"))
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"returns": list})
+}
+
+type returnNoteReq struct {
+	Note string `json:"note"`
+}
+
+func (h *ReturnHandler) Approve(w http.ResponseWriter, r *http.Request) {
+	staffID, id, req, ok := staffReturnReq[returnNoteReq](w, r)
+	if !ok {
+		return
+	}
+	if err := h.service.Approve(r.Context(), staffID, id, req.Note); err != nil {
+		writeReturnError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Return approved"})
+}
+
+func (h *ReturnHandler) Reject(w http.ResponseWriter, r *http.Request) {
+	staffID, id, req, ok := staffReturnReq[returnNoteReq](w, r)
+	if !ok {
+		return
+	}
+	if err := h.service.Reject(r.Context(), staffID, id, req.Note); err != nil {
+		writeReturnError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Return rejected"})
+}
+
+type receiveReturnReq struct {
+	// Disposition applies to every line not listed in Lines.
+	Disposition string `json:"disposition"`
+	Note        string `json:"note"`
+	Lines       []struct {
+		ProductID   string `json:"productId"`
+		Disposition string `json:"disposition"`
+	} `json:"lines"`
+}
+
+func (h *ReturnHandler) Receive(w http.ResponseWriter, r *http.Request) {
+	staffID, id, req, ok := staffReturnReq[receiveReturnReq](w, r)
+	if !ok {
+		return
+	}
+	dispositions := map[primitive.ObjectID]string{}
+	for _, l := range req.Lines {
+		pid, err := primitive.ObjectIDFromHex(l.ProductID)
+		if err != nil {
+			http.Error(w, "Invalid productId", http.StatusBadRequest)
+			return
+		}
+		dispositions[pid] = l.Disposition
+	}
+	if err := h.service.Receive(r.Context(), staffID, id, dispositions, req.Disposition, req.Note); err != nil {
+		writeReturnError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Return received"})
+}
+
+type resolveReturnReq struct {
+	Resolution string `json:"resolution"`
+	Note       string `json:"note"`
+}
+
+func (h *ReturnHandler) Resolve(w http.ResponseWriter, r *http.Request) {
+	staffID, id, req, ok := staffReturnReq[resolveReturnReq](w, r)
+	if !ok {
+		return
+	}
+	rr, err := h.service.Resolve(r.Context(), staffID, id, req.Resolution, req.Note)
+	if err != nil {
+		writeReturnError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rr)
+}
+
+// staffReturnReq reads the staff user, ?id= and the JSON body shared by the
+// staff return actions.
+func staffReturnReq[T any](w http.ResponseWriter, r *http.Request) (staffID, id primitive.ObjectID, req T, ok bool) {
+	staffID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return staffID, id, req, false
+	}
+	id, err = primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return staffID, id, req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return staffID, id, req, false
+	}
+	return staffID, id, req, true
+}
+
+func writeReturnError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrReturnNotFound),
+		errors.Is(err, service.ErrOrderNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidReturn),
+		errors.Is(err, service.ErrReturnNoteRequired),
+		errors.Is(err, service.ErrInvalidDisposition),
+		errors.Is(err, service.ErrInvalidResolution):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrOrderNotReturnable):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, service.ErrReturnTransition),
+		errors.Is(err, service.ErrReturnBusy):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		// Settling goes through refunds, which have their own mapping.
+		writeRefundError(w, err)
+	}
+}
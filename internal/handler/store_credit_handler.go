@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+)
+
+type StoreCreditHandler struct {
+	service *service.StoreCreditService
+}
+
+func NewStoreCreditHandler(s *service.StoreCreditService) *StoreCreditHandler {
+	return &StoreCreditHandler{service: s}
+}
+
+// Statement shows the current user's store credit balance and movements.
+func (h *StoreCreditHandler) Statement(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	st, err := h.service.Statement(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
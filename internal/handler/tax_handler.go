@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TaxHandler struct {
+	service *service.TaxService
+}
+
+func NewTaxHandler(s *service.TaxService) *TaxHandler {
+	return &TaxHandler{service: s}
+}
+
+func (h *TaxHandler) ListClasses(w http.ResponseWriter, r *http.Request) {
+	classes, err := h.service.ListClasses(r.Context())
+	if err != nil {
+		writeTaxError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"classes": classes})
+}
+
+func (h *TaxHandler) CreateClass(w http.ResponseWriter, r *http.Request) {
+	var c models.TaxClass
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.CreateClass(r.Context(), &c); err != nil {
+		writeTaxError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, c)
+}
+
+func (h *TaxHandler) UpdateClass(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	var c models.TaxClass
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.UpdateClass(r.Context(), id, &c); err != nil {
+		writeTaxError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (h *TaxHandler) DeleteClass(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.DeleteClass(r.Context(), id); err != nil {
+		writeTaxError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Tax class deleted"})
+}
+
+func (h *TaxHandler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	st, err := h.service.Settings(r.Context())
+	if err != nil {
+		writeTaxError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func (h *TaxHandler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var st models.TaxSettings
+	if err := json.NewDecoder(r.Body).Decode(&st); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := h.service.UpdateSettings(r.Context(), &st); err != nil {
+		writeTaxError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func writeTaxError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrTaxClassNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidTaxClass),
+		errors.Is(err, service.ErrInvalidTaxSettings):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, service.ErrTaxClassInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
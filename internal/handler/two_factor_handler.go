@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/middleware"
+	"github.com/dannieey/Assignment3_Absolute/internal/service"
+)
+
+type TwoFactorHandler struct {
+	twoFactor *service.TwoFactorService
+	auth      *service.AuthService
+}
+
+func NewTwoFactorHandler(twoFactor *service.TwoFactorService, auth *service.AuthService) *TwoFactorHandler {
+	return &TwoFactorHandler{twoFactor: twoFactor, auth: auth}
+}
+
+func (h *TwoFactorHandler) Setup(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	secret, uri, err := h.twoFactor.Setup(r.Context(), userID)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"secret":     secret,
+		"otpauthUri": uri,
+	})
+}
+
+// Enable confirms the first code. Callers that came in with an enrolment
+// pre-auth token also receive their access token here.
+func (h *TwoFactorHandler) Enable(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.twoFactor.Enable(r.Context(), userID, req.Code)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+
+	resp := map[string]any{
+		"message":       "Two-factor authentication enabled",
+		"recoveryCodes": codes,
+	}
+	if preAuth, _ := r.Context().Value(middleware.CtxPreAuth).(bool); preAuth {
+		res, err := h.auth.CompleteEnrollment(r.Context(), userID)
+		if err != nil {
+			writeTwoFactorError(w, err)
+			return
+		}
+		resp["token"] = res.Token
+		resp["userId"] = res.UserID.Hex()
+		resp["role"] = res.Role
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *TwoFactorHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.twoFactor.Disable(r.Context(), userID, req.Password, req.Code); err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, err := getUserIDFromContext(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.twoFactor.RegenerateRecoveryCodes(r.Context(), userID, req.Code)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"recoveryCodes": codes})
+}
+
+func writeTwoFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrInvalidTwoFactorCode),
+		errors.Is(err, service.ErrWrongPassword):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, service.ErrTwoFactorAlreadyOn),
+		errors.Is(err, service.ErrTwoFactorRequired):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, service.ErrTwoFactorNotSetUp),
+		errors.Is(err, service.ErrTwoFactorCodeRequired):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
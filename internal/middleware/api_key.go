@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+const CtxAPIKey ctxKey = "apiKey"
+
+// APIKeyPrincipal is the device or integration behind an API key.
+type APIKeyPrincipal struct {
+	ID     string
+	Name   string
+	Scopes []string
+}
+
+func (p *APIKeyPrincipal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyAuthenticator resolves a raw key; the router wires it to the API key
+// service.
+type APIKeyAuthenticator func(ctx context.Context, rawKey string) (*APIKeyPrincipal, error)
+
+var apiKeyAuthenticator APIKeyAuthenticator
+
+func SetAPIKeyAuthenticator(fn APIKeyAuthenticator) {
+	apiKeyAuthenticator = fn
+}
+
+// RequireStaffOrScope accepts either a staff JWT (as StaffOnly does) or an
+// API key that carries the given scope. Keys are read from X-API-Key or an
+// "Authorization: ApiKey <key>" header.
+func RequireStaffOrScope(scope string, next http.Handler) http.Handler {
+	staff := RequireAuth(RequireRole("staff", next))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := apiKeyFromRequest(r)
+		if raw == "" {
+			staff.ServeHTTP(w, r)
+			return
+		}
+		if apiKeyAuthenticator == nil {
+			http.Error(w, "API keys are not enabled", http.StatusUnauthorized)
+			return
+		}
+
+		p, err := apiKeyAuthenticator(r.Context(), raw)
+		if err != nil || p == nil {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if !p.HasScope(scope) {
+			http.Error(w, "API key lacks scope "+scope, http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), CtxAPIKey, p)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func APIKeyFromContext(ctx context.Context) *APIKeyPrincipal {
+	p, _ := ctx.Value(CtxAPIKey).(*APIKeyPrincipal)
+	return p
+}
+
+func apiKeyFromRequest(r *http.Request) string {
+	if k := strings.TrimSpace(r.Header.Get("X-API-Key")); k != "" {
+		return k
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "ApiKey ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "ApiKey "))
+	}
+	return ""
+}
@@ -2,8 +2,10 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -15,52 +17,182 @@ type ctxKey string
 const (
 	CtxUserID ctxKey = "userId"
 	CtxRole   ctxKey = "role"
+	// CtxPreAuth marks requests made with a 2FA enrolment pre-auth token.
+	CtxPreAuth ctxKey = "preAuth"
 )
 
+var (
+	ErrAccountDisabled = errors.New("account disabled")
+	ErrAccountNotFound = errors.New("account not found")
+)
+
+// AccountLookup returns the current role of an account, or ErrAccountDisabled
+// or ErrAccountNotFound. Any other error is a failed lookup, not a bad token.
+// The router wires it to the user repository so that disabled accounts and
+// role changes take effect without waiting for the token to expire.
+type AccountLookup func(ctx context.Context, userID primitive.ObjectID) (string, error)
+
+var accountLookup AccountLookup
+
+func SetAccountLookup(fn AccountLookup) {
+	accountLookup = fn
+}
+
+// TokenVerifier checks access tokens and pre-auth tokens, which have
+// different audiences. The router wires it to the service's key manager so
+// the middleware never sees a secret or the expected claims.
+type TokenVerifier struct {
+	Parse        func(tokenStr string) (*jwt.Token, error)
+	ParsePreAuth func(tokenStr string) (*jwt.Token, error)
+}
+
+// Machine-readable reasons for a 401, sent as "code" in the body and as
+// error_description in WWW-Authenticate. Clients can tell an expired session,
+// which signing in again fixes, from a broken or forged token.
+const (
+	AuthCodeMissing           = "token_missing"
+	AuthCodeExpired           = "token_expired"
+	AuthCodeMalformed         = "token_malformed"
+	AuthCodeInvalid           = "token_invalid"
+	AuthCodeTwoFactorRequired = "two_factor_required"
+	AuthCodeAccountNotFound   = "account_not_found"
+)
+
+var tokenVerifier *TokenVerifier
+
+func SetTokenVerifier(v *TokenVerifier) {
+	tokenVerifier = v
+}
+
 func RequireAuth(next http.Handler) http.Handler {
+	return authenticate(false, next)
+}
+
+// RequireEnrollment also accepts the pre-auth token handed out at login to
+// users whose role requires 2FA but who haven't set it up yet, so they can
+// reach the enrolment endpoints. CtxPreAuth is true in that case.
+func RequireEnrollment(next http.Handler) http.Handler {
+	return authenticate(true, next)
+}
+
+func authenticate(allowEnroll bool, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		auth := r.Header.Get("Authorization")
 		if !strings.HasPrefix(auth, "Bearer ") {
-			http.Error(w, "Missing token", http.StatusUnauthorized)
+			writeAuthError(w, AuthCodeMissing, "Missing token")
 			return
 		}
 
-		tokenStr := strings.TrimPrefix(auth, "Bearer ")
-		secret := os.Getenv("JWT_SECRET")
-		if secret == "" {
-			secret = "dev_secret_change_me"
+		if tokenVerifier == nil || tokenVerifier.Parse == nil || tokenVerifier.ParsePreAuth == nil {
+			http.Error(w, "Token verification not configured", http.StatusInternalServerError)
+			return
 		}
 
-		tok, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
-			return []byte(secret), nil
-		})
+		tokenStr := strings.TrimPrefix(auth, "Bearer ")
+		preAuth := false
+		tok, err := tokenVerifier.Parse(tokenStr)
+		if err != nil {
+			// Maybe a pre-auth token; only enrolment accepts those.
+			if pre, preErr := tokenVerifier.ParsePreAuth(tokenStr); preErr == nil && pre.Valid {
+				tok, err, preAuth = pre, nil, true
+			}
+		}
 		if err != nil || !tok.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			code, msg := classifyTokenError(err)
+			writeAuthError(w, code, msg)
 			return
 		}
 
 		claims, ok := tok.Claims.(jwt.MapClaims)
 		if !ok {
-			http.Error(w, "Invalid token claims", http.StatusUnauthorized)
+			writeAuthError(w, AuthCodeMalformed, "Invalid token claims")
 			return
 		}
 
+		typ, _ := claims["typ"].(string)
+		if preAuth != (typ == "preauth") {
+			writeAuthError(w, AuthCodeInvalid, "Invalid token")
+			return
+		}
+		if preAuth {
+			step, _ := claims["step"].(string)
+			if !allowEnroll || step != "enroll" {
+				writeAuthError(w, AuthCodeTwoFactorRequired, "Two-factor verification required")
+				return
+			}
+		}
+
 		sub, _ := claims["sub"].(string)
 		role, _ := claims["role"].(string)
 		if sub == "" {
-			http.Error(w, "Invalid token subject", http.StatusUnauthorized)
+			writeAuthError(w, AuthCodeMalformed, "Invalid token subject")
 			return
 		}
 
+		if accountLookup != nil {
+			userID, err := primitive.ObjectIDFromHex(sub)
+			if err != nil {
+				writeAuthError(w, AuthCodeMalformed, "Invalid token subject")
+				return
+			}
+			current, err := accountLookup(r.Context(), userID)
+			if errors.Is(err, ErrAccountDisabled) {
+				http.Error(w, "Account disabled", http.StatusForbidden)
+				return
+			}
+			if errors.Is(err, ErrAccountNotFound) {
+				writeAuthError(w, AuthCodeAccountNotFound, "Account not found")
+				return
+			}
+			if err != nil {
+				http.Error(w, "Failed to look up account", http.StatusInternalServerError)
+				return
+			}
+			role = current
+		}
+		if preAuth {
+			// Not signed in yet: no role until enrolment is finished.
+			role = ""
+		}
+
 		ctx := context.WithValue(r.Context(), CtxUserID, sub)
 		ctx = context.WithValue(ctx, CtxRole, role)
+		ctx = context.WithValue(ctx, CtxPreAuth, preAuth)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// classifyTokenError separates expired tokens, which the client can recover
+// from by signing in again, from tokens that are broken or forged.
+func classifyTokenError(err error) (code, msg string) {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return AuthCodeExpired, "Token expired"
+	case errors.Is(err, jwt.ErrTokenMalformed),
+		errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return AuthCodeMalformed, "Malformed token"
+	default:
+		return AuthCodeInvalid, "Invalid token"
+	}
+}
+
+// writeAuthError sends a 401 with an RFC 6750 challenge. A request without a
+// token gets a bare challenge; every other failure is error="invalid_token".
+func writeAuthError(w http.ResponseWriter, code, msg string) {
+	challenge := `Bearer realm="api"`
+	if code != AuthCodeMissing {
+		challenge += fmt.Sprintf(`, error="invalid_token", error_description=%q`, code)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg, "code": code})
+}
+
 func RequireRole(requiredRole string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		role, _ := r.Context().Value(CtxRole).(string)
-		if role != requiredRole {
+		if role != requiredRole && role != "admin" {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
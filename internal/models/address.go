@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Address is an entry in a customer's address book.
+type Address struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `json:"userId" bson:"user_id"`
+	Label      string             `json:"label" bson:"label"`
+	Street     string             `json:"street" bson:"street"`
+	City       string             `json:"city" bson:"city"`
+	PostalCode string             `json:"postalCode" bson:"postal_code"`
+	Phone      string             `json:"phone" bson:"phone"`
+	Notes      string             `json:"notes,omitempty" bson:"notes,omitempty"`
+	// Optional; used to match delivery zones drawn as polygons.
+	Location  *GeoPoint `json:"location,omitempty" bson:"location,omitempty"`
+	IsDefault bool      `json:"isDefault" bson:"is_default"`
+	CreatedAt time.Time `json:"createdAt" bson:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updated_at"`
+}
+
+// AddressSnapshot is the copy of an address stored on an order, so editing or
+// deleting the address book entry later doesn't change past orders.
+type AddressSnapshot struct {
+	AddressID  primitive.ObjectID `json:"addressId" bson:"address_id"`
+	Label      string             `json:"label" bson:"label"`
+	Street     string             `json:"street" bson:"street"`
+	City       string             `json:"city" bson:"city"`
+	PostalCode string             `json:"postalCode" bson:"postal_code"`
+	Phone      string             `json:"phone" bson:"phone"`
+	Notes      string             `json:"notes,omitempty" bson:"notes,omitempty"`
+	Location   *GeoPoint          `json:"location,omitempty" bson:"location,omitempty"`
+}
+
+func (a *Address) Snapshot() *AddressSnapshot {
+	return &AddressSnapshot{
+		AddressID:  a.ID,
+		Label:      a.Label,
+		Street:     a.Street,
+		City:       a.City,
+		PostalCode: a.PostalCode,
+		Phone:      a.Phone,
+		Notes:      a.Notes,
+		Location:   a.Location,
+	}
+}
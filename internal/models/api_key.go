@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// API key scopes. Keys only reach the staff endpoints their scopes name.
+const (
+	ScopeProductsRead  = "products:read"
+	ScopeProductsWrite = "products:write"
+	ScopeCatalogWrite  = "catalog:write"
+)
+
+var APIKeyScopes = []string{ScopeProductsRead, ScopeProductsWrite, ScopeCatalogWrite}
+
+func IsValidScope(scope string) bool {
+	for _, s := range APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey is a credential for in-store devices and integrations. Only the
+// SHA-256 of the key is stored; Prefix is the public part used to find it
+// and to recognise the key in logs and the admin list.
+type APIKey struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name       string             `json:"name" bson:"name"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	KeyHash    string             `json:"-" bson:"key_hash"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	CreatedBy  primitive.ObjectID `json:"createdBy" bson:"created_by"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"created_at"`
+	ExpiresAt  *time.Time         `json:"expiresAt,omitempty" bson:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `json:"lastUsedAt,omitempty" bson:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `json:"revokedAt,omitempty" bson:"revoked_at,omitempty"`
+}
@@ -17,15 +17,19 @@ type Cart struct {
 	UserID    primitive.ObjectID `json:"userId" bson:"user_id"`
 	Items     []CartItem         `json:"items" bson:"items"`
 	UpdatedAt time.Time          `json:"updatedAt" bson:"updated_at"`
+
+	// CouponCode is the coupon to redeem when checking out.
+	CouponCode string `json:"couponCode,omitempty" bson:"coupon_code,omitempty"`
 }
 
 type CartItemWithProduct struct {
 	ProductID primitive.ObjectID `json:"productId"`
 	Name      string             `json:"name"`
-	Price     float64            `json:"price"`
+	Price     Money              `json:"price"`
 	ImageURL  string             `json:"imageUrl"`
 	Quantity  int                `json:"quantity"`
-	Subtotal  float64            `json:"subtotal"`
+	Subtotal  Money              `json:"subtotal"`
+	Discount  Money              `json:"discount"`
 	InStock   bool               `json:"inStock"`
 	StockQty  int                `json:"stockQty"`
 }
@@ -33,5 +37,15 @@ type CartItemWithProduct struct {
 type CartResponse struct {
 	Items      []CartItemWithProduct `json:"items"`
 	TotalItems int                   `json:"totalItems"`
-	TotalPrice float64               `json:"totalPrice"`
+	TotalPrice Money                 `json:"totalPrice"`
+	// Discounts itemizes the promotions taken off TotalPrice.
+	Discounts     []AppliedDiscount `json:"discounts"`
+	DiscountTotal Money             `json:"discountTotal"`
+	// CouponError says why CouponCode doesn't apply right now.
+	CouponCode  string `json:"couponCode,omitempty"`
+	CouponError string `json:"couponError,omitempty"`
+	// Tax is the estimated tax, included in TotalPrice either way.
+	Tax      Money  `json:"tax"`
+	TaxMode  string `json:"taxMode"`
+	Currency string `json:"currency"`
 }
@@ -7,8 +7,10 @@ import (
 )
 
 type Category struct {
-	ID        primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
-	Name      string              `json:"name" bson:"name"`
-	ParentID  *primitive.ObjectID `json:"parentId,omitempty" bson:"parent_id,omitempty"`
-	CreatedAt time.Time           `json:"createdAt" bson:"created_at"`
+	ID       primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	Name     string              `json:"name" bson:"name"`
+	ParentID *primitive.ObjectID `json:"parentId,omitempty" bson:"parent_id,omitempty"`
+	// TaxClassID is the tax class of the category's products.
+	TaxClassID *primitive.ObjectID `json:"taxClassId,omitempty" bson:"tax_class_id,omitempty"`
+	CreatedAt  time.Time           `json:"createdAt" bson:"created_at"`
 }
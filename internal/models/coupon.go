@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Coupon is a code that unlocks a coupon-only promotion. Codes generated
+// together share a Batch.
+type Coupon struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Code        string             `json:"code" bson:"code"`
+	PromotionID primitive.ObjectID `json:"promotionId" bson:"promotion_id"`
+	Batch       string             `json:"batch,omitempty" bson:"batch,omitempty"`
+	// MaxUses and MaxUsesPerCustomer limit redemptions; 0 is unlimited.
+	MaxUses            int `json:"maxUses" bson:"max_uses"`
+	MaxUsesPerCustomer int `json:"maxUsesPerCustomer" bson:"max_uses_per_customer"`
+	// MinBasket is the goods subtotal, before discounts, the coupon needs.
+	MinBasket Money      `json:"minBasket,omitempty" bson:"min_basket,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" bson:"expires_at,omitempty"`
+	// Uses counts redemptions by orders.
+	Uses      int       `json:"uses" bson:"uses"`
+	CreatedAt time.Time `json:"createdAt" bson:"created_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ExportPending = "PENDING"
+	ExportRunning = "RUNNING"
+	ExportReady   = "READY"
+	ExportFailed  = "FAILED"
+)
+
+type DataExport struct {
+	ID          primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	UserID      primitive.ObjectID  `json:"userId" bson:"user_id"`
+	Status      string              `json:"status" bson:"status"`
+	Error       string              `json:"error,omitempty" bson:"error,omitempty"`
+	FileID      *primitive.ObjectID `json:"-" bson:"file_id,omitempty"`
+	FileName    string              `json:"fileName,omitempty" bson:"file_name,omitempty"`
+	CreatedAt   time.Time           `json:"createdAt" bson:"created_at"`
+	StartedAt   *time.Time          `json:"startedAt,omitempty" bson:"started_at,omitempty"`
+	CompletedAt *time.Time          `json:"completedAt,omitempty" bson:"completed_at,omitempty"`
+	ExpiresAt   time.Time           `json:"expiresAt" bson:"expires_at"`
+}
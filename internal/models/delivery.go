@@ -0,0 +1,106 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GeoPoint is a GeoJSON point; coordinates are [longitude, latitude].
+type GeoPoint struct {
+	Type        string    `json:"type" bson:"type"`
+	Coordinates []float64 `json:"coordinates" bson:"coordinates"`
+}
+
+func NewGeoPoint(lat, lng float64) *GeoPoint {
+	return &GeoPoint{Type: "Point", Coordinates: []float64{lng, lat}}
+}
+
+// GeoPolygon is a GeoJSON polygon: an outer ring and optional holes, each a
+// closed list of [longitude, latitude] positions.
+type GeoPolygon struct {
+	Type        string        `json:"type" bson:"type"`
+	Coordinates [][][]float64 `json:"coordinates" bson:"coordinates"`
+}
+
+// TimeWindow is a daily delivery window in store-local "HH:MM" time.
+type TimeWindow struct {
+	Start string `json:"start" bson:"start"`
+	End   string `json:"end" bson:"end"`
+}
+
+// DeliveryZone is an area we deliver to, matched by postal code or by the
+// address location falling inside Area.
+type DeliveryZone struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name        string             `json:"name" bson:"name"`
+	PostalCodes []string           `json:"postalCodes,omitempty" bson:"postal_codes,omitempty"`
+	Area        *GeoPolygon        `json:"area,omitempty" bson:"area,omitempty"`
+	Fee         Money              `json:"fee" bson:"fee"`
+	MinOrder    Money              `json:"minOrder" bson:"min_order"`
+	// FreeOver waives the fee for subtotals at or above it; 0 disables it.
+	FreeOver    Money        `json:"freeOver" bson:"free_over"`
+	Windows     []TimeWindow `json:"windows" bson:"windows"`
+	LeadMinutes int          `json:"leadMinutes" bson:"lead_minutes"`
+	HorizonDays int          `json:"horizonDays" bson:"horizon_days"`
+	Active      bool         `json:"active" bson:"active"`
+	CreatedAt   time.Time    `json:"createdAt" bson:"created_at"`
+	UpdatedAt   time.Time    `json:"updatedAt" bson:"updated_at"`
+}
+
+// DeliveryWindow is a concrete window offered for, or booked on, an order.
+type DeliveryWindow struct {
+	Start time.Time `json:"start" bson:"start"`
+	End   time.Time `json:"end" bson:"end"`
+}
+
+// DeliveryQuote is what delivering to an address would cost.
+type DeliveryQuote struct {
+	ZoneID   primitive.ObjectID `json:"zoneId"`
+	ZoneName string             `json:"zoneName"`
+	Fee      Money              `json:"fee"`
+	MinOrder Money              `json:"minOrder"`
+	FreeOver Money              `json:"freeOver"`
+	Windows  []DeliveryWindow   `json:"windows"`
+}
+
+const (
+	ProofCode      = "code"
+	ProofSignature = "signature"
+)
+
+// DeliveryProof records how a delivery was confirmed. Signature images are
+// stored in GridFS under SignatureFileID.
+type DeliveryProof struct {
+	Kind            string             `json:"kind" bson:"kind"`
+	SignatureFileID primitive.ObjectID `json:"signatureFileId,omitempty" bson:"signature_file_id,omitempty"`
+	RecordedAt      time.Time          `json:"recordedAt" bson:"recorded_at"`
+}
+
+// CourierDelivery is what a courier sees of an order: where and when to
+// deliver and what, but not the customer's delivery code.
+type CourierDelivery struct {
+	OrderID        primitive.ObjectID `json:"orderId"`
+	Status         string             `json:"status"`
+	CourierID      primitive.ObjectID `json:"courierId,omitempty"`
+	Address        *AddressSnapshot   `json:"address"`
+	DeliveryWindow *DeliveryWindow    `json:"deliveryWindow"`
+	Items          []OrderItem        `json:"items"`
+	TotalPrice     Money              `json:"totalPrice"`
+	Proof          *DeliveryProof     `json:"proof,omitempty"`
+	UpdatedAt      time.Time          `json:"updatedAt"`
+}
+
+func NewCourierDelivery(o *Order) CourierDelivery {
+	return CourierDelivery{
+		OrderID:        o.ID,
+		Status:         o.Status,
+		CourierID:      o.CourierID,
+		Address:        o.DeliveryAddress,
+		DeliveryWindow: o.DeliveryWindow,
+		Items:          o.Items,
+		TotalPrice:     o.TotalPrice,
+		Proof:          o.DeliveryProof,
+		UpdatedAt:      o.UpdatedAt,
+	}
+}
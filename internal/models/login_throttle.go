@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoginThrottle counts failed logins for one key, either "email:<address>"
+// or "ip:<address>".
+type LoginThrottle struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Key           string             `json:"key" bson:"key"`
+	Failures      int                `json:"failures" bson:"failures"`
+	LastFailureAt time.Time          `json:"lastFailureAt" bson:"last_failure_at"`
+	LockedUntil   *time.Time         `json:"lockedUntil,omitempty" bson:"locked_until,omitempty"`
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Loyalty ledger entry types. EARN and RESTORE add points that lapse at
+// ExpiresAt; the others take points away.
+const (
+	LoyaltyEarn    = "EARN"     // order completed
+	LoyaltyRedeem  = "REDEEM"   // spent at checkout
+	LoyaltyReverse = "REVERSAL" // earned points taken back after a refund
+	LoyaltyRestore = "RESTORE"  // spent points given back after a refund or cancellation
+	LoyaltyExpire  = "EXPIRE"
+)
+
+// DiscountLoyalty is the AppliedDiscount type of points spent at checkout.
+const DiscountLoyalty = "LOYALTY"
+
+// LoyaltyEntry is one movement of a customer's points. Points is signed.
+type LoyaltyEntry struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID primitive.ObjectID `json:"userId" bson:"user_id"`
+	// Seq numbers the user's entries from 1. The balance records the last
+	// one it includes, so an entry is counted exactly once.
+	Seq     int64              `json:"-" bson:"seq,omitempty"`
+	Type    string             `json:"type" bson:"type"`
+	Points  int64              `json:"points" bson:"points"`
+	OrderID primitive.ObjectID `json:"orderId,omitempty" bson:"order_id,omitempty"`
+	// LotID is the EARN or RESTORE entry an EXPIRE or REVERSAL takes its
+	// points from.
+	LotID     primitive.ObjectID `json:"lotId,omitempty" bson:"lot_id,omitempty"`
+	ExpiresAt *time.Time         `json:"expiresAt,omitempty" bson:"expires_at,omitempty"`
+	Note      string             `json:"note,omitempty" bson:"note,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"created_at"`
+}
+
+// LoyaltyMultiplier scales the points earned on a category's products;
+// 20000 basis points is double points.
+type LoyaltyMultiplier struct {
+	CategoryID  primitive.ObjectID `json:"categoryId" bson:"category_id"`
+	BasisPoints int64              `json:"basisPoints" bson:"basis_points"`
+}
+
+// LoyaltySettings configures the points program.
+type LoyaltySettings struct {
+	ID      string `json:"-" bson:"_id"`
+	Enabled bool   `json:"enabled" bson:"enabled"`
+	// SpendPerPoint is how much spend on goods, after discounts, earns one
+	// point; PointValue is what a point takes off at checkout.
+	SpendPerPoint Money `json:"spendPerPoint" bson:"spend_per_point"`
+	PointValue    Money `json:"pointValue" bson:"point_value"`
+	// ExpiryDays is how long points last; 0 keeps them forever.
+	ExpiryDays  int                 `json:"expiryDays" bson:"expiry_days"`
+	Multipliers []LoyaltyMultiplier `json:"multipliers" bson:"multipliers"`
+	UpdatedAt   time.Time           `json:"updatedAt" bson:"updated_at"`
+}
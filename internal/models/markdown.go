@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BestBeforeLayout is the format of ProductBatch.BestBefore, a date in the
+// store's timezone.
+const BestBeforeLayout = "2006-01-02"
+
+// ProductBatch is a delivery of a product that has to be sold by
+// BestBefore. A batch stays open until staff clear it, when it has sold out
+// or been written off. The store kept no batch or expiry data before
+// markdowns; batches exist to drive them and are entered by staff through
+// /staff/products/batches as deliveries come in.
+type ProductBatch struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ProductID  primitive.ObjectID `json:"productId" bson:"product_id"`
+	Lot        string             `json:"lot,omitempty" bson:"lot,omitempty"`
+	Quantity   int                `json:"quantity" bson:"quantity"`
+	BestBefore string             `json:"bestBefore" bson:"best_before"`
+	ReceivedBy primitive.ObjectID `json:"receivedBy,omitempty" bson:"received_by,omitempty"`
+	ClearedAt  *time.Time         `json:"clearedAt,omitempty" bson:"cleared_at,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"created_at"`
+}
+
+// MarkdownRule takes BasisPoints off a product from DaysBefore days before
+// its batch's best-before date; 0 is the last day.
+type MarkdownRule struct {
+	DaysBefore  int   `json:"daysBefore" bson:"days_before"`
+	BasisPoints int64 `json:"basisPoints" bson:"basis_points"` // 3000 = 30%
+}
+
+// MarkdownSettings configures automatic markdowns.
+type MarkdownSettings struct {
+	ID        string         `json:"-" bson:"_id"`
+	Enabled   bool           `json:"enabled" bson:"enabled"`
+	Rules     []MarkdownRule `json:"rules" bson:"rules"`
+	UpdatedAt time.Time      `json:"updatedAt" bson:"updated_at"`
+}
+
+// Markdown is a product reduced to clear because of the batch BatchID. The
+// shelf doesn't tell batches apart, so the whole product is reduced. A
+// markdown leaves the product's price alone, so it is not a price change:
+// the price history (PriceChange) tracks list prices only, and markdowns
+// are found on the product and its batches instead.
+type Markdown struct {
+	BatchID     primitive.ObjectID `json:"batchId" bson:"batch_id"`
+	BestBefore  string             `json:"bestBefore" bson:"best_before"`
+	BasisPoints int64              `json:"basisPoints" bson:"basis_points"`
+	Since       time.Time          `json:"since" bson:"since"`
+	// Price is the reduced price, worked out from the product's price.
+	Price Money `json:"price" bson:"-"`
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Money is an exact amount in minor units (hundredths) of the store
+// currency; the currency code is kept on the document that holds the amount
+// (Product.Currency, Order.Currency, ...).
+//
+// Rounding: whenever a result falls between two minor units it is rounded
+// half away from zero, once, at the point the amount is produced (a line, a
+// fee, a percentage). Totals are sums of already rounded amounts, so a cart
+// and the order placed from it always agree.
+//
+// In JSON Money is a plain decimal number such as 12.50, and it also accepts
+// a decimal string; decoding goes through ParseMoney. In BSON it is an int64 of minor units; doubles and
+// decimal128 values from older documents are read as major units.
+type Money int64
+
+const moneyScale = 100
+
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+// NewMoney builds an amount from whole units and hundredths, e.g.
+// NewMoney(12, 50) is 12.50.
+func NewMoney(units, cents int64) Money {
+	return Money(units*moneyScale + cents)
+}
+
+// ParseMoney reads an amount given by a client, such as "12.5" or "1e3". It
+// must be exact to the minor unit and not negative; negative amounts only
+// come from the store's own calculations.
+func ParseMoney(s string) (Money, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	if r.Sign() < 0 {
+		return 0, fmt.Errorf("%w: %q is negative", ErrInvalidMoney, s)
+	}
+	r.Mul(r, big.NewRat(moneyScale, 1))
+	if !r.IsInt() {
+		return 0, fmt.Errorf("%w: %q has more than two decimals", ErrInvalidMoney, s)
+	}
+	if !r.Num().IsInt64() {
+		return 0, fmt.Errorf("%w: out of range", ErrInvalidMoney)
+	}
+	return Money(r.Num().Int64()), nil
+}
+
+// ParseMoneyRounded reads a stored decimal amount in major units, such as
+// "-0.125", rounding to the nearest minor unit. It is for values from older
+// documents; input from clients goes through ParseMoney.
+func ParseMoneyRounded(s string) (Money, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	r.Mul(r, big.NewRat(moneyScale, 1))
+	n := roundHalfAway(r.Num(), r.Denom())
+	if !n.IsInt64() {
+		return 0, fmt.Errorf("%w: out of range", ErrInvalidMoney)
+	}
+	return Money(n.Int64()), nil
+}
+
+// MoneyFromFloat converts a float amount in major units using its shortest
+// decimal form, so 0.285 becomes 0.29 and not 0.28.
+func MoneyFromFloat(f float64) (Money, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidMoney, f)
+	}
+	return ParseMoneyRounded(strconv.FormatFloat(f, 'g', -1, 64))
+}
+
+// roundHalfAway divides num by den, rounding half away from zero.
+func roundHalfAway(num, den *big.Int) *big.Int {
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	// |2*rem| >= den means the remainder is at least half.
+	if new(big.Int).Abs(new(big.Int).Lsh(rem, 1)).Cmp(new(big.Int).Abs(den)) >= 0 {
+		if num.Sign()*den.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// Mul is the amount for qty units.
+func (m Money) Mul(qty int) Money {
+	return m * Money(qty)
+}
+
+// MulRatio is m*num/den rounded half away from zero, e.g. MulRatio(15, 100)
+// for 15%.
+func (m Money) MulRatio(num, den int64) Money {
+	if den == 0 {
+		return 0
+	}
+	n := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(num))
+	return Money(roundHalfAway(n, big.NewInt(den)).Int64())
+}
+
+// Float64 is the amount in major units, for display and reporting only.
+func (m Money) Float64() float64 {
+	return float64(m) / moneyScale
+}
+
+// String formats the amount with two decimals, e.g. "12.50" or "-0.05".
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/moneyScale, v%moneyScale)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	s := string(data)
+	if len(data) >= 2 && data[0] == '"' {
+		var err error
+		if s, err = strconv.Unquote(s); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidMoney, data)
+		}
+	}
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+func (m Money) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bsontype.Int64, bsoncore.AppendInt64(nil, int64(m)), nil
+}
+
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	switch t {
+	case bsontype.Int64:
+		v, _, ok := bsoncore.ReadInt64(data)
+		if !ok {
+			return ErrInvalidMoney
+		}
+		*m = Money(v)
+	case bsontype.Int32:
+		v, _, ok := bsoncore.ReadInt32(data)
+		if !ok {
+			return ErrInvalidMoney
+		}
+		*m = Money(v)
+	case bsontype.Double:
+		f, _, ok := bsoncore.ReadDouble(data)
+		if !ok {
+			return ErrInvalidMoney
+		}
+		v, err := MoneyFromFloat(f)
+		if err != nil {
+			return err
+		}
+		*m = v
+	case bsontype.Decimal128:
+		d, _, ok := bsoncore.ReadDecimal128(data)
+		if !ok {
+			return ErrInvalidMoney
+		}
+		v, err := ParseMoneyRounded(d.String())
+		if err != nil {
+			return err
+		}
+		*m = v
+	case bsontype.Null, bsontype.Undefined:
+		*m = 0
+	default:
+		return fmt.Errorf("%w: cannot decode BSON %s", ErrInvalidMoney, t)
+	}
+	return nil
+}
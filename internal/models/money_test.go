@@ -0,0 +1,209 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		ok   bool
+	}{
+		{"12.50", 1250, true},
+		{"12.5", 1250, true},
+		{"0", 0, true},
+		{"0.05", 5, true},
+		{"1e3", 100000, true},
+		{"12.500", 1250, true},
+		{"12.505", 0, false},
+		{"0.001", 0, false},
+		{"-0.05", 0, false},
+		{"-12", 0, false},
+		{"92233720368547758.08", 0, false},
+		{"1e30", 0, false},
+		{"", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if tt.ok != (err == nil) || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, %v; want %d, ok=%v", tt.in, got, err, tt.want, tt.ok)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidMoney) {
+			t.Errorf("ParseMoney(%q) error %v is not ErrInvalidMoney", tt.in, err)
+		}
+	}
+}
+
+func TestParseMoneyRounded(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+	}{
+		{"12.505", 1251},
+		{"12.504", 1250},
+		{"-12.505", -1251},
+		{"-0.004", 0},
+		{"0.125", 13},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoneyRounded(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseMoneyRounded(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyFromFloat(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want Money
+	}{
+		{0.285, 29},
+		{1.005, 101},
+		{19.99, 1999},
+		{-2.5, -250},
+	}
+	for _, tt := range tests {
+		if got, err := MoneyFromFloat(tt.in); err != nil || got != tt.want {
+			t.Errorf("MoneyFromFloat(%v) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestMulRatioRoundsHalfAwayFromZero(t *testing.T) {
+	tests := []struct {
+		m        Money
+		num, den int64
+		want     Money
+	}{
+		{1000, 15, 100, 150},
+		{5, 1, 2, 3},   // 2.5 -> 3
+		{-5, 1, 2, -3}, // -2.5 -> -3
+		{7, 1, 3, 2},   // 2.33 -> 2
+		{-7, 1, 3, -2}, // -2.33 -> -2
+		{1001, 1, 2, 501},
+		{1000, 1200, 11200, 107}, // tax included at 12%: 107.14 -> 107
+		{1000, 0, 100, 0},
+		{1000, 1, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.m.MulRatio(tt.num, tt.den); got != tt.want {
+			t.Errorf("%d.MulRatio(%d, %d) = %d, want %d", tt.m, tt.num, tt.den, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{1250, "12.50"},
+		{5, "0.05"},
+		{-5, "-0.05"},
+		{0, "0.00"},
+		{-1234, "-12.34"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	type doc struct {
+		Price Money `json:"price"`
+	}
+	for _, m := range []Money{0, 5, 1250, 99999999} {
+		b, err := json.Marshal(doc{Price: m})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got doc
+		if err := json.Unmarshal(b, &got); err != nil || got.Price != m {
+			t.Errorf("round trip of %d through %s = %d, %v", m, b, got.Price, err)
+		}
+	}
+	if b, _ := json.Marshal(doc{Price: 1250}); string(b) != `{"price":12.50}` {
+		t.Errorf("encoded %s, want a plain decimal", b)
+	}
+
+	decode := []struct {
+		in   string
+		want Money
+		ok   bool
+	}{
+		{`{"price":"12.50"}`, 1250, true},
+		{`{"price":12}`, 1200, true},
+		{`{"price":null}`, 0, true},
+		{`{"price":12.345}`, 0, false},
+		{`{"price":-1}`, 0, false},
+		{`{"price":"x"}`, 0, false},
+		{`{"price":true}`, 0, false},
+	}
+	for _, tt := range decode {
+		var got doc
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if tt.ok != (err == nil) || got.Price != tt.want {
+			t.Errorf("decode %s = %d, %v; want %d, ok=%v", tt.in, got.Price, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestMoneyBSON(t *testing.T) {
+	type doc struct {
+		Price Money `bson:"price"`
+	}
+	b, err := bson.Marshal(doc{Price: 1250})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := bson.Raw(b).Lookup("price"); v.Type != bson.TypeInt64 || v.Int64() != 1250 {
+		t.Errorf("stored as %s %v, want int64 1250", v.Type, v)
+	}
+
+	dec := func(s string) primitive.Decimal128 {
+		d, err := primitive.ParseDecimal128(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	tests := []struct {
+		name string
+		in   any
+		want Money
+	}{
+		{"int64 minor units", int64(1250), 1250},
+		{"int32 minor units", int32(1250), 1250},
+		{"legacy double", 12.5, 1250},
+		{"legacy double rounded", 0.285, 29},
+		{"legacy decimal128", dec("12.50"), 1250},
+		{"legacy decimal128 rounded", dec("-0.125"), -13},
+		{"null", nil, 0},
+	}
+	for _, tt := range tests {
+		raw, err := bson.Marshal(bson.M{"price": tt.in})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got doc
+		if err := bson.Unmarshal(raw, &got); err != nil || got.Price != tt.want {
+			t.Errorf("%s: decoded %d, %v; want %d", tt.name, got.Price, err, tt.want)
+		}
+	}
+
+	raw, _ := bson.Marshal(bson.M{"price": "12.50"})
+	var got doc
+	if err := bson.Unmarshal(raw, &got); !errors.Is(err, ErrInvalidMoney) {
+		t.Errorf("decoding a string = %v, want ErrInvalidMoney", err)
+	}
+}
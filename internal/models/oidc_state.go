@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// OIDCState holds what the callback needs to finish one authorization code
+// flow. It is stored server-side so any instance can handle the callback.
+type OIDCState struct {
+	State        string    `bson:"_id"`
+	Nonce        string    `bson:"nonce"`
+	CodeVerifier string    `bson:"code_verifier"`
+	RedirectTo   string    `bson:"redirect_to,omitempty"`
+	CreatedAt    time.Time `bson:"created_at"`
+}
+
+// ExternalIdentity links a user to an account at an OpenID Connect provider.
+type ExternalIdentity struct {
+	Issuer   string    `json:"issuer" bson:"issuer"`
+	Subject  string    `json:"subject" bson:"subject"`
+	LinkedAt time.Time `json:"linkedAt" bson:"linked_at"`
+}
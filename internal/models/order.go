@@ -6,13 +6,82 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Statuses a delivery order moves through after it has been packed.
+const (
+	OrderReadyForDelivery = "READY_FOR_DELIVERY"
+	OrderCourierAssigned  = "COURIER_ASSIGNED"
+	OrderOutForDelivery   = "OUT_FOR_DELIVERY"
+	OrderDelivered        = "DELIVERED"
+	OrderDeliveryFailed   = "DELIVERY_FAILED"
+)
+
+// OrderCancelled is set when a new order is called off before it is
+// prepared.
+const OrderCancelled = "CANCELLED"
+
 type Order struct {
 	ID         primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
 	UserID     primitive.ObjectID   `json:"userId" bson:"user_id"`
 	Status     string               `json:"status" bson:"status"`
-	TotalPrice float64              `json:"totalPrice" bson:"total_price"`
+	Subtotal   Money                `json:"subtotal" bson:"subtotal"`
+	TotalPrice Money                `json:"totalPrice" bson:"total_price"` // subtotal less discounts plus delivery fee, plus tax when prices exclude it
+	Currency   string               `json:"currency,omitempty" bson:"currency,omitempty"`
 	Items      []OrderItem          `json:"items" bson:"items"`
 	History    []OrderStatusHistory `json:"history" bson:"history"`
+	Anonymized bool                 `json:"anonymized,omitempty" bson:"anonymized,omitempty"`
 	CreatedAt  time.Time            `json:"createdAt" bson:"created_at"`
 	UpdatedAt  time.Time            `json:"updatedAt" bson:"updated_at"`
+
+	// Home delivery: a snapshot of the address book entry, the zone it fell
+	// in and the fee and window charged.
+	DeliveryAddress *AddressSnapshot   `json:"deliveryAddress,omitempty" bson:"delivery_address,omitempty"`
+	DeliveryZoneID  primitive.ObjectID `json:"deliveryZoneId,omitempty" bson:"delivery_zone_id,omitempty"`
+	DeliveryFee     Money              `json:"deliveryFee,omitempty" bson:"delivery_fee,omitempty"`
+	DeliveryWindow  *DeliveryWindow    `json:"deliveryWindow,omitempty" bson:"delivery_window,omitempty"`
+	// DeliveryCode is shown to the customer and given to the courier at the
+	// door as proof of delivery.
+	DeliveryCode  string             `json:"deliveryCode,omitempty" bson:"delivery_code,omitempty"`
+	CourierID     primitive.ObjectID `json:"courierId,omitempty" bson:"courier_id,omitempty"`
+	DeliveryProof *DeliveryProof     `json:"deliveryProof,omitempty" bson:"delivery_proof,omitempty"`
+
+	// PaymentStatus mirrors the latest payment attempt (see Payment*); the
+	// order is only prepared once payment is authorized.
+	PaymentStatus string             `json:"paymentStatus,omitempty" bson:"payment_status,omitempty"`
+	PaymentID     primitive.ObjectID `json:"paymentId,omitempty" bson:"payment_id,omitempty"`
+	// RefundedTotal is what has been given back so far and NetTotal what
+	// the customer paid after refunds.
+	RefundedTotal       Money `json:"refundedTotal,omitempty" bson:"refunded_total,omitempty"`
+	NetTotal            Money `json:"netTotal,omitempty" bson:"net_total,omitempty"`
+	DeliveryFeeRefunded bool  `json:"deliveryFeeRefunded,omitempty" bson:"delivery_fee_refunded,omitempty"`
+
+	// Tenders are the gift cards and store credit that paid StoredValuePaid
+	// of TotalPrice; the card pays the rest (see CardDue).
+	Tenders         []Tender `json:"tenders,omitempty" bson:"tenders,omitempty"`
+	StoredValuePaid Money    `json:"storedValuePaid,omitempty" bson:"stored_value_paid,omitempty"`
+
+	// Discounts itemizes the promotions applied, including that of the
+	// redeemed CouponCode; DiscountTotal is their sum.
+	CouponCode    string            `json:"couponCode,omitempty" bson:"coupon_code,omitempty"`
+	Discounts     []AppliedDiscount `json:"discounts,omitempty" bson:"discounts,omitempty"`
+	DiscountTotal Money             `json:"discountTotal,omitempty" bson:"discount_total,omitempty"`
+
+	// TaxMode is the pricing mode the order was taxed under (see Tax*),
+	// TaxTotal its tax and TaxLines the tax per rate for the receipt.
+	// Delivery fees are not taxed.
+	TaxMode  string    `json:"taxMode,omitempty" bson:"tax_mode,omitempty"`
+	TaxTotal Money     `json:"taxTotal" bson:"tax_total"`
+	TaxLines []TaxLine `json:"taxLines,omitempty" bson:"tax_lines,omitempty"`
+
+	// LoyaltyPointsRedeemed were spent on the order; what they took off is
+	// one of the Discounts.
+	LoyaltyPointsRedeemed int64 `json:"loyaltyPointsRedeemed,omitempty" bson:"loyalty_points_redeemed,omitempty"`
+
+	// Click-and-collect.
+	PickupSlot *PickupSlotRef `json:"pickupSlot,omitempty" bson:"pickup_slot,omitempty"`
+}
+
+// CardDue is what is left of TotalPrice for the payment provider once stored
+// value has paid its part.
+func (o *Order) CardDue() Money {
+	return o.TotalPrice - o.StoredValuePaid
 }
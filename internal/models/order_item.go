@@ -7,5 +7,15 @@ type OrderItem struct {
 	OrderID   primitive.ObjectID `json:"orderId" bson:"order_id"`
 	ProductID primitive.ObjectID `json:"productId" bson:"product_id"`
 	Quantity  int                `json:"quantity" bson:"quantity"`
-	Price     float64            `json:"price" bson:"price"`
+	Price     Money              `json:"price" bson:"price"`
+	// Discount is what promotions took off Price*Quantity.
+	Discount Money `json:"discount,omitempty" bson:"discount,omitempty"`
+	// TaxClass and TaxRate (basis points) are what the line was taxed at
+	// and Tax the line's tax, included in or added to the discounted line
+	// price according to the order's TaxMode.
+	TaxClass string `json:"taxClass,omitempty" bson:"tax_class,omitempty"`
+	TaxRate  int64  `json:"taxRate" bson:"tax_rate"`
+	Tax      Money  `json:"tax" bson:"tax"`
+	// RefundedQuantity is how many of Quantity have been refunded.
+	RefundedQuantity int `json:"refundedQuantity,omitempty" bson:"refunded_quantity,omitempty"`
 }
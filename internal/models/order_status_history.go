@@ -13,10 +13,13 @@ type OrderStatusHistory struct {
 }
 
 type OrderTracking struct {
-	OrderID   primitive.ObjectID   `json:"orderId"`
-	UserID    primitive.ObjectID   `json:"userId"`
-	Status    string               `json:"status"`
-	History   []OrderStatusHistory `json:"history"`
-	CreatedAt time.Time            `json:"createdAt"`
-	UpdatedAt time.Time            `json:"updatedAt"`
+	OrderID        primitive.ObjectID   `json:"orderId"`
+	UserID         primitive.ObjectID   `json:"userId"`
+	Status         string               `json:"status"`
+	PaymentStatus  string               `json:"paymentStatus,omitempty"`
+	History        []OrderStatusHistory `json:"history"`
+	DeliveryWindow *DeliveryWindow      `json:"deliveryWindow,omitempty"`
+	PickupSlot     *PickupSlotRef       `json:"pickupSlot,omitempty"`
+	CreatedAt      time.Time            `json:"createdAt"`
+	UpdatedAt      time.Time            `json:"updatedAt"`
 }
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Payment statuses. An order's PaymentStatus mirrors its latest payment.
+const (
+	PaymentUnpaid     = "UNPAID"
+	PaymentPending    = "PENDING"
+	PaymentAuthorized = "AUTHORIZED"
+	PaymentDeclined   = "DECLINED"
+	PaymentCaptured   = "CAPTURED"
+	PaymentVoided     = "VOIDED"
+	// PaymentRefunded and PaymentPartiallyRefunded follow a capture.
+	PaymentRefunded          = "REFUNDED"
+	PaymentPartiallyRefunded = "PARTIALLY_REFUNDED"
+	// PaymentNotRequired is an order's status when stored value paid all
+	// of it.
+	PaymentNotRequired = "NOT_REQUIRED"
+)
+
+// Payment is one attempt to pay for an order through a payment provider. A
+// declined attempt stays on record and the customer may try again.
+type Payment struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrderID  primitive.ObjectID `json:"orderId" bson:"order_id"`
+	UserID   primitive.ObjectID `json:"userId" bson:"user_id"`
+	Provider string             `json:"provider" bson:"provider"`
+	// ProviderRef is the provider's id for the payment.
+	ProviderRef    string         `json:"providerRef,omitempty" bson:"provider_ref,omitempty"`
+	Amount         Money          `json:"amount" bson:"amount"`
+	Currency       string         `json:"currency" bson:"currency"`
+	Status         string         `json:"status" bson:"status"`
+	DeclineReason  string         `json:"declineReason,omitempty" bson:"decline_reason,omitempty"`
+	CapturedAmount Money          `json:"capturedAmount,omitempty" bson:"captured_amount,omitempty"`
+	RefundedAmount Money          `json:"refundedAmount,omitempty" bson:"refunded_amount,omitempty"`
+	Events         []PaymentEvent `json:"events" bson:"events"`
+	CreatedAt      time.Time      `json:"createdAt" bson:"created_at"`
+	UpdatedAt      time.Time      `json:"updatedAt" bson:"updated_at"`
+}
+
+type PaymentEvent struct {
+	Status    string    `json:"status" bson:"status"`
+	Amount    Money     `json:"amount,omitempty" bson:"amount,omitempty"`
+	Note      string    `json:"note,omitempty" bson:"note,omitempty"`
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// OpeningHours is one weekday's trading hours in store-local "HH:MM" time.
+type OpeningHours struct {
+	Weekday time.Weekday `json:"weekday" bson:"weekday"`
+	Open    string       `json:"open" bson:"open"`
+	Close   string       `json:"close" bson:"close"`
+	Closed  bool         `json:"closed" bson:"closed"`
+}
+
+// PickupSettings controls which click-and-collect slots are offered.
+type PickupSettings struct {
+	ID          string         `json:"-" bson:"_id"`
+	Timezone    string         `json:"timezone" bson:"timezone"`
+	SlotMinutes int            `json:"slotMinutes" bson:"slot_minutes"`
+	Capacity    int            `json:"capacity" bson:"capacity"`
+	LeadMinutes int            `json:"leadMinutes" bson:"lead_minutes"`
+	HorizonDays int            `json:"horizonDays" bson:"horizon_days"`
+	Hours       []OpeningHours `json:"hours" bson:"hours"`
+	UpdatedAt   time.Time      `json:"updatedAt" bson:"updated_at"`
+}
+
+// PickupSlot is a bookable window. Remaining is computed from current
+// capacity and bookings.
+type PickupSlot struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Capacity  int       `json:"capacity"`
+	Booked    int       `json:"booked"`
+	Remaining int       `json:"remaining"`
+	Available bool      `json:"available"`
+}
+
+// PickupSlotRef is the slot stored on an order.
+type PickupSlotRef struct {
+	Start time.Time `json:"start" bson:"start"`
+	End   time.Time `json:"end" bson:"end"`
+}
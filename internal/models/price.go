@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Price change sources.
+const (
+	PriceSourceCreated   = "CREATED"   // the product's first price
+	PriceSourceManual    = "MANUAL"    // edited by staff or an API key
+	PriceSourceScheduled = "SCHEDULED" // applied from a PriceSchedule
+)
+
+// PriceChange records one change of a product's list price; markdowns are
+// not recorded (see Markdown). ChangedBy is the staff user, APIKeyID the
+// integration; a scheduled change carries whoever scheduled it.
+type PriceChange struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ProductID  primitive.ObjectID `json:"productId" bson:"product_id"`
+	OldPrice   Money              `json:"oldPrice" bson:"old_price"`
+	NewPrice   Money              `json:"newPrice" bson:"new_price"`
+	Currency   string             `json:"currency" bson:"currency"`
+	Source     string             `json:"source" bson:"source"`
+	ScheduleID primitive.ObjectID `json:"scheduleId,omitempty" bson:"schedule_id,omitempty"`
+	ChangedBy  primitive.ObjectID `json:"changedBy,omitempty" bson:"changed_by,omitempty"`
+	APIKeyID   string             `json:"apiKeyId,omitempty" bson:"api_key_id,omitempty"`
+	ChangedAt  time.Time          `json:"changedAt" bson:"changed_at"`
+}
+
+// Price schedule statuses.
+const (
+	ScheduleStatusPending = "PENDING"
+	// ScheduleStatusApplying is held while the price is being changed.
+	ScheduleStatusApplying  = "APPLYING"
+	ScheduleStatusApplied   = "APPLIED"
+	ScheduleStatusCancelled = "CANCELLED"
+	ScheduleStatusFailed    = "FAILED"
+)
+
+// PriceSchedule is a future price for a product, applied by the scheduler
+// once EffectiveAt has passed.
+type PriceSchedule struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ProductID   primitive.ObjectID `json:"productId" bson:"product_id"`
+	Price       Money              `json:"price" bson:"price"`
+	EffectiveAt time.Time          `json:"effectiveAt" bson:"effective_at"`
+	Note        string             `json:"note,omitempty" bson:"note,omitempty"`
+	Status      string             `json:"status" bson:"status"`
+	Failure     string             `json:"failure,omitempty" bson:"failure,omitempty"`
+	CreatedBy   primitive.ObjectID `json:"createdBy,omitempty" bson:"created_by,omitempty"`
+	APIKeyID    string             `json:"apiKeyId,omitempty" bson:"api_key_id,omitempty"`
+	AppliedAt   *time.Time         `json:"appliedAt,omitempty" bson:"applied_at,omitempty"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updatedAt" bson:"updated_at"`
+}
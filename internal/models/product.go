@@ -7,20 +7,34 @@ import (
 )
 
 type Product struct {
-	ID                 primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Name               string             `json:"name" bson:"name"`
-	Description        string             `json:"description" bson:"description"`
-	Barcode            string             `json:"barcode" bson:"barcode"`
-	BrandID            primitive.ObjectID `json:"brandId" bson:"brand_id"`
-	CategoryID         primitive.ObjectID `json:"categoryId" bson:"category_id"`
-	Price              float64            `json:"price" bson:"price"`
-	Currency           string             `json:"currency" bson:"currency"`
-	Aisle              string             `json:"aisle" bson:"aisle"`
-	Section            string             `json:"section" bson:"section"`
-	Shelf              string             `json:"shelf" bson:"shelf"`
-	StockQty           int                `json:"stockQty" bson:"stock_qty"`
-	AvailabilityStatus string             `json:"availabilityStatus" bson:"availability_status"`
-	ImageURL           string             `json:"imageUrl" bson:"image_url"`
-	CreatedAt          time.Time          `json:"createdAt" bson:"created_at"`
-	UpdatedAt          time.Time          `json:"updatedAt" bson:"updated_at"`
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description" bson:"description"`
+	Barcode     string             `json:"barcode" bson:"barcode"`
+	BrandID     primitive.ObjectID `json:"brandId" bson:"brand_id"`
+	CategoryID  primitive.ObjectID `json:"categoryId" bson:"category_id"`
+	Price       Money              `json:"price" bson:"price"`
+	Currency    string             `json:"currency" bson:"currency"`
+	// TaxClassID overrides the category's tax class.
+	TaxClassID         *primitive.ObjectID `json:"taxClassId,omitempty" bson:"tax_class_id,omitempty"`
+	Aisle              string              `json:"aisle" bson:"aisle"`
+	Section            string              `json:"section" bson:"section"`
+	Shelf              string              `json:"shelf" bson:"shelf"`
+	StockQty           int                 `json:"stockQty" bson:"stock_qty"`
+	AvailabilityStatus string              `json:"availabilityStatus" bson:"availability_status"`
+	ImageURL           string              `json:"imageUrl" bson:"image_url"`
+	// Markdown is set by the markdown scheduler while the product is
+	// reduced to clear.
+	Markdown  *Markdown `json:"markdown,omitempty" bson:"markdown,omitempty"`
+	CreatedAt time.Time `json:"createdAt" bson:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updated_at"`
+}
+
+// SellingPrice is what the product sells for now: its price, less any
+// markdown.
+func (p *Product) SellingPrice() Money {
+	if p.Markdown == nil {
+		return p.Price
+	}
+	return p.Price - p.Price.MulRatio(p.Markdown.BasisPoints, 10000)
 }
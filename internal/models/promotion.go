@@ -0,0 +1,109 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Promotion types.
+const (
+	// PromoPercentOff takes BasisPoints off each matching line.
+	PromoPercentOff = "PERCENT_OFF"
+	// PromoFixedOff takes Amount off each matching unit.
+	PromoFixedOff = "FIXED_OFF"
+	// PromoBuyXGetY gives GetQty units free for every BuyQty bought of the
+	// same product.
+	PromoBuyXGetY = "BUY_X_GET_Y"
+	// PromoMultiBuy sells BundleQty units of the same product for
+	// BundlePrice.
+	PromoMultiBuy = "MULTI_BUY"
+	// PromoSpendThreshold takes Amount or BasisPoints off once the matching
+	// lines come to MinSpend, spread over those lines.
+	PromoSpendThreshold = "SPEND_THRESHOLD"
+)
+
+func IsValidPromotionType(t string) bool {
+	switch t {
+	case PromoPercentOff, PromoFixedOff, PromoBuyXGetY, PromoMultiBuy, PromoSpendThreshold:
+		return true
+	}
+	return false
+}
+
+// Promotion is a discount rule. It matches products listed in ProductIDs or
+// belonging to one of CategoryIDs or BrandIDs, or every product when all
+// three are empty.
+//
+// Promotions are applied highest Priority first. A line that has had a
+// non-stackable promotion applied takes no further promotions, and a
+// non-stackable promotion skips lines that are already discounted.
+type Promotion struct {
+	ID          primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Name        string               `json:"name" bson:"name"`
+	Description string               `json:"description,omitempty" bson:"description,omitempty"`
+	Type        string               `json:"type" bson:"type"`
+	ProductIDs  []primitive.ObjectID `json:"productIds,omitempty" bson:"product_ids,omitempty"`
+	CategoryIDs []primitive.ObjectID `json:"categoryIds,omitempty" bson:"category_ids,omitempty"`
+	BrandIDs    []primitive.ObjectID `json:"brandIds,omitempty" bson:"brand_ids,omitempty"`
+
+	BasisPoints int64 `json:"basisPoints,omitempty" bson:"basis_points,omitempty"` // 1500 = 15%
+	Amount      Money `json:"amount,omitempty" bson:"amount,omitempty"`
+	BuyQty      int   `json:"buyQty,omitempty" bson:"buy_qty,omitempty"`
+	GetQty      int   `json:"getQty,omitempty" bson:"get_qty,omitempty"`
+	BundleQty   int   `json:"bundleQty,omitempty" bson:"bundle_qty,omitempty"`
+	BundlePrice Money `json:"bundlePrice,omitempty" bson:"bundle_price,omitempty"`
+	MinSpend    Money `json:"minSpend,omitempty" bson:"min_spend,omitempty"`
+
+	// The promotion runs from StartsAt until EndsAt; either may be left
+	// open.
+	StartsAt  *time.Time `json:"startsAt,omitempty" bson:"starts_at,omitempty"`
+	EndsAt    *time.Time `json:"endsAt,omitempty" bson:"ends_at,omitempty"`
+	Priority  int        `json:"priority" bson:"priority"`
+	Stackable bool       `json:"stackable" bson:"stackable"`
+	// CouponOnly promotions apply only through a coupon code.
+	CouponOnly bool      `json:"couponOnly,omitempty" bson:"coupon_only,omitempty"`
+	Active     bool      `json:"active" bson:"active"`
+	CreatedAt  time.Time `json:"createdAt" bson:"created_at"`
+	UpdatedAt  time.Time `json:"updatedAt" bson:"updated_at"`
+}
+
+// RunningAt reports whether the promotion is active and within its window.
+func (p *Promotion) RunningAt(t time.Time) bool {
+	return p.Active && (p.StartsAt == nil || !p.StartsAt.After(t)) && (p.EndsAt == nil || p.EndsAt.After(t))
+}
+
+// Matches reports whether the promotion targets a product.
+func (p *Promotion) Matches(pr *Product) bool {
+	if len(p.ProductIDs) == 0 && len(p.CategoryIDs) == 0 && len(p.BrandIDs) == 0 {
+		return true
+	}
+	for _, id := range p.ProductIDs {
+		if id == pr.ID {
+			return true
+		}
+	}
+	for _, id := range p.CategoryIDs {
+		if id == pr.CategoryID {
+			return true
+		}
+	}
+	for _, id := range p.BrandIDs {
+		if id == pr.BrandID {
+			return true
+		}
+	}
+	return false
+}
+
+// AppliedDiscount is one promotion's discount on a cart or order, per
+// product; ProductID is empty for spend-threshold discounts, which are
+// spread over the lines.
+type AppliedDiscount struct {
+	PromotionID primitive.ObjectID `json:"promotionId" bson:"promotion_id"`
+	Name        string             `json:"name" bson:"name"`
+	Type        string             `json:"type" bson:"type"`
+	ProductID   primitive.ObjectID `json:"productId,omitempty" bson:"product_id,omitempty"`
+	CouponCode  string             `json:"couponCode,omitempty" bson:"coupon_code,omitempty"`
+	Amount      Money              `json:"amount" bson:"amount"`
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Order statuses after money has gone back to the customer.
+const (
+	OrderRefunded          = "REFUNDED"
+	OrderPartiallyRefunded = "PARTIALLY_REFUNDED"
+)
+
+// Refund reason codes.
+const (
+	RefundDamaged         = "DAMAGED"
+	RefundSpoiled         = "SPOILED"
+	RefundWrongItem       = "WRONG_ITEM"
+	RefundMissingItem     = "MISSING_ITEM"
+	RefundLateDelivery    = "LATE_DELIVERY"
+	RefundCustomerRequest = "CUSTOMER_REQUEST"
+	RefundOther           = "OTHER"
+)
+
+func IsValidRefundReason(r string) bool {
+	switch r {
+	case RefundDamaged, RefundSpoiled, RefundWrongItem, RefundMissingItem,
+		RefundLateDelivery, RefundCustomerRequest, RefundOther:
+		return true
+	}
+	return false
+}
+
+// Refund record statuses.
+const (
+	RefundPending   = "PENDING"
+	RefundCompleted = "COMPLETED"
+	RefundFailed    = "FAILED"
+)
+
+// Refund methods: back through the payment provider, as store credit, back
+// to the gift cards and store credit an order was paid with, or handled
+// outside the system for orders that predate online payment. Whatever the
+// method, except STORE_CREDIT, stored value the order was paid with is
+// refunded first (see Refund.StoredValue).
+const (
+	RefundMethodProvider    = "PROVIDER"
+	RefundMethodStoreCredit = "STORE_CREDIT"
+	RefundMethodStoredValue = "STORED_VALUE"
+	RefundMethodManual      = "MANUAL"
+)
+
+// Refund is money given back on an order by staff, for some of its lines
+// and optionally the delivery fee.
+type Refund struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrderID     primitive.ObjectID `json:"orderId" bson:"order_id"`
+	PaymentID   primitive.ObjectID `json:"paymentId,omitempty" bson:"payment_id,omitempty"`
+	Lines       []RefundLine       `json:"lines" bson:"lines"`
+	DeliveryFee Money              `json:"deliveryFee,omitempty" bson:"delivery_fee,omitempty"`
+	Amount      Money              `json:"amount" bson:"amount"`
+	Reason      string             `json:"reason" bson:"reason"`
+	Note        string             `json:"note,omitempty" bson:"note,omitempty"`
+	Method      string             `json:"method" bson:"method"`
+	// StoredValue is the part of Amount going back to the order's tenders.
+	StoredValue []Tender           `json:"storedValue,omitempty" bson:"stored_value,omitempty"`
+	Status      string             `json:"status" bson:"status"`
+	Failure     string             `json:"failure,omitempty" bson:"failure,omitempty"`
+	CreatedBy   primitive.ObjectID `json:"createdBy" bson:"created_by"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updatedAt" bson:"updated_at"`
+}
+
+type RefundLine struct {
+	ProductID primitive.ObjectID `json:"productId" bson:"product_id"`
+	Quantity  int                `json:"quantity" bson:"quantity"`
+	Amount    Money              `json:"amount" bson:"amount"`
+	Tax       Money              `json:"tax,omitempty" bson:"tax,omitempty"` // part of Amount
+	// Restock puts the quantity back on the shelf; otherwise it is written
+	// off.
+	Restock bool `json:"restock" bson:"restock"`
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Return request statuses, in the order they usually happen.
+const (
+	ReturnRequested = "REQUESTED"
+	ReturnApproved  = "APPROVED"
+	ReturnRejected  = "REJECTED"
+	ReturnCancelled = "CANCELLED"
+	ReturnReceived  = "RECEIVED"
+	// ReturnResolving marks a return whose refund is being made; it goes
+	// back to RECEIVED if the refund fails.
+	ReturnResolving = "RESOLVING"
+	ReturnRefunded  = "REFUNDED"
+	ReturnCredited  = "CREDITED"
+)
+
+// What happens to returned goods once staff have them.
+const (
+	DispositionRestock  = "RESTOCK"
+	DispositionWriteOff = "WRITE_OFF"
+)
+
+// How a received return is settled.
+const (
+	ResolutionRefund      = "REFUND"
+	ResolutionStoreCredit = "STORE_CREDIT"
+)
+
+// ReturnRequest is a customer's request to bring back items of an order.
+type ReturnRequest struct {
+	ID      primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrderID primitive.ObjectID `json:"orderId" bson:"order_id"`
+	UserID  primitive.ObjectID `json:"userId" bson:"user_id"`
+	Lines   []ReturnLine       `json:"lines" bson:"lines"`
+	// Reason is one of the refund reason codes.
+	Reason     string             `json:"reason" bson:"reason"`
+	Comment    string             `json:"comment,omitempty" bson:"comment,omitempty"`
+	Status     string             `json:"status" bson:"status"`
+	Resolution string             `json:"resolution,omitempty" bson:"resolution,omitempty"`
+	Amount     Money              `json:"amount,omitempty" bson:"amount,omitempty"`
+	RefundID   primitive.ObjectID `json:"refundId,omitempty" bson:"refund_id,omitempty"`
+	Timeline   []ReturnEvent      `json:"timeline" bson:"timeline"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"created_at"`
+	UpdatedAt  time.Time          `json:"updatedAt" bson:"updated_at"`
+}
+
+type ReturnLine struct {
+	ProductID primitive.ObjectID `json:"productId" bson:"product_id"`
+	Quantity  int                `json:"quantity" bson:"quantity"`
+	// Disposition is set when the goods are received.
+	Disposition string `json:"disposition,omitempty" bson:"disposition,omitempty"`
+}
+
+type ReturnEvent struct {
+	Status    string             `json:"status" bson:"status"`
+	Note      string             `json:"note,omitempty" bson:"note,omitempty"`
+	By        primitive.ObjectID `json:"by" bson:"by"`
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SigningKey is an asymmetric key pair used to sign access tokens. A retired
+// key no longer signs but keeps verifying tokens until ExpiresAt. The private
+// key is stored encrypted in PrivateKeyEnc; PrivateKeyPEM is only set on keys
+// saved before encryption, until they are encrypted on the next load.
+type SigningKey struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Kid           string             `json:"kid" bson:"kid"`
+	Alg           string             `json:"alg" bson:"alg"`
+	PrivateKeyPEM string             `json:"-" bson:"private_key_pem,omitempty"`
+	PrivateKeyEnc string             `json:"-" bson:"private_key_enc,omitempty"`
+	PublicKeyPEM  string             `json:"publicKeyPem" bson:"public_key_pem"`
+	CreatedAt     time.Time          `json:"createdAt" bson:"created_at"`
+	RetiredAt     *time.Time         `json:"retiredAt,omitempty" bson:"retired_at,omitempty"`
+	ExpiresAt     *time.Time         `json:"expiresAt,omitempty" bson:"expires_at,omitempty"`
+}
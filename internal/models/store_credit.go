@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Store credit sources, the kind of the ledger transfer.
+const (
+	CreditSourceRefund = TransferRefund
+)
+
+// StoreCreditEntry is one movement on a customer's store credit, as seen
+// from their account: a ledger transfer in or, with a negative Amount, out.
+type StoreCreditEntry struct {
+	ID     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID primitive.ObjectID `json:"userId" bson:"user_id"`
+	Amount Money              `json:"amount" bson:"amount"`
+	Source string             `json:"source" bson:"source"`
+	// RefID is the record that caused the movement, e.g. the refund.
+	RefID     primitive.ObjectID `json:"refId,omitempty" bson:"ref_id,omitempty"`
+	Note      string             `json:"note,omitempty" bson:"note,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"created_at"`
+}
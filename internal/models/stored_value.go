@@ -0,0 +1,98 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Stored value (gift cards and store credit) is kept as a ledger of
+// transfers between accounts. Customer accounts never go below zero; the
+// system accounts are where value comes from and goes to, so the balances of
+// all accounts always add up to zero.
+const (
+	AccountGiftCardsIssued = "system:gift_cards_issued"
+	AccountCheckout        = "system:checkout" // stored value taken as payment for orders
+	AccountRefunds         = "system:refunds"
+)
+
+// GiftCardAccount is the ledger account holding a gift card's balance.
+func GiftCardAccount(id primitive.ObjectID) string {
+	return "gift_card:" + id.Hex()
+}
+
+// StoreCreditAccount is the ledger account holding a customer's store credit.
+func StoreCreditAccount(userID primitive.ObjectID) string {
+	return "store_credit:" + userID.Hex()
+}
+
+// Ledger transfer kinds.
+const (
+	TransferIssue    = "ISSUE"    // a gift card is loaded
+	TransferRedeem   = "REDEEM"   // spent at checkout
+	TransferReversal = "REVERSAL" // a redemption given back, the order was not placed or was cancelled
+	TransferRefund   = "REFUND"   // money given back on an order
+)
+
+// LedgerTransfer moves Amount from one account to another. Once applied,
+// transfers are never changed or deleted.
+type LedgerTransfer struct {
+	ID      primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	From    string             `json:"from" bson:"from"`
+	To      string             `json:"to" bson:"to"`
+	Amount  Money              `json:"amount" bson:"amount"`
+	Kind    string             `json:"kind" bson:"kind"`
+	OrderID primitive.ObjectID `json:"orderId,omitempty" bson:"order_id,omitempty"`
+	// RefID is the record that caused the transfer, e.g. the refund.
+	RefID     primitive.ObjectID `json:"refId,omitempty" bson:"ref_id,omitempty"`
+	Note      string             `json:"note,omitempty" bson:"note,omitempty"`
+	CreatedBy primitive.ObjectID `json:"createdBy,omitempty" bson:"created_by,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"created_at"`
+	// Pending is set while the balances are being moved. A transfer left
+	// pending is rolled back and dropped.
+	Pending bool `json:"-" bson:"pending,omitempty"`
+}
+
+// GiftCard is a stored-value card redeemed with its code and PIN. Its
+// balance lives in the ledger under GiftCardAccount.
+type GiftCard struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Code         string             `json:"code" bson:"code"`
+	PINHash      string             `json:"-" bson:"pin_hash"`
+	InitialValue Money              `json:"initialValue" bson:"initial_value"`
+	Currency     string             `json:"currency" bson:"currency"`
+	ExpiresAt    *time.Time         `json:"expiresAt,omitempty" bson:"expires_at,omitempty"`
+	Disabled     bool               `json:"disabled" bson:"disabled"`
+	// Wrong PINs lock the card for a while; see LockedUntil.
+	FailedPINAttempts int                `json:"-" bson:"failed_pin_attempts"`
+	LockedUntil       *time.Time         `json:"lockedUntil,omitempty" bson:"locked_until,omitempty"`
+	IssuedBy          primitive.ObjectID `json:"issuedBy,omitempty" bson:"issued_by,omitempty"`
+	CreatedAt         time.Time          `json:"createdAt" bson:"created_at"`
+
+	Balance Money `json:"balance" bson:"-"` // filled from the ledger
+}
+
+// MaskedCode shows only the last four characters of the code.
+func (g *GiftCard) MaskedCode() string {
+	if len(g.Code) <= 4 {
+		return g.Code
+	}
+	return "****" + g.Code[len(g.Code)-4:]
+}
+
+// Tender methods other than the card payment.
+const (
+	TenderGiftCard    = "GIFT_CARD"
+	TenderStoreCredit = "STORE_CREDIT"
+)
+
+// Tender is stored value that paid for part of an order.
+type Tender struct {
+	Method     string             `json:"method" bson:"method"`
+	Account    string             `json:"-" bson:"account"`
+	GiftCardID primitive.ObjectID `json:"giftCardId,omitempty" bson:"gift_card_id,omitempty"`
+	// Code is the gift card code, masked.
+	Code     string `json:"code,omitempty" bson:"code,omitempty"`
+	Amount   Money  `json:"amount" bson:"amount"`
+	Refunded Money  `json:"refunded,omitempty" bson:"refunded,omitempty"`
+}
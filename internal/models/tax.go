@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tax pricing modes: whether shelf prices already include tax.
+const (
+	TaxInclusive = "INCLUSIVE"
+	TaxExclusive = "EXCLUSIVE"
+)
+
+// TaxRate is a rate in basis points (1200 = 12%) that applies from
+// EffectiveFrom until the next rate of its class starts. A zero
+// EffectiveFrom applies from the beginning of time.
+type TaxRate struct {
+	BasisPoints   int64     `json:"basisPoints" bson:"basis_points"`
+	EffectiveFrom time.Time `json:"effectiveFrom" bson:"effective_from"`
+}
+
+// TaxClass groups goods taxed alike, e.g. standard-rated or zero-rated food.
+// It is assigned to categories and, overriding that, to single products.
+type TaxClass struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Code      string             `json:"code" bson:"code"`
+	Name      string             `json:"name" bson:"name"`
+	Rates     []TaxRate          `json:"rates" bson:"rates"` // sorted by EffectiveFrom
+	CreatedAt time.Time          `json:"createdAt" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updatedAt" bson:"updated_at"`
+}
+
+// RateAt returns the rate in effect at t; ok is false when none has started.
+func (c *TaxClass) RateAt(t time.Time) (rate TaxRate, ok bool) {
+	for _, r := range c.Rates {
+		if r.EffectiveFrom.After(t) {
+			break
+		}
+		rate, ok = r, true
+	}
+	return rate, ok
+}
+
+// TaxSettings is the store-wide tax configuration. Goods whose product and
+// category have no tax class fall back to DefaultClassID, and are untaxed
+// when that is unset too.
+type TaxSettings struct {
+	ID             string              `json:"-" bson:"_id"`
+	Mode           string              `json:"mode" bson:"mode"`
+	DefaultClassID *primitive.ObjectID `json:"defaultClassId,omitempty" bson:"default_class_id,omitempty"`
+	UpdatedAt      time.Time           `json:"updatedAt" bson:"updated_at"`
+}
+
+// TaxLine is one rate's share of an order, as broken out on the receipt.
+// Net excludes tax whatever the pricing mode.
+type TaxLine struct {
+	ClassCode   string `json:"classCode" bson:"class_code"`
+	BasisPoints int64  `json:"basisPoints" bson:"basis_points"`
+	Net         Money  `json:"net" bson:"net"`
+	Tax         Money  `json:"tax" bson:"tax"`
+}
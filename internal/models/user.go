@@ -1,16 +1,66 @@
 package models
 
 import (
+	"errors"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	RoleCustomer = "customer"
+	RoleStaff    = "staff"
+	RoleAdmin    = "admin"
+	RoleCourier  = "courier"
+)
+
 type User struct {
 	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	FullName     string             `json:"fullName" bson:"full_name"`
 	Email        string             `json:"email" bson:"email"`
-	PasswordHash string             `json:"passwordHash" bson:"password_hash"`
+	PasswordHash string             `json:"-" bson:"password_hash"`
 	Role         string             `json:"role" bson:"role"`
-	CreatedAt    time.Time          `json:"createdAt" bson:"created_at"`
+	Disabled     bool               `json:"disabled" bson:"disabled"`
+
+	// Email changes wait here until the new address is confirmed.
+	PendingEmail         string     `json:"pendingEmail,omitempty" bson:"pending_email,omitempty"`
+	EmailVerifyTokenHash string     `json:"-" bson:"email_verify_token_hash,omitempty"`
+	EmailVerifyExpiresAt *time.Time `json:"-" bson:"email_verify_expires_at,omitempty"`
+
+	// TOTP two-factor authentication. The pending secret is kept until the
+	// first code confirms the authenticator app was set up.
+	TOTPEnabled        bool     `json:"-" bson:"totp_enabled,omitempty"`
+	TOTPSecret         string   `json:"-" bson:"totp_secret,omitempty"`
+	TOTPPendingSecret  string   `json:"-" bson:"totp_pending_secret,omitempty"`
+	TOTPLastStep       int64    `json:"-" bson:"totp_last_step,omitempty"`
+	RecoveryCodeHashes []string `json:"-" bson:"recovery_code_hashes,omitempty"`
+
+	// Accounts at external OpenID Connect providers that can sign in as this
+	// user. Users created through OIDC have no password.
+	ExternalIdentities []ExternalIdentity `json:"-" bson:"external_identities,omitempty"`
+
+	// Users without a password confirm sensitive changes with a code sent by
+	// email instead. The code is good for one action.
+	ConfirmCodeHash  string     `json:"-" bson:"confirm_code_hash,omitempty"`
+	ConfirmAction    string     `json:"-" bson:"confirm_action,omitempty"`
+	ConfirmExpiresAt *time.Time `json:"-" bson:"confirm_expires_at,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt" bson:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updated_at,omitempty"`
+}
+
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleCustomer, RoleStaff, RoleAdmin, RoleCourier:
+		return true
+	}
+	return false
+}
+
+var errUserNotSerializable = errors.New("models.User must not be written to responses; use a views type")
+
+// MarshalJSON refuses to encode the storage model so a handler can't leak
+// PasswordHash or verification tokens by accident. See package views.
+func (User) MarshalJSON() ([]byte, error) {
+	return nil, errUserNotSerializable
 }
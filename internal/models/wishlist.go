@@ -21,7 +21,7 @@ type Wishlist struct {
 type WishlistItemWithProduct struct {
 	ProductID primitive.ObjectID `json:"productId"`
 	Name      string             `json:"name"`
-	Price     float64            `json:"price"`
+	Price     Money              `json:"price"`
 	ImageURL  string             `json:"imageUrl"`
 	InStock   bool               `json:"inStock"`
 	AddedAt   time.Time          `json:"addedAt"`
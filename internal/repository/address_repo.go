@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AddressRepo interface {
+	ListByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.Address, error)
+	FindByID(ctx context.Context, userID, id primitive.ObjectID) (*models.Address, error)
+	CountByUserID(ctx context.Context, userID primitive.ObjectID) (int64, error)
+	Create(ctx context.Context, a *models.Address) (primitive.ObjectID, error)
+	Update(ctx context.Context, a *models.Address) error
+	Delete(ctx context.Context, userID, id primitive.ObjectID) error
+	// SetDefault makes id the user's only default address.
+	SetDefault(ctx context.Context, userID, id primitive.ObjectID) error
+	DeleteByUserID(ctx context.Context, userID primitive.ObjectID) error
+}
+
+type addressRepo struct {
+	col *mongo.Collection
+}
+
+func NewAddressRepo(db *mongo.Database) (AddressRepo, error) {
+	col := db.Collection("addresses")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &addressRepo{col: col}, nil
+}
+
+// ListByUserID returns the default address first, then the rest oldest first.
+func (r *addressRepo) ListByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.Address, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "is_default", Value: -1}, {Key: "created_at", Value: 1}})
+	cur, err := r.col.Find(ctx, bson.M{"user_id": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.Address{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *addressRepo) FindByID(ctx context.Context, userID, id primitive.ObjectID) (*models.Address, error) {
+	var a models.Address
+	if err := r.col.FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (r *addressRepo) CountByUserID(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return r.col.CountDocuments(ctx, bson.M{"user_id": userID})
+}
+
+func (r *addressRepo) Create(ctx context.Context, a *models.Address) (primitive.ObjectID, error) {
+	now := time.Now()
+	a.CreatedAt = now
+	a.UpdatedAt = now
+	res, err := r.col.InsertOne(ctx, a)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+// Update saves the editable fields. The default flag is changed through
+// SetDefault only.
+func (r *addressRepo) Update(ctx context.Context, a *models.Address) error {
+	a.UpdatedAt = time.Now()
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"_id": a.ID, "user_id": a.UserID},
+		bson.M{"$set": bson.M{
+			"label":       a.Label,
+			"street":      a.Street,
+			"city":        a.City,
+			"postal_code": a.PostalCode,
+			"phone":       a.Phone,
+			"notes":       a.Notes,
+			"location":    a.Location,
+			"updated_at":  a.UpdatedAt,
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *addressRepo) Delete(ctx context.Context, userID, id primitive.ObjectID) error {
+	res, err := r.col.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *addressRepo) SetDefault(ctx context.Context, userID, id primitive.ObjectID) error {
+	now := time.Now()
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "user_id": userID},
+		bson.M{"$set": bson.M{"is_default": true, "updated_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	_, err = r.col.UpdateMany(
+		ctx,
+		bson.M{"user_id": userID, "_id": bson.M{"$ne": id}, "is_default": true},
+		bson.M{"$set": bson.M{"is_default": false, "updated_at": now}},
+	)
+	return err
+}
+
+func (r *addressRepo) DeleteByUserID(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.col.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
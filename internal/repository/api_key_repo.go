@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Last-used timestamps are written at most this often per key, so a busy
+// scanner doesn't turn every request into a write.
+const apiKeyTouchInterval = time.Minute
+
+type APIKeyRepo interface {
+	Create(ctx context.Context, k *models.APIKey) (primitive.ObjectID, error)
+	FindByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	List(ctx context.Context) ([]models.APIKey, error)
+	Revoke(ctx context.Context, id primitive.ObjectID) error
+	TouchLastUsed(ctx context.Context, id primitive.ObjectID) error
+}
+
+type apiKeyRepo struct {
+	col *mongo.Collection
+}
+
+func NewAPIKeyRepo(db *mongo.Database) (APIKeyRepo, error) {
+	col := db.Collection("api_keys")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "prefix", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &apiKeyRepo{col: col}, nil
+}
+
+func (r *apiKeyRepo) Create(ctx context.Context, k *models.APIKey) (primitive.ObjectID, error) {
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = time.Now()
+	}
+	res, err := r.col.InsertOne(ctx, k)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+func (r *apiKeyRepo) FindByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	var k models.APIKey
+	if err := r.col.FindOne(ctx, bson.M{"prefix": prefix}).Decode(&k); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (r *apiKeyRepo) List(ctx context.Context) ([]models.APIKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cur, err := r.col.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var list []models.APIKey
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *apiKeyRepo) Revoke(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *apiKeyRepo) TouchLastUsed(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now()
+	_, err := r.col.UpdateOne(
+		ctx,
+		bson.M{
+			"_id": id,
+			"$or": bson.A{
+				bson.M{"last_used_at": bson.M{"$exists": false}},
+				bson.M{"last_used_at": bson.M{"$lt": now.Add(-apiKeyTouchInterval)}},
+			},
+		},
+		bson.M{"$set": bson.M{"last_used_at": now}},
+	)
+	return err
+}
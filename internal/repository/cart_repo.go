@@ -16,7 +16,11 @@ type CartRepo interface {
 	AddItem(ctx context.Context, userID primitive.ObjectID, productID primitive.ObjectID, quantity int) error
 	UpdateItemQuantity(ctx context.Context, userID primitive.ObjectID, productID primitive.ObjectID, quantity int) error
 	RemoveItem(ctx context.Context, userID primitive.ObjectID, productID primitive.ObjectID) error
+	// Clear empties the cart and drops its coupon.
 	Clear(ctx context.Context, userID primitive.ObjectID) error
+	// SetCoupon stores the coupon code to use at checkout; "" removes it.
+	SetCoupon(ctx context.Context, userID primitive.ObjectID, code string) error
+	DeleteByUserID(ctx context.Context, userID primitive.ObjectID) error
 }
 
 type cartRepo struct {
@@ -129,7 +133,25 @@ func (r *cartRepo) Clear(ctx context.Context, userID primitive.ObjectID) error {
 				"items":      []models.CartItem{},
 				"updated_at": time.Now(),
 			},
+			"$unset": bson.M{"coupon_code": ""},
 		},
 	)
 	return err
 }
+
+func (r *cartRepo) SetCoupon(ctx context.Context, userID primitive.ObjectID, code string) error {
+	set := bson.M{"updated_at": time.Now()}
+	update := bson.M{"$set": set, "$setOnInsert": bson.M{"items": []models.CartItem{}}}
+	if code == "" {
+		update["$unset"] = bson.M{"coupon_code": ""}
+	} else {
+		set["coupon_code"] = code
+	}
+	_, err := r.col.UpdateOne(ctx, bson.M{"user_id": userID}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (r *cartRepo) DeleteByUserID(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.col.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
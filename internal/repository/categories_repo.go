@@ -60,8 +60,9 @@ func (r *categoryRepo) Update(ctx context.Context, id primitive.ObjectID, c *mod
 		ctx,
 		bson.M{"_id": id},
 		bson.M{"$set": bson.M{
-			"name":      c.Name,
-			"parent_id": c.ParentID,
+			"name":         c.Name,
+			"parent_id":    c.ParentID,
+			"tax_class_id": c.TaxClassID,
 		}},
 	)
 	return err
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type CouponRepo interface {
+	// Create fails with a duplicate key error when the code is taken.
+	Create(ctx context.Context, c *models.Coupon) (primitive.ObjectID, error)
+	FindByCode(ctx context.Context, code string) (*models.Coupon, error)
+	// List filters by promotion and batch when they are set.
+	List(ctx context.Context, promotionID primitive.ObjectID, batch string) ([]models.Coupon, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+
+	// CustomerUses is how many times userID has redeemed the coupon.
+	CustomerUses(ctx context.Context, couponID, userID primitive.ObjectID) (int, error)
+	// Claim counts one redemption unless the coupon already has maxUses or
+	// the customer maxPerCustomer (0 means no limit). It reports which
+	// limit stopped it, and counts nothing in that case.
+	Claim(ctx context.Context, couponID, userID primitive.ObjectID, maxUses, maxPerCustomer int) (codeOK, customerOK bool, err error)
+	// Release gives back a redemption taken by Claim.
+	Release(ctx context.Context, couponID, userID primitive.ObjectID) error
+}
+
+type couponRepo struct {
+	col   *mongo.Collection
+	usage *mongo.Collection
+}
+
+func NewCouponRepo(db *mongo.Database) (CouponRepo, error) {
+	col := db.Collection("coupons")
+	usage := db.Collection("coupon_usage")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "promotion_id", Value: 1}, {Key: "batch", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// One counter per coupon and customer; Claim relies on it being unique.
+	_, err = usage.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "coupon_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &couponRepo{col: col, usage: usage}, nil
+}
+
+func (r *couponRepo) Create(ctx context.Context, c *models.Coupon) (primitive.ObjectID, error) {
+	c.CreatedAt = time.Now()
+	res, err := r.col.InsertOne(ctx, c)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+func (r *couponRepo) FindByCode(ctx context.Context, code string) (*models.Coupon, error) {
+	var c models.Coupon
+	if err := r.col.FindOne(ctx, bson.M{"code": code}).Decode(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *couponRepo) List(ctx context.Context, promotionID primitive.ObjectID, batch string) ([]models.Coupon, error) {
+	filter := bson.M{}
+	if !promotionID.IsZero() {
+		filter["promotion_id"] = promotionID
+	}
+	if batch != "" {
+		filter["batch"] = batch
+	}
+	cur, err := r.col.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "code", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.Coupon{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *couponRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.col.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	_, err = r.usage.DeleteMany(ctx, bson.M{"coupon_id": id})
+	return err
+}
+
+func (r *couponRepo) CustomerUses(ctx context.Context, couponID, userID primitive.ObjectID) (int, error) {
+	var u struct {
+		Uses int `bson:"uses"`
+	}
+	err := r.usage.FindOne(ctx, bson.M{"coupon_id": couponID, "user_id": userID}).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return u.Uses, err
+}
+
+func (r *couponRepo) Claim(ctx context.Context, couponID, userID primitive.ObjectID, maxUses, maxPerCustomer int) (bool, bool, error) {
+	// Each limit is checked and counted in a single update, so concurrent
+	// orders can't both take the last use.
+	filter := bson.M{"_id": couponID}
+	if maxUses > 0 {
+		filter["uses"] = bson.M{"$lt": maxUses}
+	}
+	res, err := r.col.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"uses": 1}})
+	if err != nil {
+		return false, false, err
+	}
+	if res.MatchedCount == 0 {
+		return false, true, nil
+	}
+
+	// The customer's counter is created on first use. Once it has reached
+	// the limit the filter no longer matches and the upsert collides with
+	// the existing counter on the unique index.
+	filter = bson.M{"coupon_id": couponID, "user_id": userID}
+	if maxPerCustomer > 0 {
+		filter["uses"] = bson.M{"$lt": maxPerCustomer}
+	}
+	_, err = r.usage.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"uses": 1}}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		_, err = r.col.UpdateOne(ctx, bson.M{"_id": couponID}, bson.M{"$inc": bson.M{"uses": -1}})
+		return true, false, err
+	}
+	if err != nil {
+		_, _ = r.col.UpdateOne(ctx, bson.M{"_id": couponID}, bson.M{"$inc": bson.M{"uses": -1}})
+		return false, false, err
+	}
+	return true, true, nil
+}
+
+func (r *couponRepo) Release(ctx context.Context, couponID, userID primitive.ObjectID) error {
+	if _, err := r.col.UpdateOne(ctx, bson.M{"_id": couponID, "uses": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"uses": -1}}); err != nil {
+		return err
+	}
+	_, err := r.usage.UpdateOne(ctx, bson.M{"coupon_id": couponID, "user_id": userID, "uses": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"uses": -1}})
+	return err
+}
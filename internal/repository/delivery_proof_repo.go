@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeliveryProofRepo keeps signature images captured at the door in GridFS.
+type DeliveryProofRepo interface {
+	SaveSignature(ctx context.Context, orderID primitive.ObjectID, contentType string, data []byte) (primitive.ObjectID, error)
+	LoadSignature(ctx context.Context, fileID primitive.ObjectID) (data []byte, contentType string, err error)
+}
+
+type deliveryProofRepo struct {
+	bucket *gridfs.Bucket
+}
+
+func NewDeliveryProofRepo(db *mongo.Database) (DeliveryProofRepo, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName("delivery_proofs"))
+	if err != nil {
+		return nil, err
+	}
+	return &deliveryProofRepo{bucket: bucket}, nil
+}
+
+func (r *deliveryProofRepo) SaveSignature(ctx context.Context, orderID primitive.ObjectID, contentType string, data []byte) (primitive.ObjectID, error) {
+	opts := options.GridFSUpload().SetMetadata(bson.M{"order_id": orderID, "content_type": contentType})
+	return r.bucket.UploadFromStream("signature-"+orderID.Hex(), bytes.NewReader(data), opts)
+}
+
+func (r *deliveryProofRepo) LoadSignature(ctx context.Context, fileID primitive.ObjectID) ([]byte, string, error) {
+	var buf bytes.Buffer
+	stream, err := r.bucket.OpenDownloadStream(fileID)
+	if err != nil {
+		return nil, "", err
+	}
+	defer stream.Close()
+	if _, err := buf.ReadFrom(stream); err != nil {
+		return nil, "", err
+	}
+
+	var meta struct {
+		ContentType string `bson:"content_type"`
+	}
+	if raw := stream.GetFile().Metadata; raw != nil {
+		_ = bson.Unmarshal(raw, &meta)
+	}
+	return buf.Bytes(), meta.ContentType, nil
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type DeliveryZoneRepo interface {
+	List(ctx context.Context) ([]models.DeliveryZone, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.DeliveryZone, error)
+	Create(ctx context.Context, z *models.DeliveryZone) (primitive.ObjectID, error)
+	Update(ctx context.Context, z *models.DeliveryZone) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+
+	// FindActiveByPostalCode and FindActiveContaining return
+	// mongo.ErrNoDocuments when no active zone matches.
+	FindActiveByPostalCode(ctx context.Context, postalCode string) (*models.DeliveryZone, error)
+	FindActiveContaining(ctx context.Context, p *models.GeoPoint) (*models.DeliveryZone, error)
+}
+
+type deliveryZoneRepo struct {
+	col *mongo.Collection
+}
+
+func NewDeliveryZoneRepo(db *mongo.Database) (DeliveryZoneRepo, error) {
+	col := db.Collection("delivery_zones")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "area", Value: "2dsphere"}}},
+		{Keys: bson.D{{Key: "postal_codes", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &deliveryZoneRepo{col: col}, nil
+}
+
+func (r *deliveryZoneRepo) List(ctx context.Context) ([]models.DeliveryZone, error) {
+	cur, err := r.col.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.DeliveryZone{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *deliveryZoneRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*models.DeliveryZone, error) {
+	var z models.DeliveryZone
+	if err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&z); err != nil {
+		return nil, err
+	}
+	return &z, nil
+}
+
+func (r *deliveryZoneRepo) Create(ctx context.Context, z *models.DeliveryZone) (primitive.ObjectID, error) {
+	now := time.Now()
+	z.CreatedAt = now
+	z.UpdatedAt = now
+	res, err := r.col.InsertOne(ctx, z)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+func (r *deliveryZoneRepo) Update(ctx context.Context, z *models.DeliveryZone) error {
+	z.UpdatedAt = time.Now()
+	res, err := r.col.ReplaceOne(ctx, bson.M{"_id": z.ID}, z)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *deliveryZoneRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.col.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *deliveryZoneRepo) FindActiveByPostalCode(ctx context.Context, postalCode string) (*models.DeliveryZone, error) {
+	var z models.DeliveryZone
+	err := r.col.FindOne(ctx, bson.M{"active": true, "postal_codes": postalCode},
+		options.FindOne().SetSort(bson.D{{Key: "fee", Value: 1}})).Decode(&z)
+	if err != nil {
+		return nil, err
+	}
+	return &z, nil
+}
+
+func (r *deliveryZoneRepo) FindActiveContaining(ctx context.Context, p *models.GeoPoint) (*models.DeliveryZone, error) {
+	var z models.DeliveryZone
+	err := r.col.FindOne(ctx, bson.M{
+		"active": true,
+		"area":   bson.M{"$geoIntersects": bson.M{"$geometry": p}},
+	}, options.FindOne().SetSort(bson.D{{Key: "fee", Value: 1}})).Decode(&z)
+	if err != nil {
+		return nil, err
+	}
+	return &z, nil
+}
@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExportRepo stores personal data export jobs. The jobs are the queue, so
+// they survive restarts. The archives themselves live in GridFS so large
+// accounts are not limited by the 16MB document size.
+type ExportRepo interface {
+	Create(ctx context.Context, e *models.DataExport) (primitive.ObjectID, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.DataExport, error)
+	FindActiveByUserID(ctx context.Context, userID primitive.ObjectID) (*models.DataExport, error)
+	// ClaimNext moves the oldest pending job to RUNNING and returns it, or
+	// mongo.ErrNoDocuments when there is none.
+	ClaimNext(ctx context.Context) (*models.DataExport, error)
+	// FailStale fails jobs still pending since before pendingBefore or
+	// running since before runningBefore.
+	FailStale(ctx context.Context, pendingBefore, runningBefore time.Time, errMsg string) (int64, error)
+	// DeleteExpired removes jobs past their expiry along with their
+	// archives.
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+	// DeleteByUserID removes all of the user's jobs along with their
+	// archives.
+	DeleteByUserID(ctx context.Context, userID primitive.ObjectID) (int, error)
+	UpdateStatus(ctx context.Context, id primitive.ObjectID, status, errMsg string) error
+	SaveArchive(ctx context.Context, id primitive.ObjectID, fileName string, data []byte) error
+	LoadArchive(ctx context.Context, e *models.DataExport) ([]byte, error)
+}
+
+type exportRepo struct {
+	col    *mongo.Collection
+	bucket *gridfs.Bucket
+}
+
+func NewExportRepo(db *mongo.Database) (ExportRepo, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName("data_exports"))
+	if err != nil {
+		return nil, err
+	}
+	col := db.Collection("data_exports")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &exportRepo{col: col, bucket: bucket}, nil
+}
+
+func (r *exportRepo) Create(ctx context.Context, e *models.DataExport) (primitive.ObjectID, error) {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	res, err := r.col.InsertOne(ctx, e)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+func (r *exportRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*models.DataExport, error) {
+	var e models.DataExport
+	if err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// FindActiveByUserID returns the newest unexpired export that is still being
+// built or ready for download.
+func (r *exportRepo) FindActiveByUserID(ctx context.Context, userID primitive.ObjectID) (*models.DataExport, error) {
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	var e models.DataExport
+	err := r.col.FindOne(ctx, bson.M{
+		"user_id":    userID,
+		"status":     bson.M{"$in": bson.A{models.ExportPending, models.ExportRunning, models.ExportReady}},
+		"expires_at": bson.M{"$gt": time.Now()},
+	}, opts).Decode(&e)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (r *exportRepo) ClaimNext(ctx context.Context) (*models.DataExport, error) {
+	var e models.DataExport
+	err := r.col.FindOneAndUpdate(ctx,
+		bson.M{"status": models.ExportPending},
+		bson.M{"$set": bson.M{"status": models.ExportRunning, "started_at": time.Now()}},
+		options.FindOneAndUpdate().
+			SetSort(bson.D{{Key: "created_at", Value: 1}}).
+			SetReturnDocument(options.After),
+	).Decode(&e)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (r *exportRepo) FailStale(ctx context.Context, pendingBefore, runningBefore time.Time, errMsg string) (int64, error) {
+	res, err := r.col.UpdateMany(ctx,
+		bson.M{"$or": bson.A{
+			bson.M{"status": models.ExportPending, "created_at": bson.M{"$lt": pendingBefore}},
+			bson.M{"status": models.ExportRunning, "started_at": bson.M{"$lt": runningBefore}},
+		}},
+		bson.M{"$set": bson.M{"status": models.ExportFailed, "error": errMsg, "completed_at": time.Now()}})
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func (r *exportRepo) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	return r.deleteJobs(ctx, bson.M{"expires_at": bson.M{"$lte": now}})
+}
+
+func (r *exportRepo) DeleteByUserID(ctx context.Context, userID primitive.ObjectID) (int, error) {
+	return r.deleteJobs(ctx, bson.M{"user_id": userID})
+}
+
+func (r *exportRepo) deleteJobs(ctx context.Context, filter bson.M) (int, error) {
+	cur, err := r.col.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+	var jobs []models.DataExport
+	if err := cur.All(ctx, &jobs); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, e := range jobs {
+		if e.FileID != nil {
+			err := r.bucket.Delete(*e.FileID)
+			if err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+				return n, err
+			}
+		}
+		if _, err := r.col.DeleteOne(ctx, bson.M{"_id": e.ID}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (r *exportRepo) UpdateStatus(ctx context.Context, id primitive.ObjectID, status, errMsg string) error {
+	set := bson.M{"status": status}
+	if errMsg != "" {
+		set["error"] = errMsg
+	}
+	if status == models.ExportFailed {
+		set["completed_at"] = time.Now()
+	}
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
+
+func (r *exportRepo) SaveArchive(ctx context.Context, id primitive.ObjectID, fileName string, data []byte) error {
+	fileID, err := r.bucket.UploadFromStream(fileName, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"status":       models.ExportReady,
+		"file_id":      fileID,
+		"file_name":    fileName,
+		"completed_at": time.Now(),
+	}})
+	if err == nil && res.MatchedCount == 0 {
+		// The job was deleted, with its account, while the archive was
+		// being built; the archive mustn't outlive it.
+		err = mongo.ErrNoDocuments
+	}
+	if err != nil {
+		if derr := r.bucket.Delete(fileID); derr != nil && !errors.Is(derr, gridfs.ErrFileNotFound) {
+			return errors.Join(err, derr)
+		}
+	}
+	return err
+}
+
+func (r *exportRepo) LoadArchive(ctx context.Context, e *models.DataExport) ([]byte, error) {
+	if e.FileID == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	var buf bytes.Buffer
+	if _, err := r.bucket.DownloadToStream(*e.FileID, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
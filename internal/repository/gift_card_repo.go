@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type GiftCardRepo interface {
+	// Create fails with a duplicate key error when the code is taken.
+	Create(ctx context.Context, g *models.GiftCard) (primitive.ObjectID, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.GiftCard, error)
+	FindByCode(ctx context.Context, code string) (*models.GiftCard, error)
+	// List returns gift cards, newest first.
+	List(ctx context.Context) ([]models.GiftCard, error)
+	SetDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error
+
+	// PINFailed counts a wrong PIN; the lockAfter-th in a row locks the card
+	// until lockUntil and starts the count again.
+	PINFailed(ctx context.Context, id primitive.ObjectID, lockAfter int, lockUntil time.Time) error
+	// PINOK clears the count of wrong PINs.
+	PINOK(ctx context.Context, id primitive.ObjectID) error
+}
+
+type giftCardRepo struct {
+	col *mongo.Collection
+}
+
+func NewGiftCardRepo(db *mongo.Database) (GiftCardRepo, error) {
+	col := db.Collection("gift_cards")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &giftCardRepo{col: col}, nil
+}
+
+func (r *giftCardRepo) Create(ctx context.Context, g *models.GiftCard) (primitive.ObjectID, error) {
+	g.CreatedAt = time.Now()
+	res, err := r.col.InsertOne(ctx, g)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+func (r *giftCardRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*models.GiftCard, error) {
+	return r.findOne(ctx, bson.M{"_id": id})
+}
+
+func (r *giftCardRepo) FindByCode(ctx context.Context, code string) (*models.GiftCard, error) {
+	return r.findOne(ctx, bson.M{"code": code})
+}
+
+func (r *giftCardRepo) findOne(ctx context.Context, filter bson.M) (*models.GiftCard, error) {
+	var g models.GiftCard
+	if err := r.col.FindOne(ctx, filter).Decode(&g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+func (r *giftCardRepo) List(ctx context.Context) ([]models.GiftCard, error) {
+	cur, err := r.col.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.GiftCard{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *giftCardRepo) SetDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error {
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"disabled": disabled}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *giftCardRepo) PINFailed(ctx context.Context, id primitive.ObjectID, lockAfter int, lockUntil time.Time) error {
+	var g models.GiftCard
+	err := r.col.FindOneAndUpdate(ctx, bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"failed_pin_attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&g)
+	if err != nil {
+		return err
+	}
+	if g.FailedPINAttempts < lockAfter {
+		return nil
+	}
+	_, err = r.col.UpdateOne(ctx, bson.M{"_id": id, "failed_pin_attempts": bson.M{"$gte": lockAfter}},
+		bson.M{"$set": bson.M{"failed_pin_attempts": 0, "locked_until": lockUntil}})
+	return err
+}
+
+func (r *giftCardRepo) PINOK(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id, "failed_pin_attempts": bson.M{"$gt": 0}},
+		bson.M{"$set": bson.M{"failed_pin_attempts": 0}})
+	return err
+}
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LeaseRepo hands out named locks that expire on their own, so that work
+// which must not run twice at once is serialised across instances even if
+// the holder dies.
+type LeaseRepo interface {
+	// Acquire takes lease name for holder until ttl from now. It reports
+	// false while another holder has it.
+	Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	Release(ctx context.Context, name, holder string) error
+}
+
+type leaseRepo struct {
+	col *mongo.Collection
+}
+
+func NewLeaseRepo(db *mongo.Database) LeaseRepo {
+	return &leaseRepo{col: db.Collection("leases")}
+}
+
+func (r *leaseRepo) Acquire(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	_, err := r.col.UpdateOne(ctx,
+		bson.M{
+			"_id": name,
+			"$or": bson.A{
+				bson.M{"expires_at": bson.M{"$lte": now}},
+				bson.M{"holder": holder},
+			},
+		},
+		bson.M{"$set": bson.M{"holder": holder, "expires_at": now.Add(ttl)}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// Held by someone else: the filter missed and the upsert hit _id.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *leaseRepo) Release(ctx context.Context, name, holder string) error {
+	_, err := r.col.DeleteOne(ctx, bson.M{"_id": name, "holder": holder})
+	return err
+}
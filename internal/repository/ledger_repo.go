@@ -0,0 +1,313 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrTransferRolledBack is returned by a Transfer that took so long that
+// RollBackPending undid it.
+var ErrTransferRolledBack = errors.New("ledger transfer was rolled back")
+
+// LedgerRepo keeps the stored-value ledger: an append-only list of transfers
+// and a running balance per account.
+//
+// A transfer touches three documents and Mongo may be a standalone server
+// without transactions, so it is applied in steps: the transfer is written
+// pending, each account moves only if it doesn't list the transfer among
+// its pending ones yet (and then lists it), and the transfer is marked done
+// last. A transfer that never got marked done is undone the same way.
+type LedgerRepo interface {
+	Balance(ctx context.Context, account string) (models.Money, error)
+	// Balances returns the balance of each of accounts; missing accounts
+	// are left out.
+	Balances(ctx context.Context, accounts []string) (map[string]models.Money, error)
+	// Transfer records t and moves the balances. It reports false,
+	// recording nothing, when t.From is a customer account without enough
+	// in it; system accounts may go below zero. On an error the transfer is
+	// undone, here or later by RollBackPending.
+	Transfer(ctx context.Context, t *models.LedgerTransfer) (bool, error)
+	// RollBackPending undoes and drops the transfers still pending that
+	// were started before cutoff, and returns how many there were.
+	RollBackPending(ctx context.Context, cutoff time.Time) (int, error)
+	// ListByAccount returns the transfers in and out of account, newest
+	// first.
+	ListByAccount(ctx context.Context, account string) ([]models.LedgerTransfer, error)
+}
+
+type ledgerRepo struct {
+	transfers *mongo.Collection
+	accounts  *mongo.Collection
+}
+
+func NewLedgerRepo(db *mongo.Database) (LedgerRepo, error) {
+	transfers := db.Collection("ledger_transfers")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := transfers.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "from", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "to", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "order_id", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetName("pending").SetPartialFilterExpression(bson.M{"pending": true}),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ledgerRepo{transfers: transfers, accounts: db.Collection("ledger_accounts")}, nil
+}
+
+func isSystemAccount(account string) bool {
+	return strings.HasPrefix(account, "system:")
+}
+
+func (r *ledgerRepo) Balance(ctx context.Context, account string) (models.Money, error) {
+	var a struct {
+		Balance models.Money `bson:"balance"`
+	}
+	err := r.accounts.FindOne(ctx, bson.M{"_id": account}).Decode(&a)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return a.Balance, err
+}
+
+func (r *ledgerRepo) Balances(ctx context.Context, accounts []string) (map[string]models.Money, error) {
+	cur, err := r.accounts.Find(ctx, bson.M{"_id": bson.M{"$in": accounts}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var rows []struct {
+		ID      string       `bson:"_id"`
+		Balance models.Money `bson:"balance"`
+	}
+	if err := cur.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	out := make(map[string]models.Money, len(rows))
+	for _, row := range rows {
+		out[row.ID] = row.Balance
+	}
+	return out, nil
+}
+
+// ledgerSteps are the single-document updates a transfer is made of. The
+// order they run in, and how a transfer is undone, is in transfer and
+// rollBack below.
+type ledgerSteps interface {
+	insertTransfer(ctx context.Context, t *models.LedgerTransfer) error
+	// move adds amount to account as part of transfer id, once. A customer
+	// account must not go below zero.
+	move(ctx context.Context, account string, id primitive.ObjectID, amount models.Money) (bool, error)
+	// finish marks a pending transfer done unless a rollback has claimed
+	// it.
+	finish(ctx context.Context, id primitive.ObjectID) (bool, error)
+	// release drops a done transfer's id from the accounts.
+	release(ctx context.Context, id primitive.ObjectID, accounts []string) error
+	// claimRollBack marks a pending transfer as being rolled back. It
+	// reports false if the transfer isn't pending.
+	claimRollBack(ctx context.Context, id primitive.ObjectID) (bool, error)
+	transferExists(ctx context.Context, id primitive.ObjectID) (bool, error)
+	// unmove takes back a move, if account still lists the transfer.
+	unmove(ctx context.Context, account string, id primitive.ObjectID, amount models.Money) error
+	deleteTransfer(ctx context.Context, id primitive.ObjectID) error
+	pendingBefore(ctx context.Context, cutoff time.Time) ([]models.LedgerTransfer, error)
+}
+
+func (r *ledgerRepo) Transfer(ctx context.Context, t *models.LedgerTransfer) (bool, error) {
+	return transfer(ctx, r, t)
+}
+
+func (r *ledgerRepo) RollBackPending(ctx context.Context, cutoff time.Time) (int, error) {
+	return rollBackPending(ctx, r, cutoff)
+}
+
+func transfer(ctx context.Context, s ledgerSteps, t *models.LedgerTransfer) (bool, error) {
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
+	}
+	t.ID = primitive.NewObjectID()
+	t.Pending = true
+	if err := s.insertTransfer(ctx, t); err != nil {
+		return false, err
+	}
+	fail := func(cause error) (bool, error) {
+		done, err := rollBack(context.Background(), s, t)
+		if done {
+			return true, nil
+		}
+		if cause == nil {
+			cause = err
+		}
+		return false, cause
+	}
+
+	// The balance check and the debit are one update, so two checkouts
+	// can't spend the same balance.
+	ok, err := s.move(ctx, t.From, t.ID, -t.Amount)
+	if err != nil || !ok {
+		return fail(err)
+	}
+	if _, err := s.move(ctx, t.To, t.ID, t.Amount); err != nil {
+		return fail(err)
+	}
+	ok, err = s.finish(ctx, t.ID)
+	if err != nil {
+		return fail(err)
+	}
+	if !ok {
+		return fail(ErrTransferRolledBack)
+	}
+	t.Pending = false
+
+	// Only a done transfer's id may leave the accounts; left behind, it is
+	// just clutter.
+	_ = s.release(ctx, t.ID, []string{t.From, t.To})
+	return true, nil
+}
+
+// rollBack undoes the account moves of a pending transfer and deletes it. It
+// reports true, changing nothing, if t turns out to be done.
+func rollBack(ctx context.Context, s ledgerSteps, t *models.LedgerTransfer) (bool, error) {
+	claimed, err := s.claimRollBack(ctx, t.ID)
+	if err != nil {
+		return false, err
+	}
+	if !claimed {
+		return s.transferExists(ctx, t.ID)
+	}
+	for account, amount := range map[string]models.Money{t.From: t.Amount, t.To: -t.Amount} {
+		if err := s.unmove(ctx, account, t.ID, amount); err != nil {
+			return false, err
+		}
+	}
+	return false, s.deleteTransfer(ctx, t.ID)
+}
+
+func rollBackPending(ctx context.Context, s ledgerSteps, cutoff time.Time) (int, error) {
+	pending, err := s.pendingBefore(ctx, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := range pending {
+		done, err := rollBack(ctx, s, &pending[i])
+		if err != nil {
+			return n, err
+		}
+		if !done {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *ledgerRepo) insertTransfer(ctx context.Context, t *models.LedgerTransfer) error {
+	_, err := r.transfers.InsertOne(ctx, t)
+	return err
+}
+
+func (r *ledgerRepo) move(ctx context.Context, account string, id primitive.ObjectID, amount models.Money) (bool, error) {
+	filter := bson.M{"_id": account, "pending": bson.M{"$ne": id}}
+	opts := options.Update().SetUpsert(true)
+	if amount < 0 && !isSystemAccount(account) {
+		filter["balance"] = bson.M{"$gte": -amount}
+		opts = options.Update()
+	}
+	res, err := r.accounts.UpdateOne(ctx, filter,
+		bson.M{"$inc": bson.M{"balance": amount}, "$push": bson.M{"pending": id}}, opts)
+	if mongo.IsDuplicateKeyError(err) {
+		// The upsert lost to the account, which has the transfer already.
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount+res.UpsertedCount > 0, nil
+}
+
+func (r *ledgerRepo) finish(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	res, err := r.transfers.UpdateOne(ctx,
+		bson.M{"_id": id, "pending": true, "rolling_back": bson.M{"$ne": true}},
+		bson.M{"$unset": bson.M{"pending": ""}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *ledgerRepo) release(ctx context.Context, id primitive.ObjectID, accounts []string) error {
+	_, err := r.accounts.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": accounts}},
+		bson.M{"$pull": bson.M{"pending": id}})
+	return err
+}
+
+func (r *ledgerRepo) claimRollBack(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	res, err := r.transfers.UpdateOne(ctx, bson.M{"_id": id, "pending": true},
+		bson.M{"$set": bson.M{"rolling_back": true}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+func (r *ledgerRepo) transferExists(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	n, err := r.transfers.CountDocuments(ctx, bson.M{"_id": id})
+	return n == 1, err
+}
+
+func (r *ledgerRepo) unmove(ctx context.Context, account string, id primitive.ObjectID, amount models.Money) error {
+	_, err := r.accounts.UpdateOne(ctx, bson.M{"_id": account, "pending": id},
+		bson.M{"$inc": bson.M{"balance": amount}, "$pull": bson.M{"pending": id}})
+	return err
+}
+
+func (r *ledgerRepo) deleteTransfer(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.transfers.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (r *ledgerRepo) pendingBefore(ctx context.Context, cutoff time.Time) ([]models.LedgerTransfer, error) {
+	cur, err := r.transfers.Find(ctx, bson.M{"pending": true, "created_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var list []models.LedgerTransfer
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *ledgerRepo) ListByAccount(ctx context.Context, account string) ([]models.LedgerTransfer, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	cur, err := r.transfers.Find(ctx, bson.M{
+		"$or":     bson.A{bson.M{"from": account}, bson.M{"to": account}},
+		"pending": bson.M{"$ne": true},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	list := []models.LedgerTransfer{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
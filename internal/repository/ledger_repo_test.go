@@ -0,0 +1,333 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errLedgerDown = errors.New("ledger unavailable")
+
+type memAccount struct {
+	balance models.Money
+	pending []primitive.ObjectID
+}
+
+// memLedger applies the ledger steps to maps, the way the Mongo updates do.
+// before runs ahead of every step and can fail it or interleave another
+// caller.
+type memLedger struct {
+	mu        sync.Mutex
+	accounts  map[string]*memAccount
+	transfers map[primitive.ObjectID]*models.LedgerTransfer
+	rolling   map[primitive.ObjectID]bool
+	before    func(step string) error
+}
+
+func newMemLedger() *memLedger {
+	return &memLedger{
+		accounts:  map[string]*memAccount{},
+		transfers: map[primitive.ObjectID]*models.LedgerTransfer{},
+		rolling:   map[primitive.ObjectID]bool{},
+	}
+}
+
+func (m *memLedger) step(name string) error {
+	if m.before == nil {
+		return nil
+	}
+	return m.before(name)
+}
+
+func (m *memLedger) balance(account string) models.Money {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if a, ok := m.accounts[account]; ok {
+		return a.balance
+	}
+	return 0
+}
+
+func (m *memLedger) insertTransfer(_ context.Context, t *models.LedgerTransfer) error {
+	if err := m.step("insert"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := *t
+	m.transfers[t.ID] = &c
+	return nil
+}
+
+func (m *memLedger) move(_ context.Context, account string, id primitive.ObjectID, amount models.Money) (bool, error) {
+	if err := m.step("move " + account); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upsert := amount >= 0 || isSystemAccount(account)
+	a, ok := m.accounts[account]
+	if !ok {
+		if !upsert {
+			return false, nil
+		}
+		a = &memAccount{}
+		m.accounts[account] = a
+	}
+	if slices.Contains(a.pending, id) {
+		// The upsert would hit the existing account.
+		return upsert, nil
+	}
+	if !upsert && a.balance < -amount {
+		return false, nil
+	}
+	a.balance += amount
+	a.pending = append(a.pending, id)
+	return true, nil
+}
+
+func (m *memLedger) finish(_ context.Context, id primitive.ObjectID) (bool, error) {
+	if err := m.step("finish"); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.transfers[id]
+	if !ok || !t.Pending || m.rolling[id] {
+		return false, nil
+	}
+	t.Pending = false
+	return true, nil
+}
+
+func (m *memLedger) release(_ context.Context, id primitive.ObjectID, accounts []string) error {
+	if err := m.step("release"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range accounts {
+		if a, ok := m.accounts[name]; ok {
+			a.pending = slices.DeleteFunc(a.pending, func(p primitive.ObjectID) bool { return p == id })
+		}
+	}
+	return nil
+}
+
+func (m *memLedger) claimRollBack(_ context.Context, id primitive.ObjectID) (bool, error) {
+	if err := m.step("claim"); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.transfers[id]
+	if !ok || !t.Pending {
+		return false, nil
+	}
+	m.rolling[id] = true
+	return true, nil
+}
+
+func (m *memLedger) transferExists(_ context.Context, id primitive.ObjectID) (bool, error) {
+	if err := m.step("exists"); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.transfers[id]
+	return ok, nil
+}
+
+func (m *memLedger) unmove(_ context.Context, account string, id primitive.ObjectID, amount models.Money) error {
+	if err := m.step("unmove " + account); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.accounts[account]
+	if !ok || !slices.Contains(a.pending, id) {
+		return nil
+	}
+	a.balance += amount
+	a.pending = slices.DeleteFunc(a.pending, func(p primitive.ObjectID) bool { return p == id })
+	return nil
+}
+
+func (m *memLedger) deleteTransfer(_ context.Context, id primitive.ObjectID) error {
+	if err := m.step("delete"); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.transfers, id)
+	return nil
+}
+
+func (m *memLedger) pendingBefore(_ context.Context, cutoff time.Time) ([]models.LedgerTransfer, error) {
+	if err := m.step("pending"); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []models.LedgerTransfer
+	for _, t := range m.transfers {
+		if t.Pending && t.CreatedAt.Before(cutoff) {
+			out = append(out, *t)
+		}
+	}
+	return out, nil
+}
+
+const (
+	testCredit = "store_credit:test"
+	testSink   = models.AccountCheckout
+)
+
+// fundedLedger holds 10.00 of store credit.
+func fundedLedger(t *testing.T) *memLedger {
+	t.Helper()
+	m := newMemLedger()
+	ok, err := transfer(context.Background(), m, &models.LedgerTransfer{From: models.AccountRefunds, To: testCredit, Amount: 1000})
+	if err != nil || !ok {
+		t.Fatalf("funding: %v, %v", ok, err)
+	}
+	return m
+}
+
+func TestTransferInterruptedAfterDebitIsRolledBack(t *testing.T) {
+	ctx := context.Background()
+	m := fundedLedger(t)
+
+	// The database goes away once the debit is in, so neither the credit
+	// nor Transfer's own rollback gets through.
+	down := false
+	m.before = func(step string) error {
+		if down {
+			return errLedgerDown
+		}
+		down = step == "move "+testCredit
+		return nil
+	}
+	tr := &models.LedgerTransfer{From: testCredit, To: testSink, Amount: 400}
+	if _, err := transfer(ctx, m, tr); err == nil {
+		t.Fatal("interrupted transfer reported success")
+	}
+	if b := m.balance(testCredit); b != 600 {
+		t.Fatalf("credit after the interrupted debit = %v, want 6.00", b)
+	}
+
+	down = false
+	// Not yet old enough to be given up on.
+	if n, err := rollBackPending(ctx, m, tr.CreatedAt); err != nil || n != 0 {
+		t.Fatalf("rollBackPending before cutoff = %d, %v", n, err)
+	}
+	n, err := rollBackPending(ctx, m, time.Now().Add(time.Minute))
+	if err != nil || n != 1 {
+		t.Fatalf("rollBackPending = %d, %v; want 1", n, err)
+	}
+	if b := m.balance(testCredit); b != 1000 {
+		t.Errorf("credit after rollback = %v, want 10.00", b)
+	}
+	if b := m.balance(testSink); b != 0 {
+		t.Errorf("checkout after rollback = %v, want 0.00", b)
+	}
+	if ok, _ := m.transferExists(ctx, tr.ID); ok {
+		t.Error("rolled back transfer was kept")
+	}
+
+	// A second pass finds nothing left to undo.
+	if n, err := rollBackPending(ctx, m, time.Now().Add(time.Minute)); err != nil || n != 0 {
+		t.Fatalf("second rollBackPending = %d, %v", n, err)
+	}
+	if b := m.balance(testCredit); b != 1000 {
+		t.Errorf("credit after second pass = %v, want 10.00", b)
+	}
+}
+
+func TestRollBackNeverRecreditsAppliedTransfer(t *testing.T) {
+	ctx := context.Background()
+	m := fundedLedger(t)
+
+	// The ids stay on the accounts, as when release fails.
+	m.before = func(step string) error {
+		if step == "release" {
+			return errLedgerDown
+		}
+		return nil
+	}
+	tr := &models.LedgerTransfer{From: testCredit, To: testSink, Amount: 400}
+	if ok, err := transfer(ctx, m, tr); err != nil || !ok {
+		t.Fatalf("transfer = %v, %v", ok, err)
+	}
+	m.before = nil
+
+	// A rollback working from a copy read while the transfer was pending.
+	stale := *tr
+	stale.Pending = true
+	done, err := rollBack(ctx, m, &stale)
+	if err != nil || !done {
+		t.Fatalf("rollBack of an applied transfer = %v, %v; want done", done, err)
+	}
+	if n, err := rollBackPending(ctx, m, time.Now().Add(time.Minute)); err != nil || n != 0 {
+		t.Fatalf("rollBackPending = %d, %v; want 0", n, err)
+	}
+	if b := m.balance(testCredit); b != 600 {
+		t.Errorf("credit = %v, want 6.00", b)
+	}
+	if b := m.balance(testSink); b != 400 {
+		t.Errorf("checkout = %v, want 4.00", b)
+	}
+	if ok, _ := m.transferExists(ctx, tr.ID); !ok {
+		t.Error("applied transfer was deleted")
+	}
+}
+
+func TestRollBackRacingFinishUndoesOnce(t *testing.T) {
+	ctx := context.Background()
+	m := fundedLedger(t)
+
+	// The recovery loop gives up on the transfer just before it would have
+	// been marked done.
+	m.before = func(step string) error {
+		if step == "finish" {
+			m.before = nil
+			if n, err := rollBackPending(ctx, m, time.Now().Add(time.Minute)); err != nil || n != 1 {
+				t.Errorf("rollBackPending = %d, %v; want 1", n, err)
+			}
+		}
+		return nil
+	}
+	tr := &models.LedgerTransfer{From: testCredit, To: testSink, Amount: 400}
+	if _, err := transfer(ctx, m, tr); !errors.Is(err, ErrTransferRolledBack) {
+		t.Fatalf("transfer err = %v, want ErrTransferRolledBack", err)
+	}
+	if b := m.balance(testCredit); b != 1000 {
+		t.Errorf("credit = %v, want 10.00", b)
+	}
+	if b := m.balance(testSink); b != 0 {
+		t.Errorf("checkout = %v, want 0.00", b)
+	}
+}
+
+func TestTransferWithoutEnoughCreditRecordsNothing(t *testing.T) {
+	ctx := context.Background()
+	m := fundedLedger(t)
+
+	tr := &models.LedgerTransfer{From: testCredit, To: testSink, Amount: 1500}
+	ok, err := transfer(ctx, m, tr)
+	if err != nil || ok {
+		t.Fatalf("transfer = %v, %v; want false", ok, err)
+	}
+	if b := m.balance(testCredit); b != 1000 {
+		t.Errorf("credit = %v, want 10.00", b)
+	}
+	if ok, _ := m.transferExists(ctx, tr.ID); ok {
+		t.Error("refused transfer was kept")
+	}
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Counters nobody has touched for this long are removed by a TTL index.
+const loginThrottleRetention = 24 * time.Hour
+
+type LoginThrottleRepo interface {
+	Get(ctx context.Context, key string) (*models.LoginThrottle, error)
+	RecordFailure(ctx context.Context, key string) (*models.LoginThrottle, error)
+	SetLockedUntil(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+}
+
+type loginThrottleRepo struct {
+	col *mongo.Collection
+}
+
+func NewLoginThrottleRepo(db *mongo.Database) (LoginThrottleRepo, error) {
+	col := db.Collection("login_throttles")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "last_failure_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(loginThrottleRetention.Seconds()))},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &loginThrottleRepo{col: col}, nil
+}
+
+func (r *loginThrottleRepo) Get(ctx context.Context, key string) (*models.LoginThrottle, error) {
+	var t models.LoginThrottle
+	if err := r.col.FindOne(ctx, bson.M{"key": key}).Decode(&t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// RecordFailure atomically increments the failure counter so concurrent
+// attempts on several instances are all counted.
+func (r *loginThrottleRepo) RecordFailure(ctx context.Context, key string) (*models.LoginThrottle, error) {
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After)
+
+	var t models.LoginThrottle
+	err := r.col.FindOneAndUpdate(
+		ctx,
+		bson.M{"key": key},
+		bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{"last_failure_at": time.Now()},
+		},
+		opts,
+	).Decode(&t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *loginThrottleRepo) SetLockedUntil(ctx context.Context, key string, until time.Time) error {
+	_, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"key": key},
+		bson.M{"$max": bson.M{"locked_until": until}},
+	)
+	return err
+}
+
+func (r *loginThrottleRepo) Reset(ctx context.Context, key string) error {
+	_, err := r.col.DeleteOne(ctx, bson.M{"key": key})
+	return err
+}
@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const loyaltySettingsID = "default"
+
+type LoyaltyRepo interface {
+	// GetSettings returns mongo.ErrNoDocuments until settings are saved once.
+	GetSettings(ctx context.Context) (*models.LoyaltySettings, error)
+	SaveSettings(ctx context.Context, s *models.LoyaltySettings) error
+
+	Balance(ctx context.Context, userID primitive.ObjectID) (int64, error)
+	// Head returns the Seq of the user's latest entry.
+	Head(ctx context.Context, userID primitive.ObjectID) (int64, error)
+	// Add records e as the user's next entry and moves the balance by
+	// e.Points. It reports false, recording nothing, for a second EARN on
+	// an order or a second EXPIRE of a lot.
+	Add(ctx context.Context, e *models.LoyaltyEntry) (bool, error)
+	// AddAfter is Add for an entry worked out from the ledger up to entry
+	// seq: it also reports false if another entry came after seq first.
+	AddAfter(ctx context.Context, e *models.LoyaltyEntry, seq int64) (bool, error)
+	// Spend records e, a negative entry, only if the balance covers it.
+	Spend(ctx context.Context, e *models.LoyaltyEntry) (bool, error)
+	// ListByUserID returns the user's entries, oldest first.
+	ListByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.LoyaltyEntry, error)
+	ListByOrderID(ctx context.Context, orderID primitive.ObjectID) ([]models.LoyaltyEntry, error)
+	// DeleteByUserID removes the user's entries and balance.
+	DeleteByUserID(ctx context.Context, userID primitive.ObjectID) error
+}
+
+type loyaltyRepo struct {
+	settings *mongo.Collection
+	ledger   *mongo.Collection
+	accounts *mongo.Collection
+}
+
+func NewLoyaltyRepo(db *mongo.Database) (LoyaltyRepo, error) {
+	ledger := db.Collection("loyalty_ledger")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := ledger.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "type", Value: 1}}},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "seq", Value: 1}},
+			Options: options.Index().SetName("one_entry_per_seq").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"seq": bson.M{"$exists": true}}),
+		},
+		{
+			Keys: bson.D{{Key: "order_id", Value: 1}},
+			Options: options.Index().SetName("one_earn_per_order").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"type": models.LoyaltyEarn}),
+		},
+		{
+			Keys: bson.D{{Key: "lot_id", Value: 1}},
+			Options: options.Index().SetName("one_expiry_per_lot").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"type": models.LoyaltyExpire}),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &loyaltyRepo{
+		settings: db.Collection("loyalty_settings"),
+		ledger:   ledger,
+		accounts: db.Collection("loyalty_accounts"),
+	}, nil
+}
+
+func (r *loyaltyRepo) GetSettings(ctx context.Context) (*models.LoyaltySettings, error) {
+	var s models.LoyaltySettings
+	if err := r.settings.FindOne(ctx, bson.M{"_id": loyaltySettingsID}).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *loyaltyRepo) SaveSettings(ctx context.Context, s *models.LoyaltySettings) error {
+	s.ID = loyaltySettingsID
+	s.UpdatedAt = time.Now()
+	_, err := r.settings.ReplaceOne(ctx, bson.M{"_id": loyaltySettingsID}, s, options.Replace().SetUpsert(true))
+	return err
+}
+
+// loyaltyAccount is a user's balance and the Seq of the last entry counted
+// in it. Entries are written before the balance moves; an entry whose
+// write landed but whose balance move did not is applied by the next call
+// that settles the account.
+type loyaltyAccount struct {
+	Balance int64 `bson:"balance"`
+	Seq     int64 `bson:"seq"`
+}
+
+func (r *loyaltyRepo) Balance(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	a, err := r.settle(ctx, userID)
+	return a.Balance, err
+}
+
+func (r *loyaltyRepo) Head(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	a, err := r.settle(ctx, userID)
+	return a.Seq, err
+}
+
+// settle applies any entries past the account's Seq and returns the account.
+func (r *loyaltyRepo) settle(ctx context.Context, userID primitive.ObjectID) (loyaltyAccount, error) {
+	for {
+		var a loyaltyAccount
+		err := r.accounts.FindOne(ctx, bson.M{"_id": userID}).Decode(&a)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return a, err
+		}
+		var e models.LoyaltyEntry
+		err = r.ledger.FindOne(ctx, bson.M{"user_id": userID, "seq": a.Seq + 1}).Decode(&e)
+		if err == mongo.ErrNoDocuments {
+			return a, nil
+		}
+		if err != nil {
+			return a, err
+		}
+		if err := r.apply(ctx, userID, a.Seq, e.Points); err != nil {
+			return a, err
+		}
+	}
+}
+
+// apply moves the balance by points as entry seq+1, only if the account is
+// still at seq; a concurrent apply of the same entry wins the race and
+// this one does nothing.
+func (r *loyaltyRepo) apply(ctx context.Context, userID primitive.ObjectID, seq, points int64) error {
+	filter := bson.M{"_id": userID, "seq": seq}
+	if seq == 0 {
+		// Accounts from before entries were numbered have no seq.
+		filter["seq"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	_, err := r.accounts.UpdateOne(ctx, filter,
+		bson.M{"$inc": bson.M{"balance": points}, "$set": bson.M{"seq": seq + 1}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The upsert lost to an account already past seq.
+		return nil
+	}
+	return err
+}
+
+// append records e as entry seq+1. It reports false if that number or a
+// unique EARN or EXPIRE is taken.
+func (r *loyaltyRepo) append(ctx context.Context, e *models.LoyaltyEntry, seq int64) (bool, error) {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	e.Seq = seq + 1
+	res, err := r.ledger.InsertOne(ctx, e)
+	if mongo.IsDuplicateKeyError(err) {
+		e.Seq = 0
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	e.ID, _ = res.InsertedID.(primitive.ObjectID)
+	return true, r.apply(ctx, e.UserID, seq, e.Points)
+}
+
+func (r *loyaltyRepo) Add(ctx context.Context, e *models.LoyaltyEntry) (bool, error) {
+	for {
+		a, err := r.settle(ctx, e.UserID)
+		if err != nil {
+			return false, err
+		}
+		ok, err := r.append(ctx, e, a.Seq)
+		if ok || err != nil {
+			return ok, err
+		}
+		// Either another entry took the number, and the account has moved
+		// on by now, or e is a duplicate.
+		next, err := r.settle(ctx, e.UserID)
+		if err != nil {
+			return false, err
+		}
+		if next.Seq == a.Seq {
+			return false, nil
+		}
+	}
+}
+
+func (r *loyaltyRepo) AddAfter(ctx context.Context, e *models.LoyaltyEntry, seq int64) (bool, error) {
+	a, err := r.settle(ctx, e.UserID)
+	if err != nil || a.Seq != seq {
+		return false, err
+	}
+	return r.append(ctx, e, seq)
+}
+
+func (r *loyaltyRepo) Spend(ctx context.Context, e *models.LoyaltyEntry) (bool, error) {
+	// The balance is checked at a Seq and the entry can only be appended
+	// right after it, so two checkouts can't spend the same points.
+	for {
+		a, err := r.settle(ctx, e.UserID)
+		if err != nil {
+			return false, err
+		}
+		if a.Balance < -e.Points {
+			return false, nil
+		}
+		ok, err := r.append(ctx, e, a.Seq)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+}
+
+func (r *loyaltyRepo) ListByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.LoyaltyEntry, error) {
+	return r.find(ctx, bson.M{"user_id": userID})
+}
+
+func (r *loyaltyRepo) ListByOrderID(ctx context.Context, orderID primitive.ObjectID) ([]models.LoyaltyEntry, error) {
+	return r.find(ctx, bson.M{"order_id": orderID})
+}
+
+func (r *loyaltyRepo) DeleteByUserID(ctx context.Context, userID primitive.ObjectID) error {
+	if _, err := r.ledger.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+	_, err := r.accounts.DeleteOne(ctx, bson.M{"_id": userID})
+	return err
+}
+
+func (r *loyaltyRepo) find(ctx context.Context, filter bson.M) ([]models.LoyaltyEntry, error) {
+	cur, err := r.ledger.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	list := []models.LoyaltyEntry{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const markdownSettingsID = "default"
+
+type MarkdownRepo interface {
+	// GetSettings returns mongo.ErrNoDocuments until settings are saved once.
+	GetSettings(ctx context.Context) (*models.MarkdownSettings, error)
+	SaveSettings(ctx context.Context, s *models.MarkdownSettings) error
+
+	CreateBatch(ctx context.Context, b *models.ProductBatch) (primitive.ObjectID, error)
+	// ListBatches returns the product's batches, all products' when
+	// productID is zero, soonest best-before first.
+	ListBatches(ctx context.Context, productID primitive.ObjectID, openOnly bool) ([]models.ProductBatch, error)
+	// ClearBatch closes an open batch, reporting false if there is no open
+	// batch id.
+	ClearBatch(ctx context.Context, id primitive.ObjectID) (bool, error)
+}
+
+type markdownRepo struct {
+	settings *mongo.Collection
+	batches  *mongo.Collection
+}
+
+func NewMarkdownRepo(db *mongo.Database) (MarkdownRepo, error) {
+	batches := db.Collection("product_batches")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := batches.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "best_before", Value: 1}}},
+		{Keys: bson.D{{Key: "cleared_at", Value: 1}, {Key: "best_before", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &markdownRepo{
+		settings: db.Collection("markdown_settings"),
+		batches:  batches,
+	}, nil
+}
+
+func (r *markdownRepo) GetSettings(ctx context.Context) (*models.MarkdownSettings, error) {
+	var s models.MarkdownSettings
+	if err := r.settings.FindOne(ctx, bson.M{"_id": markdownSettingsID}).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *markdownRepo) SaveSettings(ctx context.Context, s *models.MarkdownSettings) error {
+	s.ID = markdownSettingsID
+	s.UpdatedAt = time.Now()
+	_, err := r.settings.ReplaceOne(ctx, bson.M{"_id": markdownSettingsID}, s, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r *markdownRepo) CreateBatch(ctx context.Context, b *models.ProductBatch) (primitive.ObjectID, error) {
+	b.CreatedAt = time.Now()
+	res, err := r.batches.InsertOne(ctx, b)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+func (r *markdownRepo) ListBatches(ctx context.Context, productID primitive.ObjectID, openOnly bool) ([]models.ProductBatch, error) {
+	filter := bson.M{}
+	if !productID.IsZero() {
+		filter["product_id"] = productID
+	}
+	if openOnly {
+		filter["cleared_at"] = bson.M{"$exists": false}
+	}
+	cur, err := r.batches.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "best_before", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.ProductBatch{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *markdownRepo) ClearBatch(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	res, err := r.batches.UpdateOne(ctx,
+		bson.M{"_id": id, "cleared_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"cleared_at": time.Now()}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
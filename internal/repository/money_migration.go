@@ -0,0 +1,203 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const moneyMigrationID = "money_minor_units"
+
+// moneyMigratedField marks the documents MigrateMoney has converted, so that
+// an interrupted run doesn't convert them again when it is repeated.
+const moneyMigratedField = "money_minor_units"
+
+// moneyFields lists, per collection, the fields holding amounts. A dotted
+// path walks into sub-documents and through arrays.
+var moneyFields = map[string][]string{
+	"products":       {"price"},
+	"orders":         {"subtotal", "total_price", "delivery_fee", "refunded_total", "net_total", "items.price"},
+	"order_items":    {"price"},
+	"delivery_zones": {"fee", "min_order", "free_over"},
+	"payments":       {"amount", "captured_amount", "refunded_amount", "events.amount"},
+	"refunds":        {"amount", "delivery_fee", "lines.amount"},
+	"returns":        {"amount"},
+	"store_credit":   {"amount"},
+}
+
+// MigrateMoney rewrites amounts stored in major units, as doubles or as
+// integers from hand-made data, into int64 minor units (see models.Money),
+// and gives products without a currency the store currency. It runs once
+// per database; completion is recorded in the "migrations" collection.
+func MigrateMoney(ctx context.Context, db *mongo.Database, currency string) error {
+	migrations := db.Collection("migrations")
+	err := migrations.FindOne(ctx, bson.M{"_id": moneyMigrationID}).Err()
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	for name, fields := range moneyFields {
+		n, err := migrateMoneyCollection(ctx, db.Collection(name), fields)
+		if err != nil {
+			return fmt.Errorf("migrate money in %s: %w", name, err)
+		}
+		if n > 0 {
+			log.Printf("[migrate] %s: converted amounts in %d documents", name, n)
+		}
+	}
+	if err := migrateProductCurrency(ctx, db.Collection("products"), currency); err != nil {
+		return fmt.Errorf("migrate product currency: %w", err)
+	}
+
+	_, err = migrations.InsertOne(ctx, bson.M{"_id": moneyMigrationID, "applied_at": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// migrateProductCurrency fills in currency where a product has none. A
+// product priced in another currency, such as the USD the old staff page
+// filled in, is only logged: its amount can't be converted here, so staff
+// have to reprice it.
+func migrateProductCurrency(ctx context.Context, col *mongo.Collection, currency string) error {
+	res, err := col.UpdateMany(ctx,
+		bson.M{"$or": bson.A{bson.M{"currency": bson.M{"$exists": false}}, bson.M{"currency": nil}, bson.M{"currency": ""}}},
+		bson.M{"$set": bson.M{"currency": currency}})
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount > 0 {
+		log.Printf("[migrate] products: set currency to %s on %d documents", currency, res.ModifiedCount)
+	}
+
+	cur, err := col.Find(ctx, bson.M{"currency": bson.M{"$ne": currency}},
+		options.Find().SetProjection(bson.M{"_id": 1, "name": 1, "currency": 1}))
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var p struct {
+			ID       primitive.ObjectID `bson:"_id"`
+			Name     string             `bson:"name"`
+			Currency string             `bson:"currency"`
+		}
+		if err := cur.Decode(&p); err != nil {
+			return err
+		}
+		log.Printf("[migrate] products: %s (%s) is priced in %s, not %s; left as it is", p.ID.Hex(), p.Name, p.Currency, currency)
+	}
+	return cur.Err()
+}
+
+func migrateMoneyCollection(ctx context.Context, col *mongo.Collection, fields []string) (int, error) {
+	or := bson.A{}
+	for _, f := range fields {
+		or = append(or, bson.M{f: bson.M{"$type": bson.A{"double", "decimal", "int", "long"}}})
+	}
+	cur, err := col.Find(ctx, bson.M{moneyMigratedField: bson.M{"$ne": true}, "$or": or})
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	n := 0
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err != nil {
+			return n, err
+		}
+		set := bson.M{}
+		for _, f := range fields {
+			path := strings.Split(f, ".")
+			changed, err := convertMoneyPath(doc, path)
+			if err != nil {
+				return n, fmt.Errorf("document %v: %w", doc["_id"], err)
+			}
+			if changed {
+				// Arrays are written back whole, with their other fields
+				// untouched.
+				set[path[0]] = doc[path[0]]
+			}
+		}
+		if len(set) == 0 {
+			continue
+		}
+		set[moneyMigratedField] = true
+		if _, err := col.UpdateOne(ctx, bson.M{"_id": doc["_id"]}, bson.M{"$set": set}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, cur.Err()
+}
+
+// convertMoneyPath converts the value at path inside doc in place.
+func convertMoneyPath(doc bson.M, path []string) (bool, error) {
+	v, ok := doc[path[0]]
+	if !ok {
+		return false, nil
+	}
+	if len(path) == 1 {
+		m, ok, err := legacyMoney(v)
+		if !ok || err != nil {
+			return false, err
+		}
+		doc[path[0]] = m
+		return true, nil
+	}
+
+	changed := false
+	var walk func(v any) error
+	walk = func(v any) error {
+		switch x := v.(type) {
+		case bson.M:
+			c, err := convertMoneyPath(x, path[1:])
+			changed = changed || c
+			return err
+		case bson.A:
+			for _, el := range x {
+				if err := walk(el); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return changed, walk(v)
+}
+
+// legacyMoney reports whether v is an amount in major units and converts
+// it. Money would read an integer as minor units, so those are converted
+// here too.
+func legacyMoney(v any) (models.Money, bool, error) {
+	var m models.Money
+	var err error
+	switch x := v.(type) {
+	case float64:
+		m, err = models.MoneyFromFloat(x)
+	case int32:
+		m, err = models.ParseMoneyRounded(strconv.FormatInt(int64(x), 10))
+	case int64:
+		m, err = models.ParseMoneyRounded(strconv.FormatInt(x, 10))
+	case primitive.Decimal128:
+		m, err = models.ParseMoneyRounded(x.String())
+	default:
+		return 0, false, nil
+	}
+	return m, true, err
+}
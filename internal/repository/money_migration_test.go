@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLegacyMoney(t *testing.T) {
+	dec, _ := primitive.ParseDecimal128("3.335")
+	tests := []struct {
+		name string
+		in   any
+		want models.Money
+		ok   bool
+	}{
+		{"double", 12.5, 1250, true},
+		{"double rounded", 0.285, 29, true},
+		{"int32 major units", int32(12), 1200, true},
+		{"int64 major units", int64(-3), -300, true},
+		{"decimal128", dec, 334, true},
+		{"string", "12.50", 0, false},
+		{"missing", nil, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok, err := legacyMoney(tt.in)
+		if err != nil || ok != tt.ok || got != tt.want {
+			t.Errorf("%s: legacyMoney(%v) = %d, %v, %v; want %d, %v", tt.name, tt.in, got, ok, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestConvertMoneyPath(t *testing.T) {
+	doc := bson.M{
+		"total_price": 20.5,
+		"items": bson.A{
+			bson.M{"price": 10.25, "quantity": int32(2)},
+			bson.M{"price": int64(1)},
+		},
+	}
+	if changed, err := convertMoneyPath(doc, []string{"total_price"}); !changed || err != nil {
+		t.Fatalf("total_price: changed=%v err=%v", changed, err)
+	}
+	if changed, err := convertMoneyPath(doc, []string{"items", "price"}); !changed || err != nil {
+		t.Fatalf("items.price: changed=%v err=%v", changed, err)
+	}
+	if doc["total_price"] != models.Money(2050) {
+		t.Errorf("total_price = %v, want 2050", doc["total_price"])
+	}
+	items := doc["items"].(bson.A)
+	if p := items[0].(bson.M)["price"]; p != models.Money(1025) {
+		t.Errorf("items[0].price = %v, want 1025", p)
+	}
+	if p := items[1].(bson.M)["price"]; p != models.Money(100) {
+		t.Errorf("items[1].price = %v, want 100", p)
+	}
+	if q := items[0].(bson.M)["quantity"]; q != int32(2) {
+		t.Errorf("quantity was changed to %v", q)
+	}
+	if changed, _ := convertMoneyPath(doc, []string{"delivery_fee"}); changed {
+		t.Error("a missing field was reported as converted")
+	}
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Login attempts that never come back from the provider are cleaned up.
+const oidcStateTTL = 10 * time.Minute
+
+type OIDCStateRepo interface {
+	Create(ctx context.Context, s *models.OIDCState) error
+	// Consume returns the state and deletes it, so a callback can't be replayed.
+	Consume(ctx context.Context, state string) (*models.OIDCState, error)
+}
+
+type oidcStateRepo struct {
+	col *mongo.Collection
+}
+
+func NewOIDCStateRepo(db *mongo.Database) (OIDCStateRepo, error) {
+	col := db.Collection("oidc_states")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(oidcStateTTL.Seconds())),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &oidcStateRepo{col: col}, nil
+}
+
+func (r *oidcStateRepo) Create(ctx context.Context, s *models.OIDCState) error {
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = time.Now()
+	}
+	_, err := r.col.InsertOne(ctx, s)
+	return err
+}
+
+func (r *oidcStateRepo) Consume(ctx context.Context, state string) (*models.OIDCState, error) {
+	var s models.OIDCState
+	err := r.col.FindOneAndDelete(ctx, bson.M{
+		"_id":        state,
+		"created_at": bson.M{"$gt": time.Now().Add(-oidcStateTTL)},
+	}).Decode(&s)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
@@ -18,6 +18,37 @@ type OrderRepo interface {
 	UpdateStatus(ctx context.Context, id primitive.ObjectID, status string) error
 	UpdateStatusWithHistory(ctx context.Context, id primitive.ObjectID, status string, note string) error
 	Delete(ctx context.Context, id primitive.ObjectID) error
+	AnonymizeByUserID(ctx context.Context, userID primitive.ObjectID) (int64, error)
+	FindByPickupRange(ctx context.Context, from, to time.Time) ([]models.Order, error)
+
+	// TransitionStatus moves the order to status only if it is currently in
+	// one of from, and reports whether it did.
+	TransitionStatus(ctx context.Context, id primitive.ObjectID, from []string, status, note string) (bool, error)
+	AssignCourier(ctx context.Context, id, courierID primitive.ObjectID, from []string, note string) (bool, error)
+	// UpdateCourierStatus is TransitionStatus restricted to the assigned
+	// courier; proof is stored when non-nil.
+	UpdateCourierStatus(ctx context.Context, id, courierID primitive.ObjectID, from []string, status, note string, proof *models.DeliveryProof) (bool, error)
+	FindDeliveries(ctx context.Context, statuses []string) ([]models.Order, error)
+	// SetPaymentStatus links paymentID and sets the payment status if the
+	// current one is in from. The change is recorded in History as
+	// "PAYMENT_<status>".
+	SetPaymentStatus(ctx context.Context, id, paymentID primitive.ObjectID, from []string, status, note string) (bool, error)
+	// ClaimPayment is SetPaymentStatus to PENDING for an order that has not
+	// been cancelled.
+	ClaimPayment(ctx context.Context, id, paymentID primitive.ObjectID, from []string, note string) (bool, error)
+	// Cancel moves a NEW order whose payment status is in paymentFrom to
+	// CANCELLED and returns the order as it was just before. It returns
+	// mongo.ErrNoDocuments if the order is not in such a state.
+	Cancel(ctx context.Context, id primitive.ObjectID, paymentFrom []string, note string) (*models.Order, error)
+	// FindAwaitingPayment lists NEW orders placed before cutoff whose
+	// payment status is in paymentStatuses.
+	FindAwaitingPayment(ctx context.Context, paymentStatuses []string, cutoff time.Time) ([]models.Order, error)
+	// SaveRefundState stores the refund bookkeeping of o (items, totals and
+	// status) and appends a history entry. It only succeeds if the order has
+	// not changed since o was read, which keeps concurrent refunds from
+	// refunding the same line twice.
+	SaveRefundState(ctx context.Context, o *models.Order, note string) (bool, error)
+	FindByCourier(ctx context.Context, courierID primitive.ObjectID, statuses []string) ([]models.Order, error)
 }
 
 type orderRepo struct {
@@ -100,3 +131,231 @@ func (r *orderRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
 	_, err := r.col.DeleteOne(ctx, bson.M{"_id": id})
 	return err
 }
+
+// AnonymizeByUserID detaches a deleted user's orders from their account while
+// keeping the order documents for sales reporting. Delivery addresses are
+// personal data and are removed.
+func (r *orderRepo) AnonymizeByUserID(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	res, err := r.col.UpdateMany(
+		ctx,
+		bson.M{"user_id": userID},
+		bson.M{
+			"$set": bson.M{
+				"user_id":    primitive.NilObjectID,
+				"anonymized": true,
+				"updated_at": time.Now(),
+			},
+			"$unset": bson.M{"delivery_address": ""},
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// FindByPickupRange returns click-and-collect orders whose slot starts in
+// [from, to), earliest slot first.
+func (r *orderRepo) FindByPickupRange(ctx context.Context, from, to time.Time) ([]models.Order, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "pickup_slot.start", Value: 1}, {Key: "created_at", Value: 1}})
+	cur, err := r.col.Find(ctx, bson.M{"pickup_slot.start": bson.M{"$gte": from, "$lt": to}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	list := []models.Order{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func historyEntry(status, note string) bson.M {
+	e := bson.M{"status": status, "timestamp": time.Now()}
+	if note != "" {
+		e["note"] = note
+	}
+	return e
+}
+
+func (r *orderRepo) TransitionStatus(ctx context.Context, id primitive.ObjectID, from []string, status, note string) (bool, error) {
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "status": bson.M{"$in": from}},
+		bson.M{
+			"$set":  bson.M{"status": status, "updated_at": time.Now()},
+			"$push": bson.M{"history": historyEntry(status, note)},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *orderRepo) AssignCourier(ctx context.Context, id, courierID primitive.ObjectID, from []string, note string) (bool, error) {
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":              id,
+			"status":           bson.M{"$in": from},
+			"delivery_address": bson.M{"$exists": true},
+		},
+		bson.M{
+			"$set": bson.M{
+				"status":     models.OrderCourierAssigned,
+				"courier_id": courierID,
+				"updated_at": time.Now(),
+			},
+			"$push": bson.M{"history": historyEntry(models.OrderCourierAssigned, note)},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *orderRepo) UpdateCourierStatus(ctx context.Context, id, courierID primitive.ObjectID, from []string, status, note string, proof *models.DeliveryProof) (bool, error) {
+	set := bson.M{"status": status, "updated_at": time.Now()}
+	if proof != nil {
+		set["delivery_proof"] = proof
+	}
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "courier_id": courierID, "status": bson.M{"$in": from}},
+		bson.M{
+			"$set":  set,
+			"$push": bson.M{"history": historyEntry(status, note)},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *orderRepo) SetPaymentStatus(ctx context.Context, id, paymentID primitive.ObjectID, from []string, status, note string) (bool, error) {
+	return r.setPaymentStatus(ctx, bson.M{"_id": id, "payment_status": bson.M{"$in": from}}, paymentID, status, note)
+}
+
+func (r *orderRepo) ClaimPayment(ctx context.Context, id, paymentID primitive.ObjectID, from []string, note string) (bool, error) {
+	return r.setPaymentStatus(ctx,
+		bson.M{"_id": id, "payment_status": bson.M{"$in": from}, "status": bson.M{"$ne": models.OrderCancelled}},
+		paymentID, models.PaymentPending, note)
+}
+
+func (r *orderRepo) setPaymentStatus(ctx context.Context, filter bson.M, paymentID primitive.ObjectID, status, note string) (bool, error) {
+	res, err := r.col.UpdateOne(
+		ctx,
+		filter,
+		bson.M{
+			"$set": bson.M{
+				"payment_status": status,
+				"payment_id":     paymentID,
+				"updated_at":     time.Now(),
+			},
+			"$push": bson.M{"history": historyEntry("PAYMENT_"+status, note)},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *orderRepo) Cancel(ctx context.Context, id primitive.ObjectID, paymentFrom []string, note string) (*models.Order, error) {
+	var o models.Order
+	err := r.col.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": id, "status": "NEW", "payment_status": bson.M{"$in": paymentFrom}},
+		bson.M{
+			"$set":  bson.M{"status": models.OrderCancelled, "updated_at": time.Now()},
+			"$push": bson.M{"history": historyEntry(models.OrderCancelled, note)},
+		},
+	).Decode(&o)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (r *orderRepo) FindAwaitingPayment(ctx context.Context, paymentStatuses []string, cutoff time.Time) ([]models.Order, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cur, err := r.col.Find(ctx, bson.M{
+		"status":         "NEW",
+		"payment_status": bson.M{"$in": paymentStatuses},
+		"created_at":     bson.M{"$lt": cutoff},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	list := []models.Order{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *orderRepo) SaveRefundState(ctx context.Context, o *models.Order, note string) (bool, error) {
+	// Mongo keeps milliseconds; truncating lets o be saved again later.
+	now := time.Now().Truncate(time.Millisecond)
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"_id": o.ID, "updated_at": o.UpdatedAt},
+		bson.M{
+			"$set": bson.M{
+				"items":                 o.Items,
+				"status":                o.Status,
+				"refunded_total":        o.RefundedTotal,
+				"net_total":             o.NetTotal,
+				"delivery_fee_refunded": o.DeliveryFeeRefunded,
+				"tenders":               o.Tenders,
+				"updated_at":            now,
+			},
+			"$push": bson.M{"history": historyEntry(o.Status, note)},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	if res.ModifiedCount != 1 {
+		return false, nil
+	}
+	o.UpdatedAt = now
+	return true, nil
+}
+
+// FindDeliveries lists home delivery orders in the given statuses, earliest
+// delivery window first.
+func (r *orderRepo) FindDeliveries(ctx context.Context, statuses []string) ([]models.Order, error) {
+	return r.findDeliveries(ctx, bson.M{
+		"delivery_address": bson.M{"$exists": true},
+		"status":           bson.M{"$in": statuses},
+	})
+}
+
+func (r *orderRepo) FindByCourier(ctx context.Context, courierID primitive.ObjectID, statuses []string) ([]models.Order, error) {
+	return r.findDeliveries(ctx, bson.M{
+		"courier_id": courierID,
+		"status":     bson.M{"$in": statuses},
+	})
+}
+
+func (r *orderRepo) findDeliveries(ctx context.Context, filter bson.M) ([]models.Order, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "delivery_window.start", Value: 1}, {Key: "created_at", Value: 1}})
+	cur, err := r.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	list := []models.Order{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PaymentUpdate describes a payment status change. Zero fields are left
+// alone; RefundedAmount is added to the running total.
+type PaymentUpdate struct {
+	Status         string
+	ProviderRef    string
+	DeclineReason  string
+	CapturedAmount models.Money
+	RefundedAmount models.Money
+	Note           string
+}
+
+type PaymentRepo interface {
+	Create(ctx context.Context, p *models.Payment) (primitive.ObjectID, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Payment, error)
+	FindByProviderRef(ctx context.Context, provider, ref string) (*models.Payment, error)
+	// ListByOrderID returns the order's payments, newest first.
+	ListByOrderID(ctx context.Context, orderID primitive.ObjectID) ([]models.Payment, error)
+	// Transition applies upd only if the payment is in one of from, and
+	// reports whether it did.
+	Transition(ctx context.Context, id primitive.ObjectID, from []string, upd PaymentUpdate) (bool, error)
+}
+
+type paymentRepo struct {
+	col *mongo.Collection
+}
+
+func NewPaymentRepo(db *mongo.Database) (PaymentRepo, error) {
+	col := db.Collection("payments")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "provider_ref", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &paymentRepo{col: col}, nil
+}
+
+func (r *paymentRepo) Create(ctx context.Context, p *models.Payment) (primitive.ObjectID, error) {
+	now := time.Now()
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	if p.Events == nil {
+		p.Events = []models.PaymentEvent{}
+	}
+	res, err := r.col.InsertOne(ctx, p)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+func (r *paymentRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Payment, error) {
+	var p models.Payment
+	if err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *paymentRepo) FindByProviderRef(ctx context.Context, provider, ref string) (*models.Payment, error) {
+	var p models.Payment
+	if err := r.col.FindOne(ctx, bson.M{"provider": provider, "provider_ref": ref}).Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *paymentRepo) ListByOrderID(ctx context.Context, orderID primitive.ObjectID) ([]models.Payment, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cur, err := r.col.Find(ctx, bson.M{"order_id": orderID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	list := []models.Payment{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *paymentRepo) Transition(ctx context.Context, id primitive.ObjectID, from []string, upd PaymentUpdate) (bool, error) {
+	now := time.Now()
+	set := bson.M{"status": upd.Status, "updated_at": now}
+	if upd.ProviderRef != "" {
+		set["provider_ref"] = upd.ProviderRef
+	}
+	if upd.DeclineReason != "" {
+		set["decline_reason"] = upd.DeclineReason
+	}
+	if upd.CapturedAmount > 0 {
+		set["captured_amount"] = upd.CapturedAmount
+	}
+
+	event := models.PaymentEvent{Status: upd.Status, Note: upd.Note, Timestamp: now}
+	update := bson.M{"$set": set}
+	switch {
+	case upd.RefundedAmount > 0:
+		event.Amount = upd.RefundedAmount
+		update["$inc"] = bson.M{"refunded_amount": upd.RefundedAmount}
+	case upd.CapturedAmount > 0:
+		event.Amount = upd.CapturedAmount
+	}
+	update["$push"] = bson.M{"events": event}
+
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": id, "status": bson.M{"$in": from}}, update)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const pickupSettingsID = "default"
+
+type PickupRepo interface {
+	// GetSettings returns mongo.ErrNoDocuments until settings are saved once.
+	GetSettings(ctx context.Context) (*models.PickupSettings, error)
+	SaveSettings(ctx context.Context, s *models.PickupSettings) error
+
+	// Reserve takes one place in the slot starting at start unless it already
+	// holds capacity bookings. It reports false when the slot is full.
+	Reserve(ctx context.Context, start, end time.Time, capacity int) (bool, error)
+	Release(ctx context.Context, start time.Time) error
+	// BookedCounts returns bookings per slot start (UTC) in [from, to).
+	BookedCounts(ctx context.Context, from, to time.Time) (map[time.Time]int, error)
+}
+
+type pickupRepo struct {
+	settings *mongo.Collection
+	slots    *mongo.Collection
+}
+
+func NewPickupRepo(db *mongo.Database) (PickupRepo, error) {
+	slots := db.Collection("pickup_slots")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// Past slots are no longer needed for capacity checks.
+	_, err := slots.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "end", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32((30 * 24 * time.Hour).Seconds())),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pickupRepo{settings: db.Collection("pickup_settings"), slots: slots}, nil
+}
+
+func (r *pickupRepo) GetSettings(ctx context.Context) (*models.PickupSettings, error) {
+	var s models.PickupSettings
+	if err := r.settings.FindOne(ctx, bson.M{"_id": pickupSettingsID}).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *pickupRepo) SaveSettings(ctx context.Context, s *models.PickupSettings) error {
+	s.ID = pickupSettingsID
+	s.UpdatedAt = time.Now()
+	_, err := r.settings.ReplaceOne(ctx, bson.M{"_id": pickupSettingsID}, s, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r *pickupRepo) Reserve(ctx context.Context, start, end time.Time, capacity int) (bool, error) {
+	start = start.UTC()
+	_, err := r.slots.UpdateOne(
+		ctx,
+		bson.M{"_id": start},
+		bson.M{"$setOnInsert": bson.M{"end": end.UTC(), "booked": 0}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return false, err
+	}
+
+	// The capacity check and the increment happen in one update, so two
+	// customers can't both take the last place.
+	res, err := r.slots.UpdateOne(
+		ctx,
+		bson.M{"_id": start, "booked": bson.M{"$lt": capacity}},
+		bson.M{"$inc": bson.M{"booked": 1}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *pickupRepo) Release(ctx context.Context, start time.Time) error {
+	_, err := r.slots.UpdateOne(
+		ctx,
+		bson.M{"_id": start.UTC(), "booked": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"booked": -1}},
+	)
+	return err
+}
+
+func (r *pickupRepo) BookedCounts(ctx context.Context, from, to time.Time) (map[time.Time]int, error) {
+	cur, err := r.slots.Find(ctx, bson.M{"_id": bson.M{"$gte": from.UTC(), "$lt": to.UTC()}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var docs []struct {
+		Start  time.Time `bson:"_id"`
+		Booked int       `bson:"booked"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	out := make(map[time.Time]int, len(docs))
+	for _, d := range docs {
+		out[d.Start.UTC()] = d.Booked
+	}
+	return out, nil
+}
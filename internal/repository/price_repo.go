@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PriceRepo interface {
+	AddChange(ctx context.Context, c *models.PriceChange) error
+	// History returns the product's price changes since since (all when
+	// zero), oldest first.
+	History(ctx context.Context, productID primitive.ObjectID, since time.Time) ([]models.PriceChange, error)
+
+	CreateSchedule(ctx context.Context, s *models.PriceSchedule) (primitive.ObjectID, error)
+	FindSchedule(ctx context.Context, id primitive.ObjectID) (*models.PriceSchedule, error)
+	// ListSchedules filters by product and status when they are set,
+	// earliest first.
+	ListSchedules(ctx context.Context, productID primitive.ObjectID, status string) ([]models.PriceSchedule, error)
+	// DueSchedules returns pending schedules effective by at, earliest
+	// first.
+	DueSchedules(ctx context.Context, at time.Time) ([]models.PriceSchedule, error)
+	// SetScheduleStatus moves a schedule on from one of from, reporting
+	// false if it was in none of them.
+	SetScheduleStatus(ctx context.Context, id primitive.ObjectID, from []string, status, failure string) (bool, error)
+}
+
+type priceRepo struct {
+	history   *mongo.Collection
+	schedules *mongo.Collection
+}
+
+func NewPriceRepo(db *mongo.Database) (PriceRepo, error) {
+	history := db.Collection("price_history")
+	schedules := db.Collection("price_schedules")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := history.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "changed_at", Value: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = schedules.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "effective_at", Value: 1}}},
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "effective_at", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &priceRepo{history: history, schedules: schedules}, nil
+}
+
+func (r *priceRepo) AddChange(ctx context.Context, c *models.PriceChange) error {
+	if c.ChangedAt.IsZero() {
+		c.ChangedAt = time.Now()
+	}
+	res, err := r.history.InsertOne(ctx, c)
+	if err != nil {
+		return err
+	}
+	c.ID, _ = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *priceRepo) History(ctx context.Context, productID primitive.ObjectID, since time.Time) ([]models.PriceChange, error) {
+	filter := bson.M{"product_id": productID}
+	if !since.IsZero() {
+		filter["changed_at"] = bson.M{"$gte": since}
+	}
+	cur, err := r.history.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "changed_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.PriceChange{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *priceRepo) CreateSchedule(ctx context.Context, s *models.PriceSchedule) (primitive.ObjectID, error) {
+	now := time.Now()
+	s.CreatedAt = now
+	s.UpdatedAt = now
+	res, err := r.schedules.InsertOne(ctx, s)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+func (r *priceRepo) FindSchedule(ctx context.Context, id primitive.ObjectID) (*models.PriceSchedule, error) {
+	var s models.PriceSchedule
+	if err := r.schedules.FindOne(ctx, bson.M{"_id": id}).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *priceRepo) ListSchedules(ctx context.Context, productID primitive.ObjectID, status string) ([]models.PriceSchedule, error) {
+	filter := bson.M{}
+	if !productID.IsZero() {
+		filter["product_id"] = productID
+	}
+	if status != "" {
+		filter["status"] = status
+	}
+	return r.findSchedules(ctx, filter)
+}
+
+func (r *priceRepo) DueSchedules(ctx context.Context, at time.Time) ([]models.PriceSchedule, error) {
+	return r.findSchedules(ctx, bson.M{
+		"status":       models.ScheduleStatusPending,
+		"effective_at": bson.M{"$lte": at},
+	})
+}
+
+func (r *priceRepo) findSchedules(ctx context.Context, filter bson.M) ([]models.PriceSchedule, error) {
+	cur, err := r.schedules.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "effective_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.PriceSchedule{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *priceRepo) SetScheduleStatus(ctx context.Context, id primitive.ObjectID, from []string, status, failure string) (bool, error) {
+	now := time.Now()
+	set := bson.M{"status": status, "updated_at": now}
+	if status == models.ScheduleStatusApplied {
+		set["applied_at"] = now
+	}
+	if failure != "" {
+		set["failure"] = failure
+	}
+	res, err := r.schedules.UpdateOne(ctx,
+		bson.M{"_id": id, "status": bson.M{"$in": from}},
+		bson.M{"$set": set})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
@@ -15,12 +15,14 @@ type ProductFilter struct {
 	Query      string
 	CategoryID *primitive.ObjectID
 	BrandID    *primitive.ObjectID
-	MinPrice   *float64
-	MaxPrice   *float64
-	SortBy     string
-	SortOrder  int
-	Page       int
-	Limit      int
+	MinPrice   *models.Money
+	MaxPrice   *models.Money
+	// Reduced keeps only products reduced to clear.
+	Reduced   bool
+	SortBy    string
+	SortOrder int
+	Page      int
+	Limit     int
 }
 
 type ProductListResult struct {
@@ -36,10 +38,19 @@ type ProductRepo interface {
 	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Product, error)
 	List(ctx context.Context, q string, categoryID *primitive.ObjectID) ([]models.Product, error)
 	ListWithFilter(ctx context.Context, filter ProductFilter) (*ProductListResult, error)
-	Update(ctx context.Context, id primitive.ObjectID, p *models.Product) error
+	// Update replaces the editable fields and returns the product as it
+	// was, or mongo.ErrNoDocuments.
+	Update(ctx context.Context, id primitive.ObjectID, p *models.Product) (*models.Product, error)
+	// SetPrice changes only the price and returns the product as it was.
+	SetPrice(ctx context.Context, id primitive.ObjectID, price models.Money) (*models.Product, error)
 	Delete(ctx context.Context, id primitive.ObjectID) error
+	// SetMarkdown reduces the product to clear, or ends its markdown when m
+	// is nil.
+	SetMarkdown(ctx context.Context, id primitive.ObjectID, m *models.Markdown) error
+	ListMarkedDown(ctx context.Context) ([]models.Product, error)
 
 	DecreaseStock(ctx context.Context, productID primitive.ObjectID, qty int) error
+	IncreaseStock(ctx context.Context, productID primitive.ObjectID, qty int) error
 	FindByBarcode(ctx context.Context, barcode string) (*models.Product, error)
 	Count(ctx context.Context) (int64, error)
 	CountByCategory(ctx context.Context, categoryID primitive.ObjectID) (int64, error)
@@ -97,9 +108,10 @@ func (r *productRepo) List(ctx context.Context, q string, categoryID *primitive.
 	}
 	return list, nil
 }
-func (r *productRepo) Update(ctx context.Context, id primitive.ObjectID, p *models.Product) error {
+func (r *productRepo) Update(ctx context.Context, id primitive.ObjectID, p *models.Product) (*models.Product, error) {
 	p.UpdatedAt = time.Now()
-	_, err := r.col.UpdateOne(
+	var old models.Product
+	err := r.col.FindOneAndUpdate(
 		ctx,
 		bson.M{"_id": id},
 		bson.M{"$set": bson.M{
@@ -110,6 +122,7 @@ func (r *productRepo) Update(ctx context.Context, id primitive.ObjectID, p *mode
 			"category_id":         p.CategoryID,
 			"price":               p.Price,
 			"currency":            p.Currency,
+			"tax_class_id":        p.TaxClassID,
 			"aisle":               p.Aisle,
 			"section":             p.Section,
 			"shelf":               p.Shelf,
@@ -118,9 +131,43 @@ func (r *productRepo) Update(ctx context.Context, id primitive.ObjectID, p *mode
 			"image_url":           p.ImageURL,
 			"updated_at":          p.UpdatedAt,
 		}},
-	)
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&old)
+	if err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+func (r *productRepo) SetPrice(ctx context.Context, id primitive.ObjectID, price models.Money) (*models.Product, error) {
+	var old models.Product
+	err := r.col.FindOneAndUpdate(ctx, bson.M{"_id": id},
+		bson.M{"$set": bson.M{"price": price, "updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&old)
+	if err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+func (r *productRepo) SetMarkdown(ctx context.Context, id primitive.ObjectID, m *models.Markdown) error {
+	update := bson.M{"$unset": bson.M{"markdown": ""}}
+	if m != nil {
+		update = bson.M{"$set": bson.M{"markdown": m}}
+	}
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, update)
 	return err
 }
+func (r *productRepo) ListMarkedDown(ctx context.Context) ([]models.Product, error) {
+	cur, err := r.col.Find(ctx, bson.M{"markdown": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cur.Close(ctx) }()
+	list := []models.Product{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
 func (r *productRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
 	_, err := r.col.DeleteOne(ctx, bson.M{"_id": id})
 	return err
@@ -133,6 +180,14 @@ func (r *productRepo) DecreaseStock(ctx context.Context, productID primitive.Obj
 	)
 	return err
 }
+func (r *productRepo) IncreaseStock(ctx context.Context, productID primitive.ObjectID, qty int) error {
+	_, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"_id": productID},
+		bson.M{"$inc": bson.M{"stock_qty": qty}},
+	)
+	return err
+}
 func (r *productRepo) FindByBarcode(ctx context.Context, barcode string) (*models.Product, error) {
 	var p models.Product
 	if err := r.col.FindOne(ctx, bson.M{"barcode": barcode}).Decode(&p); err != nil {
@@ -141,6 +196,20 @@ func (r *productRepo) FindByBarcode(ctx context.Context, barcode string) (*model
 	return &p, nil
 }
 
+// sellingPriceExpr works out models.Product.SellingPrice in an aggregation:
+// the price less the markdown's share of it, rounded half up as Money does
+// for amounts that can't be negative.
+var sellingPriceExpr = bson.M{"$subtract": bson.A{
+	"$price",
+	bson.M{"$floor": bson.M{"$divide": bson.A{
+		bson.M{"$add": bson.A{
+			bson.M{"$multiply": bson.A{"$price", bson.M{"$ifNull": bson.A{"$markdown.basis_points", 0}}}},
+			5000,
+		}},
+		10000,
+	}}},
+}}
+
 func (r *productRepo) ListWithFilter(ctx context.Context, f ProductFilter) (*ProductListResult, error) {
 	filter := bson.M{}
 
@@ -165,26 +234,27 @@ func (r *productRepo) ListWithFilter(ctx context.Context, f ProductFilter) (*Pro
 		filter["brand_id"] = *f.BrandID
 	}
 
-	if f.MinPrice != nil || f.MaxPrice != nil {
-		priceFilter := bson.M{}
-		if f.MinPrice != nil {
-			priceFilter["$gte"] = *f.MinPrice
-		}
-		if f.MaxPrice != nil {
-			priceFilter["$lte"] = *f.MaxPrice
-		}
-		filter["price"] = priceFilter
+	if f.Reduced {
+		filter["markdown"] = bson.M{"$exists": true}
 	}
 
-	total, err := r.col.CountDocuments(ctx, filter)
-	if err != nil {
-		return nil, err
+	// Price filters and sorting go by what products sell for, so a
+	// marked down product is found at its reduced price.
+	priceFilter := bson.M{}
+	if f.MinPrice != nil {
+		priceFilter["$gte"] = *f.MinPrice
+	}
+	if f.MaxPrice != nil {
+		priceFilter["$lte"] = *f.MaxPrice
 	}
 
 	sortField := "created_at"
 	if f.SortBy != "" {
 		sortField = f.SortBy
 	}
+	if sortField == "price" {
+		sortField = "selling_price"
+	}
 	sortOrder := -1
 	if f.SortOrder != 0 {
 		sortOrder = f.SortOrder
@@ -203,21 +273,45 @@ func (r *productRepo) ListWithFilter(ctx context.Context, f ProductFilter) (*Pro
 	}
 	skip := (page - 1) * limit
 
-	opts := options.Find().
-		SetSort(bson.D{{Key: sortField, Value: sortOrder}}).
-		SetSkip(int64(skip)).
-		SetLimit(int64(limit))
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$addFields", Value: bson.M{"selling_price": sellingPriceExpr}}},
+	}
+	if len(priceFilter) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"selling_price": priceFilter}}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$facet", Value: bson.M{
+		"total": bson.A{bson.M{"$count": "n"}},
+		"products": bson.A{
+			bson.M{"$sort": bson.D{{Key: sortField, Value: sortOrder}}},
+			bson.M{"$skip": int64(skip)},
+			bson.M{"$limit": int64(limit)},
+		},
+	}}})
 
-	cur, err := r.col.Find(ctx, filter, opts)
+	cur, err := r.col.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = cur.Close(ctx) }()
 
-	var products []models.Product
-	if err := cur.All(ctx, &products); err != nil {
+	var res []struct {
+		Total []struct {
+			N int64 `bson:"n"`
+		} `bson:"total"`
+		Products []models.Product `bson:"products"`
+	}
+	if err := cur.All(ctx, &res); err != nil {
 		return nil, err
 	}
+	var total int64
+	var products []models.Product
+	if len(res) > 0 {
+		products = res[0].Products
+		if len(res[0].Total) > 0 {
+			total = res[0].Total[0].N
+		}
+	}
 
 	totalPages := int(total) / limit
 	if int(total)%limit > 0 {
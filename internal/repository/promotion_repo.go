@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PromotionRepo interface {
+	List(ctx context.Context) ([]models.Promotion, error)
+	// ListRunning returns the active promotions whose window contains at,
+	// highest priority first, leaving out coupon-only ones.
+	ListRunning(ctx context.Context, at time.Time) ([]models.Promotion, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Promotion, error)
+	Create(ctx context.Context, p *models.Promotion) (primitive.ObjectID, error)
+	Update(ctx context.Context, p *models.Promotion) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type promotionRepo struct {
+	col *mongo.Collection
+}
+
+func NewPromotionRepo(db *mongo.Database) (PromotionRepo, error) {
+	col := db.Collection("promotions")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "active", Value: 1}, {Key: "priority", Value: -1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &promotionRepo{col: col}, nil
+}
+
+var promotionOrder = bson.D{{Key: "priority", Value: -1}, {Key: "created_at", Value: 1}}
+
+func (r *promotionRepo) List(ctx context.Context) ([]models.Promotion, error) {
+	return r.find(ctx, bson.M{})
+}
+
+func (r *promotionRepo) ListRunning(ctx context.Context, at time.Time) ([]models.Promotion, error) {
+	return r.find(ctx, bson.M{
+		"active":      true,
+		"coupon_only": bson.M{"$ne": true},
+		"$and": bson.A{
+			bson.M{"$or": bson.A{bson.M{"starts_at": nil}, bson.M{"starts_at": bson.M{"$lte": at}}}},
+			bson.M{"$or": bson.A{bson.M{"ends_at": nil}, bson.M{"ends_at": bson.M{"$gt": at}}}},
+		},
+	})
+}
+
+func (r *promotionRepo) find(ctx context.Context, filter bson.M) ([]models.Promotion, error) {
+	cur, err := r.col.Find(ctx, filter, options.Find().SetSort(promotionOrder))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.Promotion{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *promotionRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Promotion, error) {
+	var p models.Promotion
+	if err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *promotionRepo) Create(ctx context.Context, p *models.Promotion) (primitive.ObjectID, error) {
+	now := time.Now()
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	res, err := r.col.InsertOne(ctx, p)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+func (r *promotionRepo) Update(ctx context.Context, p *models.Promotion) error {
+	p.UpdatedAt = time.Now()
+	res, err := r.col.ReplaceOne(ctx, bson.M{"_id": p.ID}, p)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *promotionRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.col.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
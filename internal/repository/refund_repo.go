@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type RefundRepo interface {
+	Create(ctx context.Context, rf *models.Refund) (primitive.ObjectID, error)
+	// ListByOrderID returns the order's refunds, oldest first.
+	ListByOrderID(ctx context.Context, orderID primitive.ObjectID) ([]models.Refund, error)
+	SetStatus(ctx context.Context, id primitive.ObjectID, status, failure string) error
+}
+
+type refundRepo struct {
+	col *mongo.Collection
+}
+
+func NewRefundRepo(db *mongo.Database) (RefundRepo, error) {
+	col := db.Collection("refunds")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &refundRepo{col: col}, nil
+}
+
+func (r *refundRepo) Create(ctx context.Context, rf *models.Refund) (primitive.ObjectID, error) {
+	now := time.Now()
+	rf.CreatedAt = now
+	rf.UpdatedAt = now
+	res, err := r.col.InsertOne(ctx, rf)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+func (r *refundRepo) ListByOrderID(ctx context.Context, orderID primitive.ObjectID) ([]models.Refund, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cur, err := r.col.Find(ctx, bson.M{"order_id": orderID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	list := []models.Refund{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *refundRepo) SetStatus(ctx context.Context, id primitive.ObjectID, status, failure string) error {
+	set := bson.M{"status": status, "updated_at": time.Now()}
+	if failure != "" {
+		set["failure"] = failure
+	}
+	_, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReturnUpdate carries the fields a status change may set. Zero fields are
+// left alone.
+type ReturnUpdate struct {
+	Lines      []models.ReturnLine
+	Resolution string
+	Amount     models.Money
+	RefundID   primitive.ObjectID
+}
+
+type ReturnRepo interface {
+	Create(ctx context.Context, rr *models.ReturnRequest) (primitive.ObjectID, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.ReturnRequest, error)
+	// ListByUserID returns the user's returns, newest first.
+	ListByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.ReturnRequest, error)
+	// List returns returns in the given statuses (all when empty), oldest
+	// first so staff work through them in order.
+	List(ctx context.Context, statuses []string) ([]models.ReturnRequest, error)
+	ListByOrderID(ctx context.Context, orderID primitive.ObjectID, statuses []string) ([]models.ReturnRequest, error)
+	// Transition moves the return to ev.Status if it is in one of from,
+	// applies upd and appends ev to the timeline.
+	Transition(ctx context.Context, id primitive.ObjectID, from []string, ev models.ReturnEvent, upd ReturnUpdate) (bool, error)
+}
+
+type returnRepo struct {
+	col *mongo.Collection
+}
+
+func NewReturnRepo(db *mongo.Database) (ReturnRepo, error) {
+	col := db.Collection("returns")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := col.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "order_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &returnRepo{col: col}, nil
+}
+
+func (r *returnRepo) Create(ctx context.Context, rr *models.ReturnRequest) (primitive.ObjectID, error) {
+	now := time.Now()
+	rr.CreatedAt = now
+	rr.UpdatedAt = now
+	res, err := r.col.InsertOne(ctx, rr)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+func (r *returnRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*models.ReturnRequest, error) {
+	var rr models.ReturnRequest
+	if err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&rr); err != nil {
+		return nil, err
+	}
+	return &rr, nil
+}
+
+func (r *returnRepo) ListByUserID(ctx context.Context, userID primitive.ObjectID) ([]models.ReturnRequest, error) {
+	return r.find(ctx, bson.M{"user_id": userID}, -1)
+}
+
+func (r *returnRepo) List(ctx context.Context, statuses []string) ([]models.ReturnRequest, error) {
+	filter := bson.M{}
+	if len(statuses) > 0 {
+		filter["status"] = bson.M{"$in": statuses}
+	}
+	return r.find(ctx, filter, 1)
+}
+
+func (r *returnRepo) ListByOrderID(ctx context.Context, orderID primitive.ObjectID, statuses []string) ([]models.ReturnRequest, error) {
+	return r.find(ctx, bson.M{"order_id": orderID, "status": bson.M{"$in": statuses}}, 1)
+}
+
+func (r *returnRepo) find(ctx context.Context, filter bson.M, order int) ([]models.ReturnRequest, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: order}})
+	cur, err := r.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	list := []models.ReturnRequest{}
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *returnRepo) Transition(ctx context.Context, id primitive.ObjectID, from []string, ev models.ReturnEvent, upd ReturnUpdate) (bool, error) {
+	ev.Timestamp = time.Now()
+	set := bson.M{"status": ev.Status, "updated_at": ev.Timestamp}
+	if upd.Lines != nil {
+		set["lines"] = upd.Lines
+	}
+	if upd.Resolution != "" {
+		set["resolution"] = upd.Resolution
+	}
+	if upd.Amount > 0 {
+		set["amount"] = upd.Amount
+	}
+	if !upd.RefundID.IsZero() {
+		set["refund_id"] = upd.RefundID
+	}
+
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "status": bson.M{"$in": from}},
+		bson.M{"$set": set, "$push": bson.M{"timeline": ev}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
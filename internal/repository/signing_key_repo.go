@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SigningKeyRepo interface {
+	Create(ctx context.Context, k *models.SigningKey) error
+	ListUsable(ctx context.Context) ([]models.SigningKey, error)
+	RetireAllExcept(ctx context.Context, kid string, expiresAt time.Time) error
+	DeleteExpired(ctx context.Context) error
+	// SealPrivateKey replaces a plaintext private key with its encrypted form.
+	SealPrivateKey(ctx context.Context, kid, enc string) error
+}
+
+type signingKeyRepo struct {
+	col *mongo.Collection
+}
+
+func NewSigningKeyRepo(db *mongo.Database) (SigningKeyRepo, error) {
+	col := db.Collection("signing_keys")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "kid", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &signingKeyRepo{col: col}, nil
+}
+
+func (r *signingKeyRepo) Create(ctx context.Context, k *models.SigningKey) error {
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = time.Now()
+	}
+	_, err := r.col.InsertOne(ctx, k)
+	return err
+}
+
+// ListUsable returns keys that still verify tokens, newest first.
+func (r *signingKeyRepo) ListUsable(ctx context.Context) ([]models.SigningKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cur, err := r.col.Find(ctx, bson.M{
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$exists": false}},
+			bson.M{"expires_at": bson.M{"$gt": time.Now()}},
+		},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	var list []models.SigningKey
+	if err := cur.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// RetireAllExcept stops every other active key from signing. They stay valid
+// for verification until expiresAt.
+func (r *signingKeyRepo) RetireAllExcept(ctx context.Context, kid string, expiresAt time.Time) error {
+	_, err := r.col.UpdateMany(
+		ctx,
+		bson.M{"kid": bson.M{"$ne": kid}, "retired_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"retired_at": time.Now(), "expires_at": expiresAt}},
+	)
+	return err
+}
+
+func (r *signingKeyRepo) DeleteExpired(ctx context.Context) error {
+	_, err := r.col.DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lte": time.Now()}})
+	return err
+}
+
+func (r *signingKeyRepo) SealPrivateKey(ctx context.Context, kid, enc string) error {
+	_, err := r.col.UpdateOne(ctx,
+		bson.M{"kid": kid},
+		bson.M{"$set": bson.M{"private_key_enc": enc}, "$unset": bson.M{"private_key_pem": ""}})
+	return err
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const storeCreditMigrationID = "store_credit_ledger"
+
+// MigrateStoreCredit moves the entries of the old "store_credit" collection
+// into the stored-value ledger as transfers from AccountRefunds, keeping
+// their ids, and then works out every account balance from the ledger. It
+// must run before anything writes to the ledger; like MigrateMoney it runs
+// once per database and is safe to repeat if interrupted.
+func MigrateStoreCredit(ctx context.Context, db *mongo.Database) error {
+	migrations := db.Collection("migrations")
+	err := migrations.FindOne(ctx, bson.M{"_id": storeCreditMigrationID}).Err()
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	transfers := db.Collection("ledger_transfers")
+	cur, err := db.Collection("store_credit").Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	n := 0
+	for cur.Next(ctx) {
+		var e models.StoreCreditEntry
+		if err := cur.Decode(&e); err != nil {
+			return err
+		}
+		t := models.LedgerTransfer{
+			ID:        e.ID,
+			From:      models.AccountRefunds,
+			To:        models.StoreCreditAccount(e.UserID),
+			Amount:    e.Amount,
+			Kind:      e.Source,
+			RefID:     e.RefID,
+			Note:      e.Note,
+			CreatedAt: e.CreatedAt,
+		}
+		if e.Amount < 0 {
+			t.From, t.To, t.Amount = t.To, t.From, -e.Amount
+		}
+		if _, err := transfers.InsertOne(ctx, t); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		n++
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("[migrate] store_credit: moved %d entries to the ledger", n)
+	}
+
+	if err := rebuildLedgerBalances(ctx, db); err != nil {
+		return err
+	}
+
+	_, err = migrations.InsertOne(ctx, bson.M{"_id": storeCreditMigrationID, "applied_at": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// rebuildLedgerBalances sets every account balance to the sum of its
+// applied transfers.
+func rebuildLedgerBalances(ctx context.Context, db *mongo.Database) error {
+	cur, err := db.Collection("ledger_transfers").Find(ctx, bson.M{"pending": bson.M{"$ne": true}})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	balances := map[string]models.Money{}
+	for cur.Next(ctx) {
+		var t models.LedgerTransfer
+		if err := cur.Decode(&t); err != nil {
+			return err
+		}
+		balances[t.From] -= t.Amount
+		balances[t.To] += t.Amount
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	accounts := db.Collection("ledger_accounts")
+	for account, balance := range balances {
+		_, err := accounts.ReplaceOne(ctx, bson.M{"_id": account},
+			bson.M{"_id": account, "balance": balance}, options.Replace().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
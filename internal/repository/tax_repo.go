@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const taxSettingsID = "default"
+
+type TaxRepo interface {
+	ListClasses(ctx context.Context) ([]models.TaxClass, error)
+	FindClassByID(ctx context.Context, id primitive.ObjectID) (*models.TaxClass, error)
+	// CreateClass and UpdateClass fail with a duplicate key error when the
+	// code is taken.
+	CreateClass(ctx context.Context, c *models.TaxClass) (primitive.ObjectID, error)
+	UpdateClass(ctx context.Context, c *models.TaxClass) error
+	DeleteClass(ctx context.Context, id primitive.ObjectID) error
+
+	// GetSettings returns mongo.ErrNoDocuments until settings are saved once.
+	GetSettings(ctx context.Context) (*models.TaxSettings, error)
+	SaveSettings(ctx context.Context, s *models.TaxSettings) error
+}
+
+type taxRepo struct {
+	classes  *mongo.Collection
+	settings *mongo.Collection
+}
+
+func NewTaxRepo(db *mongo.Database) (TaxRepo, error) {
+	classes := db.Collection("tax_classes")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := classes.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &taxRepo{classes: classes, settings: db.Collection("tax_settings")}, nil
+}
+
+func (r *taxRepo) ListClasses(ctx context.Context) ([]models.TaxClass, error) {
+	cur, err := r.classes.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "code", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.TaxClass{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *taxRepo) FindClassByID(ctx context.Context, id primitive.ObjectID) (*models.TaxClass, error) {
+	var c models.TaxClass
+	if err := r.classes.FindOne(ctx, bson.M{"_id": id}).Decode(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *taxRepo) CreateClass(ctx context.Context, c *models.TaxClass) (primitive.ObjectID, error) {
+	now := time.Now()
+	c.CreatedAt = now
+	c.UpdatedAt = now
+	res, err := r.classes.InsertOne(ctx, c)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	id, _ := res.InsertedID.(primitive.ObjectID)
+	return id, nil
+}
+
+func (r *taxRepo) UpdateClass(ctx context.Context, c *models.TaxClass) error {
+	c.UpdatedAt = time.Now()
+	res, err := r.classes.ReplaceOne(ctx, bson.M{"_id": c.ID}, c)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *taxRepo) DeleteClass(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.classes.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *taxRepo) GetSettings(ctx context.Context) (*models.TaxSettings, error) {
+	var s models.TaxSettings
+	if err := r.settings.FindOne(ctx, bson.M{"_id": taxSettingsID}).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *taxRepo) SaveSettings(ctx context.Context, s *models.TaxSettings) error {
+	s.ID = taxSettingsID
+	s.UpdatedAt = time.Now()
+	_, err := r.settings.ReplaceOne(ctx, bson.M{"_id": taxSettingsID}, s, options.Replace().SetUpsert(true))
+	return err
+}
@@ -2,9 +2,11 @@ package repository
 
 import (
 	"context"
+	"regexp"
 
 	"github.com/dannieey/Assignment3_Absolute/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -12,12 +14,52 @@ import (
 	"time"
 )
 
+type UserFilter struct {
+	Query    string
+	Role     string
+	Disabled *bool
+	Page     int
+	Limit    int
+}
+
+type UserListResult struct {
+	Users      []models.User
+	Total      int64
+	Page       int
+	Limit      int
+	TotalPages int
+}
+
 type UserRepo interface {
 	Create(ctx context.Context, u *models.User) (primitive.ObjectID, error)
 	FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error)
 	FindByEmail(ctx context.Context, email string) (*models.User, error)
 	List(ctx context.Context) ([]models.User, error)
+	ListWithFilter(ctx context.Context, filter UserFilter) (*UserListResult, error)
 	Delete(ctx context.Context, id primitive.ObjectID) error
+
+	UpdateRole(ctx context.Context, id primitive.ObjectID, role string) error
+	SetDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error
+	UpdatePasswordHash(ctx context.Context, id primitive.ObjectID, hash string) error
+	CountActiveByRole(ctx context.Context, role string) (int64, error)
+
+	UpdateFullName(ctx context.Context, id primitive.ObjectID, fullName string) error
+	SetPendingEmail(ctx context.Context, id primitive.ObjectID, email, tokenHash string, expiresAt time.Time) error
+	FindByEmailVerifyToken(ctx context.Context, tokenHash string) (*models.User, error)
+	ConfirmPendingEmail(ctx context.Context, id primitive.ObjectID, email string) error
+
+	SetTOTPPendingSecret(ctx context.Context, id primitive.ObjectID, secret string) error
+	EnableTOTP(ctx context.Context, id primitive.ObjectID, secret string, recoveryHashes []string) error
+	DisableTOTP(ctx context.Context, id primitive.ObjectID) error
+	SetRecoveryCodes(ctx context.Context, id primitive.ObjectID, hashes []string) error
+	UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) (bool, error)
+	AdvanceTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error)
+
+	FindByExternalIdentity(ctx context.Context, issuer, subject string) (*models.User, error)
+	LinkExternalIdentity(ctx context.Context, id primitive.ObjectID, ident models.ExternalIdentity) error
+
+	SetConfirmCode(ctx context.Context, id primitive.ObjectID, action, codeHash string, expiresAt time.Time) error
+	UseConfirmCode(ctx context.Context, id primitive.ObjectID, action, codeHash string) (bool, error)
 }
 type userRepo struct {
 	col *mongo.Collection
@@ -63,7 +105,283 @@ func (r *userRepo) List(ctx context.Context) ([]models.User, error) {
 	}
 	return list, nil
 }
+
+func (r *userRepo) ListWithFilter(ctx context.Context, f UserFilter) (*UserListResult, error) {
+	filter := bson.M{}
+
+	if f.Query != "" {
+		q := regexp.QuoteMeta(f.Query)
+		filter["$or"] = bson.A{
+			bson.M{"full_name": bson.M{"$regex": q, "$options": "i"}},
+			bson.M{"email": bson.M{"$regex": q, "$options": "i"}},
+		}
+	}
+	if f.Role != "" {
+		filter["role"] = f.Role
+	}
+	if f.Disabled != nil {
+		if *f.Disabled {
+			filter["disabled"] = true
+		} else {
+			filter["disabled"] = bson.M{"$ne": true}
+		}
+	}
+
+	total, err := r.col.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := f.Page
+	if page < 1 {
+		page = 1
+	}
+	limit := f.Limit
+	if limit < 1 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	skip := (page - 1) * limit
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+
+	cur, err := r.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = cur.Close(ctx) }()
+
+	var users []models.User
+	if err := cur.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	totalPages := int(total) / limit
+	if int(total)%limit > 0 {
+		totalPages++
+	}
+
+	return &UserListResult{
+		Users:      users,
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		TotalPages: totalPages,
+	}, nil
+}
+
 func (r *userRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
 	_, err := r.col.DeleteOne(ctx, bson.M{"_id": id})
 	return err
 }
+
+func (r *userRepo) UpdateRole(ctx context.Context, id primitive.ObjectID, role string) error {
+	return r.set(ctx, id, bson.M{"role": role})
+}
+
+func (r *userRepo) SetDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error {
+	return r.set(ctx, id, bson.M{"disabled": disabled})
+}
+
+func (r *userRepo) UpdatePasswordHash(ctx context.Context, id primitive.ObjectID, hash string) error {
+	return r.set(ctx, id, bson.M{"password_hash": hash})
+}
+
+func (r *userRepo) CountActiveByRole(ctx context.Context, role string) (int64, error) {
+	return r.col.CountDocuments(ctx, bson.M{
+		"role":     role,
+		"disabled": bson.M{"$ne": true},
+	})
+}
+
+func (r *userRepo) UpdateFullName(ctx context.Context, id primitive.ObjectID, fullName string) error {
+	return r.set(ctx, id, bson.M{"full_name": fullName})
+}
+
+func (r *userRepo) SetPendingEmail(ctx context.Context, id primitive.ObjectID, email, tokenHash string, expiresAt time.Time) error {
+	return r.set(ctx, id, bson.M{
+		"pending_email":           email,
+		"email_verify_token_hash": tokenHash,
+		"email_verify_expires_at": expiresAt,
+	})
+}
+
+func (r *userRepo) FindByEmailVerifyToken(ctx context.Context, tokenHash string) (*models.User, error) {
+	var u models.User
+	if err := r.col.FindOne(ctx, bson.M{
+		"email_verify_token_hash": tokenHash,
+		"email_verify_expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ConfirmPendingEmail makes the pending address the user's email and clears
+// the verification token.
+func (r *userRepo) ConfirmPendingEmail(ctx context.Context, id primitive.ObjectID, email string) error {
+	_, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "pending_email": email},
+		bson.M{
+			"$set": bson.M{
+				"email":      email,
+				"updated_at": time.Now(),
+			},
+			"$unset": bson.M{
+				"pending_email":           "",
+				"email_verify_token_hash": "",
+				"email_verify_expires_at": "",
+			},
+		},
+	)
+	return err
+}
+
+func (r *userRepo) SetTOTPPendingSecret(ctx context.Context, id primitive.ObjectID, secret string) error {
+	return r.set(ctx, id, bson.M{"totp_pending_secret": secret})
+}
+
+func (r *userRepo) EnableTOTP(ctx context.Context, id primitive.ObjectID, secret string, recoveryHashes []string) error {
+	_, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$set": bson.M{
+				"totp_enabled":         true,
+				"totp_secret":          secret,
+				"recovery_code_hashes": recoveryHashes,
+				"updated_at":           time.Now(),
+			},
+			"$unset": bson.M{"totp_pending_secret": ""},
+		},
+	)
+	return err
+}
+
+func (r *userRepo) DisableTOTP(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$set": bson.M{"updated_at": time.Now()},
+			"$unset": bson.M{
+				"totp_enabled":         "",
+				"totp_secret":          "",
+				"totp_pending_secret":  "",
+				"totp_last_step":       "",
+				"recovery_code_hashes": "",
+			},
+		},
+	)
+	return err
+}
+
+func (r *userRepo) SetRecoveryCodes(ctx context.Context, id primitive.ObjectID, hashes []string) error {
+	return r.set(ctx, id, bson.M{"recovery_code_hashes": hashes})
+}
+
+// UseRecoveryCode removes the code in the same update that matches it, so a
+// recovery code can only ever be redeemed once.
+func (r *userRepo) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) (bool, error) {
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"_id": id, "recovery_code_hashes": hash},
+		bson.M{"$pull": bson.M{"recovery_code_hashes": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *userRepo) SetConfirmCode(ctx context.Context, id primitive.ObjectID, action, codeHash string, expiresAt time.Time) error {
+	return r.set(ctx, id, bson.M{
+		"confirm_code_hash":  codeHash,
+		"confirm_action":     action,
+		"confirm_expires_at": expiresAt,
+	})
+}
+
+// UseConfirmCode clears the code in the same update that matches it, so it
+// confirms one action only.
+func (r *userRepo) UseConfirmCode(ctx context.Context, id primitive.ObjectID, action, codeHash string) (bool, error) {
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":                id,
+			"confirm_code_hash":  codeHash,
+			"confirm_action":     action,
+			"confirm_expires_at": bson.M{"$gt": time.Now()},
+		},
+		bson.M{"$unset": bson.M{
+			"confirm_code_hash":  "",
+			"confirm_action":     "",
+			"confirm_expires_at": "",
+		}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// AdvanceTOTPStep records the last accepted TOTP time step and fails if the
+// step was already used, which blocks replaying an observed code.
+func (r *userRepo) AdvanceTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{
+			"_id": id,
+			"$or": bson.A{
+				bson.M{"totp_last_step": bson.M{"$exists": false}},
+				bson.M{"totp_last_step": bson.M{"$lt": step}},
+			},
+		},
+		bson.M{"$set": bson.M{"totp_last_step": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *userRepo) FindByExternalIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+	var u models.User
+	err := r.col.FindOne(ctx, bson.M{
+		"external_identities": bson.M{"$elemMatch": bson.M{"issuer": issuer, "subject": subject}},
+	}).Decode(&u)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (r *userRepo) LinkExternalIdentity(ctx context.Context, id primitive.ObjectID, ident models.ExternalIdentity) error {
+	_, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{
+			"$push": bson.M{"external_identities": ident},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+func (r *userRepo) set(ctx context.Context, id primitive.ObjectID, fields bson.M) error {
+	fields["updated_at"] = time.Now()
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
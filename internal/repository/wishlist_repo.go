@@ -17,6 +17,7 @@ type WishlistRepo interface {
 	RemoveItem(ctx context.Context, userID primitive.ObjectID, productID primitive.ObjectID) error
 	HasItem(ctx context.Context, userID primitive.ObjectID, productID primitive.ObjectID) (bool, error)
 	Clear(ctx context.Context, userID primitive.ObjectID) error
+	DeleteByUserID(ctx context.Context, userID primitive.ObjectID) error
 }
 
 type wishlistRepo struct {
@@ -109,3 +110,8 @@ func (r *wishlistRepo) Clear(ctx context.Context, userID primitive.ObjectID) err
 	)
 	return err
 }
+
+func (r *wishlistRepo) DeleteByUserID(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.col.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
@@ -1,6 +1,7 @@
 package router
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net/http"
@@ -9,8 +10,11 @@ import (
 	"github.com/dannieey/Assignment3_Absolute/internal/db"
 	"github.com/dannieey/Assignment3_Absolute/internal/handler"
 	"github.com/dannieey/Assignment3_Absolute/internal/middleware"
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
 	"github.com/dannieey/Assignment3_Absolute/internal/repository"
 	"github.com/dannieey/Assignment3_Absolute/internal/service"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func New() (http.Handler, error) {
@@ -31,84 +35,675 @@ func New() (http.Handler, error) {
 	}
 	database := client.Database(dbName)
 
+	if err := repository.MigrateMoney(context.Background(), database, service.StoreCurrency()); err != nil {
+		return nil, err
+	}
+	if err := repository.MigrateStoreCredit(context.Background(), database); err != nil {
+		return nil, err
+	}
+
 	productRepo := repository.NewProductRepo(database)
 	orderRepo := repository.NewOrderRepo(database)
 	userRepo := repository.NewUserRepo(database)
+	leaseRepo := repository.NewLeaseRepo(database)
 	categoryRepo := repository.NewCategoryRepo(database)
 	brandRepo := repository.NewBrandRepo(database)
 	cartRepo := repository.NewCartRepo(database)
 	wishlistRepo := repository.NewWishlistRepo(database)
+	addressRepo, err := repository.NewAddressRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	pickupRepo, err := repository.NewPickupRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	deliveryZoneRepo, err := repository.NewDeliveryZoneRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	deliveryProofRepo, err := repository.NewDeliveryProofRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	paymentRepo, err := repository.NewPaymentRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	paymentProvider, err := service.PaymentProviderFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	refundRepo, err := repository.NewRefundRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	ledgerRepo, err := repository.NewLedgerRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	priceRepo, err := repository.NewPriceRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	markdownRepo, err := repository.NewMarkdownRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	giftCardRepo, err := repository.NewGiftCardRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	returnRepo, err := repository.NewReturnRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	taxRepo, err := repository.NewTaxRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	promotionRepo, err := repository.NewPromotionRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	couponRepo, err := repository.NewCouponRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	loyaltyRepo, err := repository.NewLoyaltyRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	exportRepo, err := repository.NewExportRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	loginThrottleRepo, err := repository.NewLoginThrottleRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	apiKeyRepo, err := repository.NewAPIKeyRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	signingKeyRepo, err := repository.NewSigningKeyRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	keyManager, err := service.NewKeyManager(context.Background(), signingKeyRepo, leaseRepo)
+	if err != nil {
+		return nil, err
+	}
+	oidcStateRepo, err := repository.NewOIDCStateRepo(database)
+	if err != nil {
+		return nil, err
+	}
+	oidcConfig, err := service.OIDCConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
 
-	productService := service.NewProductService(productRepo)
-	orderService := service.NewOrderService(orderRepo, productService)
-	authService := service.NewAuthService(userRepo)
-	cartService := service.NewCartService(cartRepo, productRepo)
+	productService := service.NewProductService(productRepo, priceRepo)
+	priceService := service.NewPriceService(priceRepo, productRepo)
+	markdownService := service.NewMarkdownService(markdownRepo, productRepo)
+	pickupService := service.NewPickupService(pickupRepo, orderRepo)
+	deliveryService := service.NewDeliveryService(deliveryZoneRepo, addressRepo)
+	paymentService := service.NewPaymentService(paymentProvider, paymentRepo, orderRepo)
+	taxService := service.NewTaxService(taxRepo, categoryRepo)
+	promotionService := service.NewPromotionService(promotionRepo)
+	couponService := service.NewCouponService(couponRepo, promotionRepo)
+	loyaltyService := service.NewLoyaltyService(loyaltyRepo, orderRepo, productRepo)
+	storedValueService := service.NewStoredValueService(giftCardRepo, ledgerRepo)
+	orderService := service.NewOrderService(orderRepo, productService, addressRepo, pickupService, deliveryService, paymentService, promotionService, couponService, taxService, loyaltyService, storedValueService)
+	paymentService.OnAuthorized(orderService.Enqueue)
+	storeCreditService := service.NewStoreCreditService(ledgerRepo)
+	refundService := service.NewRefundService(refundRepo, orderRepo, paymentService, storeCreditService, storedValueService, productService, loyaltyService)
+	returnService := service.NewReturnService(returnRepo, orderRepo, refundService, productService, leaseRepo)
+	courierService := service.NewCourierService(orderRepo, userRepo, deliveryProofRepo)
+	courierService.OnDelivered(loyaltyService.OrderCompleted)
+	loginGuard := service.NewLoginGuard(loginThrottleRepo)
+	twoFactorService := service.NewTwoFactorService(userRepo)
+	authService := service.NewAuthService(userRepo, loginGuard, twoFactorService, keyManager)
+	adminGuard := service.NewAdminGuard(userRepo, leaseRepo)
+	var oidcService *service.OIDCService
+	if oidcConfig != nil {
+		oidcService = service.NewOIDCService(oidcConfig, userRepo, oidcStateRepo, authService, adminGuard)
+	}
+	userService := service.NewUserService(userRepo, loginGuard, adminGuard)
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo)
+	exportService := service.NewExportService(userRepo, orderRepo, cartRepo, wishlistRepo, addressRepo, exportRepo)
+	profileService := service.NewProfileService(userRepo, orderRepo, cartRepo, wishlistRepo, addressRepo, exportRepo, loyaltyRepo, adminGuard, service.LogMailer{})
+	addressService := service.NewAddressService(addressRepo)
+	cartService := service.NewCartService(cartRepo, productRepo, promotionService, couponService, taxService)
 	wishlistService := service.NewWishlistService(wishlistRepo, productRepo)
 
 	ch := handler.NewCategoryHandler(categoryRepo, productRepo)
 	bh := handler.NewBrandHandler(brandRepo)
 
 	ph := handler.NewProductHandler(productService)
+	priceH := handler.NewPriceHandler(priceService)
+	markdownH := handler.NewMarkdownHandler(markdownService)
 	oh := handler.NewOrderHandler(orderService)
 	ah := handler.NewAuthHandler(authService)
 	cartH := handler.NewCartHandler(cartService)
 	wishlistH := handler.NewWishlistHandler(wishlistService)
-	profileH := handler.NewProfileHandler(userRepo, orderService)
+	profileH := handler.NewProfileHandler(userRepo, orderService, profileService)
+	adminUserH := handler.NewAdminUserHandler(userService)
+	exportH := handler.NewExportHandler(exportService)
+	addressH := handler.NewAddressHandler(addressService)
+	pickupH := handler.NewPickupHandler(pickupService)
+	deliveryH := handler.NewDeliveryHandler(deliveryService)
+	courierH := handler.NewCourierHandler(courierService)
+	paymentH := handler.NewPaymentHandler(paymentService)
+	refundH := handler.NewRefundHandler(refundService)
+	returnH := handler.NewReturnHandler(returnService)
+	storeCreditH := handler.NewStoreCreditHandler(storeCreditService)
+	loyaltyH := handler.NewLoyaltyHandler(loyaltyService)
+	giftCardH := handler.NewGiftCardHandler(storedValueService)
+	taxH := handler.NewTaxHandler(taxService)
+	promotionH := handler.NewPromotionHandler(promotionService)
+	couponH := handler.NewCouponHandler(couponService)
+	twoFactorH := handler.NewTwoFactorHandler(twoFactorService, authService)
+	apiKeyH := handler.NewAPIKeyHandler(apiKeyService)
+	jwksH := handler.NewJWKSHandler(keyManager)
+	oidcH := handler.NewOIDCHandler(oidcService)
+
+	middleware.SetTokenVerifier(&middleware.TokenVerifier{
+		Parse:        keyManager.Parse,
+		ParsePreAuth: keyManager.ParsePreAuth,
+	})
+	middleware.SetAccountLookup(func(ctx context.Context, userID primitive.ObjectID) (string, error) {
+		u, err := userRepo.FindByID(ctx, userID)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return "", middleware.ErrAccountNotFound
+		}
+		if err != nil {
+			return "", err
+		}
+		if u.Disabled {
+			return "", middleware.ErrAccountDisabled
+		}
+		return u.Role, nil
+	})
+	middleware.SetAPIKeyAuthenticator(func(ctx context.Context, raw string) (*middleware.APIKeyPrincipal, error) {
+		k, err := apiKeyService.Authenticate(ctx, raw)
+		if err != nil {
+			return nil, err
+		}
+		return &middleware.APIKeyPrincipal{ID: k.ID.Hex(), Name: k.Name, Scopes: k.Scopes}, nil
+	})
+
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("pong"))
+	})
+
+	mux.HandleFunc("/.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		jwksH.Get(w, r)
+	})
+
+	mux.HandleFunc("/auth/register", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ah.Register(w, r)
+	})
+
+	mux.HandleFunc("/auth/oidc/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		oidcH.Login(w, r)
+	})
+
+	mux.HandleFunc("/auth/oidc/callback", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		oidcH.Callback(w, r)
+	})
+
+	mux.HandleFunc("/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ah.Login(w, r)
+	})
+
+	mux.HandleFunc("/auth/2fa/verify", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ah.VerifyTwoFactor(w, r)
+	})
+
+	mux.Handle("/products", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ph.List(w, r)
+	}))
+
+	mux.Handle("/orders", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		oh.Create(w, r)
+	})))
+
+	mux.Handle("/delivery/quote", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		deliveryH.Quote(w, r)
+	})))
+
+	mux.Handle("/staff/delivery/zones", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			deliveryH.ListZones(w, r)
+		case http.MethodPost:
+			deliveryH.CreateZone(w, r)
+		case http.MethodPut:
+			deliveryH.UpdateZone(w, r)
+		case http.MethodDelete:
+			deliveryH.DeleteZone(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/staff/couriers", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		courierH.ListCouriers(w, r)
+	})))
+
+	mux.Handle("/staff/deliveries", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		courierH.ListDeliveries(w, r)
+	})))
+
+	mux.Handle("/staff/deliveries/assign", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		courierH.Assign(w, r)
+	})))
+
+	mux.Handle("/staff/deliveries/signature", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		courierH.Signature(w, r)
+	})))
+
+	mux.Handle("/courier/deliveries", CourierOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		courierH.MyDeliveries(w, r)
+	})))
+
+	mux.Handle("/courier/deliveries/picked-up", CourierOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		courierH.PickedUp(w, r)
+	})))
+
+	mux.Handle("/courier/deliveries/delivered", CourierOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		courierH.Delivered(w, r)
+	})))
+
+	mux.Handle("/courier/deliveries/failed", CourierOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		courierH.Failed(w, r)
+	})))
+
+	mux.HandleFunc("/pickup/slots", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pickupH.Slots(w, r)
+	})
+
+	mux.Handle("/staff/pickup/settings", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			pickupH.GetSettings(w, r)
+		case http.MethodPut:
+			pickupH.UpdateSettings(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/staff/pickup/orders", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pickupH.Orders(w, r)
+	})))
+
+	mux.Handle("/orders/history", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		oh.History(w, r)
+	})))
+
+	mux.Handle("/orders/tracking", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		oh.GetTracking(w, r)
+	})))
+
+	mux.Handle("/orders/cancel", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		oh.Cancel(w, r)
+	})))
+
+	mux.Handle("/orders/pay", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		paymentH.Pay(w, r)
+	})))
+
+	mux.HandleFunc("/payments/webhook", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		paymentH.Webhook(w, r)
+	})
+
+	mux.Handle("/staff/payments", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		paymentH.List(w, r)
+	})))
+
+	mux.Handle("/staff/payments/capture", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		paymentH.Capture(w, r)
+	})))
+
+	mux.Handle("/staff/payments/void", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		paymentH.Void(w, r)
+	})))
+
+	mux.Handle("/staff/orders/cancel", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		oh.StaffCancel(w, r)
+	})))
+
+	mux.Handle("/staff/orders/refund", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		refundH.Create(w, r)
+	})))
+
+	mux.Handle("/staff/orders/refunds", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		refundH.List(w, r)
+	})))
+
+	mux.Handle("/orders/returns", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			returnH.Mine(w, r)
+		case http.MethodPost:
+			returnH.Open(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/orders/returns/cancel", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		returnH.Cancel(w, r)
+	})))
+
+	mux.Handle("/profile/store-credit", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		storeCreditH.Statement(w, r)
+	})))
+
+	mux.Handle("/profile/loyalty", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		loyaltyH.Statement(w, r)
+	})))
+
+	mux.Handle("/staff/returns", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		returnH.List(w, r)
+	})))
+
+	mux.Handle("/staff/returns/approve", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		returnH.Approve(w, r)
+	})))
+
+	mux.Handle("/staff/returns/reject", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		returnH.Reject(w, r)
+	})))
+
+	mux.Handle("/staff/returns/receive", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		returnH.Receive(w, r)
+	})))
+
+	mux.Handle("/staff/returns/resolve", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		returnH.Resolve(w, r)
+	})))
+
+	mux.Handle("/staff/tax/classes", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			taxH.ListClasses(w, r)
+		case http.MethodPost:
+			taxH.CreateClass(w, r)
+		case http.MethodPut:
+			taxH.UpdateClass(w, r)
+		case http.MethodDelete:
+			taxH.DeleteClass(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/staff/tax/settings", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			taxH.GetSettings(w, r)
+		case http.MethodPut:
+			taxH.UpdateSettings(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
 
-	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("pong"))
-	})
+	mux.Handle("/staff/loyalty/settings", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			loyaltyH.GetSettings(w, r)
+		case http.MethodPut:
+			loyaltyH.UpdateSettings(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
 
-	mux.HandleFunc("/auth/register", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/giftcards/balance", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		ah.Register(w, r)
+		giftCardH.Balance(w, r)
 	})
 
-	mux.HandleFunc("/auth/login", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
+	mux.Handle("/staff/giftcards", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			giftCardH.List(w, r)
+		case http.MethodPost:
+			giftCardH.Issue(w, r)
+		default:
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
 		}
-		ah.Login(w, r)
-	})
+	})))
 
-	mux.Handle("/products", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
+	mux.Handle("/staff/giftcards/disable", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		ph.List(w, r)
-	}))
+		giftCardH.Disable(w, r)
+	})))
 
-	mux.Handle("/orders", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/staff/giftcards/enable", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		oh.Create(w, r)
+		giftCardH.Enable(w, r)
 	})))
 
-	mux.Handle("/orders/history", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/staff/giftcards/ledger", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		oh.History(w, r)
+		giftCardH.Ledger(w, r)
 	})))
 
-	mux.Handle("/orders/tracking", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/promotions", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		oh.GetTracking(w, r)
+		promotionH.Running(w, r)
+	})
+
+	mux.Handle("/staff/promotions", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			promotionH.List(w, r)
+		case http.MethodPost:
+			promotionH.Create(w, r)
+		case http.MethodPut:
+			promotionH.Update(w, r)
+		case http.MethodDelete:
+			promotionH.Delete(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/staff/coupons", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			couponH.List(w, r)
+		case http.MethodPost:
+			couponH.Create(w, r)
+		case http.MethodDelete:
+			couponH.Delete(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/staff/coupons/generate", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		couponH.Generate(w, r)
 	})))
 
-	mux.Handle("/staff/products", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/staff/products", StaffOrKey(models.ScopeProductsWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -116,7 +711,7 @@ func New() (http.Handler, error) {
 		ph.Create(w, r)
 	})))
 
-	mux.Handle("/staff/products/update", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/staff/products/update", StaffOrKey(models.ScopeProductsWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPatch {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -124,7 +719,7 @@ func New() (http.Handler, error) {
 		ph.Update(w, r)
 	})))
 
-	mux.Handle("/staff/products/delete", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/staff/products/delete", StaffOrKey(models.ScopeProductsWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -132,7 +727,52 @@ func New() (http.Handler, error) {
 		ph.Delete(w, r)
 	})))
 
-	mux.Handle("/staff/ping", StaffOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/staff/products/price-history", StaffOrKey(models.ScopeProductsRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		priceH.StaffHistory(w, r)
+	})))
+
+	mux.Handle("/staff/products/price-schedules", StaffOrKey(models.ScopeProductsWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			priceH.Schedules(w, r)
+		case http.MethodPost:
+			priceH.Schedule(w, r)
+		case http.MethodDelete:
+			priceH.CancelSchedule(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/staff/products/batches", StaffOrKey(models.ScopeProductsWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			markdownH.Batches(w, r)
+		case http.MethodPost:
+			markdownH.Receive(w, r)
+		case http.MethodDelete:
+			markdownH.Clear(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/staff/markdowns/settings", StaffOrKey(models.ScopeProductsWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			markdownH.GetSettings(w, r)
+		case http.MethodPut:
+			markdownH.UpdateSettings(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/staff/ping", StaffOrKey(models.ScopeProductsRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("staff ok"))
 	})))
@@ -153,43 +793,31 @@ func New() (http.Handler, error) {
 		bh.List(w, r)
 	})
 
-	mux.Handle("/staff/categories",
-		middleware.RequireAuth(
-			middleware.RequireRole("staff",
-				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					switch r.Method {
-					case http.MethodPost:
-						ch.Create(w, r)
-					case http.MethodPatch:
-						ch.Update(w, r)
-					case http.MethodDelete:
-						ch.Delete(w, r)
-					default:
-						http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-					}
-				}),
-			),
-		),
-	)
-
-	mux.Handle("/staff/brands",
-		middleware.RequireAuth(
-			middleware.RequireRole("staff",
-				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					switch r.Method {
-					case http.MethodPost:
-						bh.Create(w, r)
-					case http.MethodPatch:
-						bh.Update(w, r)
-					case http.MethodDelete:
-						bh.Delete(w, r)
-					default:
-						http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-					}
-				}),
-			),
-		),
-	)
+	mux.Handle("/staff/categories", StaffOrKey(models.ScopeCatalogWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			ch.Create(w, r)
+		case http.MethodPatch:
+			ch.Update(w, r)
+		case http.MethodDelete:
+			ch.Delete(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/staff/brands", StaffOrKey(models.ScopeCatalogWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			bh.Create(w, r)
+		case http.MethodPatch:
+			bh.Update(w, r)
+		case http.MethodDelete:
+			bh.Delete(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
 
 	mux.HandleFunc("/products/barcode", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -199,6 +827,14 @@ func New() (http.Handler, error) {
 		ph.FindByBarcode(w, r)
 	})
 
+	mux.HandleFunc("/products/{id}/price-history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		priceH.History(w, r)
+	})
+
 	mux.HandleFunc("/products/search", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -208,13 +844,121 @@ func New() (http.Handler, error) {
 	})
 
 	mux.Handle("/profile", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			profileH.Get(w, r)
+		case http.MethodPatch:
+			profileH.Update(w, r)
+		case http.MethodDelete:
+			profileH.Delete(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/profile/password", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		profileH.ChangePassword(w, r)
+	})))
+
+	mux.Handle("/profile/confirm", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		profileH.RequestConfirmCode(w, r)
+	})))
+
+	mux.Handle("/profile/2fa/setup", middleware.RequireEnrollment(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		twoFactorH.Setup(w, r)
+	})))
+
+	mux.Handle("/profile/2fa/enable", middleware.RequireEnrollment(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		twoFactorH.Enable(w, r)
+	})))
+
+	mux.Handle("/profile/2fa/disable", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		twoFactorH.Disable(w, r)
+	})))
+
+	mux.Handle("/profile/2fa/recovery-codes", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		twoFactorH.RegenerateRecoveryCodes(w, r)
+	})))
+
+	mux.Handle("/profile/addresses", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			addressH.List(w, r)
+		case http.MethodPost:
+			addressH.Create(w, r)
+		case http.MethodPatch:
+			addressH.Update(w, r)
+		case http.MethodDelete:
+			addressH.Delete(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/profile/addresses/default", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		addressH.SetDefault(w, r)
+	})))
+
+	mux.Handle("/profile/export", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		exportH.Export(w, r)
+	})))
+
+	mux.Handle("/profile/export/status", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		exportH.Status(w, r)
+	})))
+
+	mux.Handle("/profile/export/download", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		profileH.Get(w, r)
+		exportH.Download(w, r)
 	})))
 
+	mux.HandleFunc("/auth/verify-email", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		profileH.VerifyEmail(w, r)
+	})
+
 	mux.Handle("/cart", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
@@ -230,6 +974,14 @@ func New() (http.Handler, error) {
 		}
 	})))
 
+	mux.Handle("/cart/coupon", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		cartH.Coupon(w, r)
+	})))
+
 	mux.Handle("/wishlist", AuthOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
@@ -251,6 +1003,78 @@ func New() (http.Handler, error) {
 		wishlistH.Check(w, r)
 	})))
 
+	mux.Handle("/admin/users", AdminOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			adminUserH.List(w, r)
+		case http.MethodDelete:
+			adminUserH.Delete(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
+	mux.Handle("/admin/users/role", AdminOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		adminUserH.ChangeRole(w, r)
+	})))
+
+	mux.Handle("/admin/users/disable", AdminOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		adminUserH.Disable(w, r)
+	})))
+
+	mux.Handle("/admin/users/enable", AdminOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		adminUserH.Enable(w, r)
+	})))
+
+	mux.Handle("/admin/users/unlock", AdminOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		adminUserH.Unlock(w, r)
+	})))
+
+	mux.Handle("/admin/users/reset-2fa", AdminOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		adminUserH.ResetTwoFactor(w, r)
+	})))
+
+	mux.Handle("/admin/users/reset-password", AdminOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		adminUserH.ResetPassword(w, r)
+	})))
+
+	mux.Handle("/admin/api-keys", AdminOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			apiKeyH.List(w, r)
+		case http.MethodPost:
+			apiKeyH.Create(w, r)
+		case http.MethodDelete:
+			apiKeyH.Revoke(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})))
+
 	log.Println("Router initialized")
 	return middleware.CORS(mux), nil
 }
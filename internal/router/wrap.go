@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/dannieey/Assignment3_Absolute/internal/middleware"
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
 )
 
 func AuthOnly(h http.Handler) http.Handler {
@@ -15,3 +16,21 @@ func StaffOnly(h http.Handler) http.Handler {
 		middleware.RequireRole("staff", h),
 	)
 }
+
+func AdminOnly(h http.Handler) http.Handler {
+	return middleware.RequireAuth(
+		middleware.RequireRole("admin", h),
+	)
+}
+
+// CourierOnly admits couriers (and admins, as RequireRole always does).
+func CourierOnly(h http.Handler) http.Handler {
+	return middleware.RequireAuth(
+		middleware.RequireRole(models.RoleCourier, h),
+	)
+}
+
+// StaffOrKey is StaffOnly that also admits API keys holding scope.
+func StaffOrKey(scope string, h http.Handler) http.Handler {
+	return middleware.RequireStaffOrScope(scope, h)
+}
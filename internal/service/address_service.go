@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxAddressesPerUser = 20
+	maxAddressFieldLen  = 200
+	maxAddressNotesLen  = 500
+	defaultAddressLabel = "Home"
+)
+
+var (
+	ErrAddressNotFound     = errors.New("address not found")
+	ErrInvalidAddress      = errors.New("invalid address")
+	ErrTooManyAddresses    = errors.New("address book is full")
+	ErrAddressFieldMissing = errors.New("street, city, postalCode and phone are required")
+)
+
+var (
+	postalCodePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 -]{1,8}[A-Za-z0-9]$`)
+	phonePattern      = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{5,18}[0-9]$`)
+)
+
+// AddressInput is a full address as entered by the customer.
+type AddressInput struct {
+	Label      string
+	Street     string
+	City       string
+	PostalCode string
+	Phone      string
+	Notes      string
+	// Latitude and Longitude are optional but must be given together.
+	Latitude  *float64
+	Longitude *float64
+	IsDefault bool
+}
+
+// AddressService manages a customer's address book.
+type AddressService struct {
+	addresses repository.AddressRepo
+}
+
+func NewAddressService(addresses repository.AddressRepo) *AddressService {
+	return &AddressService{addresses: addresses}
+}
+
+func (s *AddressService) List(ctx context.Context, userID primitive.ObjectID) ([]models.Address, error) {
+	return s.addresses.ListByUserID(ctx, userID)
+}
+
+func (s *AddressService) Get(ctx context.Context, userID, id primitive.ObjectID) (*models.Address, error) {
+	a, err := s.addresses.FindByID(ctx, userID, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrAddressNotFound
+	}
+	return a, err
+}
+
+// Create adds an address. The first address always becomes the default.
+func (s *AddressService) Create(ctx context.Context, userID primitive.ObjectID, in AddressInput) (*models.Address, error) {
+	in, err := normalizeAddress(in)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := s.addresses.CountByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if n >= maxAddressesPerUser {
+		return nil, ErrTooManyAddresses
+	}
+
+	a := &models.Address{
+		UserID:     userID,
+		Label:      in.Label,
+		Street:     in.Street,
+		City:       in.City,
+		PostalCode: in.PostalCode,
+		Phone:      in.Phone,
+		Notes:      in.Notes,
+		Location:   in.location(),
+	}
+	id, err := s.addresses.Create(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	a.ID = id
+
+	if in.IsDefault || n == 0 {
+		if err := s.addresses.SetDefault(ctx, userID, id); err != nil {
+			return nil, err
+		}
+		a.IsDefault = true
+	}
+	return a, nil
+}
+
+// Update replaces the address fields. Passing IsDefault makes it the default;
+// clearing it is done by choosing another default instead.
+func (s *AddressService) Update(ctx context.Context, userID, id primitive.ObjectID, in AddressInput) (*models.Address, error) {
+	in, err := normalizeAddress(in)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	a.Label = in.Label
+	a.Street = in.Street
+	a.City = in.City
+	a.PostalCode = in.PostalCode
+	a.Phone = in.Phone
+	a.Notes = in.Notes
+	a.Location = in.location()
+	if err := s.addresses.Update(ctx, a); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrAddressNotFound
+		}
+		return nil, err
+	}
+
+	if in.IsDefault && !a.IsDefault {
+		if err := s.SetDefault(ctx, userID, id); err != nil {
+			return nil, err
+		}
+		a.IsDefault = true
+	}
+	return a, nil
+}
+
+func (s *AddressService) SetDefault(ctx context.Context, userID, id primitive.ObjectID) error {
+	err := s.addresses.SetDefault(ctx, userID, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrAddressNotFound
+	}
+	return err
+}
+
+// Delete removes an address. If it was the default, the oldest remaining
+// address takes over. Orders keep their own snapshot and are not affected.
+func (s *AddressService) Delete(ctx context.Context, userID, id primitive.ObjectID) error {
+	a, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if err := s.addresses.Delete(ctx, userID, id); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrAddressNotFound
+		}
+		return err
+	}
+	if !a.IsDefault {
+		return nil
+	}
+
+	rest, err := s.addresses.ListByUserID(ctx, userID)
+	if err != nil || len(rest) == 0 {
+		return err
+	}
+	return s.addresses.SetDefault(ctx, userID, rest[0].ID)
+}
+
+func normalizeAddress(in AddressInput) (AddressInput, error) {
+	in.Label = strings.TrimSpace(in.Label)
+	in.Street = strings.TrimSpace(in.Street)
+	in.City = strings.TrimSpace(in.City)
+	in.PostalCode = strings.ToUpper(strings.TrimSpace(in.PostalCode))
+	in.Phone = strings.TrimSpace(in.Phone)
+	in.Notes = strings.TrimSpace(in.Notes)
+
+	if in.Label == "" {
+		in.Label = defaultAddressLabel
+	}
+	if in.Street == "" || in.City == "" || in.PostalCode == "" || in.Phone == "" {
+		return in, ErrAddressFieldMissing
+	}
+	for name, v := range map[string]string{"label": in.Label, "street": in.Street, "city": in.City} {
+		if utf8.RuneCountInString(v) > maxAddressFieldLen {
+			return in, fmt.Errorf("%w: %s is too long", ErrInvalidAddress, name)
+		}
+	}
+	if utf8.RuneCountInString(in.Notes) > maxAddressNotesLen {
+		return in, fmt.Errorf("%w: notes is too long", ErrInvalidAddress)
+	}
+	if !postalCodePattern.MatchString(in.PostalCode) {
+		return in, fmt.Errorf("%w: postalCode is not valid", ErrInvalidAddress)
+	}
+	if !phonePattern.MatchString(in.Phone) {
+		return in, fmt.Errorf("%w: phone is not valid", ErrInvalidAddress)
+	}
+	if (in.Latitude == nil) != (in.Longitude == nil) {
+		return in, fmt.Errorf("%w: latitude and longitude must be given together", ErrInvalidAddress)
+	}
+	if in.Latitude != nil && (*in.Latitude < -90 || *in.Latitude > 90 || *in.Longitude < -180 || *in.Longitude > 180) {
+		return in, fmt.Errorf("%w: coordinates out of range", ErrInvalidAddress)
+	}
+	return in, nil
+}
+
+func (in AddressInput) location() *models.GeoPoint {
+	if in.Latitude == nil || in.Longitude == nil {
+		return nil
+	}
+	return models.NewGeoPoint(*in.Latitude, *in.Longitude)
+}
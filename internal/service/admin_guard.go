@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	adminGuardLease    = "admin-guard"
+	adminGuardTTL      = 30 * time.Second
+	adminGuardAttempts = 20
+	adminGuardRetry    = 100 * time.Millisecond
+)
+
+var ErrAdminChangeBusy = errors.New("another admin change is in progress, try again")
+
+// AdminGuard keeps at least one active admin. Changes that could take admin
+// access away (demotion, disabling, deletion) run one at a time under a
+// lease, so two of them can't each count the other admin and both go ahead.
+// A transaction would do the same but needs a replica set.
+type AdminGuard struct {
+	users  repository.UserRepo
+	leases repository.LeaseRepo
+}
+
+func NewAdminGuard(users repository.UserRepo, leases repository.LeaseRepo) *AdminGuard {
+	return &AdminGuard{users: users, leases: leases}
+}
+
+// Revoke runs change, which may take admin access away from user id, unless
+// id is the last active admin. change is called with the user as it is
+// under the lease.
+func (g *AdminGuard) Revoke(ctx context.Context, id primitive.ObjectID, change func(u *models.User) error) error {
+	holder := primitive.NewObjectID().Hex()
+	if err := g.acquire(ctx, holder); err != nil {
+		return err
+	}
+	defer func() {
+		if err := g.leases.Release(context.Background(), adminGuardLease, holder); err != nil {
+			log.Printf("[users] release admin guard: %v", err)
+		}
+	}()
+
+	u, err := g.users.FindByID(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if u.Role == models.RoleAdmin && !u.Disabled {
+		n, err := g.users.CountActiveByRole(ctx, models.RoleAdmin)
+		if err != nil {
+			return err
+		}
+		if n <= 1 {
+			return ErrLastAdmin
+		}
+	}
+	return change(u)
+}
+
+func (g *AdminGuard) acquire(ctx context.Context, holder string) error {
+	for i := 0; i < adminGuardAttempts; i++ {
+		ok, err := g.leases.Acquire(ctx, adminGuardLease, holder, adminGuardTTL)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(adminGuardRetry):
+		}
+	}
+	return ErrAdminChangeBusy
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Keys look like "smk_<8 hex prefix>_<secret>". The prefix is stored in clear
+// so the key can be found and recognised; only a hash of the whole key is kept.
+const apiKeyTag = "smk"
+
+var (
+	ErrAPIKeyNameRequired = errors.New("name is required")
+	ErrAPIKeyScopes       = errors.New("at least one valid scope is required")
+	ErrAPIKeyNotFound     = errors.New("api key not found")
+	ErrInvalidAPIKey      = errors.New("invalid api key")
+)
+
+type APIKeyService struct {
+	repo repository.APIKeyRepo
+}
+
+func NewAPIKeyService(repo repository.APIKeyRepo) *APIKeyService {
+	return &APIKeyService{repo: repo}
+}
+
+// Create stores a new key and returns it together with the plain key, which
+// is never retrievable again.
+func (s *APIKeyService) Create(ctx context.Context, name string, scopes []string, ttl time.Duration, createdBy primitive.ObjectID) (*models.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", ErrAPIKeyNameRequired
+	}
+	if len(scopes) == 0 {
+		return nil, "", ErrAPIKeyScopes
+	}
+	for _, sc := range scopes {
+		if !models.IsValidScope(sc) {
+			return nil, "", ErrAPIKeyScopes
+		}
+	}
+
+	prefixBytes := make([]byte, 4)
+	secretBytes := make([]byte, 24)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return nil, "", err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return nil, "", err
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+	raw := apiKeyTag + "_" + prefix + "_" + hex.EncodeToString(secretBytes)
+
+	k := &models.APIKey{
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hashAPIKey(raw),
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		exp := time.Now().Add(ttl)
+		k.ExpiresAt = &exp
+	}
+
+	id, err := s.repo.Create(ctx, k)
+	if err != nil {
+		return nil, "", err
+	}
+	k.ID = id
+	return k, raw, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context) ([]models.APIKey, error) {
+	return s.repo.List(ctx)
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, id primitive.ObjectID) error {
+	err := s.repo.Revoke(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrAPIKeyNotFound
+	}
+	return err
+}
+
+// Authenticate resolves a presented key. Revoked, expired and unknown keys all
+// return ErrInvalidAPIKey.
+func (s *APIKeyService) Authenticate(ctx context.Context, raw string) (*models.APIKey, error) {
+	parts := strings.Split(raw, "_")
+	if len(parts) != 3 || parts[0] != apiKeyTag {
+		return nil, ErrInvalidAPIKey
+	}
+
+	k, err := s.repo.FindByPrefix(ctx, parts[1])
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(hashAPIKey(raw))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if k.RevokedAt != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	_ = s.repo.TouchLastUsed(ctx, k.ID)
+	return k, nil
+}
+
+func hashAPIKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
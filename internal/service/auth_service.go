@@ -3,7 +3,6 @@ package service
 import (
 	"context"
 	"errors"
-	"os"
 	"time"
 
 	"github.com/dannieey/Assignment3_Absolute/internal/models"
@@ -17,19 +16,41 @@ import (
 var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrEmailAlreadyUsed   = errors.New("email already used")
+	ErrAccountDisabled    = errors.New("account disabled")
+	ErrInvalidPreAuth     = errors.New("invalid or expired pre-auth token")
+)
+
+const (
+	TwoFactorStepVerify = "verify"
+	TwoFactorStepEnroll = "enroll"
+
+	preAuthTTL = 5 * time.Minute
 )
 
 type AuthService struct {
-	users repository.UserRepo
+	users     repository.UserRepo
+	guard     *LoginGuard
+	twoFactor *TwoFactorService
+	keys      *KeyManager
 }
 
-func NewAuthService(users repository.UserRepo) *AuthService {
-	return &AuthService{users: users}
+func NewAuthService(users repository.UserRepo, guard *LoginGuard, twoFactor *TwoFactorService, keys *KeyManager) *AuthService {
+	return &AuthService{users: users, guard: guard, twoFactor: twoFactor, keys: keys}
+}
+
+// LoginResult carries either a full access token or, when a second factor is
+// still needed, a short-lived pre-auth token and the step to complete.
+type LoginResult struct {
+	Token         string
+	UserID        primitive.ObjectID
+	Role          string
+	PreAuthToken  string
+	TwoFactorStep string
 }
 
 func (s *AuthService) Register(ctx context.Context, fullName, email, password, role string) (primitive.ObjectID, error) {
 	if role == "" {
-		role = "customer"
+		role = models.RoleCustomer
 	}
 
 	_, err := s.users.FindByEmail(ctx, email)
@@ -56,37 +77,167 @@ func (s *AuthService) Register(ctx context.Context, fullName, email, password, r
 	return s.users.Create(ctx, u)
 }
 
-func (s *AuthService) Login(ctx context.Context, email, password string) (string, primitive.ObjectID, string, error) {
+// Login checks the credentials. Failed attempts are counted per account and
+// per client IP; while either is backing off a *LockedError is returned
+// without looking at the password. Accounts with 2FA (or whose role requires
+// it) get a pre-auth token instead of an access token.
+func (s *AuthService) Login(ctx context.Context, email, password, ip string) (*LoginResult, error) {
+	if err := s.guard.Check(ctx, email, ip); err != nil {
+		return nil, err
+	}
+
 	u, err := s.users.FindByEmail(ctx, email)
+	if err == nil {
+		err = bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password))
+	}
+	if err != nil {
+		return nil, s.failed(ctx, email, ip, ErrInvalidCredentials)
+	}
+	if u.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	// The failure counter is only reset once the second factor is done too,
+	// otherwise re-entering the password would reset guesses at the code.
+	if step := twoFactorStep(u); step != "" {
+		return s.preAuth(u, step)
+	}
+
+	if err := s.guard.Success(ctx, email); err != nil {
+		return nil, err
+	}
+	return s.issue(u)
+}
+
+// signIn finishes a login that has already proven the user's identity some
+// other way, such as OIDC. Users with 2FA still get a pre-auth token.
+func (s *AuthService) signIn(u *models.User) (*LoginResult, error) {
+	if step := twoFactorStep(u); step != "" {
+		return s.preAuth(u, step)
+	}
+	return s.issue(u)
+}
+
+// twoFactorStep is the second-factor step u has to complete, if any.
+func twoFactorStep(u *models.User) string {
+	if u.TOTPEnabled {
+		return TwoFactorStepVerify
+	}
+	if TwoFactorRequired(u.Role) {
+		return TwoFactorStepEnroll
+	}
+	return ""
+}
+
+func (s *AuthService) preAuth(u *models.User, step string) (*LoginResult, error) {
+	pre, err := s.signPreAuthJWT(u.ID, step)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{UserID: u.ID, Role: u.Role, PreAuthToken: pre, TwoFactorStep: step}, nil
+}
+
+// CompleteTwoFactor exchanges a pre-auth token and a TOTP or recovery code
+// for an access token. Wrong codes count towards the login lockout.
+func (s *AuthService) CompleteTwoFactor(ctx context.Context, preAuthToken, code, recoveryCode, ip string) (*LoginResult, error) {
+	userID, step, err := s.parsePreAuth(preAuthToken)
+	if err != nil || step != TwoFactorStepVerify {
+		return nil, ErrInvalidPreAuth
+	}
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, ErrInvalidPreAuth
+	}
+	if u.Disabled {
+		return nil, ErrAccountDisabled
+	}
+	if err := s.guard.Check(ctx, u.Email, ip); err != nil {
+		return nil, err
+	}
+
+	if err := s.twoFactor.Verify(ctx, u.ID, code, recoveryCode); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			return nil, s.failed(ctx, u.Email, ip, err)
+		}
+		return nil, err
+	}
+	if err := s.guard.Success(ctx, u.Email); err != nil {
+		return nil, err
+	}
+	return s.issue(u)
+}
+
+// CompleteEnrollment issues an access token once a user who logged in with
+// an "enroll" pre-auth token has turned 2FA on.
+func (s *AuthService) CompleteEnrollment(ctx context.Context, userID primitive.ObjectID) (*LoginResult, error) {
+	u, err := s.users.FindByID(ctx, userID)
 	if err != nil {
-		return "", primitive.NilObjectID, "", ErrInvalidCredentials
+		return nil, err
 	}
+	if !u.TOTPEnabled {
+		return nil, ErrTwoFactorNotSetUp
+	}
+	return s.issue(u)
+}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)); err != nil {
-		return "", primitive.NilObjectID, "", ErrInvalidCredentials
+func (s *AuthService) failed(ctx context.Context, email, ip string, cause error) error {
+	wait, err := s.guard.Failure(ctx, email, ip)
+	if err != nil {
+		return err
 	}
+	if wait > 0 {
+		return &LockedError{RetryAfter: wait}
+	}
+	return cause
+}
 
+func (s *AuthService) issue(u *models.User) (*LoginResult, error) {
 	token, err := s.signJWT(u.ID, u.Role)
 	if err != nil {
-		return "", primitive.NilObjectID, "", err
+		return nil, err
 	}
+	return &LoginResult{Token: token, UserID: u.ID, Role: u.Role}, nil
+}
 
-	return token, u.ID, u.Role, nil
+func (s *AuthService) signPreAuthJWT(userID primitive.ObjectID, step string) (string, error) {
+	claims := jwt.MapClaims{
+		"sub":  userID.Hex(),
+		"iss":  s.keys.Issuer(),
+		"aud":  s.keys.PreAuthAudience(),
+		"typ":  "preauth",
+		"step": step,
+		"iat":  time.Now().Unix(),
+		"exp":  time.Now().Add(preAuthTTL).Unix(),
+	}
+	return s.keys.Sign(claims)
 }
 
-func (s *AuthService) signJWT(userID primitive.ObjectID, role string) (string, error) {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "dev_secret_change_me"
+func (s *AuthService) parsePreAuth(tokenStr string) (primitive.ObjectID, string, error) {
+	tok, err := s.keys.ParsePreAuth(tokenStr)
+	if err != nil || !tok.Valid {
+		return primitive.NilObjectID, "", ErrInvalidPreAuth
+	}
+	claims, _ := tok.Claims.(jwt.MapClaims)
+	if typ, _ := claims["typ"].(string); typ != "preauth" {
+		return primitive.NilObjectID, "", ErrInvalidPreAuth
+	}
+	sub, _ := claims["sub"].(string)
+	step, _ := claims["step"].(string)
+	id, err := primitive.ObjectIDFromHex(sub)
+	if err != nil {
+		return primitive.NilObjectID, "", ErrInvalidPreAuth
 	}
+	return id, step, nil
+}
 
+func (s *AuthService) signJWT(userID primitive.ObjectID, role string) (string, error) {
 	claims := jwt.MapClaims{
 		"sub":  userID.Hex(),
+		"iss":  s.keys.Issuer(),
+		"aud":  s.keys.Audience(),
 		"role": role,
 		"iat":  time.Now().Unix(),
-		"exp":  time.Now().Add(7 * 24 * time.Hour).Unix(),
+		"exp":  time.Now().Add(accessTokenTTL).Unix(),
 	}
-
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return t.SignedString([]byte(secret))
+	return s.keys.Sign(claims)
 }
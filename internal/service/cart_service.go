@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/dannieey/Assignment3_Absolute/internal/models"
 	"github.com/dannieey/Assignment3_Absolute/internal/repository"
@@ -11,12 +13,18 @@ import (
 type CartService struct {
 	cartRepo    repository.CartRepo
 	productRepo repository.ProductRepo
+	promotions  *PromotionService
+	coupons     *CouponService
+	taxes       *TaxService
 }
 
-func NewCartService(cartRepo repository.CartRepo, productRepo repository.ProductRepo) *CartService {
+func NewCartService(cartRepo repository.CartRepo, productRepo repository.ProductRepo, promotions *PromotionService, coupons *CouponService, taxes *TaxService) *CartService {
 	return &CartService{
 		cartRepo:    cartRepo,
 		productRepo: productRepo,
+		promotions:  promotions,
+		coupons:     coupons,
+		taxes:       taxes,
 	}
 }
 
@@ -27,8 +35,9 @@ func (s *CartService) GetCart(ctx context.Context, userID primitive.ObjectID) (*
 	}
 
 	var items []models.CartItemWithProduct
-	var totalPrice float64
+	var totalPrice models.Money
 	var totalItems int
+	var priced []PricedLine
 
 	for _, item := range cart.Items {
 		product, err := s.productRepo.FindByID(ctx, item.ProductID)
@@ -36,14 +45,15 @@ func (s *CartService) GetCart(ctx context.Context, userID primitive.ObjectID) (*
 			continue // Пропускаем товары, которых больше нет
 		}
 
-		subtotal := product.Price * float64(item.Quantity)
+		subtotal := product.SellingPrice().Mul(item.Quantity)
 		totalPrice += subtotal
 		totalItems += item.Quantity
+		priced = append(priced, PricedLine{Product: product, Quantity: item.Quantity})
 
 		items = append(items, models.CartItemWithProduct{
 			ProductID: item.ProductID,
 			Name:      product.Name,
-			Price:     product.Price,
+			Price:     product.SellingPrice(),
 			ImageURL:  product.ImageURL,
 			Quantity:  item.Quantity,
 			Subtotal:  subtotal,
@@ -52,13 +62,79 @@ func (s *CartService) GetCart(ctx context.Context, userID primitive.ObjectID) (*
 		})
 	}
 
+	now := time.Now()
+	// A coupon that no longer applies stays on the cart, with the reason,
+	// until the customer removes it.
+	var offer *CouponOffer
+	var couponError string
+	if cart.CouponCode != "" {
+		offer, err = s.coupons.Check(ctx, userID, cart.CouponCode, totalPrice, now)
+		if errors.Is(err, ErrCouponNotApplicable) {
+			couponError = err.Error()
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	discounts, err := s.promotions.Apply(ctx, priced, now, offer)
+	if err != nil {
+		return nil, err
+	}
+	taxable := make([]TaxableLine, len(priced))
+	for i, l := range priced {
+		items[i].Discount = discounts.Lines[i]
+		taxable[i] = TaxableLine{Product: l.Product, Amount: items[i].Subtotal - discounts.Lines[i]}
+	}
+	totalPrice -= discounts.Total
+
+	tax, err := s.taxes.Compute(ctx, taxable, now)
+	if err != nil {
+		return nil, err
+	}
+	if tax.Mode == models.TaxExclusive {
+		totalPrice += tax.Total
+	}
+
 	return &models.CartResponse{
-		Items:      items,
-		TotalItems: totalItems,
-		TotalPrice: totalPrice,
+		Items:         items,
+		TotalItems:    totalItems,
+		TotalPrice:    totalPrice,
+		Discounts:     discounts.Applied,
+		DiscountTotal: discounts.Total,
+		CouponCode:    cart.CouponCode,
+		CouponError:   couponError,
+		Tax:           tax.Total,
+		TaxMode:       tax.Mode,
+		Currency:      StoreCurrency(),
 	}, nil
 }
 
+// ApplyCoupon puts a coupon on the cart after checking it against the
+// current basket; an empty code removes it. Usage is only counted when an
+// order is placed.
+func (s *CartService) ApplyCoupon(ctx context.Context, userID primitive.ObjectID, code string) error {
+	code = normalizeCouponCode(code)
+	if code == "" {
+		return s.cartRepo.SetCoupon(ctx, userID, "")
+	}
+
+	cart, err := s.cartRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	var basket models.Money
+	for _, item := range cart.Items {
+		product, err := s.productRepo.FindByID(ctx, item.ProductID)
+		if err != nil {
+			continue
+		}
+		basket += product.SellingPrice().Mul(item.Quantity)
+	}
+	if _, err := s.coupons.Check(ctx, userID, code, basket, time.Now()); err != nil {
+		return err
+	}
+	return s.cartRepo.SetCoupon(ctx, userID, code)
+}
+
 func (s *CartService) AddItem(ctx context.Context, userID primitive.ObjectID, productID primitive.ObjectID, quantity int) error {
 	_, err := s.productRepo.FindByID(ctx, productID)
 	if err != nil {
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	couponCodeLength   = 8
+	maxCouponBatchSize = 10000
+	// Letters and digits that can't be mistaken for one another in print.
+	couponAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+var (
+	ErrInvalidCoupon = errors.New("invalid coupon")
+	// ErrCouponNotApplicable is wrapped by every reason a customer's coupon
+	// can't be used.
+	ErrCouponNotApplicable = errors.New("coupon can't be used")
+	ErrCouponNotFound      = fmt.Errorf("%w: no such code", ErrCouponNotApplicable)
+	ErrCouponExpired       = fmt.Errorf("%w: it has expired", ErrCouponNotApplicable)
+	ErrCouponNotRunning    = fmt.Errorf("%w: its promotion is not running", ErrCouponNotApplicable)
+	ErrCouponMinBasket     = fmt.Errorf("%w: the basket is below its minimum", ErrCouponNotApplicable)
+	ErrCouponUsedUp        = fmt.Errorf("%w: it has been used up", ErrCouponNotApplicable)
+	ErrCouponCustomerLimit = fmt.Errorf("%w: you have used it the maximum number of times", ErrCouponNotApplicable)
+)
+
+// CouponService manages coupon codes and counts their redemptions.
+type CouponService struct {
+	coupons    repository.CouponRepo
+	promotions repository.PromotionRepo
+}
+
+func NewCouponService(coupons repository.CouponRepo, promotions repository.PromotionRepo) *CouponService {
+	return &CouponService{coupons: coupons, promotions: promotions}
+}
+
+func (s *CouponService) List(ctx context.Context, promotionID primitive.ObjectID, batch string) ([]models.Coupon, error) {
+	return s.coupons.List(ctx, promotionID, batch)
+}
+
+// Create adds a single, hand-picked code.
+func (s *CouponService) Create(ctx context.Context, c *models.Coupon) error {
+	c.Code = normalizeCouponCode(c.Code)
+	if c.Code == "" {
+		return fmt.Errorf("%w: code is required", ErrInvalidCoupon)
+	}
+	if err := s.validate(ctx, c); err != nil {
+		return err
+	}
+	c.Uses = 0
+	id, err := s.coupons.Create(ctx, c)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: code %s is taken", ErrInvalidCoupon, c.Code)
+	}
+	if err != nil {
+		return err
+	}
+	c.ID = id
+	return nil
+}
+
+// Generate creates count random codes starting with prefix, each with the
+// limits of tmpl, as one batch.
+func (s *CouponService) Generate(ctx context.Context, tmpl models.Coupon, count int, prefix string) ([]models.Coupon, error) {
+	if count < 1 || count > maxCouponBatchSize {
+		return nil, fmt.Errorf("%w: count must be between 1 and %d", ErrInvalidCoupon, maxCouponBatchSize)
+	}
+	if err := s.validate(ctx, &tmpl); err != nil {
+		return nil, err
+	}
+	prefix = normalizeCouponCode(prefix)
+	if tmpl.Batch = strings.TrimSpace(tmpl.Batch); tmpl.Batch == "" {
+		tmpl.Batch = primitive.NewObjectID().Hex()
+	}
+
+	out := make([]models.Coupon, 0, count)
+	for len(out) < count {
+		c := tmpl
+		c.ID = primitive.NilObjectID
+		c.Uses = 0
+		code, err := randomCouponCode()
+		if err != nil {
+			return out, err
+		}
+		c.Code = prefix + code
+		id, err := s.coupons.Create(ctx, &c)
+		if mongo.IsDuplicateKeyError(err) {
+			continue // try another code
+		}
+		if err != nil {
+			return out, err
+		}
+		c.ID = id
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+func (s *CouponService) Delete(ctx context.Context, id primitive.ObjectID) error {
+	err := s.coupons.Delete(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrCouponNotFound
+	}
+	return err
+}
+
+func (s *CouponService) validate(ctx context.Context, c *models.Coupon) error {
+	p, err := s.promotions.FindByID(ctx, c.PromotionID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("%w: promotion not found", ErrInvalidCoupon)
+	}
+	if err != nil {
+		return err
+	}
+	if !p.CouponOnly {
+		return fmt.Errorf("%w: promotion %s is not coupon-only", ErrInvalidCoupon, p.Name)
+	}
+	if c.MaxUses < 0 || c.MaxUsesPerCustomer < 0 || c.MinBasket < 0 {
+		return fmt.Errorf("%w: limits must not be negative", ErrInvalidCoupon)
+	}
+	return nil
+}
+
+// CouponOffer is a coupon that checked out against a basket and the
+// promotion it unlocks.
+type CouponOffer struct {
+	Coupon    *models.Coupon
+	Promotion *models.Promotion
+}
+
+// Check tells whether userID can use code on a basket with the given goods
+// subtotal at at. Usage limits are checked too, but only Claim holds them.
+func (s *CouponService) Check(ctx context.Context, userID primitive.ObjectID, code string, basket models.Money, at time.Time) (*CouponOffer, error) {
+	c, err := s.coupons.FindByCode(ctx, normalizeCouponCode(code))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrCouponNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.ExpiresAt != nil && !c.ExpiresAt.After(at) {
+		return nil, ErrCouponExpired
+	}
+	p, err := s.promotions.FindByID(ctx, c.PromotionID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrCouponNotRunning
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !p.RunningAt(at) {
+		return nil, ErrCouponNotRunning
+	}
+	if basket < c.MinBasket {
+		return nil, fmt.Errorf("%w of %s", ErrCouponMinBasket, c.MinBasket)
+	}
+	if c.MaxUses > 0 && c.Uses >= c.MaxUses {
+		return nil, ErrCouponUsedUp
+	}
+	if c.MaxUsesPerCustomer > 0 {
+		n, err := s.coupons.CustomerUses(ctx, c.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if n >= c.MaxUsesPerCustomer {
+			return nil, ErrCouponCustomerLimit
+		}
+	}
+	return &CouponOffer{Coupon: c, Promotion: p}, nil
+}
+
+// Claim counts a redemption of the offer's coupon by userID, failing when
+// that would break either usage limit.
+func (s *CouponService) Claim(ctx context.Context, userID primitive.ObjectID, o *CouponOffer) error {
+	codeOK, customerOK, err := s.coupons.Claim(ctx, o.Coupon.ID, userID, o.Coupon.MaxUses, o.Coupon.MaxUsesPerCustomer)
+	switch {
+	case err != nil:
+		return err
+	case !codeOK:
+		return ErrCouponUsedUp
+	case !customerOK:
+		return ErrCouponCustomerLimit
+	}
+	return nil
+}
+
+// Release undoes a Claim for an order that didn't go through.
+func (s *CouponService) Release(ctx context.Context, userID primitive.ObjectID, o *CouponOffer) error {
+	return s.coupons.Release(ctx, o.Coupon.ID, userID)
+}
+
+// ReleaseCode gives back userID's redemption of code, for a cancelled order.
+func (s *CouponService) ReleaseCode(ctx context.Context, userID primitive.ObjectID, code string) error {
+	c, err := s.coupons.FindByCode(ctx, normalizeCouponCode(code))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrCouponNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.coupons.Release(ctx, c.ID, userID)
+}
+
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func randomCouponCode() (string, error) {
+	return randomString(couponAlphabet, couponCodeLength)
+}
+
+// randomString returns n characters picked at random from alphabet.
+func randomString(alphabet string, n int) (string, error) {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(alphabet)))
+	for i := range b {
+		k, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = alphabet[k.Int64()]
+	}
+	return string(b), nil
+}
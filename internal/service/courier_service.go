@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"github.com/dannieey/Assignment3_Absolute/internal/views"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxSignatureBytes = 512 << 10
+
+var (
+	ErrCourierNotFound      = errors.New("courier not found")
+	ErrOrderNotFound        = errors.New("order not found")
+	ErrOrderNotAssignable   = errors.New("order is not a delivery waiting for a courier")
+	ErrDeliveryTransition   = errors.New("delivery is not in a state that allows this")
+	ErrProofRequired        = errors.New("a delivery code or signature is required")
+	ErrWrongDeliveryCode    = errors.New("delivery code does not match")
+	ErrInvalidSignature     = errors.New("signature must be a PNG or JPEG image up to 512KB")
+	ErrFailureReasonMissing = errors.New("reason is required")
+	ErrProofNotFound        = errors.New("no signature recorded for this order")
+)
+
+// Order statuses from which staff may (re)assign a courier.
+var assignableStatuses = []string{models.OrderReadyForDelivery, models.OrderCourierAssigned, models.OrderDeliveryFailed}
+
+// CourierService dispatches delivery orders to couriers and records what
+// happens at the door. Every step is appended to the order history, which is
+// what /orders/tracking shows the customer.
+type CourierService struct {
+	orders repository.OrderRepo
+	users  repository.UserRepo
+	proofs repository.DeliveryProofRepo
+
+	onDelivered func(orderID primitive.ObjectID)
+}
+
+func NewCourierService(orders repository.OrderRepo, users repository.UserRepo, proofs repository.DeliveryProofRepo) *CourierService {
+	return &CourierService{orders: orders, users: users, proofs: proofs}
+}
+
+// OnDelivered registers fn to be called once an order has been delivered.
+func (s *CourierService) OnDelivered(fn func(orderID primitive.ObjectID)) {
+	s.onDelivered = fn
+}
+
+func (s *CourierService) ListCouriers(ctx context.Context) ([]views.PublicUser, error) {
+	disabled := false
+	res, err := s.users.ListWithFilter(ctx, repository.UserFilter{Role: models.RoleCourier, Disabled: &disabled, Page: 1, Limit: 100})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]views.PublicUser, 0, len(res.Users))
+	for i := range res.Users {
+		out = append(out, views.NewPublicUser(&res.Users[i]))
+	}
+	return out, nil
+}
+
+// Deliveries is the staff dispatch list. An empty status means orders still
+// waiting for a courier or being delivered.
+func (s *CourierService) Deliveries(ctx context.Context, status string) ([]models.CourierDelivery, error) {
+	statuses := []string{models.OrderReadyForDelivery, models.OrderCourierAssigned, models.OrderOutForDelivery, models.OrderDeliveryFailed}
+	if status != "" {
+		statuses = []string{status}
+	}
+	list, err := s.orders.FindDeliveries(ctx, statuses)
+	if err != nil {
+		return nil, err
+	}
+	return courierDeliveries(list), nil
+}
+
+func (s *CourierService) Assign(ctx context.Context, orderID, courierID primitive.ObjectID) error {
+	c, err := s.users.FindByID(ctx, courierID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrCourierNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if c.Role != models.RoleCourier || c.Disabled {
+		return ErrCourierNotFound
+	}
+
+	ok, err := s.orders.AssignCourier(ctx, orderID, courierID, assignableStatuses, "Assigned to courier "+c.FullName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrOrderNotAssignable
+	}
+	return nil
+}
+
+// Assigned lists the courier's deliveries that are not finished yet.
+func (s *CourierService) Assigned(ctx context.Context, courierID primitive.ObjectID) ([]models.CourierDelivery, error) {
+	list, err := s.orders.FindByCourier(ctx, courierID, []string{models.OrderCourierAssigned, models.OrderOutForDelivery})
+	if err != nil {
+		return nil, err
+	}
+	return courierDeliveries(list), nil
+}
+
+func (s *CourierService) PickedUp(ctx context.Context, courierID, orderID primitive.ObjectID) error {
+	return s.transition(ctx, courierID, orderID,
+		[]string{models.OrderCourierAssigned}, models.OrderOutForDelivery, "Picked up by courier, on the way", nil)
+}
+
+// Delivered completes the delivery. Proof is either the customer's delivery
+// code or a signature image.
+func (s *CourierService) Delivered(ctx context.Context, courierID, orderID primitive.ObjectID, code string, signature []byte) error {
+	code = strings.TrimSpace(code)
+	if code == "" && len(signature) == 0 {
+		return ErrProofRequired
+	}
+
+	o, err := s.orders.FindByID(ctx, orderID)
+	if err != nil || o.CourierID != courierID {
+		return ErrOrderNotFound
+	}
+	if o.Status != models.OrderOutForDelivery {
+		return ErrDeliveryTransition
+	}
+
+	proof := &models.DeliveryProof{RecordedAt: time.Now()}
+	note := "Delivered"
+	if code != "" {
+		if o.DeliveryCode == "" || subtle.ConstantTimeCompare([]byte(code), []byte(o.DeliveryCode)) != 1 {
+			return ErrWrongDeliveryCode
+		}
+		proof.Kind = models.ProofCode
+		note = "Delivered, confirmed with delivery code"
+	} else {
+		contentType := http.DetectContentType(signature)
+		if len(signature) > maxSignatureBytes || (contentType != "image/png" && contentType != "image/jpeg") {
+			return ErrInvalidSignature
+		}
+		fileID, err := s.proofs.SaveSignature(ctx, orderID, contentType, signature)
+		if err != nil {
+			return err
+		}
+		proof.Kind = models.ProofSignature
+		proof.SignatureFileID = fileID
+		note = "Delivered, signed for"
+	}
+
+	if err := s.transition(ctx, courierID, orderID, []string{models.OrderOutForDelivery}, models.OrderDelivered, note, proof); err != nil {
+		return err
+	}
+	if s.onDelivered != nil {
+		s.onDelivered(orderID)
+	}
+	return nil
+}
+
+func (s *CourierService) Failed(ctx context.Context, courierID, orderID primitive.ObjectID, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return ErrFailureReasonMissing
+	}
+	return s.transition(ctx, courierID, orderID,
+		[]string{models.OrderCourierAssigned, models.OrderOutForDelivery}, models.OrderDeliveryFailed, "Delivery failed: "+reason, nil)
+}
+
+// Signature returns the signature image recorded for an order.
+func (s *CourierService) Signature(ctx context.Context, orderID primitive.ObjectID) ([]byte, string, error) {
+	o, err := s.orders.FindByID(ctx, orderID)
+	if err != nil {
+		return nil, "", ErrOrderNotFound
+	}
+	if o.DeliveryProof == nil || o.DeliveryProof.SignatureFileID.IsZero() {
+		return nil, "", ErrProofNotFound
+	}
+	return s.proofs.LoadSignature(ctx, o.DeliveryProof.SignatureFileID)
+}
+
+func (s *CourierService) transition(ctx context.Context, courierID, orderID primitive.ObjectID, from []string, to, note string, proof *models.DeliveryProof) error {
+	ok, err := s.orders.UpdateCourierStatus(ctx, orderID, courierID, from, to, note, proof)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+
+	o, err := s.orders.FindByID(ctx, orderID)
+	if err != nil || o.CourierID != courierID {
+		return ErrOrderNotFound
+	}
+	return ErrDeliveryTransition
+}
+
+func courierDeliveries(list []models.Order) []models.CourierDelivery {
+	out := make([]models.CourierDelivery, 0, len(list))
+	for i := range list {
+		out = append(out, models.NewCourierDelivery(&list[i]))
+	}
+	return out
+}
+
+// newDeliveryCode returns a random 6-digit code.
+func newDeliveryCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
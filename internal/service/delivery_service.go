@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultDeliveryHorizonDays = 7
+	maxDeliveryHorizonDays     = 30
+)
+
+var (
+	ErrDeliveryZoneNotFound    = errors.New("delivery zone not found")
+	ErrInvalidDeliveryZone     = errors.New("invalid delivery zone")
+	ErrNoDeliveryZone          = errors.New("we don't deliver to this address")
+	ErrBelowMinimumOrder       = errors.New("order is below the minimum for delivery")
+	ErrDeliveryWindowRequired  = errors.New("deliveryWindow is required for delivery orders")
+	ErrInvalidDeliveryWindow   = errors.New("delivery window is not offered")
+	ErrDeliveryAddressRequired = errors.New("addressId is required for delivery orders")
+)
+
+// DeliveryService manages delivery zones and prices delivery for an address.
+type DeliveryService struct {
+	zones     repository.DeliveryZoneRepo
+	addresses repository.AddressRepo
+}
+
+func NewDeliveryService(zones repository.DeliveryZoneRepo, addresses repository.AddressRepo) *DeliveryService {
+	return &DeliveryService{zones: zones, addresses: addresses}
+}
+
+func (s *DeliveryService) ListZones(ctx context.Context) ([]models.DeliveryZone, error) {
+	return s.zones.List(ctx)
+}
+
+func (s *DeliveryService) CreateZone(ctx context.Context, z *models.DeliveryZone) error {
+	if err := normalizeZone(z); err != nil {
+		return err
+	}
+	id, err := s.zones.Create(ctx, z)
+	if err != nil {
+		return err
+	}
+	z.ID = id
+	return nil
+}
+
+func (s *DeliveryService) UpdateZone(ctx context.Context, id primitive.ObjectID, z *models.DeliveryZone) error {
+	existing, err := s.zones.FindByID(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrDeliveryZoneNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := normalizeZone(z); err != nil {
+		return err
+	}
+	z.ID = id
+	z.CreatedAt = existing.CreatedAt
+	return s.zones.Update(ctx, z)
+}
+
+func (s *DeliveryService) DeleteZone(ctx context.Context, id primitive.ObjectID) error {
+	err := s.zones.Delete(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrDeliveryZoneNotFound
+	}
+	return err
+}
+
+// Quote tells a customer what delivering to one of their addresses costs and
+// which windows are open.
+func (s *DeliveryService) Quote(ctx context.Context, userID, addressID primitive.ObjectID) (*models.DeliveryQuote, error) {
+	a, err := s.addresses.FindByID(ctx, userID, addressID)
+	if err != nil {
+		return nil, ErrAddressNotFound
+	}
+	z, err := s.zoneFor(ctx, a.Snapshot())
+	if err != nil {
+		return nil, err
+	}
+	return &models.DeliveryQuote{
+		ZoneID:   z.ID,
+		ZoneName: z.Name,
+		Fee:      z.Fee,
+		MinOrder: z.MinOrder,
+		FreeOver: z.FreeOver,
+		Windows:  zoneWindows(z, time.Now()),
+	}, nil
+}
+
+// DeliveryCharge is the delivery part of an order.
+type DeliveryCharge struct {
+	ZoneID primitive.ObjectID
+	Fee    models.Money
+	Window *models.DeliveryWindow
+}
+
+// Price checks that the address is served, the subtotal meets the zone's
+// minimum and windowStart is an offered window, and returns the fee.
+func (s *DeliveryService) Price(ctx context.Context, addr *models.AddressSnapshot, subtotal models.Money, windowStart time.Time) (*DeliveryCharge, error) {
+	z, err := s.zoneFor(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if subtotal < z.MinOrder {
+		return nil, fmt.Errorf("%w of %s", ErrBelowMinimumOrder, z.MinOrder)
+	}
+	if windowStart.IsZero() {
+		return nil, ErrDeliveryWindowRequired
+	}
+
+	var window *models.DeliveryWindow
+	for _, w := range zoneWindows(z, time.Now()) {
+		if w.Start.Equal(windowStart) {
+			window = &models.DeliveryWindow{Start: w.Start.UTC(), End: w.End.UTC()}
+			break
+		}
+	}
+	if window == nil {
+		return nil, ErrInvalidDeliveryWindow
+	}
+
+	fee := z.Fee
+	if z.FreeOver > 0 && subtotal >= z.FreeOver {
+		fee = 0
+	}
+	return &DeliveryCharge{ZoneID: z.ID, Fee: fee, Window: window}, nil
+}
+
+// zoneFor matches by postal code first, then by location. Postal codes are
+// the explicit choice, so they win when both would match.
+func (s *DeliveryService) zoneFor(ctx context.Context, addr *models.AddressSnapshot) (*models.DeliveryZone, error) {
+	z, err := s.zones.FindActiveByPostalCode(ctx, addr.PostalCode)
+	if err == nil {
+		return z, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	if addr.Location == nil {
+		return nil, ErrNoDeliveryZone
+	}
+
+	z, err = s.zones.FindActiveContaining(ctx, addr.Location)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNoDeliveryZone
+	}
+	return z, err
+}
+
+// zoneWindows lists the windows from now until the zone's horizon, skipping
+// those that start within the lead time.
+func zoneWindows(z *models.DeliveryZone, now time.Time) []models.DeliveryWindow {
+	loc := storeLocation()
+	today := startOfDay(now.In(loc))
+	earliest := now.Add(time.Duration(z.LeadMinutes) * time.Minute)
+
+	out := []models.DeliveryWindow{}
+	for d := 0; d < z.HorizonDays; d++ {
+		day := today.AddDate(0, 0, d)
+		for _, w := range z.Windows {
+			startOff, err1 := clockOffset(w.Start)
+			endOff, err2 := clockOffset(w.End)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			start := atClock(day, startOff)
+			if start.Before(earliest) {
+				continue
+			}
+			out = append(out, models.DeliveryWindow{Start: start, End: atClock(day, endOff)})
+		}
+	}
+	return out
+}
+
+// atClock is the wall-clock time off after midnight on day. It is built with
+// time.Date, so a DST change that day doesn't shift the window.
+func atClock(day time.Time, off time.Duration) time.Time {
+	h, m := int(off/time.Hour), int(off%time.Hour/time.Minute)
+	return time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, day.Location())
+}
+
+func normalizeZone(z *models.DeliveryZone) error {
+	z.Name = strings.TrimSpace(z.Name)
+	if z.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidDeliveryZone)
+	}
+
+	codes := make([]string, 0, len(z.PostalCodes))
+	for _, c := range z.PostalCodes {
+		if c = strings.ToUpper(strings.TrimSpace(c)); c != "" {
+			codes = append(codes, c)
+		}
+	}
+	z.PostalCodes = codes
+	if len(z.PostalCodes) == 0 && z.Area == nil {
+		return fmt.Errorf("%w: give postalCodes or an area polygon", ErrInvalidDeliveryZone)
+	}
+	if z.Area != nil {
+		if err := validatePolygon(z.Area); err != nil {
+			return err
+		}
+	}
+
+	if z.Fee < 0 || z.MinOrder < 0 || z.FreeOver < 0 {
+		return fmt.Errorf("%w: fee, minOrder and freeOver must not be negative", ErrInvalidDeliveryZone)
+	}
+	if z.LeadMinutes < 0 {
+		return fmt.Errorf("%w: leadMinutes must not be negative", ErrInvalidDeliveryZone)
+	}
+	if z.HorizonDays == 0 {
+		z.HorizonDays = defaultDeliveryHorizonDays
+	}
+	if z.HorizonDays < 1 || z.HorizonDays > maxDeliveryHorizonDays {
+		return fmt.Errorf("%w: horizonDays must be between 1 and %d", ErrInvalidDeliveryZone, maxDeliveryHorizonDays)
+	}
+
+	if len(z.Windows) == 0 {
+		return fmt.Errorf("%w: at least one delivery window is required", ErrInvalidDeliveryZone)
+	}
+	for _, w := range z.Windows {
+		start, err1 := clockOffset(w.Start)
+		end, err2 := clockOffset(w.End)
+		if err1 != nil || err2 != nil || start >= end {
+			return fmt.Errorf("%w: windows must be HH:MM with start before end", ErrInvalidDeliveryZone)
+		}
+	}
+	return nil
+}
+
+func validatePolygon(p *models.GeoPolygon) error {
+	if p.Type != "Polygon" || len(p.Coordinates) == 0 {
+		return fmt.Errorf("%w: area must be a GeoJSON Polygon", ErrInvalidDeliveryZone)
+	}
+	for _, ring := range p.Coordinates {
+		if len(ring) < 4 {
+			return fmt.Errorf("%w: polygon rings need at least 4 positions", ErrInvalidDeliveryZone)
+		}
+		for _, pos := range ring {
+			if len(pos) != 2 || pos[0] < -180 || pos[0] > 180 || pos[1] < -90 || pos[1] > 90 {
+				return fmt.Errorf("%w: positions must be [longitude, latitude]", ErrInvalidDeliveryZone)
+			}
+		}
+		first, last := ring[0], ring[len(ring)-1]
+		if first[0] != last[0] || first[1] != last[1] {
+			return fmt.Errorf("%w: polygon rings must be closed", ErrInvalidDeliveryZone)
+		}
+	}
+	return nil
+}
+
+// storeLocation is the store's timezone from STORE_TIMEZONE (default
+// Asia/Almaty), falling back to UTC if it can't be loaded.
+func storeLocation() *time.Location {
+	tz := strings.TrimSpace(os.Getenv("STORE_TIMEZONE"))
+	if tz == "" {
+		tz = defaultStoreTimezone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+)
+
+func TestZoneWindowsAcrossDSTChange(t *testing.T) {
+	t.Setenv("STORE_TIMEZONE", "Europe/Berlin")
+	loc := storeLocation()
+	if loc == time.UTC {
+		t.Skip("timezone data not available")
+	}
+
+	z := &models.DeliveryZone{
+		Windows:     []models.TimeWindow{{Start: "10:00", End: "12:00"}},
+		HorizonDays: 3,
+	}
+	// Clocks go forward at 02:00 on 29 March 2026.
+	now := time.Date(2026, 3, 28, 6, 0, 0, 0, loc)
+
+	got := zoneWindows(z, now)
+	if len(got) != 3 {
+		t.Fatalf("got %d windows, want 3", len(got))
+	}
+	for i, w := range got {
+		s, e := w.Start.In(loc), w.End.In(loc)
+		if s.Hour() != 10 || s.Minute() != 0 || e.Hour() != 12 || s.Day() != 28+i {
+			t.Errorf("window %d = %s - %s, want 10:00-12:00 on the %dth", i, s, e, 28+i)
+		}
+	}
+}
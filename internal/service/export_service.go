@@ -0,0 +1,370 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"github.com/dannieey/Assignment3_Absolute/internal/views"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// Accounts with more orders than this are exported in the background.
+	syncExportMaxOrders = 50
+	exportTTL           = 7 * 24 * time.Hour
+
+	// The worker looks for jobs this often even when nothing wakes it, so
+	// jobs left pending by a restart or by another instance get picked up.
+	exportPoll = 30 * time.Second
+	// Jobs pending or running for longer than this were lost, e.g. to a
+	// crash mid-build, and are failed so the user can start a new one.
+	exportStaleAfter = time.Hour
+	exportSweepEvery = 10 * time.Minute
+)
+
+var (
+	ErrExportNotFound = errors.New("export not found")
+	ErrExportNotReady = errors.New("export is not ready yet")
+	ErrExportExpired  = errors.New("export has expired")
+)
+
+// ExportService builds personal data archives (JSON plus CSV) for customers.
+type ExportService struct {
+	users     repository.UserRepo
+	orders    repository.OrderRepo
+	carts     repository.CartRepo
+	wishlists repository.WishlistRepo
+	addresses repository.AddressRepo
+	exports   repository.ExportRepo
+	wake      chan struct{}
+}
+
+func NewExportService(
+	users repository.UserRepo,
+	orders repository.OrderRepo,
+	carts repository.CartRepo,
+	wishlists repository.WishlistRepo,
+	addresses repository.AddressRepo,
+	exports repository.ExportRepo,
+) *ExportService {
+	s := &ExportService{
+		users:     users,
+		orders:    orders,
+		carts:     carts,
+		wishlists: wishlists,
+		addresses: addresses,
+		exports:   exports,
+		wake:      make(chan struct{}, 1),
+	}
+	go s.startWorker()
+	return s
+}
+
+type exportSession struct {
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+}
+
+type exportData struct {
+	GeneratedAt time.Time        `json:"generatedAt"`
+	User        views.SelfUser   `json:"user"`
+	Orders      []models.Order   `json:"orders"`
+	Cart        models.Cart      `json:"cart"`
+	Wishlist    models.Wishlist  `json:"wishlist"`
+	Addresses   []models.Address `json:"addresses"`
+	// Login tokens are stateless JWTs, so no server-side sessions are
+	// recorded yet. The section is kept so the archive layout stays stable.
+	Sessions []exportSession `json:"sessions"`
+}
+
+// Start returns the archive straight away for small accounts. Larger accounts
+// get a background job instead; the caller polls Status with its id.
+func (s *ExportService) Start(ctx context.Context, userID primitive.ObjectID) (archive []byte, fileName string, job *models.DataExport, err error) {
+	orders, err := s.orders.FindByUserID(ctx, userID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if orders == nil {
+		orders = []models.Order{} // loaded, just none
+	}
+
+	if len(orders) <= syncExportMaxOrders {
+		archive, err := s.build(ctx, userID, orders)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return archive, exportFileName(userID), nil, nil
+	}
+
+	existing, err := s.exports.FindActiveByUserID(ctx, userID)
+	if err == nil {
+		return nil, "", existing, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, "", nil, err
+	}
+
+	job = &models.DataExport{
+		UserID:    userID,
+		Status:    models.ExportPending,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(exportTTL),
+	}
+	id, err := s.exports.Create(ctx, job)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	job.ID = id
+
+	select {
+	case s.wake <- struct{}{}:
+	default: // the worker is already due to look
+	}
+	return nil, "", job, nil
+}
+
+func (s *ExportService) Status(ctx context.Context, userID, exportID primitive.ObjectID) (*models.DataExport, error) {
+	e, err := s.exports.FindByID(ctx, exportID)
+	if err != nil || e.UserID != userID {
+		return nil, ErrExportNotFound
+	}
+	return e, nil
+}
+
+func (s *ExportService) Download(ctx context.Context, userID, exportID primitive.ObjectID) ([]byte, string, error) {
+	e, err := s.Status(ctx, userID, exportID)
+	if err != nil {
+		return nil, "", err
+	}
+	if time.Now().After(e.ExpiresAt) {
+		return nil, "", ErrExportExpired
+	}
+	if e.Status != models.ExportReady {
+		return nil, "", ErrExportNotReady
+	}
+	data, err := s.exports.LoadArchive(ctx, e)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, e.FileName, nil
+}
+
+// startWorker builds pending exports one at a time. Jobs live in the
+// database, so the ones queued before a restart are built after it.
+func (s *ExportService) startWorker() {
+	t := time.NewTicker(exportPoll)
+	defer t.Stop()
+	var lastSweep time.Time
+	for {
+		if time.Since(lastSweep) >= exportSweepEvery {
+			s.sweep()
+			lastSweep = time.Now()
+		}
+		s.drain()
+		select {
+		case <-s.wake:
+		case <-t.C:
+		}
+	}
+}
+
+// drain builds pending jobs until there are none left.
+func (s *ExportService) drain() {
+	for {
+		e, err := s.exports.ClaimNext(context.Background())
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return
+		}
+		if err != nil {
+			log.Printf("[export] claiming next export: %v", err)
+			return
+		}
+		s.process(e)
+	}
+}
+
+// sweep fails lost jobs and deletes expired jobs with their archives.
+func (s *ExportService) sweep() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	stale := time.Now().Add(-exportStaleAfter)
+	if n, err := s.exports.FailStale(ctx, stale, stale, "export was interrupted, please start a new one"); err != nil {
+		log.Printf("[export] failing stale exports: %v", err)
+	} else if n > 0 {
+		log.Printf("[export] failed %d stale exports", n)
+	}
+	if _, err := s.exports.DeleteExpired(ctx, time.Now()); err != nil {
+		log.Printf("[export] deleting expired exports: %v", err)
+	}
+}
+
+func (s *ExportService) process(e *models.DataExport) {
+	ctx := context.Background()
+	id := e.ID
+
+	data, err := s.build(ctx, e.UserID, nil)
+	if err != nil {
+		log.Printf("[export] export %s failed: %v", id.Hex(), err)
+		_ = s.exports.UpdateStatus(ctx, id, models.ExportFailed, err.Error())
+		return
+	}
+	if err := s.exports.SaveArchive(ctx, id, exportFileName(e.UserID), data); err != nil {
+		log.Printf("[export] saving export %s failed: %v", id.Hex(), err)
+		_ = s.exports.UpdateStatus(ctx, id, models.ExportFailed, err.Error())
+	}
+}
+
+// collect gathers the user's data. orders are loaded unless the caller
+// already has them.
+func (s *ExportService) collect(ctx context.Context, userID primitive.ObjectID, orders []models.Order) (*exportData, error) {
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if orders == nil {
+		if orders, err = s.orders.FindByUserID(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+	cart, err := s.carts.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	wishlist, err := s.wishlists.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	addresses, err := s.addresses.ListByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if orders == nil {
+		orders = []models.Order{}
+	}
+
+	return &exportData{
+		GeneratedAt: time.Now(),
+		User:        views.NewSelfUser(u),
+		Orders:      orders,
+		Cart:        *cart,
+		Wishlist:    *wishlist,
+		Addresses:   addresses,
+		Sessions:    []exportSession{},
+	}, nil
+}
+
+func (s *ExportService) build(ctx context.Context, userID primitive.ObjectID, orders []models.Order) ([]byte, error) {
+	d, err := s.collect(ctx, userID, orders)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	js, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeZipFile(zw, "export.json", js); err != nil {
+		return nil, err
+	}
+
+	csvFiles := map[string][][]string{
+		"user.csv": {
+			{"id", "full_name", "email", "role", "created_at"},
+			{d.User.ID, d.User.FullName, d.User.Email, d.User.Role, formatTime(d.User.CreatedAt)},
+		},
+		"orders.csv":        {{"order_id", "status", "total_price", "created_at", "updated_at"}},
+		"order_items.csv":   {{"order_id", "product_id", "quantity", "price"}},
+		"order_history.csv": {{"order_id", "status", "timestamp", "note"}},
+		"cart.csv":          {{"product_id", "quantity", "added_at"}},
+		"wishlist.csv":      {{"product_id", "added_at"}},
+		"addresses.csv":     {{"address_id", "label", "street", "city", "postal_code", "phone", "notes", "is_default"}},
+		"sessions.csv":      {{"created_at", "expires_at", "ip", "user_agent"}},
+	}
+	for _, o := range d.Orders {
+		csvFiles["orders.csv"] = append(csvFiles["orders.csv"], []string{
+			o.ID.Hex(), o.Status, o.TotalPrice.String(), formatTime(o.CreatedAt), formatTime(o.UpdatedAt),
+		})
+		for _, it := range o.Items {
+			csvFiles["order_items.csv"] = append(csvFiles["order_items.csv"], []string{
+				o.ID.Hex(), it.ProductID.Hex(), strconv.Itoa(it.Quantity), it.Price.String(),
+			})
+		}
+		for _, h := range o.History {
+			csvFiles["order_history.csv"] = append(csvFiles["order_history.csv"], []string{
+				o.ID.Hex(), h.Status, formatTime(h.Timestamp), h.Note,
+			})
+		}
+	}
+	for _, it := range d.Cart.Items {
+		csvFiles["cart.csv"] = append(csvFiles["cart.csv"], []string{
+			it.ProductID.Hex(), strconv.Itoa(it.Quantity), formatTime(it.AddedAt),
+		})
+	}
+	for _, it := range d.Wishlist.Items {
+		csvFiles["wishlist.csv"] = append(csvFiles["wishlist.csv"], []string{
+			it.ProductID.Hex(), formatTime(it.AddedAt),
+		})
+	}
+	for _, a := range d.Addresses {
+		csvFiles["addresses.csv"] = append(csvFiles["addresses.csv"], []string{
+			a.ID.Hex(), a.Label, a.Street, a.City, a.PostalCode, a.Phone, a.Notes, strconv.FormatBool(a.IsDefault),
+		})
+	}
+	for _, ss := range d.Sessions {
+		csvFiles["sessions.csv"] = append(csvFiles["sessions.csv"], []string{
+			formatTime(ss.CreatedAt), formatTime(ss.ExpiresAt), ss.IP, ss.UserAgent,
+		})
+	}
+
+	for _, name := range []string{"user.csv", "orders.csv", "order_items.csv", "order_history.csv", "cart.csv", "wishlist.csv", "addresses.csv", "sessions.csv"} {
+		var cb bytes.Buffer
+		cw := csv.NewWriter(&cb)
+		if err := cw.WriteAll(csvFiles[name]); err != nil {
+			return nil, err
+		}
+		if err := writeZipFile(zw, name, cb.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func exportFileName(userID primitive.ObjectID) string {
+	return fmt.Sprintf("data-export-%s-%s.zip", userID.Hex(), time.Now().Format("20060102"))
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,13 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/views"
+)
+
+func TestExportArchiveExposesNoSecrets(t *testing.T) {
+	if err := views.CheckNoSecrets(exportData{}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+)
+
+// Card tokens understood by the fake gateway.
+const (
+	FakeTokenSuccess        = "tok_success"
+	FakeTokenDecline        = "tok_decline"
+	FakeTokenDelayed        = "tok_delayed"
+	FakeTokenDelayedDecline = "tok_delayed_decline"
+)
+
+const (
+	fakeSignatureHeader   = "Fake-Signature"
+	fakeWebhookTolerance  = 5 * time.Minute
+	fakeWebhookAttempts   = 3
+	defaultFakeHookDelay  = 5 * time.Second
+	fakeEventAuthorized   = "payment.authorized"
+	fakeEventDeclined     = "payment.declined"
+	fakeDeclineCard       = "card_declined"
+	fakeDeclineUnknownTok = "unknown_test_token"
+)
+
+// FakeGateway is an in-memory payment provider for development and demos.
+// The outcome is chosen by the card token: tok_success and tok_decline
+// answer straight away, tok_delayed and tok_delayed_decline answer
+// PaymentPending and send a signed webhook a few seconds later, just like a
+// real 3-D Secure flow would.
+type FakeGateway struct {
+	secret     []byte
+	webhookURL string
+	delay      time.Duration
+	client     *http.Client
+
+	mu       sync.Mutex
+	payments map[string]*fakePayment
+}
+
+type fakePayment struct {
+	status   string
+	amount   models.Money
+	captured models.Money
+	refunded models.Money
+}
+
+type fakeWebhook struct {
+	ID            string       `json:"id"`
+	Type          string       `json:"type"`
+	Ref           string       `json:"ref"`
+	Amount        models.Money `json:"amount"`
+	DeclineReason string       `json:"declineReason,omitempty"`
+}
+
+// FakeGatewayFromEnv configures the gateway from:
+//
+//	PAYMENT_WEBHOOK_URL          where webhooks are posted (default
+//	                             http://localhost:$PORT/payments/webhook)
+//	FAKE_PAYMENT_WEBHOOK_SECRET  signing secret (random per process if unset)
+//	FAKE_PAYMENT_WEBHOOK_DELAY   delay before a delayed outcome (default 5s)
+func FakeGatewayFromEnv() (*FakeGateway, error) {
+	url := strings.TrimSpace(os.Getenv("PAYMENT_WEBHOOK_URL"))
+	if url == "" {
+		port := strings.TrimSpace(os.Getenv("PORT"))
+		if port == "" {
+			port = "8080"
+		}
+		url = "http://localhost:" + port + "/payments/webhook"
+	}
+
+	secret := []byte(os.Getenv("FAKE_PAYMENT_WEBHOOK_SECRET"))
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+
+	delay := defaultFakeHookDelay
+	if v := strings.TrimSpace(os.Getenv("FAKE_PAYMENT_WEBHOOK_DELAY")); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid FAKE_PAYMENT_WEBHOOK_DELAY: %q", v)
+		}
+		delay = d
+	}
+
+	return &FakeGateway{
+		secret:     secret,
+		webhookURL: url,
+		delay:      delay,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		payments:   map[string]*fakePayment{},
+	}, nil
+}
+
+func (g *FakeGateway) Name() string { return "fake" }
+
+func (g *FakeGateway) Authorize(_ context.Context, req AuthorizeRequest) (*ProviderResult, error) {
+	ref := "fake_" + req.PaymentID.Hex()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if p, ok := g.payments[ref]; ok {
+		// Same payment id: answer as before, like an idempotency key would.
+		return &ProviderResult{Ref: ref, Status: p.status}, nil
+	}
+
+	p := &fakePayment{amount: req.Amount}
+	g.payments[ref] = p
+	res := &ProviderResult{Ref: ref}
+	switch req.Token {
+	case FakeTokenSuccess:
+		p.status = models.PaymentAuthorized
+	case FakeTokenDecline:
+		p.status = models.PaymentDeclined
+		res.DeclineReason = fakeDeclineCard
+	case FakeTokenDelayed, FakeTokenDelayedDecline:
+		p.status = models.PaymentPending
+		approve := req.Token == FakeTokenDelayed
+		time.AfterFunc(g.delay, func() { g.settle(ref, approve) })
+	default:
+		p.status = models.PaymentDeclined
+		res.DeclineReason = fakeDeclineUnknownTok
+	}
+	res.Status = p.status
+	return res, nil
+}
+
+func (g *FakeGateway) Capture(_ context.Context, ref string, amount models.Money) (*ProviderResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p, ok := g.payments[ref]
+	if !ok || p.status != models.PaymentAuthorized || amount <= 0 || amount > p.amount {
+		return nil, ErrProviderRejected
+	}
+	p.status = models.PaymentCaptured
+	p.captured = amount
+	return &ProviderResult{Ref: ref, Status: p.status}, nil
+}
+
+func (g *FakeGateway) Void(_ context.Context, ref string) (*ProviderResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p, ok := g.payments[ref]
+	if !ok || p.status != models.PaymentAuthorized {
+		return nil, ErrProviderRejected
+	}
+	p.status = models.PaymentVoided
+	return &ProviderResult{Ref: ref, Status: p.status}, nil
+}
+
+func (g *FakeGateway) Refund(_ context.Context, ref string, amount models.Money) (*ProviderResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p, ok := g.payments[ref]
+	if !ok || (p.status != models.PaymentCaptured && p.status != models.PaymentPartiallyRefunded) ||
+		amount <= 0 || p.refunded+amount > p.captured {
+		return nil, ErrProviderRejected
+	}
+	p.refunded += amount
+	p.status = models.PaymentPartiallyRefunded
+	if p.refunded == p.captured {
+		p.status = models.PaymentRefunded
+	}
+	return &ProviderResult{Ref: ref, Status: p.status}, nil
+}
+
+func (g *FakeGateway) VerifyWebhook(payload []byte, header http.Header) (*WebhookEvent, error) {
+	var ts, sig string
+	for _, part := range strings.Split(header.Get(fakeSignatureHeader), ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return nil, ErrInvalidWebhook
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > fakeWebhookTolerance || age < -fakeWebhookTolerance {
+		return nil, ErrInvalidWebhook
+	}
+	want, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(want, g.sign(ts, payload)) {
+		return nil, ErrInvalidWebhook
+	}
+
+	var hook fakeWebhook
+	if err := json.Unmarshal(payload, &hook); err != nil {
+		return nil, ErrInvalidWebhook
+	}
+	ev := &WebhookEvent{ID: hook.ID, Ref: hook.Ref, Amount: hook.Amount, DeclineReason: hook.DeclineReason}
+	switch hook.Type {
+	case fakeEventAuthorized:
+		ev.Status = models.PaymentAuthorized
+	case fakeEventDeclined:
+		ev.Status = models.PaymentDeclined
+	default:
+		return nil, ErrInvalidWebhook
+	}
+	return ev, nil
+}
+
+// settle finishes a delayed authorization and tells the shop by webhook.
+func (g *FakeGateway) settle(ref string, approve bool) {
+	g.mu.Lock()
+	p, ok := g.payments[ref]
+	if !ok || p.status != models.PaymentPending {
+		g.mu.Unlock()
+		return
+	}
+	hook := fakeWebhook{ID: "evt_" + ref, Ref: ref, Amount: p.amount, Type: fakeEventAuthorized}
+	p.status = models.PaymentAuthorized
+	if !approve {
+		hook.Type = fakeEventDeclined
+		hook.DeclineReason = fakeDeclineCard
+		p.status = models.PaymentDeclined
+	}
+	g.mu.Unlock()
+
+	payload, err := json.Marshal(hook)
+	if err != nil {
+		log.Printf("[fake-pay] encode webhook: %v", err)
+		return
+	}
+	for attempt := 1; attempt <= fakeWebhookAttempts; attempt++ {
+		if err = g.post(payload); err == nil {
+			return
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	log.Printf("[fake-pay] webhook %s not delivered: %v", hook.ID, err)
+}
+
+func (g *FakeGateway) post(payload []byte) error {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, g.webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(fakeSignatureHeader, "t="+ts+",v1="+hex.EncodeToString(g.sign(ts, payload)))
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+func (g *FakeGateway) sign(ts string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
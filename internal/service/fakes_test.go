@@ -0,0 +1,402 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// In-memory repositories for tests. Methods a test doesn't need fall through
+// to the embedded nil interface and panic.
+
+type memUsers struct {
+	repository.UserRepo
+	mu    sync.Mutex
+	users map[primitive.ObjectID]*models.User
+}
+
+func (m *memUsers) add(u *models.User) *models.User {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u.ID = primitive.NewObjectID()
+	m.users[u.ID] = u
+	return u
+}
+
+func (m *memUsers) Create(_ context.Context, u *models.User) (primitive.ObjectID, error) {
+	return m.add(u).ID, nil
+}
+
+func (m *memUsers) FindByID(_ context.Context, id primitive.ObjectID) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if u, ok := m.users[id]; ok {
+		c := *u
+		return &c, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memUsers) FindByEmail(_ context.Context, email string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.Email == email {
+			c := *u
+			return &c, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memUsers) FindByExternalIdentity(_ context.Context, issuer, subject string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		for _, e := range u.ExternalIdentities {
+			if e.Issuer == issuer && e.Subject == subject {
+				c := *u
+				return &c, nil
+			}
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memUsers) LinkExternalIdentity(_ context.Context, id primitive.ObjectID, ident models.ExternalIdentity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[id].ExternalIdentities = append(m.users[id].ExternalIdentities, ident)
+	return nil
+}
+
+func (m *memUsers) UpdateRole(_ context.Context, id primitive.ObjectID, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[id].Role = role
+	return nil
+}
+
+func (m *memUsers) CountActiveByRole(_ context.Context, role string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for _, u := range m.users {
+		if u.Role == role && !u.Disabled {
+			n++
+		}
+	}
+	return n, nil
+}
+
+type memStates struct {
+	mu     sync.Mutex
+	states map[string]models.OIDCState
+}
+
+func (m *memStates) Create(_ context.Context, s *models.OIDCState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states[s.State] = *s
+	return nil
+}
+
+func (m *memStates) Consume(_ context.Context, state string) (*models.OIDCState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.states[state]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	delete(m.states, state)
+	return &s, nil
+}
+
+type memSigningKeys struct {
+	mu   sync.Mutex
+	keys []models.SigningKey
+}
+
+func (m *memSigningKeys) Create(_ context.Context, k *models.SigningKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = append([]models.SigningKey{*k}, m.keys...)
+	return nil
+}
+
+func (m *memSigningKeys) ListUsable(context.Context) ([]models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.SigningKey(nil), m.keys...), nil
+}
+
+func (m *memSigningKeys) RetireAllExcept(context.Context, string, time.Time) error { return nil }
+func (m *memSigningKeys) DeleteExpired(context.Context) error                      { return nil }
+func (m *memSigningKeys) SealPrivateKey(context.Context, string, string) error     { return nil }
+
+type memLeases struct{}
+
+func (memLeases) Acquire(context.Context, string, string, time.Duration) (bool, error) {
+	return true, nil
+}
+func (memLeases) Release(context.Context, string, string) error { return nil }
+
+func (m *memUsers) UpdatePasswordHash(_ context.Context, id primitive.ObjectID, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[id].PasswordHash = hash
+	return nil
+}
+
+func (m *memUsers) SetConfirmCode(_ context.Context, id primitive.ObjectID, action, codeHash string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.users[id]
+	u.ConfirmCodeHash, u.ConfirmAction, u.ConfirmExpiresAt = codeHash, action, &expiresAt
+	return nil
+}
+
+func (m *memUsers) UseConfirmCode(_ context.Context, id primitive.ObjectID, action, codeHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.users[id]
+	if u.ConfirmCodeHash != codeHash || u.ConfirmAction != action || u.ConfirmExpiresAt == nil || !u.ConfirmExpiresAt.After(time.Now()) {
+		return false, nil
+	}
+	u.ConfirmCodeHash, u.ConfirmAction, u.ConfirmExpiresAt = "", "", nil
+	return true, nil
+}
+
+type memProducts struct {
+	repository.ProductRepo
+	mu       sync.Mutex
+	products map[primitive.ObjectID]*models.Product
+}
+
+func (m *memProducts) FindByID(_ context.Context, id primitive.ObjectID) (*models.Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.products[id]; ok {
+		c := *p
+		return &c, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memProducts) SetPrice(_ context.Context, id primitive.ObjectID, price models.Money) (*models.Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.products[id]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	old := *p
+	p.Price = price
+	return &old, nil
+}
+
+func (m *memProducts) DecreaseStock(_ context.Context, id primitive.ObjectID, qty int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.products[id]
+	if !ok || p.StockQty < qty {
+		return errors.New("insufficient stock")
+	}
+	p.StockQty -= qty
+	return nil
+}
+
+type memPrices struct {
+	repository.PriceRepo
+	mu        sync.Mutex
+	changes   []models.PriceChange
+	schedules map[primitive.ObjectID]*models.PriceSchedule
+	// addErr, when set, fails AddChange.
+	addErr error
+}
+
+func (m *memPrices) AddChange(_ context.Context, c *models.PriceChange) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.addErr != nil {
+		return m.addErr
+	}
+	m.changes = append(m.changes, *c)
+	return nil
+}
+
+func (m *memPrices) DueSchedules(_ context.Context, at time.Time) ([]models.PriceSchedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []models.PriceSchedule
+	for _, sc := range m.schedules {
+		if sc.Status == models.ScheduleStatusPending && !sc.EffectiveAt.After(at) {
+			out = append(out, *sc)
+		}
+	}
+	return out, nil
+}
+
+func (m *memPrices) SetScheduleStatus(_ context.Context, id primitive.ObjectID, from []string, status, failure string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sc, ok := m.schedules[id]
+	if !ok || !slices.Contains(from, sc.Status) {
+		return false, nil
+	}
+	sc.Status = status
+	if failure != "" {
+		sc.Failure = failure
+	}
+	return true, nil
+}
+
+type memPickup struct {
+	repository.PickupRepo
+	mu     sync.Mutex
+	booked map[time.Time]int
+}
+
+func (m *memPickup) GetSettings(context.Context) (*models.PickupSettings, error) {
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memPickup) Reserve(_ context.Context, start, _ time.Time, capacity int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.booked[start.UTC()] >= capacity {
+		return false, nil
+	}
+	m.booked[start.UTC()]++
+	return true, nil
+}
+
+func (m *memPickup) Release(_ context.Context, start time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.booked[start.UTC()] > 0 {
+		m.booked[start.UTC()]--
+	}
+	return nil
+}
+
+type noPromotions struct{ repository.PromotionRepo }
+
+func (noPromotions) ListRunning(context.Context, time.Time) ([]models.Promotion, error) {
+	return nil, nil
+}
+
+type noTaxes struct{ repository.TaxRepo }
+
+func (noTaxes) GetSettings(context.Context) (*models.TaxSettings, error) {
+	return nil, mongo.ErrNoDocuments
+}
+func (noTaxes) ListClasses(context.Context) ([]models.TaxClass, error) { return nil, nil }
+
+type noCategories struct{ repository.CategoryRepo }
+
+func (noCategories) FindByID(context.Context, primitive.ObjectID) (*models.Category, error) {
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memUsers) Delete(_ context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.users, id)
+	return nil
+}
+
+// userData holds per-user records of the kinds an account deletion clears;
+// each of its views below is one repository.
+type userData struct {
+	mu      sync.Mutex
+	records map[string]map[primitive.ObjectID]int
+}
+
+func (d *userData) add(kind string, userID primitive.ObjectID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.records[kind] == nil {
+		d.records[kind] = map[primitive.ObjectID]int{}
+	}
+	d.records[kind][userID]++
+}
+
+func (d *userData) count(kind string, userID primitive.ObjectID) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.records[kind][userID]
+}
+
+func (d *userData) remove(kind string, userID primitive.ObjectID) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := d.records[kind][userID]
+	delete(d.records[kind], userID)
+	return n
+}
+
+type dataOrders struct {
+	repository.OrderRepo
+	*userData
+}
+
+func (r dataOrders) AnonymizeByUserID(_ context.Context, userID primitive.ObjectID) (int64, error) {
+	return int64(r.remove("orders", userID)), nil
+}
+
+type dataCarts struct {
+	repository.CartRepo
+	*userData
+}
+
+func (r dataCarts) DeleteByUserID(_ context.Context, userID primitive.ObjectID) error {
+	r.remove("carts", userID)
+	return nil
+}
+
+type dataWishlists struct {
+	repository.WishlistRepo
+	*userData
+}
+
+func (r dataWishlists) DeleteByUserID(_ context.Context, userID primitive.ObjectID) error {
+	r.remove("wishlists", userID)
+	return nil
+}
+
+type dataAddresses struct {
+	repository.AddressRepo
+	*userData
+}
+
+func (r dataAddresses) DeleteByUserID(_ context.Context, userID primitive.ObjectID) error {
+	r.remove("addresses", userID)
+	return nil
+}
+
+type dataExports struct {
+	repository.ExportRepo
+	*userData
+}
+
+func (r dataExports) DeleteByUserID(_ context.Context, userID primitive.ObjectID) (int, error) {
+	return r.remove("exports", userID), nil
+}
+
+type dataLoyalty struct {
+	repository.LoyaltyRepo
+	*userData
+}
+
+func (r dataLoyalty) DeleteByUserID(_ context.Context, userID primitive.ObjectID) error {
+	r.remove("loyalty", userID)
+	return nil
+}
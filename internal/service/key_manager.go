@@ -0,0 +1,639 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	accessTokenTTL = 7 * 24 * time.Hour
+	// legacyTokenTTL is how long the HS256 tokens from before the asymmetric
+	// keys were issued for.
+	legacyTokenTTL = 7 * 24 * time.Hour
+	// A retired key keeps verifying until every token it signed has expired.
+	keyGracePeriod = accessTokenTTL + time.Hour
+
+	keyRefreshInterval = time.Minute
+	keyRotationCheck   = time.Hour
+	// A token with an unknown kid reloads the key set at most this often, so
+	// made-up kids can't turn every request into a database read.
+	keyMissRefresh = 5 * time.Second
+
+	keyRotationLease    = "signing-key-rotation"
+	keyRotationLeaseTTL = time.Minute
+	keyRotationWait     = 250 * time.Millisecond
+	keyRotationAttempts = 40
+
+	defaultTokenIssuer   = "supermarket-api"
+	defaultTokenAudience = "supermarket-web"
+	defaultClockSkew     = 30 * time.Second
+	maxClockSkew         = 5 * time.Minute
+)
+
+var ErrUnknownSigningKey = errors.New("unknown signing key")
+
+// JWK is one entry of the public JSON Web Key Set (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+type loadedKey struct {
+	kid       string
+	alg       string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	public    crypto.PublicKey
+	createdAt time.Time
+	retired   bool
+}
+
+// KeyManager signs access tokens with RS256 or EdDSA keys stored in MongoDB,
+// rotates them on a schedule and verifies tokens from any key that is still
+// within its grace period. Every instance reads the same key set, and other
+// services can verify tokens through the JWKS endpoint.
+type KeyManager struct {
+	repo        repository.SigningKeyRepo
+	leases      repository.LeaseRepo
+	aead        cipher.AEAD
+	alg         string
+	rotateEvery time.Duration
+
+	// Every token carries iss and aud, and verification allows leeway for
+	// clock drift between instances.
+	issuer   string
+	audience string
+	leeway   time.Duration
+
+	// legacySecret lets HS256 tokens issued before the switch to asymmetric
+	// keys verify until they expire. Set with JWT_LEGACY_SECRET.
+	legacySecret []byte
+
+	mu          sync.RWMutex
+	keys        map[string]*loadedKey
+	current     *loadedKey
+	lastRefresh time.Time
+	lastMiss    time.Time
+}
+
+// NewKeyManager loads the key set, creating the first key if needed, and
+// starts the rotation loop. JWT_SIGNING_ALG selects "EdDSA" (default) or
+// "RS256"; JWT_KEY_ROTATION_DAYS sets how long a key signs (default 30).
+// JWT_ISSUER and JWT_AUDIENCE set the iss and aud claims, and JWT_CLOCK_SKEW
+// the allowed drift (default 30s, at most 5m). JWT_KEY_ENCRYPTION_KEY, 32
+// bytes in base64, encrypts the private keys stored in MongoDB; it is
+// required. JWT_LEGACY_SECRET keeps old HS256 tokens working until they
+// expire.
+func NewKeyManager(ctx context.Context, repo repository.SigningKeyRepo, leases repository.LeaseRepo) (*KeyManager, error) {
+	aead, err := keyEncryptionAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	alg := strings.TrimSpace(os.Getenv("JWT_SIGNING_ALG"))
+	if alg == "" {
+		alg = jwt.SigningMethodEdDSA.Alg()
+	}
+	if alg != jwt.SigningMethodEdDSA.Alg() && alg != jwt.SigningMethodRS256.Alg() {
+		return nil, fmt.Errorf("unsupported JWT_SIGNING_ALG %q", alg)
+	}
+
+	days := 30
+	if v := strings.TrimSpace(os.Getenv("JWT_KEY_ROTATION_DAYS")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid JWT_KEY_ROTATION_DAYS: %q", v)
+		}
+		days = n
+	}
+
+	issuer := strings.TrimSpace(os.Getenv("JWT_ISSUER"))
+	if issuer == "" {
+		issuer = defaultTokenIssuer
+	}
+	audience := strings.TrimSpace(os.Getenv("JWT_AUDIENCE"))
+	if audience == "" {
+		audience = defaultTokenAudience
+	}
+
+	leeway := defaultClockSkew
+	if v := strings.TrimSpace(os.Getenv("JWT_CLOCK_SKEW")); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > maxClockSkew {
+			return nil, fmt.Errorf("invalid JWT_CLOCK_SKEW: %q", v)
+		}
+		leeway = d
+	}
+
+	m := &KeyManager{
+		repo:        repo,
+		leases:      leases,
+		aead:        aead,
+		alg:         alg,
+		rotateEvery: time.Duration(days) * 24 * time.Hour,
+		issuer:      issuer,
+		audience:    audience,
+		leeway:      leeway,
+		keys:        map[string]*loadedKey{},
+	}
+	if v := strings.TrimSpace(os.Getenv("JWT_LEGACY_SECRET")); v != "" {
+		m.legacySecret = []byte(v)
+	}
+	if err := m.rotateIfDue(ctx); err != nil {
+		return nil, err
+	}
+	go m.rotationLoop()
+	return m, nil
+}
+
+// Sign signs claims with the current key and sets the kid header.
+func (m *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	m.refreshIfStale(context.Background())
+
+	m.mu.RLock()
+	k := m.current
+	m.mu.RUnlock()
+	if k == nil {
+		return "", errors.New("no signing key available")
+	}
+
+	t := jwt.NewWithClaims(k.method, claims)
+	t.Header["kid"] = k.kid
+	return t.SignedString(k.private)
+}
+
+// Keyfunc resolves the verification key from the token's kid and rejects
+// tokens whose alg doesn't match that key.
+func (m *KeyManager) Keyfunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		return nil, ErrUnknownSigningKey
+	}
+
+	k := m.lookup(kid)
+	if k == nil && m.missRefreshDue() {
+		// Another instance may have rotated; reload once before giving up.
+		m.refresh(context.Background())
+		k = m.lookup(kid)
+	}
+	if k == nil {
+		return nil, ErrUnknownSigningKey
+	}
+	if t.Method.Alg() != k.alg {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", t.Method.Alg(), kid)
+	}
+	return k.public, nil
+}
+
+// ValidMethods lists the algorithms tokens may be signed with.
+func (m *KeyManager) ValidMethods() []string {
+	return []string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}
+}
+
+// Issuer is the iss claim of tokens we sign.
+func (m *KeyManager) Issuer() string { return m.issuer }
+
+// Audience is the aud claim of access tokens we sign.
+func (m *KeyManager) Audience() string { return m.audience }
+
+// PreAuthAudience is the aud claim of pre-auth tokens. It differs from
+// Audience so that a pre-auth token never passes as an access token.
+func (m *KeyManager) PreAuthAudience() string { return m.audience + ":preauth" }
+
+// Parse verifies an access token, including algorithm, expiry, issuer and
+// audience. Legacy HS256 tokens, which carry no iss or aud, are accepted
+// while JWT_LEGACY_SECRET is set, for at most legacyTokenTTL after they were
+// issued.
+func (m *KeyManager) Parse(tokenStr string) (*jwt.Token, error) {
+	if m.legacySecret != nil && isLegacyToken(tokenStr) {
+		return m.parseLegacy(tokenStr)
+	}
+	return m.parse(tokenStr, m.audience)
+}
+
+// parseLegacy verifies an HS256 token. Only tokens that live no longer than
+// the ones we used to issue are accepted, so one minted with the old secret
+// can't stay valid for years.
+func (m *KeyManager) parseLegacy(tokenStr string) (*jwt.Token, error) {
+	t, err := jwt.Parse(tokenStr,
+		func(*jwt.Token) (any, error) { return m.legacySecret, nil },
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(m.leeway),
+	)
+	if err != nil {
+		return nil, err
+	}
+	exp, _ := t.Claims.GetExpirationTime()
+	iat, _ := t.Claims.GetIssuedAt()
+	if iat == nil || exp.Sub(iat.Time) > legacyTokenTTL {
+		return nil, fmt.Errorf("%w: legacy token lives longer than %s", jwt.ErrTokenInvalidClaims, legacyTokenTTL)
+	}
+	return t, nil
+}
+
+// ParsePreAuth verifies a pre-auth token handed out between the password and
+// the second factor.
+func (m *KeyManager) ParsePreAuth(tokenStr string) (*jwt.Token, error) {
+	return m.parse(tokenStr, m.PreAuthAudience())
+}
+
+func (m *KeyManager) parse(tokenStr, audience string) (*jwt.Token, error) {
+	return jwt.Parse(tokenStr, m.Keyfunc,
+		jwt.WithValidMethods(m.ValidMethods()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(m.issuer),
+		jwt.WithAudience(audience),
+		jwt.WithLeeway(m.leeway),
+	)
+}
+
+// isLegacyToken reports whether the token's header is HS256 without a kid,
+// as signed before the asymmetric keys. The signature is checked later.
+func isLegacyToken(tokenStr string) bool {
+	t, _, err := jwt.NewParser().ParseUnverified(tokenStr, jwt.MapClaims{})
+	if err != nil {
+		return false
+	}
+	kid, _ := t.Header["kid"].(string)
+	return kid == "" && t.Method.Alg() == jwt.SigningMethodHS256.Alg()
+}
+
+// JWKS returns the public halves of every key that still verifies tokens.
+func (m *KeyManager) JWKS() JWKSet {
+	m.refreshIfStale(context.Background())
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(m.keys))}
+	for _, k := range m.keys {
+		set.Keys = append(set.Keys, toJWK(k))
+	}
+	return set
+}
+
+func (m *KeyManager) lookup(kid string) *loadedKey {
+	m.refreshIfStale(context.Background())
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.keys[kid]
+}
+
+func (m *KeyManager) refreshIfStale(ctx context.Context) {
+	m.mu.RLock()
+	stale := time.Since(m.lastRefresh) > keyRefreshInterval
+	m.mu.RUnlock()
+	if stale {
+		m.refresh(ctx)
+	}
+}
+
+// missRefreshDue reports whether an unknown kid may reload the key set now,
+// and if so records that it did.
+func (m *KeyManager) missRefreshDue() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if time.Since(m.lastRefresh) < keyMissRefresh || time.Since(m.lastMiss) < keyMissRefresh {
+		return false
+	}
+	m.lastMiss = time.Now()
+	return true
+}
+
+func (m *KeyManager) refresh(ctx context.Context) {
+	if err := m.load(ctx); err != nil {
+		log.Printf("[keys] reloading signing keys failed: %v", err)
+	}
+}
+
+func (m *KeyManager) load(ctx context.Context) error {
+	list, err := m.repo.ListUsable(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*loadedKey, len(list))
+	var current *loadedKey
+	for i := range list {
+		k, err := m.openSigningKey(ctx, &list[i])
+		if err != nil {
+			log.Printf("[keys] skipping key %s: %v", list[i].Kid, err)
+			continue
+		}
+		keys[k.kid] = k
+		// list is newest first
+		if current == nil && !k.retired {
+			current = k
+		}
+	}
+
+	m.mu.Lock()
+	m.keys = keys
+	m.current = current
+	m.lastRefresh = time.Now()
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *KeyManager) rotationLoop() {
+	t := time.NewTicker(keyRotationCheck)
+	defer t.Stop()
+	for range t.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := m.rotateIfDue(ctx); err != nil {
+			log.Printf("[keys] rotation failed: %v", err)
+		}
+		cancel()
+	}
+}
+
+// rotateIfDue creates a new signing key when there is none, the current one
+// is older than the rotation interval, or JWT_SIGNING_ALG changed. Older keys
+// are retired with a grace period. Only the instance holding the rotation
+// lease rotates; the others pick up its key.
+func (m *KeyManager) rotateIfDue(ctx context.Context) error {
+	if err := m.load(ctx); err != nil {
+		return err
+	}
+	if !m.rotationDue() {
+		return m.repo.DeleteExpired(ctx)
+	}
+
+	holder := primitive.NewObjectID().Hex()
+	for i := 0; ; i++ {
+		ok, err := m.leases.Acquire(ctx, keyRotationLease, holder, keyRotationLeaseTTL)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+		// Someone else is rotating. Keep the current key if there is one;
+		// without any key, wait for theirs.
+		if m.hasCurrent() {
+			return nil
+		}
+		if i >= keyRotationAttempts {
+			return errors.New("timed out waiting for another instance to create a signing key")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(keyRotationWait):
+		}
+		if err := m.load(ctx); err != nil {
+			return err
+		}
+		if m.hasCurrent() && !m.rotationDue() {
+			return nil
+		}
+	}
+	defer func() {
+		if err := m.leases.Release(context.Background(), keyRotationLease, holder); err != nil {
+			log.Printf("[keys] release rotation lease: %v", err)
+		}
+	}()
+
+	// The previous holder may have rotated just before we got the lease.
+	if err := m.load(ctx); err != nil {
+		return err
+	}
+	if !m.rotationDue() {
+		return m.repo.DeleteExpired(ctx)
+	}
+
+	k, err := generateSigningKey(m.alg)
+	if err != nil {
+		return err
+	}
+	if k.PrivateKeyEnc, err = m.seal(k.Kid, k.PrivateKeyPEM); err != nil {
+		return err
+	}
+	k.PrivateKeyPEM = ""
+	if err := m.repo.Create(ctx, k); err != nil {
+		return err
+	}
+	if err := m.repo.RetireAllExcept(ctx, k.Kid, time.Now().Add(keyGracePeriod)); err != nil {
+		return err
+	}
+	log.Printf("[keys] rotated signing key, new kid %s (%s)", k.Kid, k.Alg)
+
+	if err := m.repo.DeleteExpired(ctx); err != nil {
+		return err
+	}
+	return m.load(ctx)
+}
+
+func (m *KeyManager) rotationDue() bool {
+	m.mu.RLock()
+	cur := m.current
+	m.mu.RUnlock()
+	return cur == nil || cur.alg != m.alg || time.Since(cur.createdAt) >= m.rotateEvery
+}
+
+func (m *KeyManager) hasCurrent() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.current != nil
+}
+
+func generateSigningKey(alg string) (*models.SigningKey, error) {
+	var (
+		priv crypto.Signer
+		err  error
+	)
+	switch alg {
+	case jwt.SigningMethodEdDSA.Alg():
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	case jwt.SigningMethodRS256.Alg():
+		priv, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(priv.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	kidBytes := make([]byte, 8)
+	if _, err := rand.Read(kidBytes); err != nil {
+		return nil, err
+	}
+
+	return &models.SigningKey{
+		Kid:           hex.EncodeToString(kidBytes),
+		Alg:           alg,
+		PrivateKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})),
+		PublicKeyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})),
+		CreatedAt:     time.Now(),
+	}, nil
+}
+
+// openSigningKey decrypts and parses sk. A key still stored in plaintext is
+// encrypted in place.
+func (m *KeyManager) openSigningKey(ctx context.Context, sk *models.SigningKey) (*loadedKey, error) {
+	if sk.PrivateKeyEnc != "" {
+		privPEM, err := m.open(sk.Kid, sk.PrivateKeyEnc)
+		if err != nil {
+			return nil, err
+		}
+		return parseSigningKey(sk, privPEM)
+	}
+
+	k, err := parseSigningKey(sk, sk.PrivateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := m.seal(sk.Kid, sk.PrivateKeyPEM)
+	if err == nil {
+		err = m.repo.SealPrivateKey(ctx, sk.Kid, enc)
+	}
+	if err != nil {
+		log.Printf("[keys] encrypting key %s: %v", sk.Kid, err)
+	}
+	return k, nil
+}
+
+// keyEncryptionAEAD reads the key that encrypts private keys at rest from
+// JWT_KEY_ENCRYPTION_KEY.
+func keyEncryptionAEAD() (cipher.AEAD, error) {
+	v := strings.TrimSpace(os.Getenv("JWT_KEY_ENCRYPTION_KEY"))
+	if v == "" {
+		return nil, errors.New("JWT_KEY_ENCRYPTION_KEY is required: set it to 32 random bytes in base64, e.g. from `openssl rand -base64 32` (see README)")
+	}
+	raw, err := base64.StdEncoding.DecodeString(v)
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("JWT_KEY_ENCRYPTION_KEY must be 32 bytes in base64")
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts a private key PEM with AES-GCM, bound to its kid so that
+// ciphertexts can't be swapped between keys.
+func (m *KeyManager) seal(kid, privPEM string) (string, error) {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	out := m.aead.Seal(nonce, nonce, []byte(privPEM), []byte(kid))
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+func (m *KeyManager) open(kid, enc string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return "", err
+	}
+	n := m.aead.NonceSize()
+	if len(raw) < n {
+		return "", errors.New("encrypted private key too short")
+	}
+	plain, err := m.aead.Open(nil, raw[:n], raw[n:], []byte(kid))
+	if err != nil {
+		return "", errors.New("cannot decrypt private key; check JWT_KEY_ENCRYPTION_KEY")
+	}
+	return string(plain), nil
+}
+
+func parseSigningKey(sk *models.SigningKey, privPEM string) (*loadedKey, error) {
+	block, _ := pem.Decode([]byte(privPEM))
+	if block == nil {
+		return nil, errors.New("invalid private key PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("private key cannot sign")
+	}
+
+	k := &loadedKey{
+		kid:       sk.Kid,
+		alg:       sk.Alg,
+		private:   priv,
+		public:    priv.Public(),
+		createdAt: sk.CreatedAt,
+		retired:   sk.RetiredAt != nil,
+	}
+	switch sk.Alg {
+	case jwt.SigningMethodEdDSA.Alg():
+		if _, ok := priv.(ed25519.PrivateKey); !ok {
+			return nil, errors.New("key type does not match EdDSA")
+		}
+		k.method = jwt.SigningMethodEdDSA
+	case jwt.SigningMethodRS256.Alg():
+		if _, ok := priv.(*rsa.PrivateKey); !ok {
+			return nil, errors.New("key type does not match RS256")
+		}
+		k.method = jwt.SigningMethodRS256
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %s", sk.Alg)
+	}
+	return k, nil
+}
+
+func toJWK(k *loadedKey) JWK {
+	j := JWK{Kid: k.kid, Alg: k.alg, Use: "sig"}
+	switch pub := k.public.(type) {
+	case ed25519.PublicKey:
+		j.Kty = "OKP"
+		j.Crv = "Ed25519"
+		j.X = base64.RawURLEncoding.EncodeToString(pub)
+	case *rsa.PublicKey:
+		j.Kty = "RSA"
+		j.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		j.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	}
+	return j
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestKeyEncryptionRoundTrip(t *testing.T) {
+	t.Setenv("JWT_KEY_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))))
+	aead, err := keyEncryptionAEAD()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &KeyManager{aead: aead}
+
+	k, err := generateSigningKey("EdDSA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := m.seal(k.Kid, k.PrivateKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(enc, "PRIVATE KEY") {
+		t.Fatal("sealed key contains the PEM")
+	}
+	got, err := m.open(k.Kid, enc)
+	if err != nil || got != k.PrivateKeyPEM {
+		t.Fatalf("open = %q, %v", got, err)
+	}
+	if _, err := m.open("other", enc); err == nil {
+		t.Fatal("opened a key under another kid")
+	}
+}
+
+func TestKeyEncryptionKeyRequired(t *testing.T) {
+	t.Setenv("JWT_KEY_ENCRYPTION_KEY", "")
+	if _, err := keyEncryptionAEAD(); err == nil {
+		t.Fatal("missing JWT_KEY_ENCRYPTION_KEY accepted")
+	}
+	t.Setenv("JWT_KEY_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString([]byte("short")))
+	if _, err := keyEncryptionAEAD(); err == nil {
+		t.Fatal("short JWT_KEY_ENCRYPTION_KEY accepted")
+	}
+}
+
+func TestPreAuthTokenIsNotAnAccessToken(t *testing.T) {
+	t.Setenv("JWT_KEY_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	keys, err := NewKeyManager(context.Background(), &memSigningKeys{}, memLeases{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &AuthService{keys: keys}
+	userID := primitive.NewObjectID()
+
+	pre, err := auth.signPreAuthJWT(userID, TwoFactorStepVerify)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(pre); err == nil {
+		t.Fatal("pre-auth token accepted as an access token")
+	}
+	if id, step, err := auth.parsePreAuth(pre); err != nil || id != userID || step != TwoFactorStepVerify {
+		t.Fatalf("parsePreAuth = %v, %q, %v", id, step, err)
+	}
+
+	access, err := auth.signJWT(userID, "customer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := auth.parsePreAuth(access); err == nil {
+		t.Fatal("access token accepted as a pre-auth token")
+	}
+}
+
+func TestLegacyHS256Tokens(t *testing.T) {
+	t.Setenv("JWT_KEY_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":  primitive.NewObjectID().Hex(),
+		"role": "customer",
+		"iat":  time.Now().Unix(),
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("old-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("JWT_LEGACY_SECRET", "")
+	keys, err := NewKeyManager(context.Background(), &memSigningKeys{}, memLeases{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(legacy); err == nil {
+		t.Fatal("legacy token accepted without JWT_LEGACY_SECRET")
+	}
+
+	t.Setenv("JWT_LEGACY_SECRET", "old-secret")
+	keys, err = NewKeyManager(context.Background(), &memSigningKeys{}, memLeases{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Parse(legacy); err != nil {
+		t.Fatalf("legacy token rejected: %v", err)
+	}
+	if _, err := keys.ParsePreAuth(legacy); err == nil {
+		t.Fatal("legacy token accepted as a pre-auth token")
+	}
+
+	now := time.Now()
+	for name, claims := range map[string]jwt.MapClaims{
+		"far-future exp": {"sub": "x", "iat": now.Unix(), "exp": now.Add(365 * 24 * time.Hour).Unix()},
+		"no iat":         {"sub": "x", "exp": now.Add(time.Hour).Unix()},
+		"future iat":     {"sub": "x", "iat": now.Add(time.Hour).Unix(), "exp": now.Add(2 * time.Hour).Unix()},
+	} {
+		tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("old-secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := keys.Parse(tok); err == nil {
+			t.Errorf("legacy token with %s accepted", name)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type throttlePolicy struct {
+	// FreeAttempts failures are allowed before any delay is applied.
+	FreeAttempts int
+	// After MaxFailures the key is locked for Lockout.
+	MaxFailures int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Lockout     time.Duration
+}
+
+var (
+	accountThrottle = throttlePolicy{
+		FreeAttempts: 3,
+		MaxFailures:  10,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		Lockout:      15 * time.Minute,
+	}
+	// Shared IPs (store wifi, offices) get more room before they are blocked.
+	ipThrottle = throttlePolicy{
+		FreeAttempts: 10,
+		MaxFailures:  50,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		Lockout:      30 * time.Minute,
+	}
+)
+
+// LockedError is returned while a login key is backing off or locked out.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// LoginGuard tracks failed logins per account and per IP in MongoDB so the
+// limits hold across restarts and multiple instances.
+type LoginGuard struct {
+	repo repository.LoginThrottleRepo
+}
+
+func NewLoginGuard(repo repository.LoginThrottleRepo) *LoginGuard {
+	return &LoginGuard{repo: repo}
+}
+
+func accountKey(email string) string { return "email:" + strings.ToLower(strings.TrimSpace(email)) }
+func ipKey(ip string) string         { return "ip:" + ip }
+
+// Check returns a *LockedError if either the account or the IP is currently
+// blocked.
+func (g *LoginGuard) Check(ctx context.Context, email, ip string) error {
+	var wait time.Duration
+	for _, key := range g.keys(email, ip) {
+		t, err := g.repo.Get(ctx, key)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if t.LockedUntil != nil {
+			if d := time.Until(*t.LockedUntil); d > wait {
+				wait = d
+			}
+		}
+	}
+	if wait > 0 {
+		return &LockedError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Failure records a failed attempt and returns how long the caller has to
+// wait before trying again (zero while still within the free attempts).
+func (g *LoginGuard) Failure(ctx context.Context, email, ip string) (time.Duration, error) {
+	var wait time.Duration
+	for _, key := range g.keys(email, ip) {
+		policy := accountThrottle
+		if strings.HasPrefix(key, "ip:") {
+			policy = ipThrottle
+		}
+
+		t, err := g.repo.RecordFailure(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		d := policy.delay(t.Failures)
+		if d <= 0 {
+			continue
+		}
+		if err := g.repo.SetLockedUntil(ctx, key, time.Now().Add(d)); err != nil {
+			return 0, err
+		}
+		if d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// Success clears the account counter. The IP counter is left to expire so a
+// single valid account can't be used to reset it.
+func (g *LoginGuard) Success(ctx context.Context, email string) error {
+	return g.repo.Reset(ctx, accountKey(email))
+}
+
+func (g *LoginGuard) UnlockAccount(ctx context.Context, email string) error {
+	return g.repo.Reset(ctx, accountKey(email))
+}
+
+func (g *LoginGuard) UnlockIP(ctx context.Context, ip string) error {
+	return g.repo.Reset(ctx, ipKey(ip))
+}
+
+func (g *LoginGuard) keys(email, ip string) []string {
+	keys := []string{accountKey(email)}
+	if ip != "" {
+		keys = append(keys, ipKey(ip))
+	}
+	return keys
+}
+
+// delay doubles with every failure past FreeAttempts and turns into a full
+// lockout once MaxFailures is reached.
+func (p throttlePolicy) delay(failures int) time.Duration {
+	if failures >= p.MaxFailures {
+		return p.Lockout
+	}
+	over := failures - p.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	d := p.BaseDelay
+	for i := 1; i < over; i++ {
+		d *= 2
+		if d >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return d
+}
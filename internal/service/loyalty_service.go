@@ -0,0 +1,436 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrLoyaltyDisabled        = errors.New("the loyalty program is not running")
+	ErrInvalidLoyaltySettings = errors.New("invalid loyalty settings")
+	ErrNotEnoughPoints        = errors.New("not enough loyalty points")
+)
+
+// Order statuses that count as completed for earning points.
+var loyaltyCompletedStatuses = []string{"DONE", models.OrderDelivered}
+
+// LoyaltyService keeps each customer's points ledger: points are earned when
+// an order completes, spent as a discount at checkout, lapse after the
+// configured number of days and are adjusted automatically when an order is
+// refunded or cancelled.
+type LoyaltyService struct {
+	repo     repository.LoyaltyRepo
+	orders   repository.OrderRepo
+	products repository.ProductRepo
+}
+
+func NewLoyaltyService(repo repository.LoyaltyRepo, orders repository.OrderRepo, products repository.ProductRepo) *LoyaltyService {
+	return &LoyaltyService{repo: repo, orders: orders, products: products}
+}
+
+// DefaultLoyaltySettings are used until settings are saved; the program is
+// off until staff turn it on.
+func DefaultLoyaltySettings() *models.LoyaltySettings {
+	return &models.LoyaltySettings{
+		SpendPerPoint: models.NewMoney(100, 0),
+		PointValue:    models.NewMoney(1, 0),
+		ExpiryDays:    365,
+		Multipliers:   []models.LoyaltyMultiplier{},
+	}
+}
+
+func (s *LoyaltyService) Settings(ctx context.Context) (*models.LoyaltySettings, error) {
+	st, err := s.repo.GetSettings(ctx)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return DefaultLoyaltySettings(), nil
+	}
+	return st, err
+}
+
+func (s *LoyaltyService) UpdateSettings(ctx context.Context, st *models.LoyaltySettings) error {
+	if st.SpendPerPoint <= 0 || st.PointValue <= 0 {
+		return fmt.Errorf("%w: spendPerPoint and pointValue must be positive", ErrInvalidLoyaltySettings)
+	}
+	if st.ExpiryDays < 0 {
+		return fmt.Errorf("%w: expiryDays must not be negative", ErrInvalidLoyaltySettings)
+	}
+	seen := map[primitive.ObjectID]bool{}
+	for _, m := range st.Multipliers {
+		if m.CategoryID.IsZero() || m.BasisPoints < 0 {
+			return fmt.Errorf("%w: multipliers need a categoryId and non-negative basisPoints", ErrInvalidLoyaltySettings)
+		}
+		if seen[m.CategoryID] {
+			return fmt.Errorf("%w: category %s has two multipliers", ErrInvalidLoyaltySettings, m.CategoryID.Hex())
+		}
+		seen[m.CategoryID] = true
+	}
+	if st.Multipliers == nil {
+		st.Multipliers = []models.LoyaltyMultiplier{}
+	}
+	return s.repo.SaveSettings(ctx, st)
+}
+
+// LoyaltyStatement is the points balance with the movements behind it,
+// newest first.
+type LoyaltyStatement struct {
+	Balance    int64                 `json:"balance"`
+	PointValue models.Money          `json:"pointValue"`
+	Entries    []models.LoyaltyEntry `json:"entries"`
+}
+
+func (s *LoyaltyService) Statement(ctx context.Context, userID primitive.ObjectID) (*LoyaltyStatement, error) {
+	st, err := s.Settings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.expire(ctx, userID, time.Now()); err != nil {
+		return nil, err
+	}
+	b, err := s.repo.Balance(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.repo.ListByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(entries)
+	return &LoyaltyStatement{Balance: b, PointValue: st.PointValue, Entries: entries}, nil
+}
+
+// Quote works out how many of points can go towards goods worth max and
+// what they take off. It doesn't look at the balance; Spend does.
+func (s *LoyaltyService) Quote(ctx context.Context, points int64, max models.Money) (int64, models.Money, error) {
+	st, err := s.Settings(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !st.Enabled {
+		return 0, 0, ErrLoyaltyDisabled
+	}
+	if max <= 0 {
+		return 0, 0, nil
+	}
+	used := min(points, int64(max)/int64(st.PointValue))
+	return used, st.PointValue.Mul(int(used)), nil
+}
+
+// Spend takes points from userID's balance for orderID.
+func (s *LoyaltyService) Spend(ctx context.Context, userID, orderID primitive.ObjectID, points int64) error {
+	if err := s.expire(ctx, userID, time.Now()); err != nil {
+		return err
+	}
+	ok, err := s.repo.Spend(ctx, &models.LoyaltyEntry{
+		UserID:  userID,
+		Type:    models.LoyaltyRedeem,
+		Points:  -points,
+		OrderID: orderID,
+		Note:    "Spent at checkout",
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotEnoughPoints
+	}
+	return nil
+}
+
+// Unspend gives back points taken by Spend for an order that wasn't placed.
+func (s *LoyaltyService) Unspend(ctx context.Context, userID, orderID primitive.ObjectID, points int64) error {
+	st, err := s.Settings(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = s.repo.Add(ctx, restoreEntry(st, userID, orderID, points, "Order not placed"))
+	return err
+}
+
+// OrderCompleted awards the points for a completed order, logging failures;
+// it is meant to be called by whatever completes the order.
+func (s *LoyaltyService) OrderCompleted(orderID primitive.ObjectID) {
+	if err := s.Award(context.Background(), orderID); err != nil {
+		log.Printf("[loyalty] award points for order %s: %v", orderID.Hex(), err)
+	}
+}
+
+// Award credits the points earned by a completed order, once. Anything
+// refunded before completion is taken off straight away.
+func (s *LoyaltyService) Award(ctx context.Context, orderID primitive.ObjectID) error {
+	st, err := s.Settings(ctx)
+	if err != nil || !st.Enabled {
+		return err
+	}
+	o, err := s.orders.FindByID(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if o.UserID.IsZero() || !slices.Contains(loyaltyCompletedStatuses, o.Status) {
+		return nil
+	}
+
+	base, err := s.earnBase(ctx, o, st, func(it models.OrderItem) int { return it.Quantity })
+	if err != nil {
+		return err
+	}
+	points := int64(base) / int64(st.SpendPerPoint)
+	if points <= 0 {
+		return nil
+	}
+	if _, err := s.repo.Add(ctx, &models.LoyaltyEntry{
+		UserID:    o.UserID,
+		Type:      models.LoyaltyEarn,
+		Points:    points,
+		OrderID:   o.ID,
+		ExpiresAt: expiryFrom(st, time.Now()),
+		Note:      "Earned on order",
+	}); err != nil {
+		return err
+	}
+	return s.reconcile(ctx, o, st)
+}
+
+// Reconcile brings an order's points in line with what is left of it after
+// refunds or cancellation: earned points are taken back and spent points
+// given back in proportion to the goods returned.
+func (s *LoyaltyService) Reconcile(ctx context.Context, orderID primitive.ObjectID) error {
+	st, err := s.Settings(ctx)
+	if err != nil {
+		return err
+	}
+	o, err := s.orders.FindByID(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	return s.reconcile(ctx, o, st)
+}
+
+func (s *LoyaltyService) reconcile(ctx context.Context, o *models.Order, st *models.LoyaltySettings) error {
+	// Each pass works from one snapshot of the ledger and only writes if
+	// nothing was added since, so two reconciles can't both take back the
+	// same points; the loser starts over from the new ledger.
+	for {
+		done, err := s.reconcileAt(ctx, o, st)
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+func (s *LoyaltyService) reconcileAt(ctx context.Context, o *models.Order, st *models.LoyaltySettings) (bool, error) {
+	seq, err := s.repo.Head(ctx, o.UserID)
+	if err != nil {
+		return false, err
+	}
+	entries, err := s.repo.ListByOrderID(ctx, o.ID)
+	if err != nil {
+		return false, err
+	}
+	var earned, reversed, spent, restored int64
+	var earnID primitive.ObjectID
+	for _, e := range entries {
+		switch e.Type {
+		case models.LoyaltyEarn:
+			earned += e.Points
+			earnID = e.ID
+		case models.LoyaltyReverse:
+			reversed -= e.Points
+		case models.LoyaltyRedeem:
+			spent -= e.Points
+		case models.LoyaltyRestore:
+			restored += e.Points
+		}
+	}
+	cancelled := o.Status == models.OrderCancelled
+
+	if earned > 0 {
+		full, err := s.earnBase(ctx, o, st, func(it models.OrderItem) int { return it.Quantity })
+		if err != nil {
+			return false, err
+		}
+		left, err := s.earnBase(ctx, o, st, func(it models.OrderItem) int { return it.Quantity - it.RefundedQuantity })
+		if err != nil {
+			return false, err
+		}
+		keep := int64(0)
+		if !cancelled && full > 0 {
+			keep = int64(models.Money(earned).MulRatio(int64(left), int64(full)))
+			keep = min(keep, earned)
+		}
+		if n := earned - keep - reversed; n > 0 {
+			ok, err := s.repo.AddAfter(ctx, &models.LoyaltyEntry{
+				UserID:  o.UserID,
+				Type:    models.LoyaltyReverse,
+				Points:  -n,
+				OrderID: o.ID,
+				LotID:   earnID,
+				Note:    "Taken back after a refund",
+			}, seq)
+			if !ok || err != nil {
+				return false, err
+			}
+			seq++
+		}
+	}
+
+	if spent > 0 {
+		giveBack := spent
+		if !cancelled && o.Status != models.OrderRefunded {
+			var goods, returned models.Money
+			for _, it := range o.Items {
+				goods += it.Price.Mul(it.Quantity) - it.Discount
+				returned += it.Price.Mul(it.RefundedQuantity) - it.Discount.MulRatio(int64(it.RefundedQuantity), int64(it.Quantity))
+			}
+			giveBack = 0
+			if goods > 0 {
+				giveBack = int64(models.Money(spent).MulRatio(int64(returned), int64(goods)))
+			}
+		}
+		note := "Given back after a refund"
+		if cancelled {
+			note = "Given back, order cancelled"
+		}
+		if n := giveBack - restored; n > 0 {
+			return s.repo.AddAfter(ctx, restoreEntry(st, o.UserID, o.ID, n, note), seq)
+		}
+	}
+	return true, nil
+}
+
+// restoreEntry gives back points spent on orderID as a new lot.
+func restoreEntry(st *models.LoyaltySettings, userID, orderID primitive.ObjectID, points int64, note string) *models.LoyaltyEntry {
+	return &models.LoyaltyEntry{
+		UserID:    userID,
+		Type:      models.LoyaltyRestore,
+		Points:    points,
+		OrderID:   orderID,
+		ExpiresAt: expiryFrom(st, time.Now()),
+		Note:      note,
+	}
+}
+
+// earnBase is the spend that earns points on units of each line: the
+// discounted line price, scaled by the category multipliers.
+func (s *LoyaltyService) earnBase(ctx context.Context, o *models.Order, st *models.LoyaltySettings, units func(models.OrderItem) int) (models.Money, error) {
+	mult := map[primitive.ObjectID]int64{}
+	for _, m := range st.Multipliers {
+		mult[m.CategoryID] = m.BasisPoints
+	}
+
+	var base models.Money
+	for _, it := range o.Items {
+		n := units(it)
+		if n <= 0 {
+			continue
+		}
+		net := it.Price.Mul(n) - it.Discount.MulRatio(int64(n), int64(it.Quantity))
+		bp := int64(10000)
+		if len(mult) > 0 {
+			p, err := s.products.FindByID(ctx, it.ProductID)
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				return 0, err
+			}
+			if p != nil {
+				if m, ok := mult[p.CategoryID]; ok {
+					bp = m
+				}
+			}
+		}
+		base += net.MulRatio(bp, 10000)
+	}
+	return base, nil
+}
+
+// expire writes off the points of userID's lots that lapsed by now.
+func (s *LoyaltyService) expire(ctx context.Context, userID primitive.ObjectID, now time.Time) error {
+	entries, err := s.repo.ListByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, l := range loyaltyLots(entries) {
+		if l.expiresAt == nil || l.expiresAt.After(now) || l.left <= 0 {
+			continue
+		}
+		// The unique index on EXPIRE lots makes a concurrent second
+		// write-off a no-op.
+		if _, err := s.repo.Add(ctx, &models.LoyaltyEntry{
+			UserID: userID,
+			Type:   models.LoyaltyExpire,
+			Points: -l.left,
+			LotID:  l.id,
+			Note:   "Points expired",
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type loyaltyLot struct {
+	id        primitive.ObjectID
+	expiresAt *time.Time
+	left      int64
+}
+
+// loyaltyLots replays a ledger, oldest first, and returns what is left of
+// each lot of points. Expiries and reversals come out of their own lot;
+// spending and any remainder come out of the lots expiring soonest.
+func loyaltyLots(entries []models.LoyaltyEntry) []*loyaltyLot {
+	var lots []*loyaltyLot
+	byID := map[primitive.ObjectID]*loyaltyLot{}
+	take := func(n int64) {
+		for _, l := range lots {
+			if n <= 0 {
+				return
+			}
+			d := min(n, l.left)
+			l.left -= d
+			n -= d
+		}
+	}
+
+	for _, e := range entries {
+		switch e.Type {
+		case models.LoyaltyEarn, models.LoyaltyRestore:
+			if e.Points <= 0 {
+				continue
+			}
+			l := &loyaltyLot{id: e.ID, expiresAt: e.ExpiresAt, left: e.Points}
+			lots = append(lots, l)
+			byID[e.ID] = l
+			sort.SliceStable(lots, func(i, j int) bool {
+				a, b := lots[i].expiresAt, lots[j].expiresAt
+				return a != nil && (b == nil || a.Before(*b))
+			})
+		case models.LoyaltyExpire, models.LoyaltyReverse:
+			n := -e.Points
+			if l := byID[e.LotID]; l != nil {
+				d := min(n, l.left)
+				l.left -= d
+				n -= d
+			}
+			take(n)
+		case models.LoyaltyRedeem:
+			take(-e.Points)
+		}
+	}
+	return lots
+}
+
+func expiryFrom(st *models.LoyaltySettings, t time.Time) *time.Time {
+	if st.ExpiryDays == 0 {
+		return nil
+	}
+	at := t.AddDate(0, 0, st.ExpiryDays)
+	return &at
+}
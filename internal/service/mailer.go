@@ -0,0 +1,20 @@
+package service
+
+import (
+	"context"
+	"log"
+)
+
+// Mailer sends transactional emails (verification links etc).
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// LogMailer writes emails to the server log. It is used until a real SMTP
+// provider is configured.
+type LogMailer struct{}
+
+func (LogMailer) Send(_ context.Context, to, subject, body string) error {
+	log.Printf("[mail] to=%s subject=%q\n%s", to, subject, body)
+	return nil
+}
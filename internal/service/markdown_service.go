@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// How often the scheduler brings markdowns up to date. Markdowns move on
+// at midnight, so this is how late they can be.
+const markdownCheck = 10 * time.Minute
+
+var (
+	ErrInvalidMarkdownSettings = errors.New("invalid markdown settings")
+	ErrInvalidBatch            = errors.New("invalid batch")
+	ErrBatchNotFound           = errors.New("open batch not found")
+	ErrBatchProductNotFound    = errors.New("product not found")
+)
+
+// MarkdownService keeps track of product batches and reduces products whose
+// oldest batch nears its best-before date, putting the price back once the
+// batch is cleared.
+type MarkdownService struct {
+	repo     repository.MarkdownRepo
+	products repository.ProductRepo
+}
+
+// NewMarkdownService starts the scheduler in the background.
+func NewMarkdownService(repo repository.MarkdownRepo, products repository.ProductRepo) *MarkdownService {
+	s := &MarkdownService{repo: repo, products: products}
+	go s.schedulerLoop()
+	return s
+}
+
+// DefaultMarkdownSettings are used until staff save their own: 30% off two
+// days before the best-before date and 50% on the day.
+func DefaultMarkdownSettings() *models.MarkdownSettings {
+	return &models.MarkdownSettings{
+		Enabled: true,
+		Rules: []models.MarkdownRule{
+			{DaysBefore: 0, BasisPoints: 5000},
+			{DaysBefore: 2, BasisPoints: 3000},
+		},
+	}
+}
+
+func (s *MarkdownService) Settings(ctx context.Context) (*models.MarkdownSettings, error) {
+	st, err := s.repo.GetSettings(ctx)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return DefaultMarkdownSettings(), nil
+	}
+	return st, err
+}
+
+// UpdateSettings saves st and brings markdowns in line with it at once.
+func (s *MarkdownService) UpdateSettings(ctx context.Context, st *models.MarkdownSettings) error {
+	seen := map[int]bool{}
+	for _, rule := range st.Rules {
+		if rule.DaysBefore < 0 {
+			return fmt.Errorf("%w: daysBefore must not be negative", ErrInvalidMarkdownSettings)
+		}
+		if rule.BasisPoints <= 0 || rule.BasisPoints > 10000 {
+			return fmt.Errorf("%w: basisPoints must be between 1 and 10000", ErrInvalidMarkdownSettings)
+		}
+		if seen[rule.DaysBefore] {
+			return fmt.Errorf("%w: two rules for %d days before", ErrInvalidMarkdownSettings, rule.DaysBefore)
+		}
+		seen[rule.DaysBefore] = true
+	}
+	if st.Rules == nil {
+		st.Rules = []models.MarkdownRule{}
+	}
+	sort.Slice(st.Rules, func(i, j int) bool { return st.Rules[i].DaysBefore < st.Rules[j].DaysBefore })
+	if err := s.repo.SaveSettings(ctx, st); err != nil {
+		return err
+	}
+	return s.Apply(ctx, time.Now())
+}
+
+func (s *MarkdownService) Batches(ctx context.Context, productID primitive.ObjectID, openOnly bool) ([]models.ProductBatch, error) {
+	return s.repo.ListBatches(ctx, productID, openOnly)
+}
+
+// Receive records a new batch of a product.
+func (s *MarkdownService) Receive(ctx context.Context, b *models.ProductBatch, staffID primitive.ObjectID) error {
+	b.Lot = strings.TrimSpace(b.Lot)
+	b.BestBefore = strings.TrimSpace(b.BestBefore)
+	if b.Quantity <= 0 {
+		return fmt.Errorf("%w: quantity must be positive", ErrInvalidBatch)
+	}
+	if _, err := time.Parse(models.BestBeforeLayout, b.BestBefore); err != nil {
+		return fmt.Errorf("%w: bestBefore must be a date like 2006-01-02", ErrInvalidBatch)
+	}
+	if _, err := s.products.FindByID(ctx, b.ProductID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrBatchProductNotFound
+		}
+		return err
+	}
+	b.ID = primitive.NilObjectID
+	b.ClearedAt = nil
+	b.ReceivedBy = staffID
+	id, err := s.repo.CreateBatch(ctx, b)
+	if err != nil {
+		return err
+	}
+	b.ID = id
+	return s.Apply(ctx, time.Now())
+}
+
+// Clear closes a batch that has sold out or been written off.
+func (s *MarkdownService) Clear(ctx context.Context, id primitive.ObjectID) error {
+	ok, err := s.repo.ClearBatch(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrBatchNotFound
+	}
+	return s.Apply(ctx, time.Now())
+}
+
+func (s *MarkdownService) schedulerLoop() {
+	t := time.NewTicker(markdownCheck)
+	defer t.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := s.Apply(ctx, time.Now()); err != nil {
+			log.Printf("[markdowns] apply: %v", err)
+		}
+		cancel()
+		<-t.C
+	}
+}
+
+// Apply marks down each product whose oldest unexpired open batch is
+// covered by a rule as of at, and ends the markdowns that no longer apply.
+func (s *MarkdownService) Apply(ctx context.Context, at time.Time) error {
+	st, err := s.Settings(ctx)
+	if err != nil {
+		return err
+	}
+	want := map[primitive.ObjectID]*models.Markdown{}
+	if st.Enabled && len(st.Rules) > 0 {
+		batches, err := s.repo.ListBatches(ctx, primitive.NilObjectID, true)
+		if err != nil {
+			return err
+		}
+		seen := map[primitive.ObjectID]bool{}
+		for _, b := range batches {
+			if seen[b.ProductID] {
+				continue
+			}
+			days, ok := daysToBestBefore(b.BestBefore, at)
+			if !ok || days < 0 {
+				continue // expired stock is for staff to write off
+			}
+			seen[b.ProductID] = true
+			if rule := markdownRuleFor(st.Rules, days); rule != nil {
+				want[b.ProductID] = &models.Markdown{
+					BatchID:     b.ID,
+					BestBefore:  b.BestBefore,
+					BasisPoints: rule.BasisPoints,
+					Since:       at,
+				}
+			}
+		}
+	}
+
+	current, err := s.products.ListMarkedDown(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range current {
+		m, ok := want[p.ID]
+		if ok && m.BatchID == p.Markdown.BatchID && m.BasisPoints == p.Markdown.BasisPoints {
+			delete(want, p.ID)
+			continue
+		}
+		if !ok {
+			if err := s.products.SetMarkdown(ctx, p.ID, nil); err != nil {
+				return err
+			}
+		}
+	}
+	for id, m := range want {
+		if err := s.products.SetMarkdown(ctx, id, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markdownRuleFor is the rule for days days before the best-before date:
+// the one starting closest to it. rules are sorted by DaysBefore.
+func markdownRuleFor(rules []models.MarkdownRule, days int) *models.MarkdownRule {
+	for i := range rules {
+		if rules[i].DaysBefore >= days {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// daysToBestBefore counts the days from at's date in the store's timezone
+// to bestBefore: 0 on the day itself, negative once it has passed.
+func daysToBestBefore(bestBefore string, at time.Time) (int, bool) {
+	bb, err := time.Parse(models.BestBeforeLayout, bestBefore)
+	if err != nil {
+		return 0, false
+	}
+	y, m, d := at.In(storeLocation()).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	return int(bb.Sub(today).Hours() / 24), true
+}
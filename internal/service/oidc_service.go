@@ -0,0 +1,671 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrOIDCInvalidState   = errors.New("invalid or expired login state")
+	ErrOIDCProvider       = errors.New("identity provider error")
+	ErrOIDCInvalidToken   = errors.New("invalid id token")
+	ErrOIDCEmailRequired  = errors.New("identity provider did not return an email")
+	ErrOIDCEmailConflict  = errors.New("an account with this email already exists")
+	ErrOIDCSignupDisabled = errors.New("no account is linked to this identity")
+)
+
+const (
+	oidcDiscoveryTTL    = time.Hour
+	oidcJWKSMinRefresh  = time.Minute
+	oidcClockSkew       = time.Minute
+	oidcHTTPTimeout     = 10 * time.Second
+	oidcMaxResponseSize = 1 << 20
+)
+
+// OIDCConfig describes one OpenID Connect provider. Any provider that
+// publishes a discovery document works, including a local stub for
+// development.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// RoleClaim names the claim holding the user's groups or roles. Nested
+	// claims use dots, e.g. "realm_access.roles".
+	RoleClaim string
+	// RoleMap maps claim values to local roles. When it is non-empty the
+	// provider is authoritative and roles are re-synced on every login.
+	RoleMap     map[string]string
+	DefaultRole string
+
+	// AllowSignup creates local users for identities that aren't linked yet.
+	AllowSignup bool
+}
+
+// OIDCConfigFromEnv reads the provider settings. It returns nil when
+// OIDC_ISSUER is unset, which disables OIDC login.
+//
+//	OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_REDIRECT_URL   required
+//	OIDC_CLIENT_SECRET                               empty for public clients
+//	OIDC_SCOPES                                      default "openid email profile"
+//	OIDC_ROLE_CLAIM                                  default "groups"
+//	OIDC_ROLE_MAP                                    e.g. "shop-admins=admin,shop-staff=staff"
+//	OIDC_DEFAULT_ROLE                                default "customer"
+//	OIDC_ALLOW_SIGNUP                                default "true"
+func OIDCConfigFromEnv() (*OIDCConfig, error) {
+	issuer := strings.TrimRight(strings.TrimSpace(os.Getenv("OIDC_ISSUER")), "/")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	cfg := &OIDCConfig{
+		Issuer:       issuer,
+		ClientID:     strings.TrimSpace(os.Getenv("OIDC_CLIENT_ID")),
+		ClientSecret: strings.TrimSpace(os.Getenv("OIDC_CLIENT_SECRET")),
+		RedirectURL:  strings.TrimSpace(os.Getenv("OIDC_REDIRECT_URL")),
+		Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+		RoleClaim:    strings.TrimSpace(os.Getenv("OIDC_ROLE_CLAIM")),
+		RoleMap:      map[string]string{},
+		DefaultRole:  strings.TrimSpace(os.Getenv("OIDC_DEFAULT_ROLE")),
+		AllowSignup:  os.Getenv("OIDC_ALLOW_SIGNUP") != "false",
+	}
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required when OIDC_ISSUER is set")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.RoleClaim == "" {
+		cfg.RoleClaim = "groups"
+	}
+	if cfg.DefaultRole == "" {
+		cfg.DefaultRole = models.RoleCustomer
+	}
+	if !models.IsValidRole(cfg.DefaultRole) {
+		return nil, fmt.Errorf("invalid OIDC_DEFAULT_ROLE: %q", cfg.DefaultRole)
+	}
+
+	for _, pair := range strings.Split(os.Getenv("OIDC_ROLE_MAP"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, "=")
+		role = strings.TrimSpace(role)
+		if !ok || !models.IsValidRole(role) {
+			return nil, fmt.Errorf("invalid OIDC_ROLE_MAP entry: %q", pair)
+		}
+		cfg.RoleMap[strings.TrimSpace(group)] = role
+	}
+	return cfg, nil
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCService runs the authorization code flow with PKCE against an external
+// provider and signs the user in like a password login, including the local
+// second factor.
+type OIDCService struct {
+	cfg    *OIDCConfig
+	users  repository.UserRepo
+	states repository.OIDCStateRepo
+	auth   *AuthService
+	admins *AdminGuard
+	client *http.Client
+
+	mu           sync.Mutex
+	discovery    *oidcDiscovery
+	discoveredAt time.Time
+	jwks         map[string]crypto.PublicKey
+	jwksAt       time.Time
+}
+
+// NewOIDCService doesn't contact the provider; discovery happens on the first
+// login so the API still starts while the provider is down.
+func NewOIDCService(cfg *OIDCConfig, users repository.UserRepo, states repository.OIDCStateRepo, auth *AuthService, admins *AdminGuard) *OIDCService {
+	return &OIDCService{
+		cfg:    cfg,
+		users:  users,
+		states: states,
+		auth:   auth,
+		admins: admins,
+		client: &http.Client{Timeout: oidcHTTPTimeout},
+	}
+}
+
+// AuthCodeURL starts a login and returns the provider URL to redirect to and
+// the state, which the caller keeps in the browser (a cookie) and passes back
+// to Callback. redirectTo is stored with the state and handed back after the
+// callback.
+func (s *OIDCService) AuthCodeURL(ctx context.Context, redirectTo string) (target, state string, err error) {
+	d, err := s.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err = randomURLString(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomURLString(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomURLString(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = s.states.Create(ctx, &models.OIDCState{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		RedirectTo:   redirectTo,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {s.cfg.ClientID},
+		"redirect_uri":          {s.cfg.RedirectURL},
+		"scope":                 {strings.Join(s.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), state, nil
+}
+
+// Callback finishes the flow: it redeems the code, verifies the ID token and
+// signs in the mapped local user, who still has to pass 2FA if they use it.
+// browserState is the state AuthCodeURL handed to the browser; a callback
+// from a browser that didn't start the login is rejected. It also returns the
+// redirect stored by AuthCodeURL.
+func (s *OIDCService) Callback(ctx context.Context, state, browserState, code string) (*LoginResult, string, error) {
+	if browserState == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		return nil, "", ErrOIDCInvalidState
+	}
+	st, err := s.states.Consume(ctx, state)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, "", ErrOIDCInvalidState
+		}
+		return nil, "", err
+	}
+
+	rawIDToken, err := s.exchange(ctx, code, st.CodeVerifier)
+	if err != nil {
+		return nil, "", err
+	}
+	claims, err := s.verifyIDToken(ctx, rawIDToken, st.Nonce)
+	if err != nil {
+		return nil, "", err
+	}
+
+	u, err := s.resolveUser(ctx, claims)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Disabled {
+		return nil, "", ErrAccountDisabled
+	}
+
+	res, err := s.auth.signIn(u)
+	if err != nil {
+		return nil, "", err
+	}
+	return res, st.RedirectTo, nil
+}
+
+func (s *OIDCService) exchange(ctx context.Context, code, verifier string) (string, error) {
+	d, err := s.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {s.cfg.RedirectURL},
+		"client_id":     {s.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if s.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(s.cfg.ClientID), url.QueryEscape(s.cfg.ClientSecret))
+	}
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := s.doJSON(req, &body)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK || body.IDToken == "" {
+		if body.Error != "" {
+			return "", fmt.Errorf("%w: %s %s", ErrOIDCProvider, body.Error, body.ErrorDescription)
+		}
+		return "", fmt.Errorf("%w: token endpoint returned %d", ErrOIDCProvider, status)
+	}
+	return body.IDToken, nil
+}
+
+func (s *OIDCService) verifyIDToken(ctx context.Context, raw, nonce string) (jwt.MapClaims, error) {
+	d, err := s.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(raw, claims,
+		func(t *jwt.Token) (any, error) { return s.providerKey(ctx, t) },
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(s.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(oidcClockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCInvalidToken, err)
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCInvalidToken)
+	}
+	if aud, _ := claims.GetAudience(); len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != s.cfg.ClientID {
+			return nil, fmt.Errorf("%w: azp mismatch", ErrOIDCInvalidToken)
+		}
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("%w: missing sub", ErrOIDCInvalidToken)
+	}
+	return claims, nil
+}
+
+// resolveUser finds the user linked to the token's subject. Unlinked
+// identities are linked to an existing account with the same email only when
+// the provider has verified that email; otherwise a new user is created.
+func (s *OIDCService) resolveUser(ctx context.Context, claims jwt.MapClaims) (*models.User, error) {
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	mappedRole := s.mapRole(claims)
+
+	u, err := s.users.FindByExternalIdentity(ctx, issuer, subject)
+	if err == nil {
+		return s.syncRole(ctx, u, mappedRole)
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	email, _ := claims["email"].(string)
+	email = strings.TrimSpace(strings.ToLower(email))
+	if email == "" {
+		return nil, ErrOIDCEmailRequired
+	}
+	ident := models.ExternalIdentity{Issuer: issuer, Subject: subject, LinkedAt: time.Now()}
+
+	existing, err := s.users.FindByEmail(ctx, email)
+	if err == nil {
+		if verified, _ := claims["email_verified"].(bool); !verified {
+			return nil, ErrOIDCEmailConflict
+		}
+		if err := s.users.LinkExternalIdentity(ctx, existing.ID, ident); err != nil {
+			return nil, err
+		}
+		return s.syncRole(ctx, existing, mappedRole)
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	if !s.cfg.AllowSignup {
+		return nil, ErrOIDCSignupDisabled
+	}
+	name, _ := claims["name"].(string)
+	if name == "" {
+		name = email
+	}
+	role := mappedRole
+	if role == "" {
+		role = s.cfg.DefaultRole
+	}
+	u = &models.User{
+		FullName:           name,
+		Email:              email,
+		Role:               role,
+		ExternalIdentities: []models.ExternalIdentity{ident},
+		CreatedAt:          time.Now(),
+	}
+	id, err := s.users.Create(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	u.ID = id
+	return u, nil
+}
+
+// syncRole applies the provider's role when a role map is configured. Users
+// in none of the mapped groups fall back to the default role. The last
+// active admin keeps the role, like with any other demotion.
+func (s *OIDCService) syncRole(ctx context.Context, u *models.User, mappedRole string) (*models.User, error) {
+	if len(s.cfg.RoleMap) == 0 {
+		return u, nil
+	}
+	role := mappedRole
+	if role == "" {
+		role = s.cfg.DefaultRole
+	}
+	if role == u.Role {
+		return u, nil
+	}
+	if u.Role != models.RoleAdmin {
+		if err := s.users.UpdateRole(ctx, u.ID, role); err != nil {
+			return nil, err
+		}
+		u.Role = role
+		return u, nil
+	}
+
+	err := s.admins.Revoke(ctx, u.ID, func(*models.User) error {
+		return s.users.UpdateRole(ctx, u.ID, role)
+	})
+	if errors.Is(err, ErrLastAdmin) {
+		log.Printf("[oidc] keeping admin role of %s: last active admin", u.ID.Hex())
+		return u, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.Role = role
+	return u, nil
+}
+
+// mapRole returns the most privileged role any of the user's groups maps to.
+func (s *OIDCService) mapRole(claims jwt.MapClaims) string {
+	rank := map[string]int{models.RoleCustomer: 1, models.RoleCourier: 2, models.RoleStaff: 3, models.RoleAdmin: 4}
+
+	best := ""
+	for _, v := range claimStrings(claims, s.cfg.RoleClaim) {
+		if role, ok := s.cfg.RoleMap[v]; ok && rank[role] > rank[best] {
+			best = role
+		}
+	}
+	return best
+}
+
+// claimStrings resolves a dotted claim path to a list of strings. A single
+// string value is returned as a one-element list.
+func claimStrings(claims jwt.MapClaims, path string) []string {
+	var cur any = map[string]any(claims)
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[part]
+	}
+
+	switch v := cur.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// discover returns the cached discovery document, fetching it without
+// holding s.mu so a slow provider doesn't block logins already under way.
+func (s *OIDCService) discover(ctx context.Context) (*oidcDiscovery, error) {
+	s.mu.Lock()
+	d := s.discovery
+	fresh := d != nil && time.Since(s.discoveredAt) < oidcDiscoveryTTL
+	s.mu.Unlock()
+	if fresh {
+		return d, nil
+	}
+
+	d, err := s.fetchDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.discovery = d
+	s.discoveredAt = time.Now()
+	s.mu.Unlock()
+	return d, nil
+}
+
+func (s *OIDCService) fetchDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var d oidcDiscovery
+	status, err := s.doJSON(req, &d)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%w: discovery returned %d", ErrOIDCProvider, status)
+	}
+	if strings.TrimRight(d.Issuer, "/") != s.cfg.Issuer {
+		return nil, fmt.Errorf("%w: discovery issuer %q does not match %q", ErrOIDCProvider, d.Issuer, s.cfg.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: incomplete discovery document", ErrOIDCProvider)
+	}
+	return &d, nil
+}
+
+// providerKey looks up the signing key by kid, reloading the provider's JWKS
+// at most once a minute when the kid is unknown.
+func (s *OIDCService) providerKey(ctx context.Context, t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	s.mu.Lock()
+	key, ok := s.findProviderKey(kid)
+	stale := time.Since(s.jwksAt) > oidcJWKSMinRefresh
+	jwksURI := ""
+	if s.discovery != nil {
+		jwksURI = s.discovery.JWKSURI
+	}
+	s.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale || jwksURI == "" {
+		return nil, ErrUnknownSigningKey
+	}
+
+	keys, err := s.fetchJWKS(ctx, jwksURI)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jwks = keys
+	s.jwksAt = time.Now()
+	if key, ok := s.findProviderKey(kid); ok {
+		return key, nil
+	}
+	return nil, ErrUnknownSigningKey
+}
+
+// findProviderKey must be called with s.mu held. Tokens without a kid are
+// accepted only when the provider publishes a single key.
+func (s *OIDCService) findProviderKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(s.jwks) == 1 {
+		for _, k := range s.jwks {
+			return k, true
+		}
+	}
+	k, ok := s.jwks[kid]
+	return k, ok
+}
+
+func (s *OIDCService) fetchJWKS(ctx context.Context, uri string) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set JWKSet
+	status, err := s.doJSON(req, &set)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%w: jwks returned %d", ErrOIDCProvider, status)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		pub, err := parseJWK(j)
+		if err != nil {
+			// Providers may publish key types we don't use; skip them.
+			continue
+		}
+		keys[j.Kid] = pub
+	}
+	return keys, nil
+}
+
+func (s *OIDCService) doJSON(req *http.Request, out any) (int, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrOIDCProvider, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxResponseSize))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrOIDCProvider, err)
+	}
+	if err := json.Unmarshal(body, out); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("%w: %v", ErrOIDCProvider, err)
+	}
+	return resp.StatusCode, nil
+}
+
+func parseJWK(j JWK) (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("ec point not on curve")
+		}
+		return pub, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+}
+
+func randomURLString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
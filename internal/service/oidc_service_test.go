@@ -0,0 +1,271 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// stubProvider is a minimal OpenID Connect provider: discovery, JWKS and a
+// token endpoint that redeems codes registered with authorize.
+type stubProvider struct {
+	t    *testing.T
+	srv  *httptest.Server
+	key  *rsa.PrivateKey
+	mu   sync.Mutex
+	auth map[string]stubGrant
+}
+
+type stubGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &stubProvider{t: t, key: key, auth: map[string]stubGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                p.srv.URL,
+			AuthorizationEndpoint: p.srv.URL + "/authorize",
+			TokenEndpoint:         p.srv.URL + "/token",
+			JWKSURI:               p.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(JWKSet{Keys: []JWK{{
+			Kty: "RSA",
+			Kid: "stub",
+			Alg: "RS256",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		p.mu.Lock()
+		g, ok := p.auth[r.PostForm.Get("code")]
+		delete(p.auth, r.PostForm.Get("code"))
+		p.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		tok := jwt.NewWithClaims(jwt.SigningMethodRS256, g.claims)
+		tok.Header["kid"] = "stub"
+		signed, err := tok.SignedString(key)
+		if err != nil {
+			t.Error(err)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+// authorize plays the user approving the login at authURL: it registers
+// code for an ID token carrying claims plus the usual iss, aud and nonce.
+func (p *stubProvider) authorize(authURL, code string, claims jwt.MapClaims) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	q := u.Query()
+	all := jwt.MapClaims{
+		"iss":   p.srv.URL,
+		"aud":   q.Get("client_id"),
+		"nonce": q.Get("nonce"),
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+	for k, v := range claims {
+		all[k] = v
+	}
+	p.mu.Lock()
+	p.auth[code] = stubGrant{challenge: q.Get("code_challenge"), claims: all}
+	p.mu.Unlock()
+}
+
+type oidcFixture struct {
+	provider *stubProvider
+	users    *memUsers
+	svc      *OIDCService
+	keys     *KeyManager
+}
+
+func newOIDCFixture(t *testing.T, roleMap map[string]string) *oidcFixture {
+	t.Setenv("JWT_KEY_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	t.Setenv("TOTP_REQUIRED_ROLES", "")
+
+	p := newStubProvider(t)
+	users := &memUsers{users: map[primitive.ObjectID]*models.User{}}
+	keys, err := NewKeyManager(context.Background(), &memSigningKeys{}, memLeases{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roleMap == nil {
+		roleMap = map[string]string{}
+	}
+	cfg := &OIDCConfig{
+		Issuer:      p.srv.URL,
+		ClientID:    "shop",
+		RedirectURL: "http://localhost/auth/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+		RoleClaim:   "groups",
+		RoleMap:     roleMap,
+		DefaultRole: models.RoleCustomer,
+		AllowSignup: true,
+	}
+	auth := NewAuthService(users, nil, nil, keys)
+	admins := NewAdminGuard(users, memLeases{})
+	svc := NewOIDCService(cfg, users, &memStates{states: map[string]models.OIDCState{}}, auth, admins)
+	return &oidcFixture{provider: p, users: users, svc: svc, keys: keys}
+}
+
+// login runs the flow up to the callback for an ID token with claims.
+func (f *oidcFixture) login(t *testing.T, claims jwt.MapClaims) (*LoginResult, error) {
+	ctx := context.Background()
+	authURL, state, err := f.svc.AuthCodeURL(ctx, "/orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.provider.authorize(authURL, "code-1", claims)
+	res, redirectTo, err := f.svc.Callback(ctx, state, state, "code-1")
+	if err == nil && redirectTo != "/orders" {
+		t.Errorf("redirect = %q, want /orders", redirectTo)
+	}
+	return res, err
+}
+
+func TestOIDCCallbackSignsUpNewUser(t *testing.T) {
+	f := newOIDCFixture(t, nil)
+
+	res, err := f.login(t, jwt.MapClaims{"sub": "alice", "email": "Alice@example.com", "name": "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Token == "" || res.PreAuthToken != "" {
+		t.Fatalf("got %+v, want an access token", res)
+	}
+	if _, err := f.keys.Parse(res.Token); err != nil {
+		t.Fatalf("access token does not verify: %v", err)
+	}
+	u, err := f.users.FindByEmail(context.Background(), "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Role != models.RoleCustomer || u.PasswordHash != "" {
+		t.Errorf("new user = %+v", u)
+	}
+}
+
+func TestOIDCCallbackKeepsLocalTwoFactor(t *testing.T) {
+	f := newOIDCFixture(t, nil)
+	f.users.add(&models.User{Email: "staff@example.com", Role: models.RoleStaff, PasswordHash: "x", TOTPEnabled: true})
+
+	res, err := f.login(t, jwt.MapClaims{"sub": "s1", "email": "staff@example.com", "email_verified": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Token != "" || res.PreAuthToken == "" || res.TwoFactorStep != TwoFactorStepVerify {
+		t.Fatalf("got %+v, want a pre-auth token for the verify step", res)
+	}
+}
+
+func TestOIDCCallbackRequiresBrowserState(t *testing.T) {
+	f := newOIDCFixture(t, nil)
+	ctx := context.Background()
+
+	authURL, state, err := f.svc.AuthCodeURL(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.provider.authorize(authURL, "code-1", jwt.MapClaims{"sub": "mallory", "email": "m@example.com"})
+
+	for _, browser := range []string{"", "someone-elses-state"} {
+		if _, _, err := f.svc.Callback(ctx, state, browser, "code-1"); !errors.Is(err, ErrOIDCInvalidState) {
+			t.Errorf("browser state %q: err = %v, want ErrOIDCInvalidState", browser, err)
+		}
+	}
+	// The rejected attempts must not have used up the real login.
+	if _, _, err := f.svc.Callback(ctx, state, state, "code-1"); err != nil {
+		t.Fatalf("matching browser state: %v", err)
+	}
+	if _, _, err := f.svc.Callback(ctx, state, state, "code-1"); !errors.Is(err, ErrOIDCInvalidState) {
+		t.Errorf("replayed state: err = %v, want ErrOIDCInvalidState", err)
+	}
+}
+
+func TestOIDCCallbackRejectsUnverifiedEmailLink(t *testing.T) {
+	f := newOIDCFixture(t, nil)
+	f.users.add(&models.User{Email: "bob@example.com", Role: models.RoleCustomer, PasswordHash: "x"})
+
+	_, err := f.login(t, jwt.MapClaims{"sub": "b1", "email": "bob@example.com", "email_verified": false})
+	if !errors.Is(err, ErrOIDCEmailConflict) {
+		t.Fatalf("err = %v, want ErrOIDCEmailConflict", err)
+	}
+}
+
+func TestOIDCRoleSyncKeepsLastAdmin(t *testing.T) {
+	f := newOIDCFixture(t, map[string]string{"shop-admins": models.RoleAdmin})
+	admin := f.users.add(&models.User{
+		Email:              "root@example.com",
+		Role:               models.RoleAdmin,
+		ExternalIdentities: []models.ExternalIdentity{{Issuer: f.provider.srv.URL, Subject: "root"}},
+	})
+
+	// No longer in the admin group, but the only admin left.
+	res, err := f.login(t, jwt.MapClaims{"sub": "root", "groups": []string{"everyone"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Role != models.RoleAdmin {
+		t.Errorf("role = %q, want admin kept", res.Role)
+	}
+
+	f.users.add(&models.User{Email: "other@example.com", Role: models.RoleAdmin})
+	res, err = f.login(t, jwt.MapClaims{"sub": "root", "groups": []string{"everyone"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := f.users.FindByID(context.Background(), admin.ID)
+	if res.Role != models.RoleCustomer || u.Role != models.RoleCustomer {
+		t.Errorf("role = %q (stored %q), want customer once another admin exists", res.Role, u.Role)
+	}
+}
+
+func TestOIDCDiscoveryFailureIsProviderError(t *testing.T) {
+	f := newOIDCFixture(t, nil)
+	f.provider.srv.Close()
+
+	_, _, err := f.svc.AuthCodeURL(context.Background(), "/")
+	if !errors.Is(err, ErrOIDCProvider) || !strings.Contains(err.Error(), "identity provider") {
+		t.Fatalf("err = %v, want ErrOIDCProvider", err)
+	}
+}
@@ -2,13 +2,17 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/dannieey/Assignment3_Absolute/internal/models"
 	"github.com/dannieey/Assignment3_Absolute/internal/repository"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type OrderService struct {
@@ -16,28 +20,135 @@ type OrderService struct {
 	orderQueue     chan primitive.ObjectID // канал для фоновой обработки
 	workerQuitCh   chan bool               // для остановки воркера (опционально)
 	productService *ProductService
+	addresses      repository.AddressRepo
+	pickup         *PickupService
+	delivery       *DeliveryService
+	payments       *PaymentService
+	promotions     *PromotionService
+	coupons        *CouponService
+	taxes          *TaxService
+	loyalty        *LoyaltyService
+	storedValue    *StoredValueService
+	// paymentTimeout is how long a new order may wait for payment before
+	// it is cancelled; 0 keeps it forever.
+	paymentTimeout time.Duration
 }
 
-func NewOrderService(repo repository.OrderRepo, prodService *ProductService) *OrderService {
+func NewOrderService(
+	repo repository.OrderRepo,
+	prodService *ProductService,
+	addresses repository.AddressRepo,
+	pickup *PickupService,
+	delivery *DeliveryService,
+	payments *PaymentService,
+	promotions *PromotionService,
+	coupons *CouponService,
+	taxes *TaxService,
+	loyalty *LoyaltyService,
+	storedValue *StoredValueService,
+) *OrderService {
 	s := &OrderService{
 		repo:           repo,
 		productService: prodService,
+		addresses:      addresses,
+		pickup:         pickup,
+		delivery:       delivery,
+		payments:       payments,
+		promotions:     promotions,
+		coupons:        coupons,
+		taxes:          taxes,
+		loyalty:        loyalty,
+		storedValue:    storedValue,
+		paymentTimeout: orderPaymentTimeout(),
 		orderQueue:     make(chan primitive.ObjectID, 100),
 		workerQuitCh:   make(chan bool),
 	}
 	go s.startWorker() // запускаем воркер в фоне
+	if s.paymentTimeout > 0 {
+		go s.expiryLoop()
+	}
 	return s
 }
-func (s *OrderService) Create(ctx context.Context, order *models.Order) (primitive.ObjectID, error) {
+
+const (
+	defaultOrderPaymentTimeout = 30 * time.Minute
+	orderExpiryCheck           = time.Minute
+)
+
+// orderPaymentTimeout reads ORDER_PAYMENT_TIMEOUT, a Go duration; "0" turns
+// the expiry off.
+func orderPaymentTimeout() time.Duration {
+	v := strings.TrimSpace(os.Getenv("ORDER_PAYMENT_TIMEOUT"))
+	if v == "" {
+		return defaultOrderPaymentTimeout
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("[orders] invalid ORDER_PAYMENT_TIMEOUT %q, using %s", v, defaultOrderPaymentTimeout)
+		return defaultOrderPaymentTimeout
+	}
+	return d
+}
+
+// CreateOrderOptions are the optional choices made at checkout. An order is
+// either delivered (AddressID and DeliveryWindow) or collected (PickupSlot).
+type CreateOrderOptions struct {
+	// AddressID is an address book entry to deliver to; it is copied onto
+	// the order.
+	AddressID primitive.ObjectID
+	// DeliveryWindow is the start of one of the zone's delivery windows.
+	DeliveryWindow time.Time
+	// PickupSlot is the start of a click-and-collect slot to reserve.
+	PickupSlot time.Time
+	// CouponCode is a coupon to redeem.
+	CouponCode string
+	// RedeemPoints is how many loyalty points to spend, at most; only as
+	// many as the goods are worth are used.
+	RedeemPoints int64
+	// GiftCardCode and GiftCardPIN pay with a gift card, and StoreCredit
+	// with the customer's store credit, as much of the total as they
+	// cover; the card pays the rest.
+	GiftCardCode string
+	GiftCardPIN  string
+	StoreCredit  bool
+}
+
+var (
+	ErrDeliveryAndPickup   = errors.New("choose either a delivery address or a pickup slot")
+	ErrOrderNotCancellable = errors.New("order can no longer be cancelled")
+)
+
+// Order payment statuses that allow cancelling; a payment in flight has to
+// settle first.
+var cancellablePaymentStatuses = []string{models.PaymentUnpaid, models.PaymentDeclined, models.PaymentVoided, models.PaymentAuthorized, models.PaymentNotRequired}
+
+func (s *OrderService) Create(ctx context.Context, order *models.Order, opts CreateOrderOptions) (id primitive.ObjectID, err error) {
 	if order.UserID == primitive.NilObjectID {
 		return primitive.NilObjectID, fmt.Errorf("userId missing")
 	}
 	if len(order.Items) == 0 {
 		return primitive.NilObjectID, fmt.Errorf("items missing")
 	}
+	delivery := !opts.AddressID.IsZero() || !opts.DeliveryWindow.IsZero()
+	if delivery && !opts.PickupSlot.IsZero() {
+		return primitive.NilObjectID, ErrDeliveryAndPickup
+	}
+	if delivery && opts.AddressID.IsZero() {
+		return primitive.NilObjectID, ErrDeliveryAddressRequired
+	}
 
-	var total float64
+	var giftCard *models.GiftCard
+	if opts.GiftCardCode != "" {
+		giftCard, err = s.storedValue.Check(ctx, opts.GiftCardCode, opts.GiftCardPIN)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+	}
 
+	// Price everything before touching stock so that delivery rules can
+	// reject the order without side effects.
+	var subtotal models.Money
+	priced := make([]PricedLine, len(order.Items))
 	for i := range order.Items {
 		item := &order.Items[i]
 
@@ -45,38 +156,267 @@ func (s *OrderService) Create(ctx context.Context, order *models.Order) (primiti
 		if err != nil {
 			return primitive.NilObjectID, fmt.Errorf("product not found: %s", item.ProductID.Hex())
 		}
+		item.Price = p.SellingPrice()
+		subtotal += item.Price.Mul(item.Quantity)
+		priced[i] = PricedLine{Product: p, Quantity: item.Quantity}
+	}
 
+	now := time.Now()
+	var coupon *CouponOffer
+	if opts.CouponCode != "" {
+		coupon, err = s.coupons.Check(ctx, order.UserID, opts.CouponCode, subtotal, now)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		order.CouponCode = coupon.Coupon.Code
+	}
+	discounts, err := s.promotions.Apply(ctx, priced, now, coupon)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if opts.RedeemPoints > 0 {
+		used, value, err := s.loyalty.Quote(ctx, opts.RedeemPoints, subtotal-discounts.Total)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		if used > 0 {
+			order.LoyaltyPointsRedeemed = used
+			discounts.AddSpread(priced, models.AppliedDiscount{
+				Name:   fmt.Sprintf("%d loyalty points", used),
+				Type:   models.DiscountLoyalty,
+				Amount: value,
+			})
+		}
+	}
+	taxable := make([]TaxableLine, len(priced))
+	for i, l := range priced {
+		order.Items[i].Discount = discounts.Lines[i]
+		taxable[i] = TaxableLine{Product: l.Product, Amount: l.Product.SellingPrice().Mul(l.Quantity) - discounts.Lines[i]}
+	}
+	order.Discounts = discounts.Applied
+	order.DiscountTotal = discounts.Total
+
+	tax, err := s.taxes.Compute(ctx, taxable, now)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	for i, t := range tax.Lines {
+		order.Items[i].TaxClass = t.ClassCode
+		order.Items[i].TaxRate = t.BasisPoints
+		order.Items[i].Tax = t.Tax
+	}
+	order.TaxMode = tax.Mode
+	order.TaxTotal = tax.Total
+	order.TaxLines = tax.Breakdown
+
+	if delivery {
+		a, err := s.addresses.FindByID(ctx, order.UserID, opts.AddressID)
+		if err != nil {
+			return primitive.NilObjectID, ErrAddressNotFound
+		}
+		order.DeliveryAddress = a.Snapshot()
+
+		charge, err := s.delivery.Price(ctx, order.DeliveryAddress, subtotal-order.DiscountTotal, opts.DeliveryWindow)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		order.DeliveryZoneID = charge.ZoneID
+		order.DeliveryFee = charge.Fee
+		order.DeliveryWindow = charge.Window
+
+		order.DeliveryCode, err = newDeliveryCode()
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+	}
+
+	if !opts.PickupSlot.IsZero() {
+		var slot *models.PickupSlotRef
+		slot, err = s.pickup.Reserve(ctx, opts.PickupSlot)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		order.PickupSlot = slot
+		// Give the place back if the order doesn't go through.
+		defer func() {
+			if err != nil {
+				_ = s.pickup.Release(context.Background(), slot)
+			}
+		}()
+	}
+
+	order.Currency = StoreCurrency()
+	order.Subtotal = subtotal
+	order.TotalPrice = subtotal - order.DiscountTotal + order.DeliveryFee
+	if order.TaxMode == models.TaxExclusive {
+		order.TotalPrice += order.TaxTotal
+	}
+	order.NetTotal = order.TotalPrice
+	// Ledger entries point at the order, so it gets its id now.
+	order.ID = primitive.NewObjectID()
+
+	if coupon != nil {
+		// Check only looked; this is what holds the usage limits.
+		if err := s.coupons.Claim(ctx, order.UserID, coupon); err != nil {
+			return primitive.NilObjectID, err
+		}
+		defer func() {
+			if err != nil {
+				_ = s.coupons.Release(context.Background(), order.UserID, coupon)
+			}
+		}()
+	}
+
+	if order.LoyaltyPointsRedeemed > 0 {
+		if err := s.loyalty.Spend(ctx, order.UserID, order.ID, order.LoyaltyPointsRedeemed); err != nil {
+			return primitive.NilObjectID, err
+		}
+		defer func() {
+			if err != nil {
+				_ = s.loyalty.Unspend(context.Background(), order.UserID, order.ID, order.LoyaltyPointsRedeemed)
+			}
+		}()
+	}
+
+	if giftCard != nil || opts.StoreCredit {
+		order.Tenders, err = s.storedValue.Take(ctx, order, giftCard, opts.StoreCredit)
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		defer func() {
+			if err != nil {
+				s.storedValue.GiveBack(context.Background(), order.ID, order.Tenders, "Order not placed")
+			}
+		}()
+		for _, t := range order.Tenders {
+			order.StoredValuePaid += t.Amount
+		}
+	}
+
+	for _, item := range order.Items {
 		if err := s.productService.DecreaseStock(ctx, item.ProductID, item.Quantity); err != nil {
 			return primitive.NilObjectID, fmt.Errorf("not enough stock for product %s", item.ProductID.Hex())
 		}
-
-		item.Price = p.Price
-		total += p.Price * float64(item.Quantity)
 	}
 
-	order.TotalPrice = total
 	order.Status = "NEW"
+	order.PaymentStatus = models.PaymentUnpaid
+	note := "Order created, waiting for payment"
+	if order.CardDue() <= 0 {
+		order.PaymentStatus = models.PaymentNotRequired
+		note = "Order created, paid with gift card or store credit"
+	}
 
 	order.History = []models.OrderStatusHistory{
 		{
 			Status:    "NEW",
 			Timestamp: time.Now(),
-			Note:      "Order created",
+			Note:      note,
 		},
 	}
 
-	id, err := s.repo.Create(ctx, order)
+	id, err = s.repo.Create(ctx, order)
 	if err != nil {
 		return primitive.NilObjectID, err
 	}
+	if order.PaymentStatus == models.PaymentNotRequired {
+		s.Enqueue(id)
+	}
+	return id, nil
+}
 
+// Enqueue hands a paid order to the background worker for preparation.
+func (s *OrderService) Enqueue(id primitive.ObjectID) {
 	select {
 	case s.orderQueue <- id:
 	default:
 		log.Printf("[worker] orderQueue is full, skipping async processing for order %s", id.Hex())
 	}
+}
 
-	return id, nil
+// Cancel calls off an order that has not started being prepared: the card
+// authorization is released, stock goes back on the shelf, and the pickup
+// place, gift cards and store credit, coupon use and loyalty points are given
+// back. userID, when not zero, must own the order.
+func (s *OrderService) Cancel(ctx context.Context, orderID, userID primitive.ObjectID, note string) error {
+	o, err := s.repo.FindByID(ctx, orderID)
+	if err != nil || (!userID.IsZero() && o.UserID != userID) {
+		return ErrOrderNotFound
+	}
+	if note == "" {
+		note = "Order cancelled"
+	}
+	return s.cancel(ctx, orderID, cancellablePaymentStatuses, note)
+}
+
+// CancelUnpaid cancels the new orders placed before cutoff that nobody has
+// paid for, freeing what they hold.
+func (s *OrderService) CancelUnpaid(ctx context.Context, cutoff time.Time) error {
+	list, err := s.repo.FindAwaitingPayment(ctx, payableStatuses, cutoff)
+	if err != nil {
+		return err
+	}
+	for _, o := range list {
+		// A payment started since the lookup makes this a no-op.
+		err := s.cancel(ctx, o.ID, payableStatuses, "Not paid in time")
+		if err != nil && !errors.Is(err, ErrOrderNotCancellable) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *OrderService) expiryLoop() {
+	t := time.NewTicker(orderExpiryCheck)
+	defer t.Stop()
+	for range t.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := s.CancelUnpaid(ctx, time.Now().Add(-s.paymentTimeout)); err != nil {
+			log.Printf("[orders] cancel unpaid orders: %v", err)
+		}
+		cancel()
+	}
+}
+
+// cancel cancels the order if its payment status is in paymentFrom. The
+// check and the status change are one update, so a payment can't start on
+// an order that is being cancelled.
+func (s *OrderService) cancel(ctx context.Context, orderID primitive.ObjectID, paymentFrom []string, note string) error {
+	o, err := s.repo.Cancel(ctx, orderID, paymentFrom, note)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrOrderNotCancellable
+	}
+	if err != nil {
+		return err
+	}
+
+	// The order is cancelled now; what follows is cleanup that is logged
+	// rather than undone if it fails.
+	if o.PaymentStatus == models.PaymentAuthorized {
+		if err := s.payments.Void(ctx, orderID); err != nil {
+			log.Printf("[orders] void payment for cancelled order %s: %v", orderID.Hex(), err)
+		}
+	}
+	for _, item := range o.Items {
+		if err := s.productService.IncreaseStock(ctx, item.ProductID, item.Quantity); err != nil {
+			log.Printf("[orders] restock %s for cancelled order %s: %v", item.ProductID.Hex(), orderID.Hex(), err)
+		}
+	}
+	if o.PickupSlot != nil {
+		if err := s.pickup.Release(ctx, o.PickupSlot); err != nil {
+			log.Printf("[orders] release pickup slot of cancelled order %s: %v", orderID.Hex(), err)
+		}
+	}
+	s.storedValue.GiveBack(ctx, orderID, o.Tenders, "Order cancelled")
+	if o.CouponCode != "" {
+		if err := s.coupons.ReleaseCode(ctx, o.UserID, o.CouponCode); err != nil {
+			log.Printf("[orders] release coupon of cancelled order %s: %v", orderID.Hex(), err)
+		}
+	}
+	if err := s.loyalty.Reconcile(ctx, orderID); err != nil {
+		log.Printf("[orders] loyalty points of cancelled order %s: %v", orderID.Hex(), err)
+	}
+	return nil
 }
 
 func (s *OrderService) GetHistory(ctx context.Context, userID primitive.ObjectID) ([]models.Order, error) {
@@ -99,12 +439,15 @@ func (s *OrderService) GetTracking(
 	}
 
 	return &models.OrderTracking{
-		OrderID:   order.ID,
-		UserID:    order.UserID,
-		Status:    order.Status,
-		History:   order.History,
-		CreatedAt: order.CreatedAt,
-		UpdatedAt: order.UpdatedAt,
+		OrderID:        order.ID,
+		UserID:         order.UserID,
+		Status:         order.Status,
+		PaymentStatus:  order.PaymentStatus,
+		History:        order.History,
+		DeliveryWindow: order.DeliveryWindow,
+		PickupSlot:     order.PickupSlot,
+		CreatedAt:      order.CreatedAt,
+		UpdatedAt:      order.UpdatedAt,
 	}, nil
 }
 func (s *OrderService) startWorker() {
@@ -123,21 +466,47 @@ func (s *OrderService) startWorker() {
 func (s *OrderService) processOrder(orderID primitive.ObjectID) {
 	log.Printf("[worker] processing order %s", orderID.Hex())
 
+	// Transitions are conditional so that the worker never overwrites a
+	// status staff or a courier have already moved on from.
 	time.Sleep(2 * time.Second)
-	_ = s.repo.UpdateStatusWithHistory(
+	_, _ = s.repo.TransitionStatus(
 		context.Background(),
 		orderID,
+		[]string{"NEW"},
 		"PROCESSING",
 		"Order is being prepared",
 	)
 
+	status, note := "DONE", "Order ready for pickup"
+	capture := true
+	if o, err := s.repo.FindByID(context.Background(), orderID); err == nil {
+		if o.DeliveryAddress != nil {
+			status, note = models.OrderReadyForDelivery, "Order packed, waiting for a courier"
+		}
+		capture = o.PaymentStatus != models.PaymentNotRequired
+	}
+
 	time.Sleep(2 * time.Second)
-	_ = s.repo.UpdateStatusWithHistory(
+	packed, _ := s.repo.TransitionStatus(
 		context.Background(),
 		orderID,
-		"DONE",
-		"Order ready for pickup",
+		[]string{"PROCESSING"},
+		status,
+		note,
 	)
+
+	// The final amount is known once the order is packed.
+	if packed && capture {
+		if err := s.payments.Capture(context.Background(), orderID); err != nil {
+			log.Printf("[worker] capture payment for order %s: %v", orderID.Hex(), err)
+		}
+	}
+	if packed {
+		// Collection orders are complete here; deliveries once delivered.
+		if status == "DONE" {
+			s.loyalty.OrderCompleted(orderID)
+		}
+	}
 }
 
 func (s *OrderService) StopWorker() {
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFailedOrderReleasesPickupSlot(t *testing.T) {
+	t.Setenv("STORE_TIMEZONE", "UTC")
+	p := &models.Product{ID: primitive.NewObjectID(), Price: 1000, Currency: StoreCurrency(), StockQty: 1}
+	products := &memProducts{products: map[primitive.ObjectID]*models.Product{p.ID: p}}
+	slots := &memPickup{booked: map[time.Time]int{}}
+	// Built by hand so that no worker runs alongside the test.
+	s := &OrderService{
+		productService: NewProductService(products, nil),
+		pickup:         NewPickupService(slots, nil),
+		promotions:     NewPromotionService(noPromotions{}),
+		taxes:          NewTaxService(noTaxes{}, noCategories{}),
+	}
+
+	tomorrow := startOfDay(time.Now().UTC()).AddDate(0, 0, 1)
+	slot := tomorrow.Add(12 * time.Hour)
+	order := &models.Order{
+		UserID: primitive.NewObjectID(),
+		// More than is in stock, so the order fails after the slot is taken.
+		Items: []models.OrderItem{{ProductID: p.ID, Quantity: 2}},
+	}
+	_, err := s.Create(context.Background(), order, CreateOrderOptions{PickupSlot: slot})
+	if err == nil {
+		t.Fatal("order with too little stock was placed")
+	}
+	if order.PickupSlot == nil {
+		t.Fatalf("order failed before the slot was reserved: %v", err)
+	}
+	if n := slots.booked[slot]; n != 0 {
+		t.Errorf("slot holds %d bookings after the order failed, want 0", n)
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrProviderRejected is returned by a provider when an operation is not
+	// allowed for the payment in its current state.
+	ErrProviderRejected = errors.New("payment provider rejected the request")
+	ErrInvalidWebhook   = errors.New("invalid webhook signature")
+)
+
+// PaymentProvider is a card payment gateway. Amounts are in the store
+// currency and refs are the provider's own payment ids.
+type PaymentProvider interface {
+	Name() string
+	// Authorize reserves the amount on the card. The result may be
+	// PaymentPending, in which case the outcome arrives by webhook.
+	Authorize(ctx context.Context, req AuthorizeRequest) (*ProviderResult, error)
+	Capture(ctx context.Context, ref string, amount models.Money) (*ProviderResult, error)
+	Void(ctx context.Context, ref string) (*ProviderResult, error)
+	Refund(ctx context.Context, ref string, amount models.Money) (*ProviderResult, error)
+	// VerifyWebhook checks an incoming webhook's signature and decodes it.
+	VerifyWebhook(payload []byte, header http.Header) (*WebhookEvent, error)
+}
+
+type AuthorizeRequest struct {
+	// PaymentID is our payment record, passed on as the idempotency key.
+	PaymentID primitive.ObjectID
+	Amount    models.Money
+	Currency  string
+	// Token is the card token produced by the provider's client-side SDK.
+	Token string
+}
+
+// ProviderResult is what the provider did; Status is one of models.Payment*.
+type ProviderResult struct {
+	Ref           string
+	Status        string
+	DeclineReason string
+}
+
+// WebhookEvent is a provider notification about a payment's status.
+type WebhookEvent struct {
+	ID            string
+	Ref           string
+	Status        string
+	Amount        models.Money
+	DeclineReason string
+}
+
+// PaymentProviderFromEnv picks the provider named by PAYMENT_PROVIDER. Only
+// the built-in "fake" gateway exists so far, and it is the default.
+func PaymentProviderFromEnv() (PaymentProvider, error) {
+	name := strings.TrimSpace(os.Getenv("PAYMENT_PROVIDER"))
+	switch name {
+	case "", "fake":
+		return FakeGatewayFromEnv()
+	default:
+		return nil, fmt.Errorf("unknown PAYMENT_PROVIDER %q", name)
+	}
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrPaymentDeclined      = errors.New("payment declined")
+	ErrPaymentTokenRequired = errors.New("token is required")
+	ErrOrderAlreadyPaid     = errors.New("order is already paid or a payment is in progress")
+	ErrPaymentNotFound      = errors.New("payment not found")
+	ErrPaymentState         = errors.New("payment is not in a state that allows this")
+	ErrOrderCancelled       = errors.New("order has been cancelled")
+)
+
+// Order payment statuses from which the customer may start a new attempt.
+var payableStatuses = []string{models.PaymentUnpaid, models.PaymentDeclined, models.PaymentVoided}
+
+// PaymentService takes payment for orders through a PaymentProvider and keeps
+// the payment records and the order's PaymentStatus in step.
+type PaymentService struct {
+	provider PaymentProvider
+	payments repository.PaymentRepo
+	orders   repository.OrderRepo
+
+	onAuthorized func(orderID primitive.ObjectID)
+}
+
+func NewPaymentService(provider PaymentProvider, payments repository.PaymentRepo, orders repository.OrderRepo) *PaymentService {
+	return &PaymentService{provider: provider, payments: payments, orders: orders}
+}
+
+// OnAuthorized registers fn to be called once an order's payment is
+// authorized, whether straight away or later by webhook.
+func (s *PaymentService) OnAuthorized(fn func(orderID primitive.ObjectID)) {
+	s.onAuthorized = fn
+}
+
+// Pay authorizes the order total on the customer's card. A pending result is
+// not an error; the order moves on when the provider's webhook arrives.
+func (s *PaymentService) Pay(ctx context.Context, userID, orderID primitive.ObjectID, token string) (*models.Payment, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, ErrPaymentTokenRequired
+	}
+	o, err := s.orders.FindByID(ctx, orderID)
+	if err != nil || o.UserID != userID {
+		return nil, ErrOrderNotFound
+	}
+	if o.Status == models.OrderCancelled {
+		return nil, ErrOrderCancelled
+	}
+	if o.CardDue() <= 0 {
+		return nil, ErrOrderAlreadyPaid
+	}
+
+	currency := o.Currency
+	if currency == "" {
+		currency = StoreCurrency()
+	}
+	p := &models.Payment{
+		OrderID:  o.ID,
+		UserID:   userID,
+		Provider: s.provider.Name(),
+		Amount:   o.CardDue(),
+		Currency: currency,
+		Status:   models.PaymentPending,
+	}
+	p.ID, err = s.payments.Create(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	// Claiming the order first means two concurrent attempts can't both
+	// reach the provider, nor can one race a cancellation.
+	ok, err := s.orders.ClaimPayment(ctx, o.ID, p.ID, payableStatuses, "Payment started")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		_, _ = s.payments.Transition(ctx, p.ID, []string{models.PaymentPending},
+			repository.PaymentUpdate{Status: models.PaymentVoided, Note: "Superseded by another attempt"})
+		if cur, err := s.orders.FindByID(ctx, o.ID); err == nil && cur.Status == models.OrderCancelled {
+			return nil, ErrOrderCancelled
+		}
+		return nil, ErrOrderAlreadyPaid
+	}
+
+	res, err := s.provider.Authorize(ctx, AuthorizeRequest{PaymentID: p.ID, Amount: p.Amount, Currency: p.Currency, Token: token})
+	if err != nil {
+		s.settle(ctx, p, models.PaymentDeclined, "", "provider_error")
+		return nil, err
+	}
+	if err := s.settle(ctx, p, res.Status, res.Ref, res.DeclineReason); err != nil {
+		return nil, err
+	}
+	if p.Status == models.PaymentDeclined {
+		return p, fmt.Errorf("%w: %s", ErrPaymentDeclined, p.DeclineReason)
+	}
+	return p, nil
+}
+
+// HandleWebhook applies a provider notification. Repeated deliveries of the
+// same event are harmless because transitions are conditional.
+func (s *PaymentService) HandleWebhook(ctx context.Context, payload []byte, header http.Header) error {
+	ev, err := s.provider.VerifyWebhook(payload, header)
+	if err != nil {
+		return err
+	}
+	p, err := s.payments.FindByProviderRef(ctx, s.provider.Name(), ev.Ref)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrPaymentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if p.Status != models.PaymentPending {
+		return nil
+	}
+	return s.settle(ctx, p, ev.Status, "", ev.DeclineReason)
+}
+
+// Capture takes the authorized amount of the order's current payment.
+func (s *PaymentService) Capture(ctx context.Context, orderID primitive.ObjectID) error {
+	p, err := s.current(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if p.Status != models.PaymentAuthorized {
+		return ErrPaymentState
+	}
+	if _, err := s.provider.Capture(ctx, p.ProviderRef, p.Amount); err != nil {
+		return err
+	}
+	return s.record(ctx, p, []string{models.PaymentAuthorized}, repository.PaymentUpdate{
+		Status:         models.PaymentCaptured,
+		CapturedAmount: p.Amount,
+		Note:           "Payment captured",
+	})
+}
+
+// Void releases an authorization that will not be captured.
+func (s *PaymentService) Void(ctx context.Context, orderID primitive.ObjectID) error {
+	p, err := s.current(ctx, orderID)
+	if err != nil {
+		return err
+	}
+	if p.Status != models.PaymentAuthorized {
+		return ErrPaymentState
+	}
+	if _, err := s.provider.Void(ctx, p.ProviderRef); err != nil {
+		return err
+	}
+	return s.record(ctx, p, []string{models.PaymentAuthorized}, repository.PaymentUpdate{
+		Status: models.PaymentVoided,
+		Note:   "Payment voided",
+	})
+}
+
+// Refund returns amount of a captured payment to the card.
+func (s *PaymentService) Refund(ctx context.Context, orderID primitive.ObjectID, amount models.Money, note string) (*models.Payment, error) {
+	p, err := s.current(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if p.Status != models.PaymentCaptured && p.Status != models.PaymentPartiallyRefunded {
+		return nil, ErrPaymentState
+	}
+	res, err := s.provider.Refund(ctx, p.ProviderRef, amount)
+	if err != nil {
+		return nil, err
+	}
+	if note == "" {
+		note = fmt.Sprintf("Refunded %s", amount)
+	}
+	err = s.record(ctx, p, []string{models.PaymentCaptured, models.PaymentPartiallyRefunded}, repository.PaymentUpdate{
+		Status:         res.Status,
+		RefundedAmount: amount,
+		Note:           note,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.payments.FindByID(ctx, p.ID)
+}
+
+// ForOrder lists every payment attempt for an order, newest first.
+func (s *PaymentService) ForOrder(ctx context.Context, orderID primitive.ObjectID) ([]models.Payment, error) {
+	return s.payments.ListByOrderID(ctx, orderID)
+}
+
+func (s *PaymentService) current(ctx context.Context, orderID primitive.ObjectID) (*models.Payment, error) {
+	o, err := s.orders.FindByID(ctx, orderID)
+	if err != nil {
+		return nil, ErrOrderNotFound
+	}
+	if o.PaymentID.IsZero() {
+		return nil, ErrPaymentNotFound
+	}
+	p, err := s.payments.FindByID(ctx, o.PaymentID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrPaymentNotFound
+	}
+	return p, err
+}
+
+// settle records the outcome of an authorization and fires onAuthorized.
+func (s *PaymentService) settle(ctx context.Context, p *models.Payment, status, ref, declineReason string) error {
+	note := "Payment authorized"
+	switch status {
+	case models.PaymentPending:
+		if ref != "" {
+			_, err := s.payments.Transition(ctx, p.ID, []string{models.PaymentPending},
+				repository.PaymentUpdate{Status: status, ProviderRef: ref, Note: "Waiting for the provider"})
+			p.ProviderRef = ref
+			return err
+		}
+		return nil
+	case models.PaymentDeclined:
+		note = "Payment declined"
+		if declineReason != "" {
+			note += ": " + declineReason
+		}
+	}
+
+	err := s.record(ctx, p, []string{models.PaymentPending}, repository.PaymentUpdate{
+		Status:        status,
+		ProviderRef:   ref,
+		DeclineReason: declineReason,
+		Note:          note,
+	})
+	if err != nil {
+		return err
+	}
+	p.DeclineReason = declineReason
+	if status == models.PaymentAuthorized && s.onAuthorized != nil {
+		s.onAuthorized(p.OrderID)
+	}
+	return nil
+}
+
+// record applies upd to the payment and mirrors the new status on the order.
+func (s *PaymentService) record(ctx context.Context, p *models.Payment, from []string, upd repository.PaymentUpdate) error {
+	ok, err := s.payments.Transition(ctx, p.ID, from, upd)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPaymentState
+	}
+	p.Status = upd.Status
+	if upd.ProviderRef != "" {
+		p.ProviderRef = upd.ProviderRef
+	}
+
+	if _, err := s.orders.SetPaymentStatus(ctx, p.OrderID, p.ID, from, upd.Status, upd.Note); err != nil {
+		log.Printf("[payments] order %s: %v", p.OrderID.Hex(), err)
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const defaultStoreTimezone = "Asia/Almaty"
+
+var (
+	ErrInvalidPickupSlot     = errors.New("pickup slot is not offered")
+	ErrPickupSlotFull        = errors.New("pickup slot is fully booked")
+	ErrInvalidPickupSettings = errors.New("invalid pickup settings")
+	ErrInvalidDate           = errors.New("date must be YYYY-MM-DD")
+)
+
+// PickupService offers click-and-collect slots from the store's opening hours
+// and enforces the per-slot capacity.
+type PickupService struct {
+	repo   repository.PickupRepo
+	orders repository.OrderRepo
+}
+
+func NewPickupService(repo repository.PickupRepo, orders repository.OrderRepo) *PickupService {
+	return &PickupService{repo: repo, orders: orders}
+}
+
+// DefaultPickupSettings is used until staff save their own. STORE_TIMEZONE
+// sets the timezone (default Asia/Almaty).
+func DefaultPickupSettings() *models.PickupSettings {
+	tz := strings.TrimSpace(os.Getenv("STORE_TIMEZONE"))
+	if tz == "" {
+		tz = defaultStoreTimezone
+	}
+	s := &models.PickupSettings{
+		Timezone:    tz,
+		SlotMinutes: 30,
+		Capacity:    10,
+		LeadMinutes: 60,
+		HorizonDays: 7,
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		h := models.OpeningHours{Weekday: d, Open: "09:00", Close: "21:00"}
+		if d == time.Sunday {
+			h.Open, h.Close = "10:00", "18:00"
+		}
+		s.Hours = append(s.Hours, h)
+	}
+	return s
+}
+
+func (s *PickupService) Settings(ctx context.Context) (*models.PickupSettings, error) {
+	st, err := s.repo.GetSettings(ctx)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return DefaultPickupSettings(), nil
+	}
+	return st, err
+}
+
+func (s *PickupService) UpdateSettings(ctx context.Context, st *models.PickupSettings) error {
+	if err := validatePickupSettings(st); err != nil {
+		return err
+	}
+	return s.repo.SaveSettings(ctx, st)
+}
+
+// Slots lists the slots of one store-local day ("YYYY-MM-DD"); an empty date
+// means today.
+func (s *PickupService) Slots(ctx context.Context, date string) ([]models.PickupSlot, error) {
+	st, err := s.Settings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(st.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	day, err := parseStoreDate(date, loc)
+	if err != nil {
+		return nil, err
+	}
+	slots := daySlots(st, day)
+	if len(slots) == 0 {
+		return slots, nil
+	}
+
+	booked, err := s.repo.BookedCounts(ctx, slots[0].Start, slots[len(slots)-1].End)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	earliest := now.Add(time.Duration(st.LeadMinutes) * time.Minute)
+	latest := startOfDay(now.In(loc)).AddDate(0, 0, st.HorizonDays)
+	for i := range slots {
+		sl := &slots[i]
+		sl.Booked = booked[sl.Start.UTC()]
+		sl.Remaining = max(sl.Capacity-sl.Booked, 0)
+		sl.Available = sl.Remaining > 0 && !sl.Start.Before(earliest) && sl.Start.Before(latest)
+	}
+	return slots, nil
+}
+
+// Reserve books one place in the slot starting at start.
+func (s *PickupService) Reserve(ctx context.Context, start time.Time) (*models.PickupSlotRef, error) {
+	st, err := s.Settings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := time.LoadLocation(st.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	var slot *models.PickupSlot
+	for _, sl := range daySlots(st, startOfDay(start.In(loc))) {
+		if sl.Start.Equal(start) {
+			slot = &sl
+			break
+		}
+	}
+	now := time.Now()
+	if slot == nil ||
+		slot.Start.Before(now.Add(time.Duration(st.LeadMinutes)*time.Minute)) ||
+		!slot.Start.Before(startOfDay(now.In(loc)).AddDate(0, 0, st.HorizonDays)) {
+		return nil, ErrInvalidPickupSlot
+	}
+
+	ok, err := s.repo.Reserve(ctx, slot.Start, slot.End, st.Capacity)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrPickupSlotFull
+	}
+	return &models.PickupSlotRef{Start: slot.Start.UTC(), End: slot.End.UTC()}, nil
+}
+
+func (s *PickupService) Release(ctx context.Context, ref *models.PickupSlotRef) error {
+	return s.repo.Release(ctx, ref.Start)
+}
+
+// SlotOrders is one slot in the staff view, with the orders to hand out.
+type SlotOrders struct {
+	Slot   models.PickupSlot `json:"slot"`
+	Orders []models.Order    `json:"orders"`
+}
+
+// OrdersBySlot groups a day's click-and-collect orders by slot for staff.
+func (s *PickupService) OrdersBySlot(ctx context.Context, date string) ([]SlotOrders, error) {
+	slots, err := s.Slots(ctx, date)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]SlotOrders, 0, len(slots))
+	if len(slots) == 0 {
+		return out, nil
+	}
+
+	orders, err := s.orders.FindByPickupRange(ctx, slots[0].Start.UTC(), slots[len(slots)-1].End.UTC())
+	if err != nil {
+		return nil, err
+	}
+	byStart := map[time.Time][]models.Order{}
+	for _, o := range orders {
+		k := o.PickupSlot.Start.UTC()
+		byStart[k] = append(byStart[k], o)
+	}
+	for _, sl := range slots {
+		list := byStart[sl.Start.UTC()]
+		if list == nil {
+			list = []models.Order{}
+		}
+		out = append(out, SlotOrders{Slot: sl, Orders: list})
+	}
+	return out, nil
+}
+
+// daySlots cuts the opening hours of day into back-to-back slots. day must be
+// midnight in the store's timezone.
+func daySlots(st *models.PickupSettings, day time.Time) []models.PickupSlot {
+	slots := []models.PickupSlot{}
+	for _, h := range st.Hours {
+		if h.Weekday != day.Weekday() || h.Closed {
+			continue
+		}
+		open, err1 := clockOffset(h.Open)
+		closing, err2 := clockOffset(h.Close)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		step := time.Duration(st.SlotMinutes) * time.Minute
+		// Slots are wall-clock times (see atClock), so a DST change that
+		// day doesn't shift them.
+		for off := open; off+step <= closing; off += step {
+			slots = append(slots, models.PickupSlot{
+				Start:    atClock(day, off),
+				End:      atClock(day, off+step),
+				Capacity: st.Capacity,
+			})
+		}
+	}
+	return slots
+}
+
+func validatePickupSettings(st *models.PickupSettings) error {
+	if _, err := time.LoadLocation(st.Timezone); err != nil || st.Timezone == "" {
+		return fmt.Errorf("%w: unknown timezone %q", ErrInvalidPickupSettings, st.Timezone)
+	}
+	if st.SlotMinutes < 10 || st.SlotMinutes > 240 {
+		return fmt.Errorf("%w: slotMinutes must be between 10 and 240", ErrInvalidPickupSettings)
+	}
+	if st.Capacity < 1 {
+		return fmt.Errorf("%w: capacity must be at least 1", ErrInvalidPickupSettings)
+	}
+	if st.LeadMinutes < 0 {
+		return fmt.Errorf("%w: leadMinutes must not be negative", ErrInvalidPickupSettings)
+	}
+	if st.HorizonDays < 1 || st.HorizonDays > 60 {
+		return fmt.Errorf("%w: horizonDays must be between 1 and 60", ErrInvalidPickupSettings)
+	}
+
+	seen := map[time.Weekday]bool{}
+	for _, h := range st.Hours {
+		if h.Weekday < time.Sunday || h.Weekday > time.Saturday || seen[h.Weekday] {
+			return fmt.Errorf("%w: each weekday (0-6) may appear once", ErrInvalidPickupSettings)
+		}
+		seen[h.Weekday] = true
+		if h.Closed {
+			continue
+		}
+		open, err1 := clockOffset(h.Open)
+		closing, err2 := clockOffset(h.Close)
+		if err1 != nil || err2 != nil || open >= closing {
+			return fmt.Errorf("%w: hours for %s must be HH:MM with open before close", ErrInvalidPickupSettings, h.Weekday)
+		}
+	}
+	return nil
+}
+
+// clockOffset turns "HH:MM" into the duration since midnight.
+func clockOffset(hhmm string) (time.Duration, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func parseStoreDate(date string, loc *time.Location) (time.Time, error) {
+	if date == "" {
+		return startOfDay(time.Now().In(loc)), nil
+	}
+	d, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return time.Time{}, ErrInvalidDate
+	}
+	return d, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
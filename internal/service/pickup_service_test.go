@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+)
+
+func TestDaySlotsAcrossDSTChange(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("timezone data not available")
+	}
+	st := &models.PickupSettings{
+		SlotMinutes: 60,
+		Capacity:    1,
+		Hours:       []models.OpeningHours{{Weekday: time.Sunday, Open: "09:00", Close: "12:00"}},
+	}
+	// Clocks go forward at 02:00 on 29 March 2026, a Sunday.
+	got := daySlots(st, time.Date(2026, 3, 29, 0, 0, 0, 0, loc))
+	if len(got) != 3 {
+		t.Fatalf("got %d slots, want 3", len(got))
+	}
+	for i, sl := range got {
+		s, e := sl.Start.In(loc), sl.End.In(loc)
+		if s.Hour() != 9+i || s.Minute() != 0 || e.Hour() != 10+i || e.Minute() != 0 {
+			t.Errorf("slot %d = %s - %s, want %02d:00-%02d:00", i, s, e, 9+i, 10+i)
+		}
+	}
+}
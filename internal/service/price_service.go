@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// How often the scheduler looks for price changes that are due.
+const priceScheduleCheck = time.Minute
+
+var (
+	ErrPriceProductNotFound  = errors.New("product not found")
+	ErrPriceScheduleNotFound = errors.New("price schedule not found")
+	ErrInvalidPriceSchedule  = errors.New("invalid price schedule")
+	ErrPriceScheduleState    = errors.New("price schedule is no longer pending")
+)
+
+// PriceActor is who changes a price: a staff user or an API key. The zero
+// value is the system.
+type PriceActor struct {
+	UserID   primitive.ObjectID
+	APIKeyID string
+}
+
+// PriceService keeps the price history of products and applies scheduled
+// price changes when they fall due.
+type PriceService struct {
+	prices   repository.PriceRepo
+	products repository.ProductRepo
+}
+
+// NewPriceService starts the scheduler in the background.
+func NewPriceService(prices repository.PriceRepo, products repository.ProductRepo) *PriceService {
+	s := &PriceService{prices: prices, products: products}
+	go s.schedulerLoop()
+	return s
+}
+
+// History returns the product's price changes since since (all when zero),
+// oldest first.
+func (s *PriceService) History(ctx context.Context, productID primitive.ObjectID, since time.Time) ([]models.PriceChange, error) {
+	if _, err := s.products.FindByID(ctx, productID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrPriceProductNotFound
+		}
+		return nil, err
+	}
+	return s.prices.History(ctx, productID, since)
+}
+
+func (s *PriceService) Schedules(ctx context.Context, productID primitive.ObjectID, status string) ([]models.PriceSchedule, error) {
+	return s.prices.ListSchedules(ctx, productID, strings.ToUpper(strings.TrimSpace(status)))
+}
+
+// Schedule plans a new price for a product from sc.EffectiveAt.
+func (s *PriceService) Schedule(ctx context.Context, sc *models.PriceSchedule, by PriceActor) error {
+	if sc.Price < 0 {
+		return fmt.Errorf("%w: price must not be negative", ErrInvalidPriceSchedule)
+	}
+	if !sc.EffectiveAt.After(time.Now()) {
+		return fmt.Errorf("%w: effectiveAt must be in the future", ErrInvalidPriceSchedule)
+	}
+	if _, err := s.products.FindByID(ctx, sc.ProductID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrPriceProductNotFound
+		}
+		return err
+	}
+	sc.ID = primitive.NilObjectID
+	sc.Note = strings.TrimSpace(sc.Note)
+	sc.Status = models.ScheduleStatusPending
+	sc.Failure = ""
+	sc.AppliedAt = nil
+	sc.CreatedBy = by.UserID
+	sc.APIKeyID = by.APIKeyID
+	id, err := s.prices.CreateSchedule(ctx, sc)
+	if err != nil {
+		return err
+	}
+	sc.ID = id
+	return nil
+}
+
+// Cancel calls off a pending schedule.
+func (s *PriceService) Cancel(ctx context.Context, id primitive.ObjectID) error {
+	ok, err := s.prices.SetScheduleStatus(ctx, id, []string{models.ScheduleStatusPending}, models.ScheduleStatusCancelled, "")
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+	if _, err := s.prices.FindSchedule(ctx, id); errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrPriceScheduleNotFound
+	}
+	return ErrPriceScheduleState
+}
+
+func (s *PriceService) schedulerLoop() {
+	t := time.NewTicker(priceScheduleCheck)
+	defer t.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := s.ApplyDue(ctx, time.Now()); err != nil {
+			log.Printf("[prices] apply scheduled prices: %v", err)
+		}
+		cancel()
+		<-t.C
+	}
+}
+
+// ApplyDue applies the pending schedules effective by at, earliest first.
+// Each is claimed before it is applied, so running it twice at once is
+// harmless, and only counts as applied once the price and its history entry
+// are both written.
+func (s *PriceService) ApplyDue(ctx context.Context, at time.Time) error {
+	due, err := s.prices.DueSchedules(ctx, at)
+	if err != nil {
+		return err
+	}
+	for _, sc := range due {
+		ok, err := s.prices.SetScheduleStatus(ctx, sc.ID, []string{models.ScheduleStatusPending}, models.ScheduleStatusApplying, "")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		status, failure := models.ScheduleStatusApplied, ""
+		if err := s.apply(ctx, &sc); err != nil {
+			status, failure = models.ScheduleStatusFailed, err.Error()
+		}
+		if _, err := s.prices.SetScheduleStatus(ctx, sc.ID, []string{models.ScheduleStatusApplying}, status, failure); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply sets the scheduled price and records it. If the history can't be
+// written the old price is put back.
+func (s *PriceService) apply(ctx context.Context, sc *models.PriceSchedule) error {
+	old, err := s.products.SetPrice(ctx, sc.ProductID, sc.Price)
+	if err != nil {
+		return err
+	}
+	by := PriceActor{UserID: sc.CreatedBy, APIKeyID: sc.APIKeyID}
+	if err := recordPriceChange(ctx, s.prices, old, sc.Price, models.PriceSourceScheduled, sc.ID, by); err != nil {
+		if _, rerr := s.products.SetPrice(context.Background(), sc.ProductID, old.Price); rerr != nil {
+			log.Printf("[prices] restore price of %s: %v", sc.ProductID.Hex(), rerr)
+		}
+		return fmt.Errorf("record price history: %w", err)
+	}
+	return nil
+}
+
+// recordPriceChange adds a history entry for p's price moving to price,
+// unless it didn't move.
+func recordPriceChange(ctx context.Context, prices repository.PriceRepo, p *models.Product, price models.Money, source string, scheduleID primitive.ObjectID, by PriceActor) error {
+	if source != models.PriceSourceCreated && p.Price == price {
+		return nil
+	}
+	c := &models.PriceChange{
+		ProductID:  p.ID,
+		NewPrice:   price,
+		Currency:   p.Currency,
+		Source:     source,
+		ScheduleID: scheduleID,
+		ChangedBy:  by.UserID,
+		APIKeyID:   by.APIKeyID,
+	}
+	if source != models.PriceSourceCreated {
+		c.OldPrice = p.Price
+	}
+	return prices.AddChange(ctx, c)
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newPriceFixture(price models.Money) (*PriceService, *memProducts, *memPrices, *models.PriceSchedule) {
+	p := &models.Product{ID: primitive.NewObjectID(), Price: price, Currency: "KZT"}
+	sc := &models.PriceSchedule{
+		ID:          primitive.NewObjectID(),
+		ProductID:   p.ID,
+		Price:       price / 2,
+		EffectiveAt: time.Now().Add(-time.Minute),
+		Status:      models.ScheduleStatusPending,
+		CreatedBy:   primitive.NewObjectID(),
+		APIKeyID:    "key-1",
+	}
+	products := &memProducts{products: map[primitive.ObjectID]*models.Product{p.ID: p}}
+	prices := &memPrices{schedules: map[primitive.ObjectID]*models.PriceSchedule{sc.ID: sc}}
+	// Built by hand so that no scheduler runs alongside the test.
+	return &PriceService{prices: prices, products: products}, products, prices, sc
+}
+
+func TestApplyDueRecordsWhoScheduled(t *testing.T) {
+	s, products, prices, sc := newPriceFixture(1000)
+
+	if err := s.ApplyDue(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if sc.Status != models.ScheduleStatusApplied {
+		t.Errorf("status = %s, want APPLIED", sc.Status)
+	}
+	if p, _ := products.FindByID(context.Background(), sc.ProductID); p.Price != 500 {
+		t.Errorf("price = %v, want 5.00", p.Price)
+	}
+	if len(prices.changes) != 1 {
+		t.Fatalf("history = %+v, want one entry", prices.changes)
+	}
+	c := prices.changes[0]
+	if c.OldPrice != 1000 || c.NewPrice != 500 || c.ScheduleID != sc.ID {
+		t.Errorf("entry = %+v", c)
+	}
+	if c.ChangedBy != sc.CreatedBy || c.APIKeyID != sc.APIKeyID {
+		t.Errorf("entry by %s/%q, want %s/%q", c.ChangedBy.Hex(), c.APIKeyID, sc.CreatedBy.Hex(), sc.APIKeyID)
+	}
+}
+
+func TestApplyDueWithoutHistoryKeepsOldPrice(t *testing.T) {
+	s, products, prices, sc := newPriceFixture(1000)
+	prices.addErr = errors.New("history unavailable")
+
+	if err := s.ApplyDue(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if sc.Status != models.ScheduleStatusFailed || sc.Failure == "" {
+		t.Errorf("status = %s (%q), want FAILED with a reason", sc.Status, sc.Failure)
+	}
+	if p, _ := products.FindByID(context.Background(), sc.ProductID); p.Price != 1000 {
+		t.Errorf("price = %v, want the old 10.00 back", p.Price)
+	}
+}
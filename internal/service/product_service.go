@@ -2,24 +2,63 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"os"
+	"strings"
 
 	"github.com/dannieey/Assignment3_Absolute/internal/models"
 	"github.com/dannieey/Assignment3_Absolute/internal/repository"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+const defaultStoreCurrency = "KZT"
+
+var (
+	ErrInvalidProduct  = errors.New("invalid product")
+	ErrProductNotFound = errors.New("product not found")
+)
+
+// StoreCurrency is the ISO code all prices are in, from STORE_CURRENCY
+// (default KZT). Carts and orders never mix currencies.
+func StoreCurrency() string {
+	c := strings.ToUpper(strings.TrimSpace(os.Getenv("STORE_CURRENCY")))
+	if c == "" {
+		return defaultStoreCurrency
+	}
+	return c
+}
+
 type ProductService struct {
-	repo repository.ProductRepo
+	repo   repository.ProductRepo
+	prices repository.PriceRepo
 }
 
-func NewProductService(repo repository.ProductRepo) *ProductService {
-	return &ProductService{repo: repo}
+func NewProductService(repo repository.ProductRepo, prices repository.PriceRepo) *ProductService {
+	return &ProductService{repo: repo, prices: prices}
 }
 
-func (s *ProductService) Create(ctx context.Context, p *models.Product) (primitive.ObjectID, error) {
+func (s *ProductService) Create(ctx context.Context, p *models.Product, by PriceActor) (primitive.ObjectID, error) {
+	if err := normalizeProductPrice(p); err != nil {
+		return primitive.NilObjectID, err
+	}
 	s.applyAvailabilityLogic(p)
-	return s.repo.Create(ctx, p)
+	p.Markdown = nil // only the markdown scheduler sets it
+	id, err := s.repo.Create(ctx, p)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	p.ID = id
+	// A product isn't kept without its first price in the history.
+	if err := recordPriceChange(ctx, s.prices, p, p.Price, models.PriceSourceCreated, primitive.NilObjectID, by); err != nil {
+		if derr := s.repo.Delete(context.Background(), id); derr != nil {
+			log.Printf("[prices] remove product %s without price history: %v", id.Hex(), derr)
+		}
+		return primitive.NilObjectID, fmt.Errorf("record price history: %w", err)
+	}
+	return id, nil
 }
 
 func (s *ProductService) List(ctx context.Context, q string, catID *primitive.ObjectID) ([]models.Product, error) {
@@ -29,19 +68,56 @@ func (s *ProductService) List(ctx context.Context, q string, catID *primitive.Ob
 	}
 	for i := range products {
 		s.applyAvailabilityLogic(&products[i])
+		showMarkdownPrice(&products[i])
 	}
 	return products, nil
 }
 
-func (s *ProductService) Update(ctx context.Context, id primitive.ObjectID, p *models.Product) error {
+// Update replaces the product; a new price goes into its price history.
+func (s *ProductService) Update(ctx context.Context, id primitive.ObjectID, p *models.Product, by PriceActor) error {
+	if err := normalizeProductPrice(p); err != nil {
+		return err
+	}
 	s.applyAvailabilityLogic(p)
-	return s.repo.Update(ctx, id, p)
+	// The update hands back the product as it was, so the history gets
+	// the price this update replaced even if another one came first.
+	old, err := s.repo.Update(ctx, id, p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := recordPriceChange(ctx, s.prices, old, p.Price, models.PriceSourceManual, primitive.NilObjectID, by); err != nil {
+		// A price isn't changed without a history entry for it.
+		if _, rerr := s.repo.SetPrice(context.Background(), id, old.Price); rerr != nil {
+			log.Printf("[prices] restore price of %s: %v", id.Hex(), rerr)
+		}
+		return fmt.Errorf("record price history: %w", err)
+	}
+	return nil
 }
 
 func (s *ProductService) Delete(ctx context.Context, id primitive.ObjectID) error {
 	return s.repo.Delete(ctx, id)
 }
 
+// normalizeProductPrice defaults the currency to the store's and rejects
+// anything else, so that line totals can always be added up.
+func normalizeProductPrice(p *models.Product) error {
+	if p.Price < 0 {
+		return fmt.Errorf("%w: price must not be negative", ErrInvalidProduct)
+	}
+	p.Currency = strings.ToUpper(strings.TrimSpace(p.Currency))
+	if p.Currency == "" {
+		p.Currency = StoreCurrency()
+	}
+	if p.Currency != StoreCurrency() {
+		return fmt.Errorf("%w: prices must be in %s", ErrInvalidProduct, StoreCurrency())
+	}
+	return nil
+}
+
 func (s *ProductService) applyAvailabilityLogic(p *models.Product) {
 	if p.StockQty <= 0 {
 		p.AvailabilityStatus = "OUT_OF_STOCK"
@@ -52,18 +128,34 @@ func (s *ProductService) applyAvailabilityLogic(p *models.Product) {
 	}
 }
 
+// showMarkdownPrice fills in the reduced price of a marked down product.
+func showMarkdownPrice(p *models.Product) {
+	if p.Markdown != nil {
+		p.Markdown.Price = p.SellingPrice()
+	}
+}
+
 func (s *ProductService) DecreaseStock(ctx context.Context, id primitive.ObjectID, qty int) error {
 	if qty <= 0 {
 		return nil
 	}
 	return s.repo.DecreaseStock(ctx, id, qty)
 }
+
+// IncreaseStock puts returned or refunded goods back on the shelf.
+func (s *ProductService) IncreaseStock(ctx context.Context, id primitive.ObjectID, qty int) error {
+	if qty <= 0 {
+		return nil
+	}
+	return s.repo.IncreaseStock(ctx, id, qty)
+}
 func (s *ProductService) GetByID(ctx context.Context, id primitive.ObjectID) (*models.Product, error) {
 	p, err := s.repo.FindByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 	s.applyAvailabilityLogic(p)
+	showMarkdownPrice(p)
 	return p, nil
 }
 
@@ -76,6 +168,7 @@ func (s *ProductService) GetByBarcode(ctx context.Context, barcode string) (*mod
 		return nil, err
 	}
 	s.applyAvailabilityLogic(p)
+	showMarkdownPrice(p)
 	return p, nil
 }
 
@@ -86,6 +179,7 @@ func (s *ProductService) ListWithFilter(ctx context.Context, filter repository.P
 	}
 	for i := range result.Products {
 		s.applyAvailabilityLogic(&result.Products[i])
+		showMarkdownPrice(&result.Products[i])
 	}
 	return result, nil
 }
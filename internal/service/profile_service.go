@@ -0,0 +1,315 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	emailVerifyTTL = 24 * time.Hour
+	confirmCodeTTL = 15 * time.Minute
+)
+
+// Actions a user without a password confirms with an emailed code.
+const (
+	ConfirmDeleteAccount = "delete-account"
+	ConfirmSetPassword   = "set-password"
+)
+
+var (
+	ErrWrongPassword      = errors.New("current password is incorrect")
+	ErrInvalidEmail       = errors.New("invalid email")
+	ErrInvalidVerifyToken = errors.New("invalid or expired verification token")
+	ErrFullNameRequired   = errors.New("fullName is required")
+	ErrNothingToUpdate    = errors.New("nothing to update")
+	ErrInvalidConfirmCode = errors.New("invalid or expired confirmation code")
+	ErrHasPassword        = errors.New("account has a password; confirm with it instead")
+	ErrUnknownConfirm     = errors.New("unknown confirmation action")
+)
+
+// ProfileService lets a signed-in user manage their own account.
+type ProfileService struct {
+	users     repository.UserRepo
+	orders    repository.OrderRepo
+	carts     repository.CartRepo
+	wishlists repository.WishlistRepo
+	addresses repository.AddressRepo
+	exports   repository.ExportRepo
+	loyalty   repository.LoyaltyRepo
+	admins    *AdminGuard
+	mailer    Mailer
+}
+
+func NewProfileService(
+	users repository.UserRepo,
+	orders repository.OrderRepo,
+	carts repository.CartRepo,
+	wishlists repository.WishlistRepo,
+	addresses repository.AddressRepo,
+	exports repository.ExportRepo,
+	loyalty repository.LoyaltyRepo,
+	admins *AdminGuard,
+	mailer Mailer,
+) *ProfileService {
+	return &ProfileService{
+		users:     users,
+		orders:    orders,
+		carts:     carts,
+		wishlists: wishlists,
+		addresses: addresses,
+		exports:   exports,
+		loyalty:   loyalty,
+		admins:    admins,
+		mailer:    mailer,
+	}
+}
+
+type ProfileUpdate struct {
+	FullName *string
+	Email    *string
+}
+
+// Update changes the full name right away. A new email is only stored as
+// pending and a verification link is sent to it; emailPending reports that.
+func (s *ProfileService) Update(ctx context.Context, userID primitive.ObjectID, upd ProfileUpdate) (emailPending bool, err error) {
+	if upd.FullName == nil && upd.Email == nil {
+		return false, ErrNothingToUpdate
+	}
+
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, ErrUserNotFound
+		}
+		return false, err
+	}
+
+	if upd.FullName != nil {
+		name := strings.TrimSpace(*upd.FullName)
+		if name == "" {
+			return false, ErrFullNameRequired
+		}
+		if err := s.users.UpdateFullName(ctx, userID, name); err != nil {
+			return false, err
+		}
+		u.FullName = name
+	}
+
+	if upd.Email != nil {
+		return s.requestEmailChange(ctx, u, *upd.Email)
+	}
+	return false, nil
+}
+
+func (s *ProfileService) requestEmailChange(ctx context.Context, u *models.User, email string) (bool, error) {
+	email = strings.TrimSpace(strings.ToLower(email))
+	if email == "" || !strings.Contains(email, "@") {
+		return false, ErrInvalidEmail
+	}
+	if email == u.Email {
+		return false, nil
+	}
+
+	_, err := s.users.FindByEmail(ctx, email)
+	if err == nil {
+		return false, ErrEmailAlreadyUsed
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return false, err
+	}
+
+	token, tokenHash, err := newVerifyToken()
+	if err != nil {
+		return false, err
+	}
+	if err := s.users.SetPendingEmail(ctx, u.ID, email, tokenHash, time.Now().Add(emailVerifyTTL)); err != nil {
+		return false, err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", frontendBaseURL(), token)
+	body := fmt.Sprintf("Hi %s,\n\nConfirm your new email address by opening:\n%s\n\nThe link expires in 24 hours.", u.FullName, link)
+	if err := s.mailer.Send(ctx, email, "Confirm your new email address", body); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// VerifyEmail completes an email change started by Update.
+func (s *ProfileService) VerifyEmail(ctx context.Context, token string) error {
+	if token == "" {
+		return ErrInvalidVerifyToken
+	}
+	sum := sha256.Sum256([]byte(token))
+
+	u, err := s.users.FindByEmailVerifyToken(ctx, hex.EncodeToString(sum[:]))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrInvalidVerifyToken
+	}
+	if err != nil {
+		return err
+	}
+
+	// The address may have been taken while the link was waiting.
+	_, err = s.users.FindByEmail(ctx, u.PendingEmail)
+	if err == nil {
+		return ErrEmailAlreadyUsed
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	return s.users.ConfirmPendingEmail(ctx, u.ID, u.PendingEmail)
+}
+
+// RequestConfirmCode emails a one-time code that confirms action for a user
+// who signs in through OIDC only and so has no password to re-enter.
+func (s *ProfileService) RequestConfirmCode(ctx context.Context, userID primitive.ObjectID, action string) error {
+	if action != ConfirmDeleteAccount && action != ConfirmSetPassword {
+		return ErrUnknownConfirm
+	}
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if u.PasswordHash != "" {
+		return ErrHasPassword
+	}
+
+	code, codeHash, err := newVerifyToken()
+	if err != nil {
+		return err
+	}
+	if err := s.users.SetConfirmCode(ctx, u.ID, action, codeHash, time.Now().Add(confirmCodeTTL)); err != nil {
+		return err
+	}
+	what := "delete your account"
+	if action == ConfirmSetPassword {
+		what = "set a password"
+	}
+	body := fmt.Sprintf("Hi %s,\n\nUse this code to %s:\n%s\n\nThe code expires in 15 minutes. If you didn't ask for it, ignore this email.", u.FullName, what, code)
+	return s.mailer.Send(ctx, u.Email, "Confirm your account change", body)
+}
+
+// confirm checks the password, or for a user without one the emailed code
+// for action.
+func (s *ProfileService) confirm(ctx context.Context, u *models.User, password, code, action string) error {
+	if u.PasswordHash != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)); err != nil {
+			return ErrWrongPassword
+		}
+		return nil
+	}
+	if code == "" {
+		return ErrInvalidConfirmCode
+	}
+	sum := sha256.Sum256([]byte(code))
+	ok, err := s.users.UseConfirmCode(ctx, u.ID, action, hex.EncodeToString(sum[:]))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidConfirmCode
+	}
+	return nil
+}
+
+// ChangePassword sets a new password after checking the current one. Users
+// without a password (OIDC only) set their first one with a code from
+// RequestConfirmCode instead.
+func (s *ProfileService) ChangePassword(ctx context.Context, userID primitive.ObjectID, current, code, next string) error {
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if len(next) < minPasswordLength {
+		return ErrPasswordTooWeak
+	}
+	if err := s.confirm(ctx, u, current, code, ConfirmSetPassword); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(next), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return s.users.UpdatePasswordHash(ctx, userID, string(hash))
+}
+
+// DeleteAccount removes the user after confirming their password, or the
+// emailed code for users without one. Orders are kept but detached from the
+// account so sales reports stay intact; cart, wishlist, address book, data
+// exports and loyalty points are deleted. Store credit transfers are kept:
+// the stored value ledger has to balance, and they name the account only by
+// the user's id, which no longer leads to anyone.
+func (s *ProfileService) DeleteAccount(ctx context.Context, userID primitive.ObjectID, password, code string) error {
+	u, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if err := s.confirm(ctx, u, password, code, ConfirmDeleteAccount); err != nil {
+		return err
+	}
+	return s.admins.Revoke(ctx, userID, func(*models.User) error {
+		return s.deleteAccount(ctx, userID)
+	})
+}
+
+func (s *ProfileService) deleteAccount(ctx context.Context, userID primitive.ObjectID) error {
+	if _, err := s.orders.AnonymizeByUserID(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.carts.DeleteByUserID(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.wishlists.DeleteByUserID(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.addresses.DeleteByUserID(ctx, userID); err != nil {
+		return err
+	}
+	if _, err := s.exports.DeleteByUserID(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.loyalty.DeleteByUserID(ctx, userID); err != nil {
+		return err
+	}
+	return s.users.Delete(ctx, userID)
+}
+
+func newVerifyToken() (token, tokenHash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	sum := sha256.Sum256([]byte(token))
+	return token, hex.EncodeToString(sum[:]), nil
+}
+
+func frontendBaseURL() string {
+	if u := strings.TrimSpace(os.Getenv("FRONTEND_URL")); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "http://localhost:5173"
+}
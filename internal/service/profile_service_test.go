@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type lastMail struct{ body string }
+
+func (m *lastMail) Send(_ context.Context, _, _, body string) error {
+	m.body = body
+	return nil
+}
+
+// code picks the confirmation code out of the email body.
+func (m *lastMail) code() string {
+	lines := strings.Split(m.body, "\n")
+	for i, l := range lines {
+		if strings.HasPrefix(l, "Use this code") && i+1 < len(lines) {
+			return lines[i+1]
+		}
+	}
+	return ""
+}
+
+func TestPasswordlessUserSetsPasswordWithEmailedCode(t *testing.T) {
+	ctx := context.Background()
+	users := &memUsers{users: map[primitive.ObjectID]*models.User{}}
+	mail := &lastMail{}
+	s := NewProfileService(users, nil, nil, nil, nil, nil, nil, nil, mail)
+	u := users.add(&models.User{Email: "sso@example.com", Role: models.RoleCustomer})
+
+	if err := s.ChangePassword(ctx, u.ID, "", "", "a-new-password"); !errors.Is(err, ErrInvalidConfirmCode) {
+		t.Fatalf("without code: err = %v, want ErrInvalidConfirmCode", err)
+	}
+	if err := s.RequestConfirmCode(ctx, u.ID, ConfirmDeleteAccount); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ChangePassword(ctx, u.ID, "", mail.code(), "a-new-password"); !errors.Is(err, ErrInvalidConfirmCode) {
+		t.Fatalf("code for another action: err = %v, want ErrInvalidConfirmCode", err)
+	}
+
+	if err := s.RequestConfirmCode(ctx, u.ID, ConfirmSetPassword); err != nil {
+		t.Fatal(err)
+	}
+	code := mail.code()
+	if err := s.ChangePassword(ctx, u.ID, "", code, "a-new-password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RequestConfirmCode(ctx, u.ID, ConfirmSetPassword); !errors.Is(err, ErrHasPassword) {
+		t.Fatalf("after setting a password: err = %v, want ErrHasPassword", err)
+	}
+	if err := s.ChangePassword(ctx, u.ID, "", code, "another-password"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("reused code: err = %v, want ErrWrongPassword", err)
+	}
+}
+
+func TestDeleteAccountRemovesPersonalData(t *testing.T) {
+	ctx := context.Background()
+	users := &memUsers{users: map[primitive.ObjectID]*models.User{}}
+	data := &userData{records: map[string]map[primitive.ObjectID]int{}}
+	s := NewProfileService(users, dataOrders{userData: data}, dataCarts{userData: data}, dataWishlists{userData: data},
+		dataAddresses{userData: data}, dataExports{userData: data}, dataLoyalty{userData: data}, nil, nil)
+
+	kinds := []string{"orders", "carts", "wishlists", "addresses", "exports", "loyalty"}
+	u := users.add(&models.User{Email: "gone@example.com", Role: models.RoleCustomer})
+	other := users.add(&models.User{Email: "stays@example.com", Role: models.RoleCustomer})
+	for _, k := range kinds {
+		data.add(k, u.ID)
+		data.add(k, other.ID)
+	}
+
+	if err := s.deleteAccount(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.FindByID(ctx, u.ID); err == nil {
+		t.Error("user still exists")
+	}
+	for _, k := range kinds {
+		if n := data.count(k, u.ID); n != 0 {
+			t.Errorf("%d %s left for the deleted user", n, k)
+		}
+		if n := data.count(k, other.ID); n != 1 {
+			t.Errorf("other user has %d %s, want 1", n, k)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrPromotionNotFound = errors.New("promotion not found")
+	ErrInvalidPromotion  = errors.New("invalid promotion")
+)
+
+// PromotionService manages promotions and applies the running ones to carts
+// and orders.
+type PromotionService struct {
+	repo repository.PromotionRepo
+}
+
+func NewPromotionService(repo repository.PromotionRepo) *PromotionService {
+	return &PromotionService{repo: repo}
+}
+
+func (s *PromotionService) List(ctx context.Context) ([]models.Promotion, error) {
+	return s.repo.List(ctx)
+}
+
+// Running lists the promotions customers can get right now.
+func (s *PromotionService) Running(ctx context.Context) ([]models.Promotion, error) {
+	return s.repo.ListRunning(ctx, time.Now())
+}
+
+func (s *PromotionService) Create(ctx context.Context, p *models.Promotion) error {
+	if err := normalizePromotion(p); err != nil {
+		return err
+	}
+	id, err := s.repo.Create(ctx, p)
+	if err != nil {
+		return err
+	}
+	p.ID = id
+	return nil
+}
+
+func (s *PromotionService) Update(ctx context.Context, id primitive.ObjectID, p *models.Promotion) error {
+	existing, err := s.repo.FindByID(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrPromotionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := normalizePromotion(p); err != nil {
+		return err
+	}
+	p.ID = id
+	p.CreatedAt = existing.CreatedAt
+	return s.repo.Update(ctx, p)
+}
+
+func (s *PromotionService) Delete(ctx context.Context, id primitive.ObjectID) error {
+	err := s.repo.Delete(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrPromotionNotFound
+	}
+	return err
+}
+
+// PricedLine is a cart or order line to discount at the product's price.
+type PricedLine struct {
+	Product  *models.Product
+	Quantity int
+}
+
+// Discounts is what the running promotions take off a set of lines.
+type Discounts struct {
+	Lines   []models.Money // one per PricedLine, in order
+	Applied []models.AppliedDiscount
+	Total   models.Money
+}
+
+// Apply applies the promotions running at at to lines, together with the
+// promotion of coupon when it is not nil.
+func (s *PromotionService) Apply(ctx context.Context, lines []PricedLine, at time.Time, coupon *CouponOffer) (*Discounts, error) {
+	promos, err := s.repo.ListRunning(ctx, at)
+	if err != nil {
+		return nil, err
+	}
+	if coupon != nil {
+		promos = append(promos, *coupon.Promotion)
+		sort.SliceStable(promos, func(i, j int) bool { return promos[i].Priority > promos[j].Priority })
+	}
+
+	d := applyPromotions(promos, lines)
+	if coupon != nil {
+		for i := range d.Applied {
+			if d.Applied[i].PromotionID == coupon.Promotion.ID {
+				d.Applied[i].CouponCode = coupon.Coupon.Code
+			}
+		}
+	}
+	return d, nil
+}
+
+// applyPromotions applies promos, highest priority first, to lines. No line
+// is discounted below zero.
+func applyPromotions(promos []models.Promotion, lines []PricedLine) *Discounts {
+	d := &Discounts{Lines: make([]models.Money, len(lines)), Applied: []models.AppliedDiscount{}}
+	locked := make([]bool, len(lines)) // a non-stackable promotion applied
+	remaining := func(j int) models.Money {
+		return lines[j].Product.SellingPrice().Mul(lines[j].Quantity) - d.Lines[j]
+	}
+	give := func(p *models.Promotion, j int, off models.Money) {
+		d.Lines[j] += off
+		d.Total += off
+		if !p.Stackable {
+			locked[j] = true
+		}
+	}
+
+	for i := range promos {
+		p := &promos[i]
+		var eligible []int
+		for j, l := range lines {
+			if locked[j] || !p.Matches(l.Product) || remaining(j) <= 0 {
+				continue
+			}
+			if !p.Stackable && d.Lines[j] > 0 {
+				continue
+			}
+			eligible = append(eligible, j)
+		}
+
+		if p.Type == models.PromoSpendThreshold {
+			var base models.Money
+			for _, j := range eligible {
+				base += remaining(j)
+			}
+			if len(eligible) == 0 || base < p.MinSpend {
+				continue
+			}
+			off := p.Amount
+			if p.BasisPoints > 0 {
+				off = base.MulRatio(p.BasisPoints, 10000)
+			}
+			off = min(off, base)
+			if off <= 0 {
+				continue
+			}
+			bases := make([]models.Money, len(eligible))
+			for k, j := range eligible {
+				bases[k] = remaining(j)
+			}
+			for k, share := range spreadByValue(off, bases) {
+				give(p, eligible[k], share)
+			}
+			d.Applied = append(d.Applied, models.AppliedDiscount{
+				PromotionID: p.ID, Name: p.Name, Type: p.Type, Amount: off,
+			})
+			continue
+		}
+
+		for _, j := range eligible {
+			off := min(lineDiscount(p, lines[j]), remaining(j))
+			if off <= 0 {
+				continue
+			}
+			give(p, j, off)
+			d.Applied = append(d.Applied, models.AppliedDiscount{
+				PromotionID: p.ID, Name: p.Name, Type: p.Type, ProductID: lines[j].Product.ID, Amount: off,
+			})
+		}
+	}
+	return d
+}
+
+// AddSpread adds an order-level discount a, spreading its amount over lines
+// by what is left of each after the discounts so far. The amount must not be
+// more than that.
+func (d *Discounts) AddSpread(lines []PricedLine, a models.AppliedDiscount) {
+	bases := make([]models.Money, len(lines))
+	for j, l := range lines {
+		bases[j] = l.Product.SellingPrice().Mul(l.Quantity) - d.Lines[j]
+	}
+	for j, share := range spreadByValue(a.Amount, bases) {
+		d.Lines[j] += share
+	}
+	d.Applied = append(d.Applied, a)
+	d.Total += a.Amount
+}
+
+// spreadByValue splits off over lines worth bases, in proportion to their
+// value. Each line gets the rounded running total up to it less the one
+// before, so the shares add up to off, none is negative and lines worth
+// nothing get nothing; the last line with a value takes the rounding.
+func spreadByValue(off models.Money, bases []models.Money) []models.Money {
+	var total models.Money
+	for _, b := range bases {
+		if b > 0 {
+			total += b
+		}
+	}
+	shares := make([]models.Money, len(bases))
+	var cum, given models.Money
+	for k, b := range bases {
+		if b <= 0 {
+			continue
+		}
+		cum += b
+		upTo := off.MulRatio(int64(cum), int64(total))
+		shares[k] = upTo - given
+		given = upTo
+	}
+	return shares
+}
+
+// lineDiscount is what an item-level promotion takes off one line before
+// capping; percentages apply to the full line price.
+func lineDiscount(p *models.Promotion, l PricedLine) models.Money {
+	price := l.Product.SellingPrice()
+	switch p.Type {
+	case models.PromoPercentOff:
+		return price.Mul(l.Quantity).MulRatio(p.BasisPoints, 10000)
+	case models.PromoFixedOff:
+		return min(p.Amount, price).Mul(l.Quantity)
+	case models.PromoBuyXGetY:
+		free := l.Quantity / (p.BuyQty + p.GetQty) * p.GetQty
+		return price.Mul(free)
+	case models.PromoMultiBuy:
+		saving := price.Mul(p.BundleQty) - p.BundlePrice
+		if saving <= 0 {
+			return 0
+		}
+		return saving.Mul(l.Quantity / p.BundleQty)
+	}
+	return 0
+}
+
+func normalizePromotion(p *models.Promotion) error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidPromotion)
+	}
+	p.Type = strings.ToUpper(strings.TrimSpace(p.Type))
+	if !models.IsValidPromotionType(p.Type) {
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidPromotion, p.Type)
+	}
+	if p.BasisPoints < 0 || p.BasisPoints > 10000 {
+		return fmt.Errorf("%w: basisPoints must be between 0 and 10000", ErrInvalidPromotion)
+	}
+	if p.Amount < 0 || p.BundlePrice < 0 || p.MinSpend < 0 || p.BuyQty < 0 || p.GetQty < 0 || p.BundleQty < 0 {
+		return fmt.Errorf("%w: amounts and quantities must not be negative", ErrInvalidPromotion)
+	}
+
+	switch p.Type {
+	case models.PromoPercentOff:
+		if p.BasisPoints == 0 {
+			return fmt.Errorf("%w: basisPoints is required", ErrInvalidPromotion)
+		}
+	case models.PromoFixedOff:
+		if p.Amount == 0 {
+			return fmt.Errorf("%w: amount is required", ErrInvalidPromotion)
+		}
+	case models.PromoBuyXGetY:
+		if p.BuyQty == 0 || p.GetQty == 0 {
+			return fmt.Errorf("%w: buyQty and getQty are required", ErrInvalidPromotion)
+		}
+	case models.PromoMultiBuy:
+		if p.BundleQty < 2 || p.BundlePrice == 0 {
+			return fmt.Errorf("%w: bundleQty of at least 2 and bundlePrice are required", ErrInvalidPromotion)
+		}
+	case models.PromoSpendThreshold:
+		if p.MinSpend == 0 {
+			return fmt.Errorf("%w: minSpend is required", ErrInvalidPromotion)
+		}
+		if (p.Amount == 0) == (p.BasisPoints == 0) {
+			return fmt.Errorf("%w: give either amount or basisPoints", ErrInvalidPromotion)
+		}
+	}
+
+	if p.StartsAt != nil && p.EndsAt != nil && !p.EndsAt.After(*p.StartsAt) {
+		return fmt.Errorf("%w: endsAt must be after startsAt", ErrInvalidPromotion)
+	}
+	return nil
+}
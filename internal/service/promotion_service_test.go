@@ -0,0 +1,41 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+)
+
+func TestSpreadByValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		off   models.Money
+		bases []models.Money
+		want  []models.Money
+	}{
+		{"proportional", 300, []models.Money{100, 200}, []models.Money{100, 200}},
+		{"rounding", 2, []models.Money{1, 1, 1}, []models.Money{1, 0, 1}},
+		{"trailing zero base", 100, []models.Money{100, 200, 0}, []models.Money{33, 67, 0}},
+		{"zero bases between", 50, []models.Money{0, 100, 0, 100, 0}, []models.Money{0, 25, 0, 25, 0}},
+		{"rounding before a zero base", 1, []models.Money{1, 1, 0}, []models.Money{1, 0, 0}},
+		{"all of it", 7, []models.Money{3, 0, 4}, []models.Money{3, 0, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := spreadByValue(tt.off, tt.bases)
+			var sum models.Money
+			for k, share := range got {
+				if share != tt.want[k] {
+					t.Fatalf("shares = %v, want %v", got, tt.want)
+				}
+				if share < 0 || share > max(tt.bases[k], 0) {
+					t.Errorf("line %d gets %v of a %v base", k, share, tt.bases[k])
+				}
+				sum += share
+			}
+			if sum != tt.off {
+				t.Errorf("shares add up to %v, want %v", sum, tt.off)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrInvalidRefund      = errors.New("invalid refund")
+	ErrNothingToRefund    = errors.New("nothing left to refund")
+	ErrOrderNotRefundable = errors.New("order cannot be refunded in its current state")
+	ErrRefundConflict     = errors.New("order changed while refunding, try again")
+)
+
+// Order statuses in which goods have been handed over (or the attempt to do
+// so has ended), so money may go back.
+var refundableStatuses = []string{"DONE", models.OrderDelivered, models.OrderDeliveryFailed, models.OrderPartiallyRefunded}
+
+// RefundLineInput asks to refund Quantity units of a product on the order.
+type RefundLineInput struct {
+	ProductID primitive.ObjectID
+	Quantity  int
+	Restock   bool
+}
+
+// RefundInput is a staff refund. Full refunds everything not yet refunded,
+// including the delivery fee, and Restock then applies to every line.
+// StoreCredit pays the refund out as store credit instead of to the card.
+type RefundInput struct {
+	Full        bool
+	Restock     bool
+	Lines       []RefundLineInput
+	DeliveryFee bool
+	StoreCredit bool
+	Reason      string
+	Note        string
+}
+
+// RefundService gives money back on orders, through the payment provider when
+// the order was paid online.
+type RefundService struct {
+	refunds        repository.RefundRepo
+	orders         repository.OrderRepo
+	payments       *PaymentService
+	credits        *StoreCreditService
+	storedValue    *StoredValueService
+	productService *ProductService
+	loyalty        *LoyaltyService
+}
+
+func NewRefundService(
+	refunds repository.RefundRepo,
+	orders repository.OrderRepo,
+	payments *PaymentService,
+	credits *StoreCreditService,
+	storedValue *StoredValueService,
+	productService *ProductService,
+	loyalty *LoyaltyService,
+) *RefundService {
+	return &RefundService{
+		refunds:        refunds,
+		orders:         orders,
+		payments:       payments,
+		credits:        credits,
+		storedValue:    storedValue,
+		productService: productService,
+		loyalty:        loyalty,
+	}
+}
+
+func (s *RefundService) ForOrder(ctx context.Context, orderID primitive.ObjectID) ([]models.Refund, error) {
+	return s.refunds.ListByOrderID(ctx, orderID)
+}
+
+// Refund books the refund on the order first, so a concurrent refund can't
+// take the same lines, then pays it out and rolls the order back if the
+// provider refuses.
+func (s *RefundService) Refund(ctx context.Context, orderID, staffID primitive.ObjectID, in RefundInput) (*models.Refund, error) {
+	in.Reason = strings.ToUpper(strings.TrimSpace(in.Reason))
+	in.Note = strings.TrimSpace(in.Note)
+	if !models.IsValidRefundReason(in.Reason) {
+		return nil, fmt.Errorf("%w: unknown reason %q", ErrInvalidRefund, in.Reason)
+	}
+
+	o, err := s.orders.FindByID(ctx, orderID)
+	if err != nil {
+		return nil, ErrOrderNotFound
+	}
+	if !slices.Contains(refundableStatuses, o.Status) {
+		return nil, ErrOrderNotRefundable
+	}
+	method := refundMethod(o)
+	if method == "" {
+		return nil, fmt.Errorf("%w: payment has not been captured", ErrOrderNotRefundable)
+	}
+	if in.StoreCredit {
+		if o.UserID.IsZero() {
+			return nil, fmt.Errorf("%w: order has no customer account", ErrOrderNotRefundable)
+		}
+		method = models.RefundMethodStoreCredit
+	}
+
+	prev := *o
+	prev.Items = slices.Clone(o.Items)
+	prev.Tenders = slices.Clone(o.Tenders)
+
+	rf := &models.Refund{
+		OrderID:   o.ID,
+		PaymentID: o.PaymentID,
+		Reason:    in.Reason,
+		Note:      in.Note,
+		Method:    method,
+		Status:    models.RefundPending,
+		CreatedBy: staffID,
+	}
+	if err := applyRefundLines(o, rf, in); err != nil {
+		return nil, err
+	}
+	if method != models.RefundMethodStoreCredit {
+		allocateStoredValue(o, rf)
+	}
+
+	rf.ID, err = s.refunds.Create(ctx, rf)
+	if err != nil {
+		return nil, err
+	}
+
+	note := fmt.Sprintf("Refunded %s (%s)", rf.Amount, rf.Reason)
+	ok, err := s.orders.SaveRefundState(ctx, o, note)
+	if err == nil && !ok {
+		err = ErrRefundConflict
+	}
+	if err != nil {
+		s.fail(ctx, rf, err)
+		return nil, err
+	}
+
+	if err := s.payOut(ctx, o, rf, note); err != nil {
+		prev.UpdatedAt = o.UpdatedAt
+		if _, rbErr := s.orders.SaveRefundState(ctx, &prev, "Refund failed: "+err.Error()); rbErr != nil {
+			log.Printf("[refunds] roll back order %s: %v", o.ID.Hex(), rbErr)
+		}
+		s.fail(ctx, rf, err)
+		return nil, err
+	}
+
+	for _, l := range rf.Lines {
+		if !l.Restock {
+			continue
+		}
+		if err := s.productService.IncreaseStock(ctx, l.ProductID, l.Quantity); err != nil {
+			log.Printf("[refunds] restock %s: %v", l.ProductID.Hex(), err)
+		}
+	}
+
+	if err := s.loyalty.Reconcile(ctx, o.ID); err != nil {
+		log.Printf("[refunds] loyalty points of order %s: %v", o.ID.Hex(), err)
+	}
+
+	if err := s.refunds.SetStatus(ctx, rf.ID, models.RefundCompleted, ""); err != nil {
+		return nil, err
+	}
+	rf.Status = models.RefundCompleted
+	return rf, nil
+}
+
+// refundMethod says how money goes back for o, or "" if it can't yet.
+// Orders from before online payment have no payment status and are refunded
+// by hand.
+func refundMethod(o *models.Order) string {
+	switch o.PaymentStatus {
+	case "":
+		return models.RefundMethodManual
+	case models.PaymentCaptured, models.PaymentPartiallyRefunded:
+		return models.RefundMethodProvider
+	case models.PaymentNotRequired:
+		return models.RefundMethodStoredValue
+	}
+	return ""
+}
+
+// payOut pays the stored-value part of rf back first, then the rest to the
+// card, and takes the stored value back again if the card refund fails.
+func (s *RefundService) payOut(ctx context.Context, o *models.Order, rf *models.Refund, note string) error {
+	if rf.Method == models.RefundMethodStoreCredit {
+		return s.credits.Grant(ctx, o.UserID, rf.Amount, models.CreditSourceRefund, rf.ID, note)
+	}
+
+	var stored models.Money
+	for i, t := range rf.StoredValue {
+		if err := s.storedValue.Refund(ctx, o.ID, rf.ID, t, note); err != nil {
+			s.unrefund(ctx, o, rf, rf.StoredValue[:i])
+			return err
+		}
+		stored += t.Amount
+	}
+	if rf.Method == models.RefundMethodProvider && rf.Amount > stored {
+		if _, err := s.payments.Refund(ctx, o.ID, rf.Amount-stored, note); err != nil {
+			s.unrefund(ctx, o, rf, rf.StoredValue)
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RefundService) unrefund(ctx context.Context, o *models.Order, rf *models.Refund, shares []models.Tender) {
+	for _, t := range shares {
+		if err := s.storedValue.Unrefund(ctx, o.ID, rf.ID, t); err != nil {
+			log.Printf("[refunds] take back %s from %s for refund %s: %v", t.Amount, t.Account, rf.ID.Hex(), err)
+		}
+	}
+}
+
+// allocateStoredValue gives the gift cards and store credit o was paid with
+// their refund first, in the order they were taken, recording the shares on
+// rf and o.
+func allocateStoredValue(o *models.Order, rf *models.Refund) {
+	left := rf.Amount
+	for i := range o.Tenders {
+		t := &o.Tenders[i]
+		share := min(t.Amount-t.Refunded, left)
+		if share <= 0 {
+			continue
+		}
+		t.Refunded += share
+		left -= share
+		rf.StoredValue = append(rf.StoredValue, models.Tender{
+			Method:     t.Method,
+			Account:    t.Account,
+			GiftCardID: t.GiftCardID,
+			Code:       t.Code,
+			Amount:     share,
+		})
+	}
+}
+
+func (s *RefundService) fail(ctx context.Context, rf *models.Refund, cause error) {
+	if err := s.refunds.SetStatus(ctx, rf.ID, models.RefundFailed, cause.Error()); err != nil {
+		log.Printf("[refunds] mark %s failed: %v", rf.ID.Hex(), err)
+	}
+}
+
+// unitsShare is the part of total that n more units of qty carry once done
+// have been taken.
+func unitsShare(total models.Money, done, n, qty int) models.Money {
+	return total.MulRatio(int64(done+n), int64(qty)) - total.MulRatio(int64(done), int64(qty))
+}
+
+// applyRefundLines fills rf from in and updates o's refunded quantities,
+// totals and status to match.
+func applyRefundLines(o *models.Order, rf *models.Refund, in RefundInput) error {
+	lines := in.Lines
+	feeRequested := in.DeliveryFee
+	if in.Full {
+		lines = nil
+		for _, it := range o.Items {
+			if left := it.Quantity - it.RefundedQuantity; left > 0 {
+				lines = append(lines, RefundLineInput{ProductID: it.ProductID, Quantity: left, Restock: in.Restock})
+			}
+		}
+		feeRequested = true
+	}
+
+	for _, l := range lines {
+		if l.Quantity <= 0 {
+			return fmt.Errorf("%w: quantity must be > 0", ErrInvalidRefund)
+		}
+		// A product may appear on several order lines; take from each in
+		// turn.
+		need := l.Quantity
+		var amount, taxShare models.Money
+		for i := range o.Items {
+			it := &o.Items[i]
+			if it.ProductID != l.ProductID || need == 0 {
+				continue
+			}
+			n := min(need, it.Quantity-it.RefundedQuantity)
+			if n <= 0 {
+				continue
+			}
+			// Discount and tax shares are worked out on the running
+			// quantity so that refunding every unit gives back exactly
+			// the line's.
+			tax := unitsShare(it.Tax, it.RefundedQuantity, n, it.Quantity)
+			amount += it.Price.Mul(n) - unitsShare(it.Discount, it.RefundedQuantity, n, it.Quantity)
+			it.RefundedQuantity += n
+			if o.TaxMode == models.TaxExclusive {
+				amount += tax
+			}
+			taxShare += tax
+			need -= n
+		}
+		if need > 0 {
+			return fmt.Errorf("%w: only %d of product %s can still be refunded", ErrInvalidRefund, l.Quantity-need, l.ProductID.Hex())
+		}
+		rf.Lines = append(rf.Lines, models.RefundLine{
+			ProductID: l.ProductID,
+			Quantity:  l.Quantity,
+			Amount:    amount,
+			Tax:       taxShare,
+			Restock:   l.Restock,
+		})
+	}
+
+	if feeRequested && o.DeliveryFee > 0 && !o.DeliveryFeeRefunded {
+		rf.DeliveryFee = o.DeliveryFee
+		o.DeliveryFeeRefunded = true
+	} else if in.DeliveryFee && !in.Full {
+		return fmt.Errorf("%w: there is no delivery fee left to refund", ErrInvalidRefund)
+	}
+
+	for _, l := range rf.Lines {
+		rf.Amount += l.Amount
+	}
+	rf.Amount += rf.DeliveryFee
+	if rf.Amount <= 0 {
+		return ErrNothingToRefund
+	}
+	if rf.Lines == nil {
+		rf.Lines = []models.RefundLine{}
+	}
+
+	o.RefundedTotal += rf.Amount
+	o.NetTotal = o.TotalPrice - o.RefundedTotal
+	o.Status = models.OrderRefunded
+	for _, it := range o.Items {
+		if it.RefundedQuantity < it.Quantity {
+			o.Status = models.OrderPartiallyRefunded
+		}
+	}
+	if o.DeliveryFee > 0 && !o.DeliveryFeeRefunded {
+		o.Status = models.OrderPartiallyRefunded
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxReturnCommentLen = 1000
+
+const (
+	returnOpenTTL      = 30 * time.Second
+	returnOpenAttempts = 20
+	returnOpenRetry    = 100 * time.Millisecond
+)
+
+var (
+	ErrReturnNotFound     = errors.New("return not found")
+	ErrInvalidReturn      = errors.New("invalid return")
+	ErrReturnTransition   = errors.New("return is not in a state that allows this")
+	ErrOrderNotReturnable = errors.New("order cannot be returned")
+	ErrReturnNoteRequired = errors.New("note is required when rejecting a return")
+	ErrInvalidDisposition = errors.New("disposition must be RESTOCK or WRITE_OFF for every line")
+	ErrInvalidResolution  = errors.New("resolution must be REFUND or STORE_CREDIT")
+	ErrReturnBusy         = errors.New("another return for this order is being opened, try again")
+)
+
+// Order statuses from which goods can be brought back.
+var returnableStatuses = []string{"DONE", models.OrderDelivered, models.OrderPartiallyRefunded}
+
+// Return statuses that still hold on to order quantities.
+var openReturnStatuses = []string{models.ReturnRequested, models.ReturnApproved, models.ReturnReceived, models.ReturnResolving}
+
+// ReturnLineInput asks to return Quantity units of a product.
+type ReturnLineInput struct {
+	ProductID primitive.ObjectID
+	Quantity  int
+}
+
+// ReturnService runs the returns (RMA) workflow: the customer asks, staff
+// approve or reject, receive the goods, restock or write them off and settle
+// with a refund or store credit.
+type ReturnService struct {
+	returns        repository.ReturnRepo
+	orders         repository.OrderRepo
+	refunds        *RefundService
+	productService *ProductService
+	leases         repository.LeaseRepo
+}
+
+func NewReturnService(returns repository.ReturnRepo, orders repository.OrderRepo, refunds *RefundService, productService *ProductService, leases repository.LeaseRepo) *ReturnService {
+	return &ReturnService{returns: returns, orders: orders, refunds: refunds, productService: productService, leases: leases}
+}
+
+// Open creates a return for items of one of the customer's orders.
+func (s *ReturnService) Open(ctx context.Context, userID, orderID primitive.ObjectID, reason, comment string, lines []ReturnLineInput) (*models.ReturnRequest, error) {
+	reason = strings.ToUpper(strings.TrimSpace(reason))
+	comment = strings.TrimSpace(comment)
+	if !models.IsValidRefundReason(reason) {
+		return nil, fmt.Errorf("%w: unknown reason %q", ErrInvalidReturn, reason)
+	}
+	if len(comment) > maxReturnCommentLen {
+		return nil, fmt.Errorf("%w: comment is too long", ErrInvalidReturn)
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%w: at least one line is required", ErrInvalidReturn)
+	}
+
+	// Returns for one order are opened one at a time, so two of them can't
+	// both claim the same units.
+	lease := "returns:" + orderID.Hex()
+	holder := primitive.NewObjectID().Hex()
+	if err := s.acquire(ctx, lease, holder); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := s.leases.Release(context.Background(), lease, holder); err != nil {
+			log.Printf("[returns] release %s: %v", lease, err)
+		}
+	}()
+
+	o, err := s.orders.FindByID(ctx, orderID)
+	if err != nil || o.UserID != userID {
+		return nil, ErrOrderNotFound
+	}
+	if !slices.Contains(returnableStatuses, o.Status) || refundMethod(o) == "" {
+		return nil, ErrOrderNotReturnable
+	}
+
+	left, err := s.returnable(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	rr := &models.ReturnRequest{
+		OrderID: o.ID,
+		UserID:  userID,
+		Reason:  reason,
+		Comment: comment,
+		Status:  models.ReturnRequested,
+	}
+	for _, l := range lines {
+		if l.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: quantity must be > 0", ErrInvalidReturn)
+		}
+		if l.Quantity > left[l.ProductID] {
+			return nil, fmt.Errorf("%w: only %d of product %s can be returned", ErrInvalidReturn, left[l.ProductID], l.ProductID.Hex())
+		}
+		left[l.ProductID] -= l.Quantity
+		rr.Lines = append(rr.Lines, models.ReturnLine{ProductID: l.ProductID, Quantity: l.Quantity})
+	}
+	rr.Timeline = []models.ReturnEvent{{Status: models.ReturnRequested, Note: comment, By: userID, Timestamp: time.Now()}}
+
+	rr.ID, err = s.returns.Create(ctx, rr)
+	if err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
+
+func (s *ReturnService) Mine(ctx context.Context, userID primitive.ObjectID) ([]models.ReturnRequest, error) {
+	return s.returns.ListByUserID(ctx, userID)
+}
+
+// Cancel withdraws a return the customer no longer wants, before staff have
+// the goods.
+func (s *ReturnService) Cancel(ctx context.Context, userID, id primitive.ObjectID) error {
+	rr, err := s.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if rr.UserID != userID {
+		return ErrReturnNotFound
+	}
+	return s.transition(ctx, id, []string{models.ReturnRequested, models.ReturnApproved},
+		models.ReturnEvent{Status: models.ReturnCancelled, By: userID}, repository.ReturnUpdate{})
+}
+
+// List is the staff queue; an empty status means all open returns.
+func (s *ReturnService) List(ctx context.Context, status string) ([]models.ReturnRequest, error) {
+	statuses := openReturnStatuses
+	if status != "" {
+		statuses = []string{strings.ToUpper(status)}
+	}
+	return s.returns.List(ctx, statuses)
+}
+
+func (s *ReturnService) Approve(ctx context.Context, staffID, id primitive.ObjectID, note string) error {
+	return s.transition(ctx, id, []string{models.ReturnRequested},
+		models.ReturnEvent{Status: models.ReturnApproved, Note: strings.TrimSpace(note), By: staffID}, repository.ReturnUpdate{})
+}
+
+func (s *ReturnService) Reject(ctx context.Context, staffID, id primitive.ObjectID, note string) error {
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return ErrReturnNoteRequired
+	}
+	return s.transition(ctx, id, []string{models.ReturnRequested, models.ReturnApproved},
+		models.ReturnEvent{Status: models.ReturnRejected, Note: note, By: staffID}, repository.ReturnUpdate{})
+}
+
+// Receive records that staff have the goods. dispositions maps products to
+// RESTOCK or WRITE_OFF; lines not in it get fallback. Restocked goods go back
+// on the shelf straight away.
+func (s *ReturnService) Receive(ctx context.Context, staffID, id primitive.ObjectID, dispositions map[primitive.ObjectID]string, fallback, note string) error {
+	rr, err := s.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	lines := slices.Clone(rr.Lines)
+	for i := range lines {
+		d, ok := dispositions[lines[i].ProductID]
+		if !ok {
+			d = fallback
+		}
+		d = strings.ToUpper(strings.TrimSpace(d))
+		if d != models.DispositionRestock && d != models.DispositionWriteOff {
+			return ErrInvalidDisposition
+		}
+		lines[i].Disposition = d
+	}
+
+	err = s.transition(ctx, id, []string{models.ReturnApproved},
+		models.ReturnEvent{Status: models.ReturnReceived, Note: strings.TrimSpace(note), By: staffID},
+		repository.ReturnUpdate{Lines: lines})
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		if l.Disposition != models.DispositionRestock {
+			continue
+		}
+		if err := s.productService.IncreaseStock(ctx, l.ProductID, l.Quantity); err != nil {
+			log.Printf("[returns] restock %s: %v", l.ProductID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// Resolve settles a received return with a refund to the original payment or
+// with store credit.
+func (s *ReturnService) Resolve(ctx context.Context, staffID, id primitive.ObjectID, resolution, note string) (*models.ReturnRequest, error) {
+	resolution = strings.ToUpper(strings.TrimSpace(resolution))
+	if resolution != models.ResolutionRefund && resolution != models.ResolutionStoreCredit {
+		return nil, ErrInvalidResolution
+	}
+	rr, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// Claiming the return first means two staff can't both refund it.
+	err = s.transition(ctx, id, []string{models.ReturnReceived},
+		models.ReturnEvent{Status: models.ReturnResolving, By: staffID}, repository.ReturnUpdate{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Stock was already dealt with on receipt.
+	in := RefundInput{
+		Reason:      rr.Reason,
+		Note:        "Return " + rr.ID.Hex(),
+		StoreCredit: resolution == models.ResolutionStoreCredit,
+	}
+	for _, l := range rr.Lines {
+		in.Lines = append(in.Lines, RefundLineInput{ProductID: l.ProductID, Quantity: l.Quantity})
+	}
+	rf, err := s.refunds.Refund(ctx, rr.OrderID, staffID, in)
+	if err != nil {
+		if err := s.transition(context.Background(), id, []string{models.ReturnResolving},
+			models.ReturnEvent{Status: models.ReturnReceived, Note: "Refund failed: " + err.Error(), By: staffID},
+			repository.ReturnUpdate{}); err != nil {
+			log.Printf("[returns] release return %s: %v", id.Hex(), err)
+		}
+		return nil, err
+	}
+
+	status := models.ReturnRefunded
+	if in.StoreCredit {
+		status = models.ReturnCredited
+	}
+	note = strings.TrimSpace(note)
+	if note == "" {
+		note = fmt.Sprintf("%s returned as %s", rf.Amount, strings.ToLower(strings.ReplaceAll(resolution, "_", " ")))
+	}
+	err = s.transition(ctx, id, []string{models.ReturnResolving},
+		models.ReturnEvent{Status: status, Note: note, By: staffID},
+		repository.ReturnUpdate{Resolution: resolution, Amount: rf.Amount, RefundID: rf.ID})
+	if err != nil {
+		return nil, err
+	}
+	return s.get(ctx, id)
+}
+
+// returnable is how many of each product the customer can still send back:
+// what was bought, less what was refunded and what other open returns claim.
+func (s *ReturnService) returnable(ctx context.Context, o *models.Order) (map[primitive.ObjectID]int, error) {
+	left := map[primitive.ObjectID]int{}
+	for _, it := range o.Items {
+		left[it.ProductID] += it.Quantity - it.RefundedQuantity
+	}
+	open, err := s.returns.ListByOrderID(ctx, o.ID, openReturnStatuses)
+	if err != nil {
+		return nil, err
+	}
+	for _, rr := range open {
+		for _, l := range rr.Lines {
+			left[l.ProductID] -= l.Quantity
+		}
+	}
+	return left, nil
+}
+
+func (s *ReturnService) acquire(ctx context.Context, lease, holder string) error {
+	for i := 0; i < returnOpenAttempts; i++ {
+		ok, err := s.leases.Acquire(ctx, lease, holder, returnOpenTTL)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(returnOpenRetry):
+		}
+	}
+	return ErrReturnBusy
+}
+
+func (s *ReturnService) get(ctx context.Context, id primitive.ObjectID) (*models.ReturnRequest, error) {
+	rr, err := s.returns.FindByID(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrReturnNotFound
+	}
+	return rr, err
+}
+
+func (s *ReturnService) transition(ctx context.Context, id primitive.ObjectID, from []string, ev models.ReturnEvent, upd repository.ReturnUpdate) error {
+	ok, err := s.returns.Transition(ctx, id, from, ev, upd)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+	if _, err := s.get(ctx, id); err != nil {
+		return err
+	}
+	return ErrReturnTransition
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StoreCreditService keeps each customer's store credit, an account in the
+// stored-value ledger.
+type StoreCreditService struct {
+	ledger repository.LedgerRepo
+}
+
+func NewStoreCreditService(ledger repository.LedgerRepo) *StoreCreditService {
+	return &StoreCreditService{ledger: ledger}
+}
+
+// Grant adds amount to the user's balance.
+func (s *StoreCreditService) Grant(ctx context.Context, userID primitive.ObjectID, amount models.Money, source string, refID primitive.ObjectID, note string) error {
+	if amount <= 0 {
+		return fmt.Errorf("store credit grant must be positive, got %s", amount)
+	}
+	_, err := s.ledger.Transfer(ctx, &models.LedgerTransfer{
+		From:   models.AccountRefunds,
+		To:     models.StoreCreditAccount(userID),
+		Amount: amount,
+		Kind:   source,
+		RefID:  refID,
+		Note:   note,
+	})
+	return err
+}
+
+func (s *StoreCreditService) Balance(ctx context.Context, userID primitive.ObjectID) (models.Money, error) {
+	return s.ledger.Balance(ctx, models.StoreCreditAccount(userID))
+}
+
+// StoreCreditStatement is the balance with the movements behind it.
+type StoreCreditStatement struct {
+	Balance models.Money              `json:"balance"`
+	Entries []models.StoreCreditEntry `json:"entries"`
+}
+
+func (s *StoreCreditService) Statement(ctx context.Context, userID primitive.ObjectID) (*StoreCreditStatement, error) {
+	b, err := s.Balance(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	account := models.StoreCreditAccount(userID)
+	transfers, err := s.ledger.ListByAccount(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]models.StoreCreditEntry, 0, len(transfers))
+	for _, t := range transfers {
+		amount := t.Amount
+		if t.From == account {
+			amount = -amount
+		}
+		entries = append(entries, models.StoreCreditEntry{
+			ID:        t.ID,
+			UserID:    userID,
+			Amount:    amount,
+			Source:    t.Kind,
+			RefID:     t.RefID,
+			Note:      t.Note,
+			CreatedAt: t.CreatedAt,
+		})
+	}
+	return &StoreCreditStatement{Balance: b, Entries: entries}, nil
+}
@@ -0,0 +1,334 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	giftCardCodeLength   = 16
+	giftCardPINLength    = 6
+	maxGiftCardBatchSize = 1000
+	// Wrong PINs in a row that lock a gift card, and for how long.
+	giftCardPINAttempts = 5
+	giftCardLockout     = 15 * time.Minute
+	// A ledger transfer still pending after ledgerPendingTimeout was cut
+	// short and is rolled back.
+	ledgerPendingTimeout = 5 * time.Minute
+	ledgerRecoveryCheck  = time.Minute
+)
+
+var (
+	ErrInvalidGiftCard    = errors.New("invalid gift card")
+	ErrGiftCardNotFound   = errors.New("gift card not found")
+	ErrWrongGiftCardPIN   = errors.New("wrong gift card PIN")
+	ErrGiftCardLocked     = errors.New("gift card is locked after too many wrong PINs, try again later")
+	ErrGiftCardUnusable   = errors.New("gift card is disabled or has expired")
+	ErrStoredValueChanged = errors.New("gift card or store credit balance changed, try again")
+)
+
+// StoredValueService issues gift cards and takes and gives back the gift
+// cards and store credit that pay for orders. Every balance change is a
+// transfer in the ledger.
+type StoredValueService struct {
+	cards  repository.GiftCardRepo
+	ledger repository.LedgerRepo
+}
+
+func NewStoredValueService(cards repository.GiftCardRepo, ledger repository.LedgerRepo) *StoredValueService {
+	s := &StoredValueService{cards: cards, ledger: ledger}
+	go s.recoveryLoop()
+	return s
+}
+
+func (s *StoredValueService) recoveryLoop() {
+	t := time.NewTicker(ledgerRecoveryCheck)
+	defer t.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		n, err := s.ledger.RollBackPending(ctx, time.Now().Add(-ledgerPendingTimeout))
+		if err != nil {
+			log.Printf("[ledger] roll back pending transfers: %v", err)
+		} else if n > 0 {
+			log.Printf("[ledger] rolled back %d unfinished transfers", n)
+		}
+		cancel()
+		<-t.C
+	}
+}
+
+// IssuedGiftCard is a new gift card with its PIN, which is shown only once.
+type IssuedGiftCard struct {
+	models.GiftCard
+	PIN string `json:"pin"`
+}
+
+// Issue creates count gift cards loaded with value.
+func (s *StoredValueService) Issue(ctx context.Context, value models.Money, count int, expiresAt *time.Time, staffID primitive.ObjectID) ([]IssuedGiftCard, error) {
+	if value <= 0 {
+		return nil, fmt.Errorf("%w: value must be positive", ErrInvalidGiftCard)
+	}
+	if count < 1 || count > maxGiftCardBatchSize {
+		return nil, fmt.Errorf("%w: count must be between 1 and %d", ErrInvalidGiftCard, maxGiftCardBatchSize)
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: expiresAt must be in the future", ErrInvalidGiftCard)
+	}
+
+	out := make([]IssuedGiftCard, 0, count)
+	for len(out) < count {
+		code, err := randomString(couponAlphabet, giftCardCodeLength)
+		if err != nil {
+			return out, err
+		}
+		pin, err := randomString("0123456789", giftCardPINLength)
+		if err != nil {
+			return out, err
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+		if err != nil {
+			return out, err
+		}
+		g := models.GiftCard{
+			Code:         code,
+			PINHash:      string(hash),
+			InitialValue: value,
+			Currency:     StoreCurrency(),
+			ExpiresAt:    expiresAt,
+			IssuedBy:     staffID,
+		}
+		g.ID, err = s.cards.Create(ctx, &g)
+		if mongo.IsDuplicateKeyError(err) {
+			continue // try another code
+		}
+		if err != nil {
+			return out, err
+		}
+		if _, err := s.ledger.Transfer(ctx, &models.LedgerTransfer{
+			From:      models.AccountGiftCardsIssued,
+			To:        models.GiftCardAccount(g.ID),
+			Amount:    value,
+			Kind:      models.TransferIssue,
+			RefID:     g.ID,
+			Note:      "Gift card issued",
+			CreatedBy: staffID,
+		}); err != nil {
+			return out, err
+		}
+		g.Balance = value
+		out = append(out, IssuedGiftCard{GiftCard: g, PIN: pin})
+	}
+	return out, nil
+}
+
+func (s *StoredValueService) List(ctx context.Context) ([]models.GiftCard, error) {
+	list, err := s.cards.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	accounts := make([]string, len(list))
+	for i := range list {
+		accounts[i] = models.GiftCardAccount(list[i].ID)
+	}
+	balances, err := s.ledger.Balances(ctx, accounts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		list[i].Balance = balances[accounts[i]]
+	}
+	return list, nil
+}
+
+func (s *StoredValueService) SetDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error {
+	err := s.cards.SetDisabled(ctx, id, disabled)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrGiftCardNotFound
+	}
+	return err
+}
+
+// GiftCardStatement is a gift card with every transfer in and out of it.
+type GiftCardStatement struct {
+	GiftCard  *models.GiftCard        `json:"giftCard"`
+	Transfers []models.LedgerTransfer `json:"transfers"`
+}
+
+func (s *StoredValueService) Statement(ctx context.Context, id primitive.ObjectID) (*GiftCardStatement, error) {
+	g, err := s.cards.FindByID(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrGiftCardNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if g.Balance, err = s.ledger.Balance(ctx, models.GiftCardAccount(id)); err != nil {
+		return nil, err
+	}
+	transfers, err := s.ledger.ListByAccount(ctx, models.GiftCardAccount(id))
+	if err != nil {
+		return nil, err
+	}
+	return &GiftCardStatement{GiftCard: g, Transfers: transfers}, nil
+}
+
+// Check returns the gift card with its balance once its PIN is right. Wrong
+// PINs count towards locking the card.
+func (s *StoredValueService) Check(ctx context.Context, code, pin string) (*models.GiftCard, error) {
+	g, err := s.cards.FindByCode(ctx, normalizeGiftCardCode(code))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrGiftCardNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if g.LockedUntil != nil && g.LockedUntil.After(now) {
+		return nil, ErrGiftCardLocked
+	}
+	if bcrypt.CompareHashAndPassword([]byte(g.PINHash), []byte(strings.TrimSpace(pin))) != nil {
+		if err := s.cards.PINFailed(ctx, g.ID, giftCardPINAttempts, now.Add(giftCardLockout)); err != nil {
+			return nil, err
+		}
+		return nil, ErrWrongGiftCardPIN
+	}
+	if g.FailedPINAttempts > 0 {
+		if err := s.cards.PINOK(ctx, g.ID); err != nil {
+			return nil, err
+		}
+	}
+	if g.Balance, err = s.ledger.Balance(ctx, models.GiftCardAccount(g.ID)); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Take pays what it can of order o's TotalPrice from card, when not nil,
+// and then from the customer's store credit when storeCredit is set. It
+// returns the tenders taken; o must already have its id.
+func (s *StoredValueService) Take(ctx context.Context, o *models.Order, card *models.GiftCard, storeCredit bool) ([]models.Tender, error) {
+	due := o.TotalPrice
+	var tenders []models.Tender
+	take := func(t models.Tender) error {
+		balance, err := s.ledger.Balance(ctx, t.Account)
+		if err != nil {
+			return err
+		}
+		t.Amount = min(balance, due)
+		if t.Amount <= 0 {
+			return nil
+		}
+		ok, err := s.ledger.Transfer(ctx, &models.LedgerTransfer{
+			From:    t.Account,
+			To:      models.AccountCheckout,
+			Amount:  t.Amount,
+			Kind:    models.TransferRedeem,
+			OrderID: o.ID,
+			Note:    "Paid for order",
+		})
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrStoredValueChanged
+		}
+		due -= t.Amount
+		tenders = append(tenders, t)
+		return nil
+	}
+
+	var err error
+	if card != nil {
+		now := time.Now()
+		if card.Disabled || (card.ExpiresAt != nil && !card.ExpiresAt.After(now)) || card.Currency != o.Currency {
+			return nil, ErrGiftCardUnusable
+		}
+		err = take(models.Tender{
+			Method:     models.TenderGiftCard,
+			Account:    models.GiftCardAccount(card.ID),
+			GiftCardID: card.ID,
+			Code:       card.MaskedCode(),
+		})
+	}
+	if err == nil && storeCredit && due > 0 {
+		err = take(models.Tender{
+			Method:  models.TenderStoreCredit,
+			Account: models.StoreCreditAccount(o.UserID),
+		})
+	}
+	if err != nil {
+		s.GiveBack(ctx, o.ID, tenders, "Order not placed")
+		return nil, err
+	}
+	return tenders, nil
+}
+
+// GiveBack returns what is left of tenders, after refunds, to where it came
+// from, logging failures.
+func (s *StoredValueService) GiveBack(ctx context.Context, orderID primitive.ObjectID, tenders []models.Tender, note string) {
+	for _, t := range tenders {
+		amount := t.Amount - t.Refunded
+		if amount <= 0 {
+			continue
+		}
+		if _, err := s.ledger.Transfer(ctx, &models.LedgerTransfer{
+			From:    models.AccountCheckout,
+			To:      t.Account,
+			Amount:  amount,
+			Kind:    models.TransferReversal,
+			OrderID: orderID,
+			Note:    note,
+		}); err != nil {
+			log.Printf("[stored value] give back %s to %s for order %s: %v", amount, t.Account, orderID.Hex(), err)
+		}
+	}
+}
+
+// Refund pays share.Amount of a refund back to the tender share came from.
+func (s *StoredValueService) Refund(ctx context.Context, orderID, refundID primitive.ObjectID, share models.Tender, note string) error {
+	_, err := s.ledger.Transfer(ctx, &models.LedgerTransfer{
+		From:    models.AccountRefunds,
+		To:      share.Account,
+		Amount:  share.Amount,
+		Kind:    models.TransferRefund,
+		OrderID: orderID,
+		RefID:   refundID,
+		Note:    note,
+	})
+	return err
+}
+
+// Unrefund takes back a Refund whose refund as a whole failed. It fails if
+// the customer has spent the money in the meantime.
+func (s *StoredValueService) Unrefund(ctx context.Context, orderID, refundID primitive.ObjectID, share models.Tender) error {
+	ok, err := s.ledger.Transfer(ctx, &models.LedgerTransfer{
+		From:    share.Account,
+		To:      models.AccountRefunds,
+		Amount:  share.Amount,
+		Kind:    models.TransferReversal,
+		OrderID: orderID,
+		RefID:   refundID,
+		Note:    "Refund failed",
+	})
+	if err == nil && !ok {
+		err = ErrStoredValueChanged
+	}
+	return err
+}
+
+// normalizeGiftCardCode accepts codes typed with spaces or dashes between
+// groups.
+func normalizeGiftCardCode(code string) string {
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+	return strings.ToUpper(code)
+}
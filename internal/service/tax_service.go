@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxTaxBasisPoints = 10000
+
+var (
+	ErrTaxClassNotFound   = errors.New("tax class not found")
+	ErrInvalidTaxClass    = errors.New("invalid tax class")
+	ErrInvalidTaxSettings = errors.New("invalid tax settings")
+	ErrTaxClassInUse      = errors.New("tax class is the store default")
+)
+
+// TaxService manages tax classes and settings and works out the tax on
+// priced lines.
+type TaxService struct {
+	taxes      repository.TaxRepo
+	categories repository.CategoryRepo
+}
+
+func NewTaxService(taxes repository.TaxRepo, categories repository.CategoryRepo) *TaxService {
+	return &TaxService{taxes: taxes, categories: categories}
+}
+
+// DefaultTaxSettings are used until settings are saved: shelf prices include
+// tax and nothing is taxed until classes are assigned.
+func DefaultTaxSettings() *models.TaxSettings {
+	return &models.TaxSettings{Mode: models.TaxInclusive}
+}
+
+func (s *TaxService) Settings(ctx context.Context) (*models.TaxSettings, error) {
+	st, err := s.taxes.GetSettings(ctx)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return DefaultTaxSettings(), nil
+	}
+	return st, err
+}
+
+func (s *TaxService) UpdateSettings(ctx context.Context, st *models.TaxSettings) error {
+	st.Mode = strings.ToUpper(strings.TrimSpace(st.Mode))
+	if st.Mode != models.TaxInclusive && st.Mode != models.TaxExclusive {
+		return fmt.Errorf("%w: mode must be %s or %s", ErrInvalidTaxSettings, models.TaxInclusive, models.TaxExclusive)
+	}
+	if st.DefaultClassID != nil && st.DefaultClassID.IsZero() {
+		st.DefaultClassID = nil
+	}
+	if st.DefaultClassID != nil {
+		if _, err := s.taxes.FindClassByID(ctx, *st.DefaultClassID); errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("%w: defaultClassId does not exist", ErrInvalidTaxSettings)
+		} else if err != nil {
+			return err
+		}
+	}
+	return s.taxes.SaveSettings(ctx, st)
+}
+
+func (s *TaxService) ListClasses(ctx context.Context) ([]models.TaxClass, error) {
+	return s.taxes.ListClasses(ctx)
+}
+
+func (s *TaxService) CreateClass(ctx context.Context, c *models.TaxClass) error {
+	if err := normalizeTaxClass(c); err != nil {
+		return err
+	}
+	id, err := s.taxes.CreateClass(ctx, c)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: code %s is taken", ErrInvalidTaxClass, c.Code)
+	}
+	if err != nil {
+		return err
+	}
+	c.ID = id
+	return nil
+}
+
+func (s *TaxService) UpdateClass(ctx context.Context, id primitive.ObjectID, c *models.TaxClass) error {
+	existing, err := s.taxes.FindClassByID(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrTaxClassNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if err := normalizeTaxClass(c); err != nil {
+		return err
+	}
+	c.ID = id
+	c.CreatedAt = existing.CreatedAt
+	err = s.taxes.UpdateClass(ctx, c)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: code %s is taken", ErrInvalidTaxClass, c.Code)
+	}
+	return err
+}
+
+// DeleteClass removes a class. Categories and products still pointing at it
+// fall back as if they had none; the store default can't be deleted.
+func (s *TaxService) DeleteClass(ctx context.Context, id primitive.ObjectID) error {
+	st, err := s.Settings(ctx)
+	if err != nil {
+		return err
+	}
+	if st.DefaultClassID != nil && *st.DefaultClassID == id {
+		return ErrTaxClassInUse
+	}
+	err = s.taxes.DeleteClass(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrTaxClassNotFound
+	}
+	return err
+}
+
+// TaxableLine is a priced line to tax; Amount is price times quantity.
+type TaxableLine struct {
+	Product *models.Product
+	Amount  models.Money
+}
+
+// LineTax is the tax on one TaxableLine. ClassCode is empty for untaxed
+// goods.
+type LineTax struct {
+	ClassCode   string
+	BasisPoints int64
+	Tax         models.Money
+}
+
+// TaxBill is the tax on a set of lines under the current pricing mode.
+type TaxBill struct {
+	Mode      string
+	Lines     []LineTax // one per TaxableLine, in order
+	Total     models.Money
+	Breakdown []models.TaxLine
+}
+
+// Compute taxes lines at the rates in effect at at. Each line is rounded on
+// its own so that Total is the sum of the line taxes. A product's own class
+// wins over its category's, which wins over the store default.
+func (s *TaxService) Compute(ctx context.Context, lines []TaxableLine, at time.Time) (*TaxBill, error) {
+	st, err := s.Settings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list, err := s.taxes.ListClasses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	classes := make(map[primitive.ObjectID]*models.TaxClass, len(list))
+	for i := range list {
+		classes[list[i].ID] = &list[i]
+	}
+
+	bill := &TaxBill{Mode: st.Mode, Lines: make([]LineTax, len(lines)), Breakdown: []models.TaxLine{}}
+	byRate := map[models.TaxLine]int{} // class code and rate -> index in Breakdown
+	categoryClass := map[primitive.ObjectID]*primitive.ObjectID{}
+	for i, l := range lines {
+		classID := l.Product.TaxClassID
+		if classID == nil || classes[*classID] == nil {
+			classID, err = s.categoryClass(ctx, l.Product.CategoryID, categoryClass)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if classID == nil || classes[*classID] == nil {
+			classID = st.DefaultClassID
+		}
+		if classID == nil || classes[*classID] == nil {
+			continue
+		}
+		c := classes[*classID]
+		rate, ok := c.RateAt(at)
+		if !ok {
+			continue
+		}
+
+		tax := lineTax(l.Amount, rate.BasisPoints, st.Mode)
+		bill.Lines[i] = LineTax{ClassCode: c.Code, BasisPoints: rate.BasisPoints, Tax: tax}
+		bill.Total += tax
+
+		net := l.Amount
+		if st.Mode == models.TaxInclusive {
+			net -= tax
+		}
+		key := models.TaxLine{ClassCode: c.Code, BasisPoints: rate.BasisPoints}
+		j, seen := byRate[key]
+		if !seen {
+			j = len(bill.Breakdown)
+			byRate[key] = j
+			bill.Breakdown = append(bill.Breakdown, key)
+		}
+		bill.Breakdown[j].Net += net
+		bill.Breakdown[j].Tax += tax
+	}
+
+	sort.Slice(bill.Breakdown, func(i, j int) bool {
+		a, b := bill.Breakdown[i], bill.Breakdown[j]
+		if a.ClassCode != b.ClassCode {
+			return a.ClassCode < b.ClassCode
+		}
+		return a.BasisPoints < b.BasisPoints
+	})
+	return bill, nil
+}
+
+func (s *TaxService) categoryClass(ctx context.Context, id primitive.ObjectID, cache map[primitive.ObjectID]*primitive.ObjectID) (*primitive.ObjectID, error) {
+	if classID, ok := cache[id]; ok {
+		return classID, nil
+	}
+	c, err := s.categories.FindByID(ctx, id)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	var classID *primitive.ObjectID
+	if c != nil {
+		classID = c.TaxClassID
+	}
+	cache[id] = classID
+	return classID, nil
+}
+
+// lineTax is the tax on amount at bp basis points: on top of it when prices
+// exclude tax, or the part of it that is tax when they include it.
+func lineTax(amount models.Money, bp int64, mode string) models.Money {
+	if mode == models.TaxExclusive {
+		return amount.MulRatio(bp, maxTaxBasisPoints)
+	}
+	return amount.MulRatio(bp, maxTaxBasisPoints+bp)
+}
+
+func normalizeTaxClass(c *models.TaxClass) error {
+	c.Code = strings.ToUpper(strings.TrimSpace(c.Code))
+	if c.Code == "" {
+		return fmt.Errorf("%w: code is required", ErrInvalidTaxClass)
+	}
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		c.Name = c.Code
+	}
+
+	if len(c.Rates) == 0 {
+		return fmt.Errorf("%w: at least one rate is required", ErrInvalidTaxClass)
+	}
+	for i := range c.Rates {
+		r := &c.Rates[i]
+		if r.BasisPoints < 0 || r.BasisPoints > maxTaxBasisPoints {
+			return fmt.Errorf("%w: basisPoints must be between 0 and %d", ErrInvalidTaxClass, maxTaxBasisPoints)
+		}
+		r.EffectiveFrom = r.EffectiveFrom.UTC()
+	}
+	sort.Slice(c.Rates, func(i, j int) bool {
+		return c.Rates[i].EffectiveFrom.Before(c.Rates[j].EffectiveFrom)
+	})
+	for i := 1; i < len(c.Rates); i++ {
+		if c.Rates[i].EffectiveFrom.Equal(c.Rates[i-1].EffectiveFrom) {
+			return fmt.Errorf("%w: two rates take effect at %s", ErrInvalidTaxClass, c.Rates[i].EffectiveFrom.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
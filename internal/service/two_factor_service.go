@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const totpIssuer = "Supermarket"
+
+var (
+	ErrTwoFactorNotSetUp     = errors.New("two-factor authentication is not set up")
+	ErrTwoFactorAlreadyOn    = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorRequired     = errors.New("two-factor authentication is mandatory for this role")
+	ErrInvalidTwoFactorCode  = errors.New("invalid two-factor code")
+	ErrTwoFactorCodeRequired = errors.New("code or recoveryCode is required")
+)
+
+// TwoFactorService manages TOTP enrolment and code verification.
+type TwoFactorService struct {
+	users repository.UserRepo
+}
+
+func NewTwoFactorService(users repository.UserRepo) *TwoFactorService {
+	return &TwoFactorService{users: users}
+}
+
+// TwoFactorRequired reports whether accounts with this role must use 2FA.
+// Configured with TOTP_REQUIRED_ROLES, e.g. "staff,admin". Unset means 2FA
+// is optional for everyone; affected users enrol at their next login.
+func TwoFactorRequired(role string) bool {
+	roles := strings.TrimSpace(os.Getenv("TOTP_REQUIRED_ROLES"))
+	if roles == "" {
+		return false
+	}
+	for _, r := range strings.Split(roles, ",") {
+		if strings.TrimSpace(strings.ToLower(r)) == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Setup creates a new pending secret and returns it with an otpauth:// URI
+// for QR codes. 2FA is not active until Enable confirms a code.
+func (s *TwoFactorService) Setup(ctx context.Context, userID primitive.ObjectID) (secret, uri string, err error) {
+	u, err := s.user(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+	if u.TOTPEnabled {
+		return "", "", ErrTwoFactorAlreadyOn
+	}
+
+	secret, err = newTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.users.SetTOTPPendingSecret(ctx, userID, secret); err != nil {
+		return "", "", err
+	}
+	return secret, totpURI(totpIssuer, u.Email, secret), nil
+}
+
+// Enable checks a code against the pending secret, turns 2FA on and returns
+// freshly generated recovery codes. They are only shown this once.
+func (s *TwoFactorService) Enable(ctx context.Context, userID primitive.ObjectID, code string) ([]string, error) {
+	u, err := s.user(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if u.TOTPEnabled {
+		return nil, ErrTwoFactorAlreadyOn
+	}
+	if u.TOTPPendingSecret == "" {
+		return nil, ErrTwoFactorNotSetUp
+	}
+	step, ok := checkTOTP(u.TOTPPendingSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.EnableTOTP(ctx, userID, u.TOTPPendingSecret, hashes); err != nil {
+		return nil, err
+	}
+	_, _ = s.users.AdvanceTOTPStep(ctx, userID, step)
+	return codes, nil
+}
+
+// Disable turns 2FA off after re-checking the password and a current code.
+func (s *TwoFactorService) Disable(ctx context.Context, userID primitive.ObjectID, password, code string) error {
+	u, err := s.user(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !u.TOTPEnabled {
+		return ErrTwoFactorNotSetUp
+	}
+	if TwoFactorRequired(u.Role) {
+		return ErrTwoFactorRequired
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)); err != nil {
+		return ErrWrongPassword
+	}
+	if err := s.verify(ctx, u, code, ""); err != nil {
+		return err
+	}
+	return s.users.DisableTOTP(ctx, userID)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes; a current TOTP code is
+// required.
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, userID primitive.ObjectID, code string) ([]string, error) {
+	u, err := s.user(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !u.TOTPEnabled {
+		return nil, ErrTwoFactorNotSetUp
+	}
+	if err := s.verify(ctx, u, code, ""); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.SetRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify accepts either a TOTP code or one unused recovery code.
+func (s *TwoFactorService) Verify(ctx context.Context, userID primitive.ObjectID, code, recoveryCode string) error {
+	u, err := s.user(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !u.TOTPEnabled {
+		return ErrTwoFactorNotSetUp
+	}
+	return s.verify(ctx, u, code, recoveryCode)
+}
+
+func (s *TwoFactorService) verify(ctx context.Context, u *models.User, code, recoveryCode string) error {
+	switch {
+	case code != "":
+		step, ok := checkTOTP(u.TOTPSecret, code, time.Now())
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+		fresh, err := s.users.AdvanceTOTPStep(ctx, u.ID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	case recoveryCode != "":
+		used, err := s.users.UseRecoveryCode(ctx, u.ID, hashRecoveryCode(recoveryCode))
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	default:
+		return ErrTwoFactorCodeRequired
+	}
+}
+
+func (s *TwoFactorService) user(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
+	u, err := s.users.FindByID(ctx, userID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
+	return u, err
+}
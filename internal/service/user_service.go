@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+	"github.com/dannieey/Assignment3_Absolute/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLength = 6
+
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrInvalidRole     = errors.New("invalid role")
+	ErrLastAdmin       = errors.New("cannot remove the last active admin")
+	ErrPasswordTooWeak = errors.New("password must be at least 6 characters")
+)
+
+// UserService backs the admin user management endpoints.
+type UserService struct {
+	users  repository.UserRepo
+	guard  *LoginGuard
+	admins *AdminGuard
+}
+
+func NewUserService(users repository.UserRepo, guard *LoginGuard, admins *AdminGuard) *UserService {
+	return &UserService{users: users, guard: guard, admins: admins}
+}
+
+func (s *UserService) List(ctx context.Context, filter repository.UserFilter) (*repository.UserListResult, error) {
+	return s.users.ListWithFilter(ctx, filter)
+}
+
+func (s *UserService) Get(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	u, err := s.users.FindByID(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
+	return u, err
+}
+
+func (s *UserService) ChangeRole(ctx context.Context, id primitive.ObjectID, role string) error {
+	if !models.IsValidRole(role) {
+		return ErrInvalidRole
+	}
+	u, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if u.Role == role {
+		return nil
+	}
+	return s.admins.Revoke(ctx, id, func(*models.User) error {
+		return s.users.UpdateRole(ctx, id, role)
+	})
+}
+
+func (s *UserService) SetDisabled(ctx context.Context, id primitive.ObjectID, disabled bool) error {
+	u, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if u.Disabled == disabled {
+		return nil
+	}
+	if !disabled {
+		return s.users.SetDisabled(ctx, id, false)
+	}
+	return s.admins.Revoke(ctx, id, func(*models.User) error {
+		return s.users.SetDisabled(ctx, id, true)
+	})
+}
+
+// ResetPassword sets a new password for the user. When password is empty a
+// random temporary one is generated and returned so the admin can hand it over.
+func (s *UserService) ResetPassword(ctx context.Context, id primitive.ObjectID, password string) (string, error) {
+	if _, err := s.Get(ctx, id); err != nil {
+		return "", err
+	}
+
+	generated := ""
+	if password == "" {
+		tmp, err := randomPassword()
+		if err != nil {
+			return "", err
+		}
+		password = tmp
+		generated = tmp
+	}
+	if len(password) < minPasswordLength {
+		return "", ErrPasswordTooWeak
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	if err := s.users.UpdatePasswordHash(ctx, id, string(hash)); err != nil {
+		return "", err
+	}
+	return generated, nil
+}
+
+// Unlock clears the failed-login counter of the user's account.
+func (s *UserService) Unlock(ctx context.Context, id primitive.ObjectID) error {
+	u, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	return s.guard.UnlockAccount(ctx, u.Email)
+}
+
+func (s *UserService) UnlockIP(ctx context.Context, ip string) error {
+	return s.guard.UnlockIP(ctx, ip)
+}
+
+// ResetTwoFactor removes a user's authenticator and recovery codes, e.g. after
+// a lost phone. Users whose role requires 2FA enrol again at next login.
+func (s *UserService) ResetTwoFactor(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := s.Get(ctx, id); err != nil {
+		return err
+	}
+	return s.users.DisableTOTP(ctx, id)
+}
+
+func (s *UserService) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return s.admins.Revoke(ctx, id, func(*models.User) error {
+		return s.users.Delete(ctx, id)
+	})
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -37,7 +37,7 @@ func (s *WishlistService) GetWishlist(ctx context.Context, userID primitive.Obje
 		items = append(items, models.WishlistItemWithProduct{
 			ProductID: item.ProductID,
 			Name:      product.Name,
-			Price:     product.Price,
+			Price:     product.SellingPrice(),
 			ImageURL:  product.ImageURL,
 			InStock:   product.StockQty > 0,
 			AddedAt:   item.AddedAt,
@@ -0,0 +1,86 @@
+package views
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// sensitiveFields are JSON names that must never appear in a response body.
+// Names are compared case-insensitively.
+var sensitiveFields = []string{
+	"password",
+	"passwordhash",
+	"hash",
+	"secret",
+	"tokenhash",
+	"salt",
+	"token",
+	"pin",
+	"recoverycodes",
+	"privatekeypem",
+}
+
+// CheckNoSecrets walks the JSON shape of v's type and reports the first field
+// whose JSON name looks like a secret. Types that implement json.Marshaler
+// are treated as opaque.
+func CheckNoSecrets(v any) error {
+	return checkType(reflect.TypeOf(v), reflect.TypeOf(v).String(), map[reflect.Type]bool{})
+}
+
+var marshalerType = reflect.TypeOf((*interface{ MarshalJSON() ([]byte, error) })(nil)).Elem()
+
+func checkType(t reflect.Type, path string, seen map[reflect.Type]bool) error {
+	if t == nil {
+		return nil
+	}
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return nil
+	}
+	if t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+		return nil
+	}
+	seen[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			if err := checkType(f.Type, path, seen); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if IsSensitive(name) {
+			return fmt.Errorf("views: %s.%s would expose sensitive field %q", path, f.Name, name)
+		}
+		if err := checkType(f.Type, path+"."+f.Name, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsSensitive reports whether a JSON name looks like a secret.
+func IsSensitive(name string) bool {
+	n := strings.ToLower(name)
+	for _, s := range sensitiveFields {
+		if n == s || strings.HasSuffix(n, s) {
+			return true
+		}
+	}
+	return false
+}
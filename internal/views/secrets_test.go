@@ -0,0 +1,39 @@
+package views
+
+import (
+	"testing"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+)
+
+func TestCheckNoSecrets(t *testing.T) {
+	type nested struct {
+		Items []struct {
+			Hash string `json:"tokenHash"`
+		} `json:"items"`
+	}
+	type hidden struct {
+		PasswordHash string `json:"-"`
+		Name         string `json:"name"`
+	}
+	cases := []struct {
+		name string
+		v    any
+		leak bool
+	}{
+		{"user", models.User{}, false},
+		{"self user", SelfUser{}, false},
+		{"hidden field", hidden{}, false},
+		{"nested", nested{}, true},
+		{"pin", struct {
+			PIN string `json:"pin"`
+		}{}, true},
+		{"untagged", struct{ Secret string }{}, true},
+	}
+	for _, c := range cases {
+		err := CheckNoSecrets(c.v)
+		if (err != nil) != c.leak {
+			t.Errorf("%s: CheckNoSecrets = %v, want leak %v", c.name, err, c.leak)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Package views holds the API representations of models that carry secrets.
+// Handlers must respond with these types instead of the storage models.
+package views
+
+import (
+	"time"
+
+	"github.com/dannieey/Assignment3_Absolute/internal/models"
+)
+
+// PublicUser is what other customers or anonymous callers may see.
+type PublicUser struct {
+	ID       string `json:"id"`
+	FullName string `json:"fullName"`
+}
+
+// SelfUser is returned to the account owner.
+type SelfUser struct {
+	ID               string    `json:"id"`
+	FullName         string    `json:"fullName"`
+	Email            string    `json:"email"`
+	PendingEmail     string    `json:"pendingEmail,omitempty"`
+	Role             string    `json:"role"`
+	TwoFactorEnabled bool      `json:"twoFactorEnabled"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// AdminUser is returned by the /admin/users endpoints.
+type AdminUser struct {
+	ID               string    `json:"id"`
+	FullName         string    `json:"fullName"`
+	Email            string    `json:"email"`
+	Role             string    `json:"role"`
+	Disabled         bool      `json:"disabled"`
+	TwoFactorEnabled bool      `json:"twoFactorEnabled"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt,omitempty"`
+}
+
+func NewPublicUser(u *models.User) PublicUser {
+	return PublicUser{
+		ID:       u.ID.Hex(),
+		FullName: u.FullName,
+	}
+}
+
+func NewSelfUser(u *models.User) SelfUser {
+	return SelfUser{
+		ID:               u.ID.Hex(),
+		FullName:         u.FullName,
+		Email:            u.Email,
+		PendingEmail:     u.PendingEmail,
+		Role:             u.Role,
+		TwoFactorEnabled: u.TOTPEnabled,
+		CreatedAt:        u.CreatedAt,
+	}
+}
+
+func NewAdminUser(u *models.User) AdminUser {
+	return AdminUser{
+		ID:               u.ID.Hex(),
+		FullName:         u.FullName,
+		Email:            u.Email,
+		Role:             u.Role,
+		Disabled:         u.Disabled,
+		TwoFactorEnabled: u.TOTPEnabled,
+		CreatedAt:        u.CreatedAt,
+		UpdatedAt:        u.UpdatedAt,
+	}
+}
+
+func NewAdminUsers(list []models.User) []AdminUser {
+	out := make([]AdminUser, 0, len(list))
+	for i := range list {
+		out = append(out, NewAdminUser(&list[i]))
+	}
+	return out
+}